@@ -156,6 +156,15 @@ async function handleCallback(req, res) {
 }
 
 
+// Stored times are Firestore Timestamps, or RFC 3339 strings in older documents
+function toIsoString(value) {
+  if (value && typeof value.toDate === 'function') {
+    return value.toDate().toISOString();
+  }
+  return value;
+}
+
+
 // Extract user from Bearer token (X-Forwarded-Authorization or Authorization) or cookie
 function getUserFromRequest(req) {
   let user = null;
@@ -186,7 +195,7 @@ async function handleGetPixels(req, res) {
         color: data.color,
         userId: data.userId,
         username: data.username,
-        updatedAt: data.updatedAt
+        updatedAt: toIsoString(data.updatedAt)
       });
     });
     res.status(200).json({ pixels, count: pixels.length });
@@ -223,7 +232,7 @@ async function handlePlacePixel(req, res) {
     };
     await pubsub.topic(PIXEL_EVENTS_TOPIC).publishMessage({
       data: Buffer.from(JSON.stringify(messageData)),
-      attributes: { type: 'pixel_placement', user_id: user.sub }
+      attributes: { type: 'pixel_placement', schemaVersion: '2', user_id: user.sub }
     });
   } catch (error) {
     logJson('ERROR', 'place_pixel_failed', { error: error.message });
@@ -275,7 +284,7 @@ async function handleMe(req, res) {
     username: user.username,
     discriminator: user.discriminator,
     pixelCount: data.pixelCount || 0,
-    lastPixelAt: data.lastPixelAt || null
+    lastPixelAt: toIsoString(data.lastPixelAt) || null
   });
 }
 
@@ -0,0 +1,145 @@
+package discordproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/team11/discord-proxy/internal/discord"
+)
+
+const (
+	botTokenCheckTimeout = 5 * time.Second
+	// botTokenCheckTTL is how long a check result holds on a warm instance
+	botTokenCheckTTL = 5 * time.Minute
+)
+
+var (
+	// discordApplicationID is the application the bot token must belong to
+	// (DISCORD_APPLICATION_ID); empty skips that part of the check
+	discordApplicationID = strings.TrimSpace(os.Getenv("DISCORD_APPLICATION_ID"))
+	// DISCORD_SELF_CHECK=false turns the check off for runs without Discord,
+	// such as against the Firestore emulator
+	botTokenCheckEnabled = os.Getenv("DISCORD_SELF_CHECK") != "false"
+	// requiredIntents are the privileged intents the application must have
+	// enabled (DISCORD_REQUIRED_INTENTS, e.g. "guild_members"); none by
+	// default, since the functions only use the REST API
+	requiredIntents, requiredIntentsErr = discord.ParseIntents(os.Getenv("DISCORD_REQUIRED_INTENTS"))
+
+	botTokenMu       sync.Mutex
+	botTokenChecking bool
+	botTokenLast     *botTokenResult
+)
+
+// botTokenResult is the outcome of a self-check, as the deep health check
+// reports it
+type botTokenResult struct {
+	OK        bool      `json:"ok"`
+	Problem   string    `json:"problem,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	Status    int       `json:"status,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// runBotTokenCheck asks Discord who the bot token belongs to, see
+// discord.Client.SelfCheck, and logs bot_token_invalid naming the problem.
+func runBotTokenCheck() *botTokenResult {
+	ctx, cancel := context.WithTimeout(context.Background(), botTokenCheckTimeout)
+	defer cancel()
+
+	result := &botTokenResult{OK: true, CheckedAt: time.Now().UTC()}
+	var err error
+	if requiredIntentsErr != nil {
+		// Which intents to require is unknown, so they count as missing
+		err = &discord.SelfCheckError{Problem: discord.ProblemMissingIntents, Detail: "DISCORD_REQUIRED_INTENTS: " + requiredIntentsErr.Error()}
+	} else {
+		err = discordClient.SelfCheck(ctx, discordApplicationID, requiredIntents)
+	}
+	if err != nil {
+		result.OK = false
+		var checkErr *discord.SelfCheckError
+		if errors.As(err, &checkErr) {
+			result.Problem, result.Detail, result.Status = checkErr.Problem, checkErr.Detail, checkErr.Status
+		} else {
+			result.Problem, result.Detail = discord.ProblemUnreachable, err.Error()
+		}
+		slog.Error("bot_token_invalid",
+			"problem", result.Problem,
+			"detail", result.Detail,
+			"status", result.Status,
+			"application_id", discordApplicationID,
+		)
+	}
+
+	botTokenMu.Lock()
+	botTokenLast, botTokenChecking = result, false
+	botTokenMu.Unlock()
+	return result
+}
+
+// botTokenFresh reports whether the last check is recent enough to reuse.
+// Callers hold botTokenMu.
+func botTokenFresh() bool {
+	return botTokenLast != nil && time.Since(botTokenLast.CheckedAt) < botTokenCheckTTL
+}
+
+// checkBotToken runs the self-check unless one ran in the last
+// botTokenCheckTTL, in the background so no interaction waits on it. A bad
+// token is only logged; requests are still served, since Discord pings and
+// ACKs do not need the token.
+func checkBotToken() {
+	if !botTokenCheckEnabled {
+		return
+	}
+	botTokenMu.Lock()
+	if botTokenChecking || botTokenFresh() {
+		botTokenMu.Unlock()
+		return
+	}
+	botTokenChecking = true
+	botTokenMu.Unlock()
+
+	go runBotTokenCheck()
+}
+
+// serveHealth answers GET .../health with 200. With ?deep=true it also
+// reports the bot token self-check, running it first when the cached result
+// is stale, and answers 503 when the token is not usable.
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	body := map[string]interface{}{"status": "ok"}
+	code := http.StatusOK
+	if secretsErr != nil {
+		body["status"], code = "config_invalid", http.StatusServiceUnavailable
+	}
+
+	if r.URL.Query().Get("deep") == "true" {
+		if !botTokenCheckEnabled {
+			body["discord"] = map[string]bool{"skipped": true}
+		} else {
+			botTokenMu.Lock()
+			result := botTokenLast
+			if !botTokenFresh() {
+				result = nil
+			}
+			botTokenMu.Unlock()
+			if result == nil {
+				result = runBotTokenCheck()
+			}
+			body["discord"] = result
+			if !result.OK && code == http.StatusOK {
+				body["status"], code = "discord_unusable", http.StatusServiceUnavailable
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
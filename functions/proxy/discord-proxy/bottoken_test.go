@@ -0,0 +1,153 @@
+package discordproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/team11/discord-proxy/internal/discord"
+)
+
+// useDiscordAPI serves the Discord API from handler for the rest of the
+// test, with the self-check on and nothing cached. It returns how many
+// requests reached the handler.
+func useDiscordAPI(t *testing.T, handler http.HandlerFunc) *atomic.Int32 {
+	t.Helper()
+	// Let the check init started finish first, so it cannot land mid-test
+	for deadline := time.Now().Add(botTokenCheckTimeout + time.Second); ; time.Sleep(10 * time.Millisecond) {
+		botTokenMu.Lock()
+		checking := botTokenChecking
+		botTokenMu.Unlock()
+		if !checking || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	prevClient, prevEnabled, prevAppID, prevSecrets := discordClient, botTokenCheckEnabled, discordApplicationID, secretsErr
+	botTokenMu.Lock()
+	prevLast := botTokenLast
+	botTokenLast = nil
+	botTokenMu.Unlock()
+	discordClient = &discord.Client{BotToken: "test-token", BaseURL: srv.URL, HTTP: srv.Client()}
+	botTokenCheckEnabled, discordApplicationID, secretsErr = true, "app1", nil
+	t.Cleanup(func() {
+		discordClient, botTokenCheckEnabled, discordApplicationID, secretsErr = prevClient, prevEnabled, prevAppID, prevSecrets
+		botTokenMu.Lock()
+		botTokenLast = prevLast
+		botTokenMu.Unlock()
+	})
+	return &requests
+}
+
+// validBot answers the self-check for the bot of application app1
+func validBot(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/users/@me":
+		w.Write([]byte(`{"id":"b1","username":"pixels","bot":true}`))
+	case "/applications/@me":
+		w.Write([]byte(`{"id":"app1","name":"Pixels","bot":{"id":"b1"}}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// revokedToken refuses the token like Discord does
+func revokedToken(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`{"message":"401: Unauthorized","code":0}`))
+}
+
+func TestRunBotTokenCheck(t *testing.T) {
+	t.Run("valid token", func(t *testing.T) {
+		useDiscordAPI(t, validBot)
+		if got := runBotTokenCheck(); !got.OK || got.Problem != "" {
+			t.Errorf("result = %+v, want ok", got)
+		}
+	})
+	t.Run("revoked token", func(t *testing.T) {
+		useDiscordAPI(t, revokedToken)
+		got := runBotTokenCheck()
+		if got.OK || got.Problem != discord.ProblemTokenInvalid || got.Status != http.StatusUnauthorized {
+			t.Errorf("result = %+v, want %s with status 401", got, discord.ProblemTokenInvalid)
+		}
+	})
+	t.Run("another application's token", func(t *testing.T) {
+		useDiscordAPI(t, validBot)
+		discordApplicationID = "app2"
+		if got := runBotTokenCheck(); got.OK || got.Problem != discord.ProblemApplicationMismatch {
+			t.Errorf("result = %+v, want %s", got, discord.ProblemApplicationMismatch)
+		}
+	})
+}
+
+// deepHealth asks serveHealth for the deep check
+func deepHealth(t *testing.T) (int, map[string]interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	serveHealth(rec, httptest.NewRequest(http.MethodGet, "/health?deep=true", nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, body
+}
+
+func TestDeepHealthCheck(t *testing.T) {
+	t.Run("valid token", func(t *testing.T) {
+		useDiscordAPI(t, validBot)
+		if code, body := deepHealth(t); code != http.StatusOK || body["status"] != "ok" {
+			t.Errorf("health = %d %v, want 200 ok", code, body)
+		}
+	})
+	t.Run("revoked token", func(t *testing.T) {
+		useDiscordAPI(t, revokedToken)
+		code, body := deepHealth(t)
+		if code != http.StatusServiceUnavailable || body["status"] != "discord_unusable" {
+			t.Errorf("health = %d %v, want 503 discord_unusable", code, body)
+		}
+		if d, _ := body["discord"].(map[string]interface{}); d["problem"] != discord.ProblemTokenInvalid {
+			t.Errorf("discord = %v, want problem %s", body["discord"], discord.ProblemTokenInvalid)
+		}
+	})
+	t.Run("check off", func(t *testing.T) {
+		requests := useDiscordAPI(t, revokedToken)
+		botTokenCheckEnabled = false
+		if code, _ := deepHealth(t); code != http.StatusOK || requests.Load() != 0 {
+			t.Errorf("health = %d after %d Discord requests, want 200 without any", code, requests.Load())
+		}
+	})
+}
+
+func TestBotTokenCheckCached(t *testing.T) {
+	requests := useDiscordAPI(t, validBot)
+
+	deepHealth(t)
+	first := requests.Load()
+	if first == 0 {
+		t.Fatal("the first deep health check did not ask Discord")
+	}
+	deepHealth(t)
+	checkBotToken()
+	if n := requests.Load(); n != first {
+		t.Errorf("made %d more Discord requests within %s", n-first, botTokenCheckTTL)
+	}
+
+	// Once the result is older than the TTL, the next check asks again
+	botTokenMu.Lock()
+	botTokenLast.CheckedAt = time.Now().Add(-botTokenCheckTTL - time.Second)
+	botTokenMu.Unlock()
+	deepHealth(t)
+	if n := requests.Load(); n != 2*first {
+		t.Errorf("made %d Discord requests after the result expired, want %d", n-first, first)
+	}
+}
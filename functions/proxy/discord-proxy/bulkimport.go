@@ -0,0 +1,200 @@
+package discordproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/team11/discord-proxy/internal/messages"
+)
+
+const (
+	// bulkImportMaxPixels caps one /import-pixels file
+	bulkImportMaxPixels = 500
+	// bulkImportMaxBytes is far more than bulkImportMaxPixels pixels take
+	bulkImportMaxBytes = 1 << 20
+	// bulkImportChunkSize pixels go in each pixel_batch, which the pixel
+	// worker writes in one transaction
+	bulkImportChunkSize = 100
+	// bulkImportMaxErrors invalid pixels are listed in the reply; the rest
+	// are only counted
+	bulkImportMaxErrors = 10
+)
+
+var hexColorPattern = regexp.MustCompile(`^[0-9A-F]{6}$`)
+
+// attachmentClient downloads interaction attachments from Discord's CDN
+var attachmentClient = &http.Client{Timeout: 15 * time.Second}
+
+// importAttachment returns the file passed in the "file" option of
+// /import-pixels or /session action:import
+func importAttachment(interaction Interaction) (Attachment, bool) {
+	for _, opt := range interaction.Data.Options {
+		if opt.Name == "file" {
+			a, ok := interaction.Data.Resolved.Attachments[fmt.Sprintf("%v", opt.Value)]
+			return a, ok
+		}
+	}
+	return Attachment{}, false
+}
+
+// validateBulkPixels checks an imported file's pixels before any is
+// published: too many pixels refuse the whole file, otherwise each pixel
+// needs a 6-digit hex color and coordinates on the canvas. A canvas side of
+// 0 is unbounded. Colors of the valid pixels are normalized like /draw's.
+func validateBulkPixels(pixels []messages.PixelEvent, maxCount, width, height int) (valid []messages.PixelEvent, errs []string) {
+	if len(pixels) == 0 {
+		return nil, []string{"The file has no pixels."}
+	}
+	if len(pixels) > maxCount {
+		return nil, []string{fmt.Sprintf("The file has %d pixels; at most %d can be imported at once.", len(pixels), maxCount)}
+	}
+	for i, p := range pixels {
+		p.Color = strings.ToUpper(strings.TrimPrefix(p.Color, "#"))
+		switch {
+		case !hexColorPattern.MatchString(p.Color):
+			errs = append(errs, fmt.Sprintf("pixel %d (%d, %d): %q is not a hex color like FF0000", i+1, p.X, p.Y, pixels[i].Color))
+		case p.X < 0 || p.Y < 0:
+			errs = append(errs, fmt.Sprintf("pixel %d (%d, %d): coordinates must be 0 or more", i+1, p.X, p.Y))
+		case (width > 0 && p.X >= width) || (height > 0 && p.Y >= height):
+			errs = append(errs, fmt.Sprintf("pixel %d (%d, %d): out of bounds (0-%d, 0-%d)", i+1, p.X, p.Y, width-1, height-1))
+		default:
+			valid = append(valid, p)
+		}
+	}
+	return valid, errs
+}
+
+// bulkImportReport summarizes what was refused, listing the first
+// bulkImportMaxErrors problems
+func bulkImportReport(published int, errs []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d pixels were invalid and skipped", len(errs))
+	if published > 0 {
+		fmt.Fprintf(&b, "; the other %d are being placed", published)
+	}
+	b.WriteString(":\n")
+	for i, e := range errs {
+		if i == bulkImportMaxErrors {
+			fmt.Fprintf(&b, "...and %d more", len(errs)-i)
+			break
+		}
+		b.WriteString("- " + e + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// downloadBulkPixels fetches and decodes an /import-pixels file:
+// {"pixels": [{"x": 0, "y": 0, "color": "FF0000"}, ...]}
+func downloadBulkPixels(ctx context.Context, url string) ([]messages.PixelEvent, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := attachmentClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download attachment: %s", resp.Status)
+	}
+	var file messages.PixelBatch
+	if err := json.NewDecoder(io.LimitReader(resp.Body, bulkImportMaxBytes)).Decode(&file); err != nil {
+		return nil, fmt.Errorf("decode attachment: %w", err)
+	}
+	return file.Pixels, nil
+}
+
+// handleImportPixelsCommand answers /import-pixels file: an admin places the
+// pixels of a JSON file. The file is validated here against the current
+// session, and only its valid pixels go to the pixel worker, as pixel_batch
+// messages whose outcomes are replied to this interaction.
+func handleImportPixelsCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "handleImportPixelsCommand")
+	defer span.End()
+
+	if !isAdmin(interaction.Member) {
+		auditDenied(ctx, interaction, "pixels.import", "canvas")
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "You do not have permission to import pixels.")
+	}
+	file, ok := importAttachment(interaction)
+	switch {
+	case !ok:
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, `Attach a JSON file of {"pixels": [{"x", "y", "color"}]} in the file option.`)
+	case !strings.HasSuffix(strings.ToLower(file.Filename), ".json"):
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, fmt.Sprintf("%s is not a JSON file.", file.Filename))
+	case file.Size > bulkImportMaxBytes:
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, fmt.Sprintf("%s is too large to import (%d MB at most).", file.Filename, bulkImportMaxBytes>>20))
+	}
+
+	client := getFirestoreClient()
+	if client == nil {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Pixel import is unavailable.")
+	}
+	session, err := client.Collection("sessions").Doc("current").Get(ctx)
+	if err != nil || session.Data()["status"] != "active" {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "There is no active session to import pixels into.")
+	}
+	width, _ := session.Data()["canvasWidth"].(int64)
+	height, _ := session.Data()["canvasHeight"].(int64)
+
+	pixels, err := downloadBulkPixels(ctx, file.URL)
+	if err != nil {
+		sendFollowUp(interaction.ApplicationID, interaction.Token, fmt.Sprintf("Could not read %s as a pixel file.", file.Filename))
+		return err
+	}
+	valid, errs := validateBulkPixels(pixels, bulkImportMaxPixels, int(width), int(height))
+	span.SetAttributes(
+		attribute.Int("import.pixels", len(pixels)),
+		attribute.Int("import.valid", len(valid)),
+		attribute.Int("import.invalid", len(errs)),
+	)
+	if len(valid) == 0 {
+		if len(pixels) == 0 || len(pixels) > bulkImportMaxPixels {
+			return sendFollowUp(interaction.ApplicationID, interaction.Token, errs[0])
+		}
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, bulkImportReport(0, errs))
+	}
+
+	// x and y are in the session's origin, like /draw's; the worker
+	// translates them
+	now := time.Now().UTC().Format(time.RFC3339)
+	for i := range valid {
+		valid[i].UserID = interaction.Member.User.ID
+		valid[i].Username = interaction.Member.User.Username
+		valid[i].Source = "discord"
+		valid[i].InteractionToken = interaction.Token
+		valid[i].ApplicationID = interaction.ApplicationID
+		valid[i].Timestamp = now
+		valid[i].IsAdmin = true
+		valid[i].GuildID = interaction.GuildID
+	}
+	for start := 0; start < len(valid); start += bulkImportChunkSize {
+		chunk := valid[start:min(start+bulkImportChunkSize, len(valid))]
+		if err := publishMessage(ctx, pixelEventsTopic, messages.PixelBatch{Pixels: chunk}, map[string]string{
+			"type":                          messages.TypePixelBatch,
+			"source":                        "discord",
+			messages.SchemaVersionAttribute: strconv.Itoa(messages.PixelEventSchemaVersion),
+		}); err != nil {
+			sendFollowUp(interaction.ApplicationID, interaction.Token,
+				fmt.Sprintf("Failed to queue pixels %d to %d of the import.", start+1, len(valid)))
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, bulkImportReport(len(valid), errs))
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package discordproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/team11/discord-proxy/internal/messages"
+)
+
+func px(x, y int, color string) messages.PixelEvent {
+	return messages.PixelEvent{X: x, Y: y, Color: color}
+}
+
+func TestValidateBulkPixels(t *testing.T) {
+	tests := []struct {
+		name          string
+		pixels        []messages.PixelEvent
+		max           int
+		width, height int
+		wantValid     []messages.PixelEvent
+		wantErrs      []string
+	}{
+		{
+			name:     "empty",
+			max:      500,
+			width:    10,
+			height:   10,
+			wantErrs: []string{"The file has no pixels."},
+		},
+		{
+			name:      "all valid",
+			pixels:    []messages.PixelEvent{px(0, 0, "ff0000"), px(9, 9, "#00FF00")},
+			max:       500,
+			width:     10,
+			height:    10,
+			wantValid: []messages.PixelEvent{px(0, 0, "FF0000"), px(9, 9, "00FF00")},
+		},
+		{
+			name: "partially invalid",
+			pixels: []messages.PixelEvent{
+				px(1, 1, "0000FF"),
+				px(2, 2, "blue"),
+				px(10, 0, "0000FF"),
+				px(-1, 3, "0000FF"),
+				px(4, 4, "#ABC"),
+			},
+			max:       500,
+			width:     10,
+			height:    10,
+			wantValid: []messages.PixelEvent{px(1, 1, "0000FF")},
+			wantErrs: []string{
+				`pixel 2 (2, 2): "blue" is not a hex color like FF0000`,
+				"pixel 3 (10, 0): out of bounds (0-9, 0-9)",
+				"pixel 4 (-1, 3): coordinates must be 0 or more",
+				`pixel 5 (4, 4): "#ABC" is not a hex color like FF0000`,
+			},
+		},
+		{
+			name:      "unbounded canvas",
+			pixels:    []messages.PixelEvent{px(5000, 7000, "123456")},
+			max:       500,
+			wantValid: []messages.PixelEvent{px(5000, 7000, "123456")},
+		},
+		{
+			name:     "over the limit",
+			pixels:   []messages.PixelEvent{px(0, 0, "FF0000"), px(1, 0, "FF0000"), px(2, 0, "FF0000")},
+			max:      2,
+			width:    10,
+			height:   10,
+			wantErrs: []string{"The file has 3 pixels; at most 2 can be imported at once."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, errs := validateBulkPixels(tt.pixels, tt.max, tt.width, tt.height)
+			if fmt.Sprint(valid) != fmt.Sprint(tt.wantValid) {
+				t.Errorf("valid = %+v, want %+v", valid, tt.wantValid)
+			}
+			if strings.Join(errs, "\n") != strings.Join(tt.wantErrs, "\n") {
+				t.Errorf("errors = %q, want %q", errs, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func TestBulkImportReport(t *testing.T) {
+	got := bulkImportReport(3, []string{"a", "b"})
+	if want := "2 pixels were invalid and skipped; the other 3 are being placed:\n- a\n- b"; got != want {
+		t.Errorf("report = %q, want %q", got, want)
+	}
+
+	errs := make([]string, bulkImportMaxErrors+4)
+	for i := range errs {
+		errs[i] = fmt.Sprintf("e%d", i)
+	}
+	got = bulkImportReport(0, errs)
+	if !strings.HasPrefix(got, "14 pixels were invalid and skipped:\n- e0\n") || !strings.HasSuffix(got, "- e9\n...and 4 more") {
+		t.Errorf("report = %q", got)
+	}
+}
+
+// useAttachment serves body as a Discord CDN attachment and returns its URL
+func useAttachment(t *testing.T, body string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/pixels.json"
+}
+
+func TestDownloadBulkPixels(t *testing.T) {
+	pixels, err := downloadBulkPixels(t.Context(), useAttachment(t, `{"pixels":[{"x":1,"y":2,"color":"FF0000"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pixels) != 1 || pixels[0] != px(1, 2, "FF0000") {
+		t.Errorf("pixels = %+v", pixels)
+	}
+
+	for _, body := range []string{`not json`, `{"pixels":[{"x":1.5,"y":2,"color":"FF0000"}]}`} {
+		if _, err := downloadBulkPixels(t.Context(), useAttachment(t, body)); err == nil {
+			t.Errorf("%s: no error", body)
+		}
+	}
+}
+
+// importPixelsCommand is /import-pixels from an admin with file at url
+func importPixelsCommand(t *testing.T, url string) Interaction {
+	t.Helper()
+	var i Interaction
+	body := `{"type":2,"token":"tok","application_id":"app","channel_id":"c1","guild_id":"g1",` +
+		`"member":{"user":{"id":"123456789012345678","username":"alice"},"roles":["admin"]},` +
+		`"data":{"name":"import-pixels","options":[{"name":"file","value":"a1"}],` +
+		`"resolved":{"attachments":{"a1":{"id":"a1","filename":"pixels.json","url":"` + url + `","size":100}}}}}`
+	if err := json.Unmarshal([]byte(body), &i); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestImportAttachment(t *testing.T) {
+	file, ok := importAttachment(importPixelsCommand(t, "https://cdn.example/pixels.json"))
+	if !ok || file.Filename != "pixels.json" || file.URL != "https://cdn.example/pixels.json" {
+		t.Errorf("importAttachment() = %+v, %v", file, ok)
+	}
+	if _, ok := importAttachment(Interaction{}); ok {
+		t.Error("found an attachment in an interaction without one")
+	}
+}
+
+func TestImportPixelsCommand(t *testing.T) {
+	requireEmulator(t)
+	withAdminRoles(t, "admin")
+	seedDoc(t, "sessions/current", map[string]interface{}{"status": "active", "canvasWidth": 10, "canvasHeight": 10})
+
+	var file messages.PixelBatch
+	for i := 0; i < bulkImportChunkSize+5; i++ {
+		file.Pixels = append(file.Pixels, px(i%10, (i/10)%10, "00FF00"))
+	}
+	file.Pixels = append(file.Pixels, px(20, 0, "00FF00"))
+	body, _ := json.Marshal(file)
+
+	ps := usePubsubFake(t)
+	dc := useFakeDiscord(t)
+	if err := handleImportPixelsCommand(t.Context(), importPixelsCommand(t, useAttachment(t, string(body)))); err != nil {
+		t.Fatalf("handleImportPixelsCommand: %v", err)
+	}
+
+	batches := ps.publishedOfType(messages.TypePixelBatch)
+	if len(batches) != 2 {
+		t.Fatalf("published %d batches, want 2", len(batches))
+	}
+	total := 0
+	for _, m := range batches {
+		var batch messages.PixelBatch
+		if err := json.Unmarshal(m.Data, &batch); err != nil {
+			t.Fatal(err)
+		}
+		// The pixel worker exempts admin imports from the rate limit by these
+		for _, p := range batch.Pixels {
+			if p.X >= 10 || p.Source != "discord" || !p.IsAdmin || p.GuildID != "g1" || p.InteractionToken != "tok" || p.UserID != "123456789012345678" {
+				t.Errorf("published pixel %+v", p)
+			}
+		}
+		total += len(batch.Pixels)
+	}
+	if total != bulkImportChunkSize+5 {
+		t.Errorf("published %d pixels, want %d", total, bulkImportChunkSize+5)
+	}
+
+	calls := dc.followUps()
+	if len(calls) != 1 || !strings.Contains(calls[0].Body.Content, "pixel 106 (20, 0): out of bounds") {
+		t.Errorf("follow-ups = %+v", calls)
+	}
+}
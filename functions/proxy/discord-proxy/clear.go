@@ -0,0 +1,69 @@
+package discordproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/team11/discord-proxy/internal/messages"
+)
+
+const (
+	canvasClearConfirmID = "canvas_clear_confirm"
+	canvasClearCancelID  = "canvas_clear_cancel"
+)
+
+// canvasView returns the "view" option of /canvas, empty when not given
+func canvasView(interaction Interaction) string {
+	for _, opt := range interaction.Data.Options {
+		if opt.Name == "view" {
+			return fmt.Sprintf("%v", opt.Value)
+		}
+	}
+	return ""
+}
+
+// sendClearPrompt answers /canvas view:clear with an ephemeral confirmation
+// button; nothing is published until an admin confirms.
+func sendClearPrompt(ctx context.Context, w http.ResponseWriter, interaction Interaction) {
+	if !isAdmin(interaction.Member) {
+		auditDenied(ctx, interaction, "canvas.clear", "pixels")
+		sendEphemeral(w, "You do not have permission to clear the canvas.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type": 4,
+		"data": map[string]interface{}{
+			"flags":   64,
+			"content": "This deletes every pixel on the canvas and resets pixel counts and the leaderboard. A backup snapshot is taken first, and placements are refused until the clear is done. Continue?",
+			"components": []map[string]interface{}{{
+				"type": 1,
+				"components": []map[string]interface{}{
+					{"type": 2, "style": 4, "label": "Clear canvas", "custom_id": canvasClearConfirmID},
+					{"type": 2, "style": 2, "label": "Cancel", "custom_id": canvasClearCancelID},
+				},
+			}},
+		},
+	})
+}
+
+// publishCanvasClear hands a confirmed clear to the snapshot worker, which
+// takes the backup and then deletes the pixels. The button interaction's ID
+// names the job, so redeliveries resume it instead of starting over.
+func publishCanvasClear(ctx context.Context, interaction Interaction) error {
+	return publishMessage(ctx, snapshotEventsTopic, messages.CanvasClearRequest{
+		JobID:            interaction.ID,
+		ChannelID:        interaction.ChannelID,
+		RequestedBy:      interaction.Member.User.ID,
+		RequestedByName:  interaction.Member.User.Username,
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+	}, map[string]string{
+		"type": messages.TypeCanvasClear,
+	})
+}
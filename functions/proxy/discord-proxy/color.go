@@ -0,0 +1,83 @@
+package discordproxy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/team11/discord-proxy/internal/coords"
+)
+
+// handleColorCommand answers /color x y with the color of one pixel and
+// nothing else: one read of the pixel, plus the session for its bounds.
+func handleColorCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "handleColorCommand")
+	defer span.End()
+
+	x, y := -1, -1
+	for _, opt := range interaction.Data.Options {
+		v, err := toInt(opt.Value)
+		if err != nil {
+			continue
+		}
+		switch opt.Name {
+		case "x":
+			x = v
+		case "y":
+			y = v
+		}
+	}
+	if x < 0 || y < 0 {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Invalid coordinates: X and Y must be integers of 0 or more.")
+	}
+	span.SetAttributes(attribute.Int("pixel.x", x), attribute.Int("pixel.y", y))
+
+	client := getFirestoreClient()
+	if client == nil {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Pixel colors are unavailable.")
+	}
+
+	// A session without a size has an unbounded canvas. x and y are typed in
+	// the session's origin; pixels are stored top-left.
+	cx, cy := x, y
+	if session, err := client.Collection("sessions").Doc("current").Get(ctx); err == nil {
+		w, _ := session.Data()["canvasWidth"].(int64)
+		h, _ := session.Data()["canvasHeight"].(int64)
+		if (w > 0 && int64(x) >= w) || (h > 0 && int64(y) >= h) {
+			return sendFollowUp(interaction.ApplicationID, interaction.Token,
+				fmt.Sprintf("(%d, %d) is out of bounds (0-%d, 0-%d)", x, y, w-1, h-1))
+		}
+		cx, cy = coords.FromSession(session.Data()).ToCanvas(x, y, int(h))
+	}
+
+	doc, err := client.Collection("pixels").Doc(fmt.Sprintf("%d_%d", cx, cy)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, fmt.Sprintf("(%d, %d) is empty.", x, y))
+	}
+	if err != nil {
+		sendFollowUp(interaction.ApplicationID, interaction.Token, "Failed to read the pixel.")
+		return err
+	}
+
+	color, _ := doc.Data()["color"].(string)
+	return sendFollowUpEmbed(interaction.ApplicationID, interaction.Token, buildColorEmbed(x, y, color))
+}
+
+// buildColorEmbed shows the color as the title, with the embed's side bar as
+// the swatch
+func buildColorEmbed(x, y int, color string) map[string]interface{} {
+	embed := map[string]interface{}{
+		"title":       "#" + color,
+		"description": fmt.Sprintf("Color at (%d, %d)", x, y),
+	}
+	if rgb, err := strconv.ParseUint(color, 16, 32); err == nil && len(color) == 6 {
+		embed["color"] = rgb
+	}
+	return embed
+}
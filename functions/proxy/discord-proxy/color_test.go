@@ -0,0 +1,87 @@
+package discordproxy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBuildColorEmbed(t *testing.T) {
+	embed := buildColorEmbed(3, 4, "FF8800")
+	if embed["title"] != "#FF8800" || embed["description"] != "Color at (3, 4)" {
+		t.Errorf("embed = %v", embed)
+	}
+	if embed["color"] != uint64(0xFF8800) {
+		t.Errorf("swatch = %v, want 0xFF8800", embed["color"])
+	}
+
+	// A stored color that is not hex still shows, without a swatch
+	if _, ok := buildColorEmbed(3, 4, "red")["color"]; ok {
+		t.Error("a swatch was set for a color that is not hex")
+	}
+}
+
+// colorCommand is /color with the given options, as Discord sends them
+func colorCommand(t *testing.T, options string) Interaction {
+	t.Helper()
+	var i Interaction
+	body := `{"type":2,"token":"tok","application_id":"app","channel_id":"c1","guild_id":"g1",` +
+		`"member":{"user":{"id":"123456789012345678","username":"alice"}},` +
+		`"data":{"name":"color","options":` + options + `}}`
+	if err := json.Unmarshal([]byte(body), &i); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestColorCommandInvalidCoordinates(t *testing.T) {
+	for _, options := range []string{
+		`[{"name":"x","value":-1},{"name":"y","value":2}]`,
+		`[{"name":"x","value":1}]`,
+		`[{"name":"x","value":"one"},{"name":"y","value":2}]`,
+	} {
+		dc := useFakeDiscord(t)
+		if err := handleColorCommand(t.Context(), colorCommand(t, options)); err != nil {
+			t.Errorf("%s: %v", options, err)
+		}
+		calls := dc.followUps()
+		if len(calls) != 1 || calls[0].Body.Content != "Invalid coordinates: X and Y must be integers of 0 or more." {
+			t.Errorf("%s: follow-ups = %+v", options, calls)
+		}
+	}
+}
+
+func TestColorCommand(t *testing.T) {
+	requireEmulator(t)
+	seedDoc(t, "sessions/current", map[string]interface{}{"status": "active", "canvasWidth": 10, "canvasHeight": 10})
+	seedDoc(t, "pixels/1_2", map[string]interface{}{"x": 1, "y": 2, "color": "00AAFF", "userId": "u1"})
+
+	tests := []struct {
+		name    string
+		options string
+		content string
+		title   string
+	}{
+		{"set pixel", `[{"name":"x","value":1},{"name":"y","value":2}]`, "", "#00AAFF"},
+		{"unset pixel", `[{"name":"x","value":2},{"name":"y","value":1}]`, "(2, 1) is empty.", ""},
+		{"out of bounds", `[{"name":"x","value":10},{"name":"y","value":0}]`, "(10, 0) is out of bounds (0-9, 0-9)", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := useFakeDiscord(t)
+			if err := handleColorCommand(t.Context(), colorCommand(t, tt.options)); err != nil {
+				t.Fatalf("handleColorCommand: %v", err)
+			}
+			calls := dc.followUps()
+			if len(calls) != 1 {
+				t.Fatalf("sent %d follow-ups, want 1", len(calls))
+			}
+			msg := calls[0].Body
+			if msg.Content != tt.content {
+				t.Errorf("content = %q, want %q", msg.Content, tt.content)
+			}
+			if tt.title != "" && (len(msg.Embeds) != 1 || msg.Embeds[0]["title"] != tt.title) {
+				t.Errorf("embeds = %v, want one titled %s", msg.Embeds, tt.title)
+			}
+		})
+	}
+}
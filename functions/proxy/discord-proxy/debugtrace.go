@@ -0,0 +1,35 @@
+package discordproxy
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/team11/discord-proxy/internal/sampling"
+)
+
+// debugTraceRequested reports whether an admin passed debug:true to the
+// command. The option is ignored for everyone else.
+func debugTraceRequested(interaction Interaction) bool {
+	for _, opt := range interaction.Data.Options {
+		if opt.Name == "debug" {
+			debug, _ := opt.Value.(bool)
+			return debug && isAdmin(interaction.Member)
+		}
+	}
+	return false
+}
+
+// startDebugTrace starts a forced trace for the rest of the interaction.
+// The request span was started before the interaction could be read, under
+// the global sample ratio, so the forced trace gets its own root, linked to
+// it. Workers that receive messages published under the returned context
+// continue the forced trace.
+func startDebugTrace(ctx context.Context, command string) (context.Context, trace.Span) {
+	return tracer.Start(sampling.Force(ctx), "discord-debug-command",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(attribute.String("discord.command", command)),
+	)
+}
@@ -0,0 +1,120 @@
+package discordproxy
+
+import (
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/team11/discord-proxy/internal/messages"
+	"github.com/team11/discord-proxy/internal/sampling"
+)
+
+// useTraceRecorder traces the rest of the test with nothing sampled unless
+// forced, and returns the spans that were
+func useTraceRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sampling.New(0)), sdktrace.WithSpanProcessor(sr))
+	prev := tracer
+	tracer = tp.Tracer("discord-proxy")
+	t.Cleanup(func() { tracer = prev })
+	return sr
+}
+
+// withAdminRoles sets ADMIN_ROLE_IDS for the rest of the test
+func withAdminRoles(t *testing.T, ids ...string) {
+	t.Helper()
+	prev := adminRoleIDs
+	adminRoleIDs = ids
+	t.Cleanup(func() { adminRoleIDs = prev })
+}
+
+// debugDraw is a /draw with debug:true from a member with the given role
+func debugDraw(role string) string {
+	return `{"type":2,"token":"tok","application_id":"app","channel_id":"c1","guild_id":"g1",` +
+		`"member":{"user":{"id":"123456789012345678","username":"alice"},"roles":["` + role + `"]},` +
+		`"data":{"name":"draw","options":[{"name":"x","value":3},{"name":"y","value":4},{"name":"color","value":"#ff0000"},{"name":"debug","value":true}]}}`
+}
+
+func TestDebugTraceRequested(t *testing.T) {
+	withAdminRoles(t, "admin")
+	tests := []struct {
+		name  string
+		roles []string
+		debug interface{}
+		want  bool
+	}{
+		{"admin", []string{"admin"}, true, true},
+		{"admin, debug off", []string{"admin"}, false, false},
+		{"admin, not a boolean", []string{"admin"}, "true", false},
+		{"member", []string{"member"}, true, false},
+		{"admin without the option", []string{"admin"}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := Interaction{Member: Member{User: User{ID: "1"}, Roles: tt.roles}}
+			if tt.debug != nil {
+				i.Data.Options = []Option{{Name: "debug", Value: tt.debug}}
+			}
+			if got := debugTraceRequested(i); got != tt.want {
+				t.Errorf("debugTraceRequested() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugTraceForcesSampling(t *testing.T) {
+	withoutBotTokenCheck(t)
+	withAdminRoles(t, "admin")
+	priv := useSigningKey(t)
+
+	tests := []struct {
+		name   string
+		role   string
+		forced bool
+	}{
+		{"admin", "admin", true},
+		{"member", "member", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := useTraceRecorder(t)
+			ps := usePubsubFake(t)
+			serveSigned(t, priv, debugDraw(tt.role))
+
+			got := ps.publishedOfType(messages.TypePixelPlacement)
+			if len(got) != 1 {
+				t.Fatalf("published %d placements, want 1", len(got))
+			}
+			attrs := got[0].Attributes
+			if forced := attrs[sampling.Attribute] == "true"; forced != tt.forced {
+				t.Errorf("%s = %q, want forced %v", sampling.Attribute, attrs[sampling.Attribute], tt.forced)
+			}
+			// Workers join the forced trace; an unsampled one is not passed on
+			if hasTrace := attrs["traceId"] != "" && attrs["spanId"] != ""; hasTrace != tt.forced {
+				t.Errorf("trace attributes %v, want them only on a forced trace", attrs)
+			}
+
+			spans := sr.Ended()
+			if !tt.forced {
+				if len(spans) != 0 {
+					t.Errorf("sampled %d spans at ratio 0 without debug", len(spans))
+				}
+				return
+			}
+			var root sdktrace.ReadOnlySpan
+			for _, s := range spans {
+				if s.Name() == "discord-debug-command" {
+					root = s
+				}
+			}
+			if root == nil {
+				t.Fatalf("no discord-debug-command span among %d sampled", len(spans))
+			}
+			if root.SpanContext().TraceID().String() != attrs["traceId"] {
+				t.Errorf("published trace %s, want the debug trace %s", attrs["traceId"], root.SpanContext().TraceID())
+			}
+		})
+	}
+}
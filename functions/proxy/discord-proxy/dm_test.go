@@ -0,0 +1,75 @@
+package discordproxy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// dmDraw is /draw sent from a DM with the bot: the user is at the top level
+// and there is no member or guild
+const dmDraw = `{"type":2,"token":"tok","application_id":"app","channel_id":"dm1",` +
+	`"user":{"id":"223456789012345678","username":"bob"},` +
+	`"data":{"name":"draw","options":[{"name":"x","value":3},{"name":"y","value":4},{"name":"color","value":"#ff0000"}]}}`
+
+// dmButton is a button pressed in a DM with the bot
+const dmButton = `{"type":3,"token":"tok","application_id":"app","channel_id":"dm1",` +
+	`"user":{"id":"223456789012345678","username":"bob"},` +
+	`"data":{"custom_id":"mydata_delete_confirm:223456789012345678"}}`
+
+func TestInteractionUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantID   string
+		wantName string
+		wantDM   bool
+	}{
+		{"guild", drawInteraction("c1"), "123456789012345678", "alice", false},
+		{"DM", dmDraw, "223456789012345678", "bob", true},
+		{"guild member and user both set", `{"type":2,"member":{"user":{"id":"1","username":"m"}},"user":{"id":"2","username":"u"}}`, "1", "m", false},
+		{"neither", `{"type":2}`, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var i Interaction
+			if err := json.Unmarshal([]byte(tt.body), &i); err != nil {
+				t.Fatal(err)
+			}
+			if u := i.User(); u.ID != tt.wantID || u.Username != tt.wantName {
+				t.Errorf("User() = %+v, want %s (%s)", u, tt.wantID, tt.wantName)
+			}
+			if got := i.inDM(); got != tt.wantDM {
+				t.Errorf("inDM() = %v, want %v", got, tt.wantDM)
+			}
+		})
+	}
+}
+
+func TestHandlerRefusesDMs(t *testing.T) {
+	withoutBotTokenCheck(t)
+	priv := useSigningKey(t)
+
+	for name, body := range map[string]string{"command": dmDraw, "button": dmButton} {
+		t.Run(name, func(t *testing.T) {
+			ps := usePubsubFake(t)
+			rec := serveSigned(t, priv, body)
+
+			var resp struct {
+				Type int `json:"type"`
+				Data struct {
+					Content string `json:"content"`
+					Flags   int    `json:"flags"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+			}
+			if resp.Type != 4 || resp.Data.Flags != 64 || resp.Data.Content != "This command only works in a server." {
+				t.Errorf("response = %+v, want the ephemeral server-only message", resp)
+			}
+			if got := ps.published(); len(got) != 0 {
+				t.Errorf("published %d messages for a DM", len(got))
+			}
+		})
+	}
+}
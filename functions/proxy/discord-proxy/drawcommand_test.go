@@ -0,0 +1,92 @@
+package discordproxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/team11/discord-proxy/internal/messages"
+)
+
+func TestToCoordinate(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       interface{}
+		want    int
+		wantErr bool
+	}{
+		{"float64", 12.0, 12, false},
+		{"negative", -3.0, -3, false},
+		{"fraction", 1.5, 0, true},
+		{"numeric string", "12", 0, true},
+		{"string", "abc", 0, true},
+		{"nil", nil, 0, true},
+		{"int", 12, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toCoordinate(tt.v)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("toCoordinate(%v) = %d, %v; want %d, error %v", tt.v, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// drawWithOptions is a /draw whose options are the given JSON, as Discord
+// or a broken client would send them
+func drawWithOptions(t *testing.T, options string) Interaction {
+	t.Helper()
+	var i Interaction
+	body := `{"type":2,"token":"tok","application_id":"app","channel_id":"c1","guild_id":"g1",` +
+		`"member":{"user":{"id":"123456789012345678","username":"alice"}},` +
+		`"data":{"name":"draw","options":` + options + `}}`
+	if err := json.Unmarshal([]byte(body), &i); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestRouteDrawCommandCoordinateTypes(t *testing.T) {
+	const color = `{"name":"color","value":"#00ff00"}`
+	tests := []struct {
+		name    string
+		options string
+		wantErr bool
+	}{
+		{"numbers", `[{"name":"x","value":3},{"name":"y","value":4},` + color + `]`, false},
+		{"x string", `[{"name":"x","value":"3"},{"name":"y","value":4},` + color + `]`, true},
+		{"y string", `[{"name":"x","value":3},{"name":"y","value":"four"},` + color + `]`, true},
+		{"x null", `[{"name":"x","value":null},{"name":"y","value":4},` + color + `]`, true},
+		{"y null", `[{"name":"x","value":3},{"name":"y","value":null},` + color + `]`, true},
+		{"x missing", `[{"name":"y","value":4},` + color + `]`, true},
+		{"y missing", `[{"name":"x","value":3},` + color + `]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := usePubsubFake(t)
+			dc := useFakeDiscord(t)
+			err := routeDrawCommand(t.Context(), drawWithOptions(t, tt.options))
+			placements := ps.publishedOfType(messages.TypePixelPlacement)
+			calls := dc.followUps()
+
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("routeDrawCommand: %v", err)
+				}
+				if len(placements) != 1 || len(calls) != 0 {
+					t.Errorf("published %d placements and sent %+v, want 1 and no follow-ups", len(placements), calls)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("routeDrawCommand accepted invalid coordinates")
+			}
+			if len(placements) != 0 {
+				t.Errorf("published %d placements, want none", len(placements))
+			}
+			if len(calls) != 1 || calls[0].Body.Content != "Invalid coordinates: X and Y must be integers." {
+				t.Errorf("follow-ups = %+v, want the invalid coordinates message", calls)
+			}
+		})
+	}
+}
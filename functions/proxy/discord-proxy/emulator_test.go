@@ -0,0 +1,49 @@
+package discordproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// Emulator tests read and write the Firestore emulator like the workers':
+// `docker compose up firestore`, then go test with
+// FIRESTORE_EMULATOR_HOST=localhost:8080. They are skipped without it and
+// with -short.
+
+// requireEmulator skips the test unless the emulator is configured, and
+// otherwise empties it.
+func requireEmulator(t *testing.T) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("emulator test skipped with -short")
+	}
+	host := os.Getenv("FIRESTORE_EMULATOR_HOST")
+	if host == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	if projectID == "" {
+		projectID = "team11-local"
+	}
+
+	url := fmt.Sprintf("http://%s/emulator/v1/projects/%s/databases/team11-database/documents", host, projectID)
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("clear emulator: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("clear emulator: %s", resp.Status)
+	}
+}
+
+// seedDoc writes a document at path, e.g. "pixels/4_4"
+func seedDoc(t *testing.T, path string, data map[string]interface{}) {
+	t.Helper()
+	if _, err := getFirestoreClient().Doc(path).Set(context.Background(), data); err != nil {
+		t.Fatalf("seed %s: %v", path, err)
+	}
+}
@@ -0,0 +1,71 @@
+package discordproxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/team11/discord-proxy/internal/discord"
+)
+
+// discordCall is one request the fake Discord API received
+type discordCall struct {
+	Method string
+	Path   string
+	Body   discord.Message
+}
+
+// fakeDiscord records the bot's Discord API calls and answers 204
+type fakeDiscord struct {
+	mu    sync.Mutex
+	calls []discordCall
+}
+
+// useFakeDiscord points discordClient at a fresh fakeDiscord for the rest
+// of the test.
+func useFakeDiscord(t *testing.T) *fakeDiscord {
+	t.Helper()
+	f := &fakeDiscord{}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	prevClient, prevToken := discordClient, discordBotToken
+	discordClient = &discord.Client{BotToken: "test-token", BaseURL: srv.URL, HTTP: srv.Client()}
+	discordBotToken = "test-token"
+	t.Cleanup(func() { discordClient, discordBotToken = prevClient, prevToken })
+	return f
+}
+
+// received returns the calls made so far
+func (f *fakeDiscord) received() []discordCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]discordCall(nil), f.calls...)
+}
+
+// followUps returns the interaction follow-ups sent so far, leaving out the
+// bot token self-check that init may still be running
+func (f *fakeDiscord) followUps() []discordCall {
+	var out []discordCall
+	for _, c := range f.received() {
+		if strings.HasPrefix(c.Path, "/webhooks/") {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (f *fakeDiscord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	call := discordCall{Method: r.Method, Path: r.URL.Path}
+	if body, _ := io.ReadAll(r.Body); len(body) > 0 {
+		json.Unmarshal(body, &call.Body)
+	}
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
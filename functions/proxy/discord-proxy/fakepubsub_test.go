@@ -0,0 +1,94 @@
+package discordproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// publishedMessage is one message the fake Pub/Sub received
+type publishedMessage struct {
+	Topic      string
+	Data       []byte
+	Attributes map[string]string
+}
+
+// fakePubsub is an in-memory Pub/Sub publisher that accepts every message
+// for any topic and records it.
+type fakePubsub struct {
+	pubsubpb.UnimplementedPublisherServer
+	mu       sync.Mutex
+	messages []publishedMessage
+	nextID   int
+}
+
+// usePubsubFake points getPubsubClient at a fresh fakePubsub for the rest of the
+// test.
+func usePubsubFake(t *testing.T) *fakePubsub {
+	t.Helper()
+	f := &fakePubsub{}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	pubsubpb.RegisterPublisherServer(srv, f)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial fake Pub/Sub: %v", err)
+	}
+	client, err := pubsub.NewClient(context.Background(), "team11-local", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatalf("Pub/Sub client: %v", err)
+	}
+
+	pubsubOnce.Do(func() {})
+	prev := pubsubClient
+	pubsubClient = client
+	t.Cleanup(func() {
+		pubsubClient = prev
+		client.Close()
+	})
+	return f
+}
+
+func (f *fakePubsub) Publish(_ context.Context, req *pubsubpb.PublishRequest) (*pubsubpb.PublishResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &pubsubpb.PublishResponse{}
+	for _, m := range req.Messages {
+		f.messages = append(f.messages, publishedMessage{Topic: req.Topic, Data: m.Data, Attributes: m.Attributes})
+		f.nextID++
+		resp.MessageIds = append(resp.MessageIds, fmt.Sprint(f.nextID))
+	}
+	return resp, nil
+}
+
+// published returns the messages received so far
+func (f *fakePubsub) published() []publishedMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]publishedMessage(nil), f.messages...)
+}
+
+// publishedOfType returns the messages published with the given type attribute
+func (f *fakePubsub) publishedOfType(typ string) []publishedMessage {
+	var out []publishedMessage
+	for _, m := range f.published() {
+		if m.Attributes["type"] == typ {
+			out = append(out, m)
+		}
+	}
+	return out
+}
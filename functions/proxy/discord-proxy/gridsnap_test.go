@@ -0,0 +1,78 @@
+package discordproxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/team11/discord-proxy/internal/messages"
+)
+
+// commandWithOptions is an admin's command name with the given options JSON
+func commandWithOptions(t *testing.T, name, options string) Interaction {
+	t.Helper()
+	var i Interaction
+	body := `{"type":2,"token":"tok","application_id":"app","channel_id":"c1","guild_id":"g1",` +
+		`"member":{"user":{"id":"123456789012345678","username":"alice"},"roles":["admin"]},` +
+		`"data":{"name":"` + name + `","options":` + options + `}}`
+	if err := json.Unmarshal([]byte(body), &i); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+// publishedSessionCommand decodes the one session command published
+func publishedSessionCommand(t *testing.T, ps *fakePubsub) messages.SessionCommand {
+	t.Helper()
+	got := ps.publishedOfType(messages.TypeSessionCommand)
+	if len(got) != 1 {
+		t.Fatalf("published %d session commands, want 1", len(got))
+	}
+	var cmd messages.SessionCommand
+	if err := json.Unmarshal(got[0].Data, &cmd); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestRouteCanvasCommandGridView(t *testing.T) {
+	for view, want := range map[string]string{"": "status", "status": "status", "grid": "grid"} {
+		ps := usePubsubFake(t)
+		options := `[]`
+		if view != "" {
+			options = `[{"name":"view","value":"` + view + `"}]`
+		}
+		if err := routeCanvasCommand(t.Context(), commandWithOptions(t, "canvas", options)); err != nil {
+			t.Fatalf("view %q: %v", view, err)
+		}
+		if cmd := publishedSessionCommand(t, ps); cmd.Action != want {
+			t.Errorf("view %q: action = %q, want %q", view, cmd.Action, want)
+		}
+	}
+}
+
+func TestRouteSessionStartGridSnap(t *testing.T) {
+	withAdminRoles(t, "admin")
+	tests := []struct {
+		name  string
+		value string
+		want  int
+	}{
+		{"grid", `8`, 8},
+		{"largest", `100`, 100},
+		{"1 is no grid", `1`, 0},
+		{"too large", `101`, 0},
+		{"not a number", `"eight"`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := usePubsubFake(t)
+			options := `[{"name":"action","value":"start"},{"name":"grid_snap","value":` + tt.value + `}]`
+			if err := routeSessionCommand(t.Context(), commandWithOptions(t, "session", options)); err != nil {
+				t.Fatal(err)
+			}
+			if cmd := publishedSessionCommand(t, ps); cmd.GridSnap != tt.want {
+				t.Errorf("gridSnap = %d, want %d", cmd.GridSnap, tt.want)
+			}
+		})
+	}
+}
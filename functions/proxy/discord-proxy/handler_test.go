@@ -0,0 +1,237 @@
+package discordproxy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/team11/discord-proxy/internal/messages"
+)
+
+// useSigningKey makes the proxy trust a fresh key pair for the rest of the
+// test and returns the private half, to sign requests with.
+func useSigningKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := discordPublicKey
+	discordPublicKey = pub
+	t.Cleanup(func() { discordPublicKey = prev })
+	return priv
+}
+
+// sign returns the X-Signature-Ed25519 Discord would send
+func sign(priv ed25519.PrivateKey, timestamp, body string) string {
+	return hex.EncodeToString(ed25519.Sign(priv, []byte(timestamp+body)))
+}
+
+func TestVerifySignature(t *testing.T) {
+	priv := useSigningKey(t)
+	const timestamp, body = "1700000000", `{"type":1}`
+	valid := sign(priv, timestamp, body)
+
+	tests := []struct {
+		name      string
+		signature string
+		timestamp string
+		body      string
+		want      bool
+	}{
+		{"valid", valid, timestamp, body, true},
+		{"tampered body", valid, timestamp, `{"type":2}`, false},
+		{"replayed with another timestamp", valid, "1700000001", body, false},
+		{"invalid hex", "zz" + valid[2:], timestamp, body, false},
+		{"truncated", valid[:len(valid)-2], timestamp, body, false},
+		{"empty", "", timestamp, body, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifySignature(tt.signature, tt.timestamp, tt.body); got != tt.want {
+				t.Errorf("verifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("no public key", func(t *testing.T) {
+		prev := discordPublicKey
+		discordPublicKey = nil
+		defer func() { discordPublicKey = prev }()
+		if verifySignature(valid, timestamp, body) {
+			t.Error("verifySignature() = true without a public key")
+		}
+	})
+}
+
+// serveSigned sends body to Handler as Discord would, signed with priv
+func serveSigned(t *testing.T, priv ed25519.PrivateKey, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	const timestamp = "1700000000"
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("X-Signature-Ed25519", sign(priv, timestamp, body))
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	rec := httptest.NewRecorder()
+	Handler(rec, req)
+	return rec
+}
+
+// withoutBotTokenCheck keeps Handler from calling Discord in the background
+func withoutBotTokenCheck(t *testing.T) {
+	t.Helper()
+	prev := botTokenCheckEnabled
+	botTokenCheckEnabled = false
+	t.Cleanup(func() { botTokenCheckEnabled = prev })
+}
+
+// responseType decodes the interaction response type Handler wrote
+func responseType(t *testing.T, rec *httptest.ResponseRecorder) int {
+	t.Helper()
+	var resp struct {
+		Type int `json:"type"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+	}
+	return resp.Type
+}
+
+func TestHandlerRejectsBadSignatures(t *testing.T) {
+	withoutBotTokenCheck(t)
+	priv := useSigningKey(t)
+	const timestamp, body = "1700000000", `{"type":1}`
+
+	tests := []struct {
+		name      string
+		signature string
+		body      string
+	}{
+		{"missing signature", "", body},
+		{"tampered body", sign(priv, timestamp, body), `{"type":2}`},
+		{"invalid hex", "not-hex", body},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("X-Signature-Ed25519", tt.signature)
+			req.Header.Set("X-Signature-Timestamp", timestamp)
+			rec := httptest.NewRecorder()
+			Handler(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestHandlerPing(t *testing.T) {
+	withoutBotTokenCheck(t)
+	rec := serveSigned(t, useSigningKey(t), `{"type":1}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := responseType(t, rec); got != 1 {
+		t.Errorf("response type = %d, want 1 (pong)", got)
+	}
+}
+
+// drawInteraction is a /draw from a server member in channel
+func drawInteraction(channel string) string {
+	return `{"type":2,"token":"tok","application_id":"app","channel_id":"` + channel + `","guild_id":"g1",` +
+		`"member":{"user":{"id":"123456789012345678","username":"alice"}},` +
+		`"data":{"name":"draw","options":[{"name":"x","value":3},{"name":"y","value":4},{"name":"color","value":"#ff0000"}]}}`
+}
+
+func TestHandlerDraw(t *testing.T) {
+	withoutBotTokenCheck(t)
+	ps := usePubsubFake(t)
+	rec := serveSigned(t, useSigningKey(t), drawInteraction("c1"))
+
+	if got := responseType(t, rec); got != 5 {
+		t.Errorf("response type = %d, want 5 (deferred ACK)", got)
+	}
+	got := ps.publishedOfType(messages.TypePixelPlacement)
+	if len(got) != 1 {
+		t.Fatalf("published %d placements, want 1", len(got))
+	}
+	if want := "projects/team11-local/topics/" + pixelEventsTopic; got[0].Topic != want {
+		t.Errorf("topic = %s, want %s", got[0].Topic, want)
+	}
+	var ev messages.PixelEvent
+	if err := json.Unmarshal(got[0].Data, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.X != 3 || ev.Y != 4 || ev.Color != "FF0000" || ev.UserID != "123456789012345678" || ev.Source != "discord" ||
+		ev.InteractionToken != "tok" || ev.ApplicationID != "app" || ev.GuildID != "g1" {
+		t.Errorf("placement = %+v", ev)
+	}
+}
+
+// withAllowedChannels sets ALLOWED_CHANNEL_IDS for the rest of the test
+func withAllowedChannels(t *testing.T, ids ...string) {
+	t.Helper()
+	prev := allowedChannelIDs
+	allowedChannelIDs = ids
+	t.Cleanup(func() { allowedChannelIDs = prev })
+}
+
+func TestIsChannelAllowed(t *testing.T) {
+	withAllowedChannels(t)
+	if !isChannelAllowed("c1") {
+		t.Error("an empty allowlist refused a channel")
+	}
+	withAllowedChannels(t, "c1", "c2")
+	if !isChannelAllowed("c2") {
+		t.Error("an allowlisted channel was refused")
+	}
+	if isChannelAllowed("c3") || isChannelAllowed("") {
+		t.Error("a channel missing from the allowlist was allowed")
+	}
+}
+
+// deleteConfirmation is a member pressing the /mydata delete confirmation
+// button in channel
+func deleteConfirmation(channel string) string {
+	return `{"type":3,"token":"tok","application_id":"app","channel_id":"` + channel + `","guild_id":"g1",` +
+		`"member":{"user":{"id":"123456789012345678","username":"alice"}},` +
+		`"data":{"custom_id":"mydata_delete_confirm:123456789012345678"}}`
+}
+
+func TestHandlerChannelAllowlist(t *testing.T) {
+	withoutBotTokenCheck(t)
+	withAllowedChannels(t, "allowed")
+	priv := useSigningKey(t)
+
+	tests := []struct {
+		name      string
+		body      string
+		wantType  int
+		published string
+	}{
+		{"command in an allowed channel", drawInteraction("allowed"), 5, messages.TypePixelPlacement},
+		{"command elsewhere", drawInteraction("other"), 4, ""},
+		{"button in an allowed channel", deleteConfirmation("allowed"), 7, messages.TypeUserDataDelete},
+		{"button elsewhere", deleteConfirmation("other"), 4, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := usePubsubFake(t)
+			rec := serveSigned(t, priv, tt.body)
+			if got := responseType(t, rec); got != tt.wantType {
+				t.Errorf("response type = %d, want %d: %s", got, tt.wantType, rec.Body.String())
+			}
+			got := ps.published()
+			switch {
+			case tt.published == "" && len(got) != 0:
+				t.Errorf("published %d messages from a disallowed channel", len(got))
+			case tt.published != "" && len(ps.publishedOfType(tt.published)) != 1:
+				t.Errorf("published %v, want one %s", got, tt.published)
+			}
+		})
+	}
+}
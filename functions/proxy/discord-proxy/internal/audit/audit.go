@@ -0,0 +1,76 @@
+// Package audit records who did what in the audit_log collection.
+//
+// Entries are append-only: this package only ever adds documents, and no
+// function deletes them. Recording is best effort, so a Firestore outage never
+// fails the action being audited.
+//
+// The same package lives in each Go function module; keep the copies in sync.
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Collection is the Firestore collection holding audit entries
+const Collection = "audit_log"
+
+// Entry is one audited action. Timestamp and TraceID are filled in by Record
+// when left empty.
+type Entry struct {
+	ActorID   string                 `firestore:"actorId"`
+	ActorName string                 `firestore:"actorName"`
+	Action    string                 `firestore:"action"`
+	Target    string                 `firestore:"target"`
+	Params    map[string]interface{} `firestore:"params"`
+	Timestamp string                 `firestore:"timestamp"`
+	TraceID   string                 `firestore:"traceId"`
+}
+
+// Record appends e to the audit log. Failures are logged, never returned.
+func Record(ctx context.Context, client *firestore.Client, e Entry) {
+	if e.Timestamp == "" {
+		e.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+	if e.TraceID == "" {
+		if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+			e.TraceID = sc.TraceID().String()
+		}
+	}
+	if e.Params == nil {
+		e.Params = map[string]interface{}{}
+	}
+
+	if client == nil {
+		slog.Warn("audit_log_write_failed", "action", e.Action, "actor_id", e.ActorID, "error", "no firestore client")
+		return
+	}
+	if _, _, err := client.Collection(Collection).Add(ctx, e); err != nil {
+		slog.Warn("audit_log_write_failed", "action", e.Action, "actor_id", e.ActorID, "error", err.Error())
+	}
+}
+
+// Recent returns the newest n entries, newest first.
+func Recent(ctx context.Context, client *firestore.Client, n int) ([]Entry, error) {
+	docs, err := client.Collection(Collection).
+		OrderBy("timestamp", firestore.Desc).
+		Limit(n).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(docs))
+	for _, doc := range docs {
+		var e Entry
+		if err := doc.DataTo(&e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,332 @@
+// Package messages defines the Pub/Sub payloads the functions exchange, so
+// producers and consumers marshal the same structs. Each payload is told
+// apart by the "type" attribute of its message.
+//
+// The same package lives in each Go function module; keep the copies in sync.
+// The session worker (Node.js) reads SessionCommand; change it together.
+package messages
+
+// Message types, set as the "type" attribute
+const (
+	TypePixelPlacement  = "pixel_placement"
+	TypePixelBatch      = "pixel_batch"
+	TypePresence        = "presence"
+	TypeSnapshotRequest = "snapshot_request"
+	TypeSnapshotVerify  = "snapshot_verify"
+	TypeSnapshotRegion  = "snapshot_region"
+	TypeTileRequest     = "tile_request"
+	TypePixelHistory    = "pixel_history"
+	TypeColorChart      = "color_chart"
+	TypeOwnershipMap    = "ownership_map"
+	TypeRegionStats     = "region_stats"
+	TypeUserDataExport  = "user_data_export"
+	TypeUserDataDelete  = "user_data_delete"
+	TypeCanvasClear     = "canvas_clear"
+	TypeSessionCommand  = "session_command"
+	TypeOverwriteNotice = "overwrite_notice"
+	TypeCanvasSummary   = "canvas_summary"
+	TypeSessionExport   = "session_export"
+	TypeRoleReward      = "role_reward"
+	// Published by the pixel worker after placements commit
+	TypeLeaderboardRefresh = "leaderboard_refresh"
+	// Scheduled, without a payload
+	TypePixelCountReconcile = "pixel_count_reconcile"
+)
+
+// SchemaVersionAttribute carries the PixelEvent schema version of
+// pixel-events placements and batches, so a worker can tell messages from
+// an older proxy apart during a rolling deploy. Messages without it are
+// version 1.
+const SchemaVersionAttribute = "schemaVersion"
+
+// PixelEventSchemaVersion is the version PixelEvent describes:
+//
+//	1: no version attribute; IsAdmin and GuildID are never set
+//	2: IsAdmin and GuildID are set by the Discord proxy
+const PixelEventSchemaVersion = 2
+
+// MessagePublishedData is the CloudEvent data of a Pub/Sub push delivery
+type MessagePublishedData struct {
+	Message struct {
+		Data       []byte            `json:"data"`
+		Attributes map[string]string `json:"attributes"`
+		MessageID  string            `json:"messageId"`
+	} `json:"message"`
+	// DeliveryAttempt counts deliveries of the message, from 1; it is only
+	// set when the subscription has a dead-letter policy
+	DeliveryAttempt int `json:"deliveryAttempt,omitempty"`
+}
+
+// PixelEvent is a single placement on the pixel-events topic, from /draw or
+// the web client.
+type PixelEvent struct {
+	X                int    `json:"x"`
+	Y                int    `json:"y"`
+	Color            string `json:"color"`
+	UserID           string `json:"userId"`
+	Username         string `json:"username"`
+	Source           string `json:"source"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+	// Optional: the client's ID for this request, echoed in pixel_rejected
+	RequestID string `json:"requestId,omitempty"`
+	// Optional: the pixel's updatedAt as last seen by the client. When set,
+	// the placement is rejected if the stored pixel is newer.
+	ExpectedUpdatedAt string `json:"expectedUpdatedAt,omitempty"`
+	// Set by the Discord proxy for members with an admin role; their pixels
+	// are recorded as admin-placed
+	IsAdmin bool `json:"isAdmin,omitempty"`
+	// The guild of a Discord placement, kept on the user for role rewards
+	GuildID string `json:"guildId,omitempty"`
+}
+
+// PixelBatch is several placements processed in a single invocation
+type PixelBatch struct {
+	Pixels []PixelEvent `json:"pixels"`
+}
+
+// PresenceEvent is a transient "hover" signal: where a user is about to draw.
+// Presence is never persisted.
+type PresenceEvent struct {
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+	Source   string `json:"source"`
+}
+
+// SnapshotRequest is published by the discord-proxy for /snapshot
+type SnapshotRequest struct {
+	ChannelID        string `json:"channelId"`
+	UserID           string `json:"userId"`
+	Username         string `json:"username"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+	// Optional; when both are set the session document is not read
+	CanvasWidth  int `json:"canvasWidth,omitempty"`
+	CanvasHeight int `json:"canvasHeight,omitempty"`
+	// Render zones.png with the protected zones outlined
+	Zones bool `json:"zones,omitempty"`
+	// Draw the thumbnail over a faded copy of the previous one
+	Layered bool `json:"layered,omitempty"`
+	// Longest side of the thumbnail, 100 to 4096; zero uses
+	// THUMBNAIL_MAX_SIZE
+	ThumbnailSize int `json:"thumbnailSize,omitempty"`
+	// Why the snapshot is taken, e.g. "pre_clear"; a tagged snapshot is
+	// always rendered fresh
+	Tag string `json:"tag,omitempty"`
+	// Store the snapshot in this bucket instead of the session's or
+	// SNAPSHOTS_BUCKET; it must be in SNAPSHOTS_BUCKET_ALLOWLIST
+	SnapshotsBucket string `json:"snapshotsBucket,omitempty"`
+}
+
+// SnapshotVerifyRequest is published by the discord-proxy for /snapshot
+// verify:true. SnapshotTimestamp names the snapshot; zero means the latest.
+type SnapshotVerifyRequest struct {
+	SnapshotTimestamp int64  `json:"snapshotTimestamp,omitempty"`
+	Repair            bool   `json:"repair,omitempty"`
+	UserID            string `json:"userId"`
+	Username          string `json:"username"`
+	InteractionToken  string `json:"interactionToken"`
+	ApplicationID     string `json:"applicationId"`
+	Timestamp         string `json:"timestamp,omitempty"`
+}
+
+// TileRequest is published by the discord-proxy for /tile. Either the tile
+// coordinates or a pixel coordinate inside the tile are set.
+type TileRequest struct {
+	TileX            *int   `json:"tileX,omitempty"`
+	TileY            *int   `json:"tileY,omitempty"`
+	X                *int   `json:"x,omitempty"`
+	Y                *int   `json:"y,omitempty"`
+	UserID           string `json:"userId"`
+	Username         string `json:"username"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+}
+
+// SnapshotRegionRequest is published by the discord-proxy for
+// /snapshot-region. The corners are inclusive canvas coordinates with
+// MinX <= MaxX and MinY <= MaxY.
+type SnapshotRegionRequest struct {
+	MinX             int    `json:"minX"`
+	MinY             int    `json:"minY"`
+	MaxX             int    `json:"maxX"`
+	MaxY             int    `json:"maxY"`
+	UserID           string `json:"userId"`
+	Username         string `json:"username"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+}
+
+// CanvasSummaryRequest is published by the Cloud Scheduler job of the canvas
+// summary, on its configured schedule.
+type CanvasSummaryRequest struct {
+	ChannelID string `json:"channelId"`
+}
+
+// RoleRewardCheck is published by the pixel worker when a user's pixelCount
+// crosses a threshold in config/rewards; the snapshot worker grants the roles
+// the user has earned but not received yet.
+type RoleRewardCheck struct {
+	UserID     string `json:"userId"`
+	PixelCount int    `json:"pixelCount"`
+}
+
+// LeaderboardRefresh is published by the pixel worker after placements
+// change users' pixelCount. The snapshot worker rebuilds
+// leaderboards/session_leaderboard from the users collection, unless it
+// already did so after RequestedAt (RFC 3339).
+type LeaderboardRefresh struct {
+	RequestedAt string `json:"requestedAt"`
+}
+
+// OverwriteNotice is published by the pixel worker when pixels of a user who
+// turned on /notify are painted over by others, one notice per owner per
+// placement or batch.
+type OverwriteNotice struct {
+	OwnerID     string       `json:"ownerId"`
+	Overwriters []Overwriter `json:"overwriters"`
+	Timestamp   string       `json:"timestamp,omitempty"`
+}
+
+// Overwriter is one user who painted over the owner's pixels and how many
+type Overwriter struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+	Count    int    `json:"count"`
+}
+
+// PixelHistoryRequest is published by the discord-proxy for /history
+type PixelHistoryRequest struct {
+	X                int    `json:"x"`
+	Y                int    `json:"y"`
+	UserID           string `json:"userId"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+}
+
+// ColorChartRequest is published by the discord-proxy for /canvas view:colors
+type ColorChartRequest struct {
+	UserID           string `json:"userId"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+}
+
+// OwnershipMapRequest is published by the discord-proxy for /canvas view:owners
+type OwnershipMapRequest struct {
+	UserID           string `json:"userId"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+}
+
+// RegionStatsRequest is published by the discord-proxy for /canvas
+// view:region. The corners are inclusive and in the session's origin, with
+// MinX <= MaxX and MinY <= MaxY.
+type RegionStatsRequest struct {
+	MinX             int    `json:"minX"`
+	MinY             int    `json:"minY"`
+	MaxX             int    `json:"maxX"`
+	MaxY             int    `json:"maxY"`
+	UserID           string `json:"userId"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+}
+
+// DataExportRequest is published by the discord-proxy for /mydata export
+type DataExportRequest struct {
+	UserID           string `json:"userId"`
+	Username         string `json:"username"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+}
+
+// SessionExportRequest is published by the discord-proxy for
+// /session action:export
+type SessionExportRequest struct {
+	UserID           string `json:"userId"`
+	Username         string `json:"username"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+}
+
+// DataDeletionRequest is published by the discord-proxy once /mydata delete
+// is confirmed
+type DataDeletionRequest struct {
+	UserID           string `json:"userId"`
+	RequestedBy      string `json:"requestedBy"`
+	RequestedByName  string `json:"requestedByName"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+}
+
+// CanvasClearRequest is published by the discord-proxy once /canvas
+// view:clear is confirmed. JobID names the clear_jobs document, so
+// redeliveries resume the same job.
+type CanvasClearRequest struct {
+	JobID            string `json:"jobId"`
+	ChannelID        string `json:"channelId"`
+	RequestedBy      string `json:"requestedBy"`
+	RequestedByName  string `json:"requestedByName"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+}
+
+// SessionCommand is published by the discord-proxy for /canvas, /session,
+// /verify and /zone. Only the fields of the given Action are set.
+//
+// Pointer fields distinguish "not given" from a zero value: the session
+// worker keeps the stored value when a field is absent.
+type SessionCommand struct {
+	Action           string `json:"action"`
+	ChannelID        string `json:"channelId,omitempty"`
+	UserID           string `json:"userId"`
+	Username         string `json:"username"`
+	InteractionToken string `json:"interactionToken"`
+	ApplicationID    string `json:"applicationId"`
+	Timestamp        string `json:"timestamp,omitempty"`
+
+	// start
+	CanvasWidth     int    `json:"canvasWidth,omitempty"`
+	CanvasHeight    int    `json:"canvasHeight,omitempty"`
+	SnapshotsBucket string `json:"snapshotsBucket,omitempty"`
+	// Where (0, 0) is for users, see internal/coords; empty is top-left
+	Origin string `json:"origin,omitempty"`
+	// Side of the grid cells placements snap to; 0 places pixels as typed
+	GridSnap int `json:"gridSnap,omitempty"`
+
+	// schedule; an empty string clears the bound
+	OpensAt       *string `json:"opensAt,omitempty"`
+	ClosesAt      *string `json:"closesAt,omitempty"`
+	ClosedMessage *string `json:"closedMessage,omitempty"`
+
+	// verify
+	Repair bool `json:"repair,omitempty"`
+
+	// stop: delete the live pixels once they are archived
+	Purge bool `json:"purge,omitempty"`
+
+	// import: the import_jobs document holding the confirmed file
+	ImportID string `json:"importId,omitempty"`
+
+	// zone
+	ZoneAction   string    `json:"zoneAction,omitempty"`
+	Label        string    `json:"label,omitempty"`
+	MinX         *int      `json:"minX,omitempty"`
+	MinY         *int      `json:"minY,omitempty"`
+	MaxX         *int      `json:"maxX,omitempty"`
+	MaxY         *int      `json:"maxY,omitempty"`
+	AllowedUsers *[]string `json:"allowedUsers,omitempty"`
+}
@@ -0,0 +1,162 @@
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// payloads lists every message struct, so a new one only needs adding here
+var payloads = []interface{}{
+	&MessagePublishedData{},
+	&PixelEvent{},
+	&PixelBatch{},
+	&PresenceEvent{},
+	&SnapshotRequest{},
+	&SnapshotVerifyRequest{},
+	&TileRequest{},
+	&SnapshotRegionRequest{},
+	&CanvasSummaryRequest{},
+	&RoleRewardCheck{},
+	&OverwriteNotice{},
+	&PixelHistoryRequest{},
+	&ColorChartRequest{},
+	&OwnershipMapRequest{},
+	&RegionStatsRequest{},
+	&DataExportRequest{},
+	&SessionExportRequest{},
+	&DataDeletionRequest{},
+	&CanvasClearRequest{},
+	&SessionCommand{},
+}
+
+// fill sets every field of v, recursively, to a distinct non-zero value, so
+// a field that does not survive a round trip shows up as a difference.
+func fill(v reflect.Value, n *int) {
+	*n++
+	switch v.Kind() {
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem(), n)
+	case reflect.Struct:
+		for i := range v.NumField() {
+			fill(v.Field(i), n)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(fmt.Sprintf("data-%d", *n)))
+			return
+		}
+		v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+		for i := range 2 {
+			fill(v.Index(i), n)
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key := reflect.New(v.Type().Key()).Elem()
+		elem := reflect.New(v.Type().Elem()).Elem()
+		fill(key, n)
+		fill(elem, n)
+		v.SetMapIndex(key, elem)
+	case reflect.String:
+		v.SetString(fmt.Sprintf("value-%d", *n))
+	case reflect.Int, reflect.Int64:
+		v.SetInt(int64(*n))
+	case reflect.Bool:
+		v.SetBool(true)
+	default:
+		panic("fill: unhandled kind " + v.Kind().String())
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, p := range payloads {
+		typ := reflect.TypeOf(p).Elem()
+		t.Run(typ.Name(), func(t *testing.T) {
+			want := reflect.New(typ)
+			n := 0
+			fill(want.Elem(), &n)
+
+			data, err := json.Marshal(want.Interface())
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			got := reflect.New(typ)
+			if err := json.Unmarshal(data, got.Interface()); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got.Interface(), want.Interface()) {
+				t.Errorf("round trip changed the message:\n got %+v\nwant %+v\njson %s", got.Elem(), want.Elem(), data)
+			}
+		})
+	}
+}
+
+// Every field is named explicitly in lowerCamelCase, the convention the
+// Node.js session worker and the web client read
+func TestFieldNames(t *testing.T) {
+	var check func(t *testing.T, typ reflect.Type)
+	check = func(t *testing.T, typ reflect.Type) {
+		for i := range typ.NumField() {
+			f := typ.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" || name == "-" || strings.ToLower(name[:1]) != name[:1] || strings.Contains(name, "_") {
+				t.Errorf("%s.%s has JSON name %q, want an explicit lowerCamelCase name", typ.Name(), f.Name, name)
+			}
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer || ft.Kind() == reflect.Slice {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				check(t, ft)
+			}
+		}
+	}
+	for _, p := range payloads {
+		check(t, reflect.TypeOf(p).Elem())
+	}
+}
+
+func TestPixelEventWireFormat(t *testing.T) {
+	// What the web proxy publishes
+	data := []byte(`{"x":3,"y":4,"color":"FF0000","userId":"u1","username":"alice","source":"web","requestId":"r1","expectedUpdatedAt":"2026-01-01T00:00:00Z"}`)
+	var ev PixelEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		t.Fatal(err)
+	}
+	want := PixelEvent{X: 3, Y: 4, Color: "FF0000", UserID: "u1", Username: "alice", Source: "web", RequestID: "r1", ExpectedUpdatedAt: "2026-01-01T00:00:00Z"}
+	if ev != want {
+		t.Errorf("decoded %+v, want %+v", ev, want)
+	}
+
+	// Optional fields stay off the wire when unset
+	out, _ := json.Marshal(PixelEvent{X: 1, Y: 2, Color: "00FF00", UserID: "u2", Source: "discord"})
+	for _, key := range []string{"requestId", "expectedUpdatedAt", "isAdmin", "guildId", "timestamp"} {
+		if strings.Contains(string(out), `"`+key+`"`) {
+			t.Errorf("%s is set in %s", key, out)
+		}
+	}
+}
+
+func TestSessionCommandAbsentVersusEmpty(t *testing.T) {
+	empty := ""
+	cmd := SessionCommand{Action: "schedule", OpensAt: &empty}
+	data, _ := json.Marshal(cmd)
+
+	var raw map[string]interface{}
+	json.Unmarshal(data, &raw)
+	if v, ok := raw["opensAt"]; !ok || v != "" {
+		t.Errorf("opensAt = %v, %v; an empty string must be sent to clear the bound", v, ok)
+	}
+	if _, ok := raw["closesAt"]; ok {
+		t.Error("closesAt is sent although it was not given")
+	}
+
+	var back SessionCommand
+	json.Unmarshal(data, &back)
+	if back.OpensAt == nil || *back.OpensAt != "" || back.ClosesAt != nil {
+		t.Errorf("decoded opensAt %v, closesAt %v; want empty and absent", back.OpensAt, back.ClosesAt)
+	}
+}
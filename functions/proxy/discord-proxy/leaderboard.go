@@ -0,0 +1,293 @@
+package discordproxy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/team11/discord-proxy/internal/discord"
+)
+
+const (
+	leaderboardPageSize = 10
+	// Buttons older than this update the message to say the view expired
+	leaderboardViewTTL = time.Hour
+	// Cursors are only reused briefly; counts move while people draw
+	leaderboardCursorTTL = time.Minute
+	// Upper bound on pixels scanned for the 24h window
+	leaderboardDayScanLimit = 20000
+
+	leaderboardWindowAll = "all"
+	leaderboardWindowDay = "24h"
+
+	leaderboardButtonPrefix = "lb:"
+)
+
+type leaderboardEntry struct {
+	UserID   string
+	Username string
+	Pixels   int
+}
+
+// leaderboardCursor is where page N+1 of the all-time board starts
+type leaderboardCursor struct {
+	pixelCount int64
+	userID     string
+	at         time.Time
+}
+
+// Per-instance cursor cache, keyed by page number. A miss falls back to an
+// offset query, so losing it (cold start) only costs a slower page.
+var (
+	leaderboardCursorMu sync.Mutex
+	leaderboardCursors  = make(map[int]leaderboardCursor)
+)
+
+// leaderboardButtonID encodes window, page and issue time: lb:<window>:<page>:<unix>
+func leaderboardButtonID(window string, page int, issued time.Time) string {
+	return fmt.Sprintf("%s%s:%d:%d", leaderboardButtonPrefix, window, page, issued.Unix())
+}
+
+func parseLeaderboardButtonID(customID string) (window string, page int, issued time.Time, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(customID, leaderboardButtonPrefix), ":")
+	if len(parts) != 3 {
+		return "", 0, time.Time{}, false
+	}
+	window = parts[0]
+	if window != leaderboardWindowAll && window != leaderboardWindowDay {
+		return "", 0, time.Time{}, false
+	}
+	page, err := strconv.Atoi(parts[1])
+	if err != nil || page < 0 {
+		return "", 0, time.Time{}, false
+	}
+	ts, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", 0, time.Time{}, false
+	}
+	return window, page, time.Unix(ts, 0), true
+}
+
+// getLeaderboardPage returns one page and whether another page follows.
+func getLeaderboardPage(ctx context.Context, window string, page int) ([]leaderboardEntry, bool, error) {
+	if window == leaderboardWindowDay {
+		return getDayLeaderboardPage(ctx, page)
+	}
+	return getAllTimeLeaderboardPage(ctx, page)
+}
+
+func getAllTimeLeaderboardPage(ctx context.Context, page int) ([]leaderboardEntry, bool, error) {
+	client := getFirestoreClient()
+	if client == nil {
+		return nil, false, fmt.Errorf("firestore unavailable")
+	}
+
+	q := client.Collection("users").
+		OrderBy("pixelCount", firestore.Desc).
+		OrderBy(firestore.DocumentID, firestore.Desc).
+		Limit(leaderboardPageSize + 1)
+
+	if page > 0 {
+		leaderboardCursorMu.Lock()
+		cursor, ok := leaderboardCursors[page]
+		leaderboardCursorMu.Unlock()
+		if ok && time.Since(cursor.at) < leaderboardCursorTTL {
+			q = q.StartAfter(cursor.pixelCount, cursor.userID)
+		} else {
+			q = q.Offset(page * leaderboardPageSize)
+		}
+	}
+
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasNext := len(docs) > leaderboardPageSize
+	if hasNext {
+		docs = docs[:leaderboardPageSize]
+	}
+
+	entries := make([]leaderboardEntry, 0, len(docs))
+	var lastCount int64
+	for _, doc := range docs {
+		data := doc.Data()
+		count, _ := data["pixelCount"].(int64)
+		lastCount = count
+		entries = append(entries, leaderboardEntry{UserID: doc.Ref.ID, Username: userDisplayName(data), Pixels: int(count)})
+	}
+
+	if hasNext && len(docs) > 0 {
+		leaderboardCursorMu.Lock()
+		leaderboardCursors[page+1] = leaderboardCursor{
+			pixelCount: lastCount,
+			userID:     docs[len(docs)-1].Ref.ID,
+			at:         time.Now(),
+		}
+		leaderboardCursorMu.Unlock()
+	}
+
+	return entries, hasNext, nil
+}
+
+// getDayLeaderboardPage ranks users by pixels placed in the last 24 hours that
+// are still on the canvas. There is no per-placement history, so overwritten
+// pixels no longer count.
+func getDayLeaderboardPage(ctx context.Context, page int) ([]leaderboardEntry, bool, error) {
+	client := getFirestoreClient()
+	if client == nil {
+		return nil, false, fmt.Errorf("firestore unavailable")
+	}
+
+	// Range filters only match values of the same type, so pixels last
+	// written before updatedAt became a Timestamp are found by a string query
+	cutoff := time.Now().Add(-24 * time.Hour).UTC()
+	docs, err := client.Collection("pixels").
+		Where("updatedAt", ">=", cutoff).
+		Limit(leaderboardDayScanLimit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, false, err
+	}
+	legacy, err := client.Collection("pixels").
+		Where("updatedAt", ">=", cutoff.Format(time.RFC3339)).
+		Limit(leaderboardDayScanLimit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, false, err
+	}
+	docs = append(docs, legacy...)
+
+	counts := make(map[string]*leaderboardEntry)
+	for _, doc := range docs {
+		data := doc.Data()
+		userID, _ := data["userId"].(string)
+		if userID == "" {
+			continue
+		}
+		e, ok := counts[userID]
+		if !ok {
+			username, _ := data["username"].(string)
+			e = &leaderboardEntry{UserID: userID, Username: username}
+			counts[userID] = e
+		}
+		e.Pixels++
+	}
+
+	ranked := make([]leaderboardEntry, 0, len(counts))
+	for _, e := range counts {
+		ranked = append(ranked, *e)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Pixels != ranked[j].Pixels {
+			return ranked[i].Pixels > ranked[j].Pixels
+		}
+		return ranked[i].UserID < ranked[j].UserID
+	})
+
+	start := page * leaderboardPageSize
+	if start >= len(ranked) {
+		return nil, false, nil
+	}
+	end := start + leaderboardPageSize
+	if end > len(ranked) {
+		end = len(ranked)
+	}
+	return ranked[start:end], end < len(ranked), nil
+}
+
+// buildLeaderboardMessage renders a page as an embed with Previous/Next buttons
+func buildLeaderboardMessage(window string, page int, entries []leaderboardEntry, hasNext bool) map[string]interface{} {
+	title := "Leaderboard (all time)"
+	if window == leaderboardWindowDay {
+		title = "Leaderboard (last 24 hours)"
+	}
+
+	description := "No pixels placed yet."
+	if len(entries) > 0 {
+		lines := make([]string, 0, len(entries))
+		for i, e := range entries {
+			name := e.Username
+			if name == "" {
+				name = e.UserID
+			}
+			lines = append(lines, fmt.Sprintf("**#%d** %s: %d pixels", page*leaderboardPageSize+i+1, name, e.Pixels))
+		}
+		description = strings.Join(lines, "\n")
+	}
+
+	now := time.Now()
+	return map[string]interface{}{
+		"embeds": []map[string]interface{}{{
+			"title":       title,
+			"description": description,
+			"color":       0xF1C40F,
+			"footer":      map[string]string{"text": fmt.Sprintf("Page %d", page+1)},
+		}},
+		"components": []map[string]interface{}{{
+			"type": 1,
+			"components": []map[string]interface{}{
+				{"type": 2, "style": 2, "label": "Previous", "custom_id": leaderboardButtonID(window, page-1, now), "disabled": page == 0},
+				{"type": 2, "style": 2, "label": "Next", "custom_id": leaderboardButtonID(window, page+1, now), "disabled": !hasNext},
+			},
+		}},
+	}
+}
+
+// handleLeaderboardCommand answers /leaderboard [window] with the first page.
+func handleLeaderboardCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "handleLeaderboardCommand")
+	defer span.End()
+
+	window := leaderboardWindowAll
+	for _, opt := range interaction.Data.Options {
+		if opt.Name == "window" && fmt.Sprintf("%v", opt.Value) == leaderboardWindowDay {
+			window = leaderboardWindowDay
+		}
+	}
+	span.SetAttributes(attribute.String("leaderboard.window", window))
+
+	entries, hasNext, err := getLeaderboardPage(ctx, window, 0)
+	if err != nil {
+		sendFollowUp(interaction.ApplicationID, interaction.Token, "Failed to load the leaderboard.")
+		return err
+	}
+	msg := buildLeaderboardMessage(window, 0, entries, hasNext)
+	return discordClient.FollowUp(ctx, interaction.ApplicationID, interaction.Token, discord.Message{
+		Embeds:     msg["embeds"].([]map[string]interface{}),
+		Components: msg["components"].([]map[string]interface{}),
+	})
+}
+
+// leaderboardPageUpdate builds the UPDATE_MESSAGE data for a button click
+func leaderboardPageUpdate(ctx context.Context, customID string) map[string]interface{} {
+	expired := map[string]interface{}{
+		"content":    "This leaderboard view expired. Run /leaderboard again.",
+		"embeds":     []interface{}{},
+		"components": []interface{}{},
+	}
+
+	window, page, issued, ok := parseLeaderboardButtonID(customID)
+	if !ok || time.Since(issued) > leaderboardViewTTL {
+		return expired
+	}
+
+	entries, hasNext, err := getLeaderboardPage(ctx, window, page)
+	if err != nil {
+		return map[string]interface{}{
+			"content":    "Failed to load the leaderboard.",
+			"embeds":     []interface{}{},
+			"components": []interface{}{},
+		}
+	}
+	return buildLeaderboardMessage(window, page, entries, hasNext)
+}
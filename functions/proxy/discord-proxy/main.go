@@ -1,7 +1,6 @@
 package discordproxy
 
 import (
-	"bytes"
 	"context"
 	"crypto/ed25519"
 	"encoding/hex"
@@ -9,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
@@ -16,6 +16,7 @@ import (
 	"sync"
 	"time"
 
+	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"go.opentelemetry.io/otel"
@@ -25,27 +26,37 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
+
+	"github.com/team11/discord-proxy/internal/audit"
+	"github.com/team11/discord-proxy/internal/coords"
+	"github.com/team11/discord-proxy/internal/discord"
+	"github.com/team11/discord-proxy/internal/messages"
+	"github.com/team11/discord-proxy/internal/sampling"
 )
 
 var (
 	projectID           string
 	discordPublicKey    ed25519.PublicKey
 	discordBotToken     string
+	discordClient       *discord.Client
 	pixelEventsTopic    string
 	snapshotEventsTopic string
 	sessionEventsTopic  string
 	adminRoleIDs        []string
+	allowedChannelIDs   []string
 	pubsubClient        *pubsub.Client
 	pubsubOnce          sync.Once
+	firestoreClient     *firestore.Client
+	firestoreOnce       sync.Once
 	tracer              trace.Tracer
 	tracerProvider      *sdktrace.TracerProvider
 )
 
-const discordAPIEndpoint = "https://discord.com/api/v10"
-
 func init() {
 	projectID = os.Getenv("PROJECT_ID")
-	discordBotToken = strings.TrimSpace(os.Getenv("DISCORD_BOT_TOKEN"))
+	// Either may come from Secret Manager instead, see secretOrEnv
+	discordBotToken = secretOrEnv(context.Background(), "DISCORD_BOT_TOKEN")
+	discordClient = discord.New(discordBotToken)
 	pixelEventsTopic = envOrDefault("PIXEL_EVENTS_TOPIC", "pixel-events")
 	snapshotEventsTopic = envOrDefault("SNAPSHOT_EVENTS_TOPIC", "snapshot-events")
 	sessionEventsTopic = envOrDefault("SESSION_EVENTS_TOPIC", "session-events")
@@ -54,9 +65,19 @@ func init() {
 		adminRoleIDs = strings.Split(roleIDs, ",")
 	}
 
-	if keyHex := strings.TrimSpace(os.Getenv("DISCORD_PUBLIC_KEY")); keyHex != "" {
+	if channelIDs := os.Getenv("ALLOWED_CHANNEL_IDS"); channelIDs != "" {
+		for _, id := range strings.Split(channelIDs, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				allowedChannelIDs = append(allowedChannelIDs, id)
+			}
+		}
+	}
+
+	if keyHex := secretOrEnv(context.Background(), "DISCORD_PUBLIC_KEY"); keyHex != "" {
+		// ed25519.Verify panics on a key of the wrong length; without a key
+		// every request is refused instead
 		keyBytes, err := hex.DecodeString(keyHex)
-		if err == nil {
+		if err == nil && len(keyBytes) == ed25519.PublicKeySize {
 			discordPublicKey = ed25519.PublicKey(keyBytes)
 		}
 	}
@@ -72,6 +93,7 @@ func init() {
 		tracerProvider = sdktrace.NewTracerProvider(
 			sdktrace.WithBatcher(exporter),
 			sdktrace.WithResource(res),
+			sdktrace.WithSampler(sampling.New(sampling.Ratio())),
 		)
 		otel.SetTracerProvider(tracerProvider)
 	}
@@ -88,6 +110,11 @@ func init() {
 		},
 	})))
 
+	if secretsErr != nil {
+		slog.Error("config_invalid", "error", secretsErr.Error())
+	}
+	checkBotToken()
+
 	functions.HTTP("handler", Handler)
 }
 
@@ -98,6 +125,24 @@ func getPubsubClient() *pubsub.Client {
 	return pubsubClient
 }
 
+func getFirestoreClient() *firestore.Client {
+	firestoreOnce.Do(func() {
+		firestoreClient, _ = firestore.NewClientWithDatabase(context.Background(), projectID, "team11-database")
+	})
+	return firestoreClient
+}
+
+// auditDenied records an admin action refused by the proxy itself
+func auditDenied(ctx context.Context, interaction Interaction, action, target string) {
+	audit.Record(ctx, getFirestoreClient(), audit.Entry{
+		ActorID:   interaction.Member.User.ID,
+		ActorName: interaction.Member.User.Username,
+		Action:    action,
+		Target:    target,
+		Params:    map[string]interface{}{"denied": true, "reason": "not_admin"},
+	})
+}
+
 func envOrDefault(key, defaultVal string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -107,22 +152,56 @@ func envOrDefault(key, defaultVal string) string {
 
 // Discord types
 type Interaction struct {
+	ID            string          `json:"id"`
 	Type          int             `json:"type"`
 	Data          InteractionData `json:"data"`
 	Member        Member          `json:"member"`
 	Token         string          `json:"token"`
 	ApplicationID string          `json:"application_id"`
 	ChannelID     string          `json:"channel_id"`
+	GuildID       string          `json:"guild_id"`
+	// Set instead of Member in a DM with the bot
+	DMUser *User `json:"user"`
+}
+
+// User is the invoking user, in a server or in a DM
+func (i Interaction) User() User {
+	if i.inDM() {
+		return *i.DMUser
+	}
+	return i.Member.User
+}
+
+// inDM reports whether the interaction comes from a DM with the bot, which
+// has no member: no roles, no guild and no channel from the allowlist
+func (i Interaction) inDM() bool {
+	return i.Member.User.ID == "" && i.DMUser != nil
 }
 
 type InteractionData struct {
-	Name    string   `json:"name"`
-	Options []Option `json:"options"`
+	Name     string   `json:"name"`
+	Options  []Option `json:"options"`
+	CustomID string   `json:"custom_id"`
+	Resolved Resolved `json:"resolved"`
+}
+
+// Resolved holds the objects that options refer to by ID
+type Resolved struct {
+	Attachments map[string]Attachment `json:"attachments"`
+}
+
+type Attachment struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+	Size     int    `json:"size"`
 }
 
 type Option struct {
-	Name  string      `json:"name"`
-	Value interface{} `json:"value"`
+	Name    string      `json:"name"`
+	Type    int         `json:"type"`
+	Value   interface{} `json:"value"`
+	Options []Option    `json:"options"`
 }
 
 type Member struct {
@@ -159,27 +238,28 @@ func isAdmin(member Member) bool {
 	return false
 }
 
-func sendFollowUp(applicationID, token, content string) error {
-	url := fmt.Sprintf("%s/webhooks/%s/%s", discordAPIEndpoint, applicationID, token)
-	payload, _ := json.Marshal(map[string]string{"content": content})
-
-	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
-	if err != nil {
-		return err
+// isChannelAllowed reports whether commands may be used in the given channel.
+// An empty allowlist accepts every channel.
+func isChannelAllowed(channelID string) bool {
+	if len(allowedChannelIDs) == 0 {
+		return true
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bot "+discordBotToken)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("discord API request failed: %w", err)
+	for _, id := range allowedChannelIDs {
+		if id == channelID {
+			return true
+		}
 	}
-	defer resp.Body.Close()
+	return false
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("discord API error: %d", resp.StatusCode)
-	}
-	return nil
+func sendFollowUp(applicationID, token, content string) error {
+	return discordClient.FollowUp(context.Background(), applicationID, token, discord.Message{Content: content})
+}
+
+func sendFollowUpEmbed(applicationID, token string, embed map[string]interface{}) error {
+	return discordClient.FollowUp(context.Background(), applicationID, token, discord.Message{
+		Embeds: []map[string]interface{}{embed},
+	})
 }
 
 func publishMessage(ctx context.Context, topicName string, data interface{}, attrs map[string]string) error {
@@ -188,10 +268,14 @@ func publishMessage(ctx context.Context, topicName string, data interface{}, att
 		return err
 	}
 
-	// Propagate trace context via attributes
-	if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
-		attrs["traceId"] = span.SpanContext().TraceID().String()
-		attrs["spanId"] = span.SpanContext().SpanID().String()
+	// Propagate trace context via attributes. An unsampled trace is not
+	// exported, so workers start their own instead of joining it.
+	if sc := trace.SpanFromContext(ctx).SpanContext(); sc.IsValid() && sc.IsSampled() {
+		attrs["traceId"] = sc.TraceID().String()
+		attrs["spanId"] = sc.SpanID().String()
+	}
+	if sampling.Forced(ctx) {
+		attrs[sampling.Attribute] = "true"
 	}
 
 	topic := getPubsubClient().Topic(topicName)
@@ -209,17 +293,49 @@ func routeCanvasCommand(ctx context.Context, interaction Interaction) error {
 	ctx, span = tracer.Start(ctx, "routeCanvasCommand")
 	defer span.End()
 
-	messageData := map[string]interface{}{
-		"action":           "status",
-		"userId":           interaction.Member.User.ID,
-		"username":         interaction.Member.User.Username,
-		"interactionToken": interaction.Token,
-		"applicationId":    interaction.ApplicationID,
-		"timestamp":        time.Now().UTC().Format(time.RFC3339),
+	// /canvas view:colors renders a color chart in the snapshot worker
+	if canvasView(interaction) == "colors" {
+		return publishMessage(ctx, snapshotEventsTopic, messages.ColorChartRequest{
+			UserID:           interaction.Member.User.ID,
+			InteractionToken: interaction.Token,
+			ApplicationID:    interaction.ApplicationID,
+			Timestamp:        time.Now().UTC().Format(time.RFC3339),
+		}, map[string]string{
+			"type": messages.TypeColorChart,
+		})
+	}
+	// /canvas view:owners renders an ownership map in the snapshot worker
+	if canvasView(interaction) == "owners" {
+		return publishMessage(ctx, snapshotEventsTopic, messages.OwnershipMapRequest{
+			UserID:           interaction.Member.User.ID,
+			InteractionToken: interaction.Token,
+			ApplicationID:    interaction.ApplicationID,
+			Timestamp:        time.Now().UTC().Format(time.RFC3339),
+		}, map[string]string{
+			"type": messages.TypeOwnershipMap,
+		})
+	}
+	// /canvas view:region sums up one area in the snapshot worker
+	if canvasView(interaction) == "region" {
+		return routeRegionStats(ctx, interaction)
+	}
+
+	// /canvas view:grid describes the session's grid in the session worker
+	action := "status"
+	if canvasView(interaction) == "grid" {
+		action = "grid"
+	}
+	messageData := messages.SessionCommand{
+		Action:           action,
+		UserID:           interaction.Member.User.ID,
+		Username:         interaction.Member.User.Username,
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
 	}
 
 	return publishMessage(ctx, sessionEventsTopic, messageData, map[string]string{
-		"type": "session_command",
+		"type": messages.TypeSessionCommand,
 	})
 }
 
@@ -233,8 +349,17 @@ func routeDrawCommand(ctx context.Context, interaction Interaction) error {
 		options[opt.Name] = opt.Value
 	}
 
-	x, _ := toInt(options["x"])
-	y, _ := toInt(options["y"])
+	x, errX := toCoordinate(options["x"])
+	y, errY := toCoordinate(options["y"])
+	if errX != nil || errY != nil {
+		slog.Warn("invalid_draw_coordinates",
+			"x_type", fmt.Sprintf("%T", options["x"]),
+			"y_type", fmt.Sprintf("%T", options["y"]),
+			"user_id", interaction.Member.User.ID,
+		)
+		sendFollowUp(interaction.ApplicationID, interaction.Token, "Invalid coordinates: X and Y must be integers.")
+		return fmt.Errorf("invalid draw coordinates: x=%v (%T), y=%v (%T)", options["x"], options["x"], options["y"], options["y"])
+	}
 	color := strings.TrimPrefix(fmt.Sprintf("%v", options["color"]), "#")
 	color = strings.ToUpper(color)
 
@@ -246,21 +371,24 @@ func routeDrawCommand(ctx context.Context, interaction Interaction) error {
 		)
 	}
 
-	messageData := map[string]interface{}{
-		"x":                x,
-		"y":                y,
-		"color":            color,
-		"userId":           interaction.Member.User.ID,
-		"username":         interaction.Member.User.Username,
-		"source":           "discord",
-		"interactionToken": interaction.Token,
-		"applicationId":    interaction.ApplicationID,
-		"timestamp":        time.Now().UTC().Format(time.RFC3339),
+	messageData := messages.PixelEvent{
+		X:                x,
+		Y:                y,
+		Color:            color,
+		UserID:           interaction.Member.User.ID,
+		Username:         interaction.Member.User.Username,
+		Source:           "discord",
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+		IsAdmin:          isAdmin(interaction.Member),
+		GuildID:          interaction.GuildID,
 	}
 
 	return publishMessage(ctx, pixelEventsTopic, messageData, map[string]string{
-		"type":   "pixel_placement",
-		"source": "discord",
+		"type":                          messages.TypePixelPlacement,
+		"source":                        "discord",
+		messages.SchemaVersionAttribute: strconv.Itoa(messages.PixelEventSchemaVersion),
 	})
 }
 
@@ -269,69 +397,620 @@ func routeSnapshotCommand(ctx context.Context, interaction Interaction) error {
 	ctx, span = tracer.Start(ctx, "routeSnapshotCommand")
 	defer span.End()
 
+	options := make(map[string]interface{})
+	for _, opt := range interaction.Data.Options {
+		options[opt.Name] = opt.Value
+	}
+
+	if options["verify"] == true {
+		if !isAdmin(interaction.Member) {
+			auditDenied(ctx, interaction, "snapshot.verify", "snapshots")
+			return sendFollowUp(interaction.ApplicationID, interaction.Token, "You do not have permission to verify snapshots.")
+		}
+		verify := messages.SnapshotVerifyRequest{
+			Repair:           options["repair"] == true,
+			UserID:           interaction.Member.User.ID,
+			Username:         interaction.Member.User.Username,
+			InteractionToken: interaction.Token,
+			ApplicationID:    interaction.ApplicationID,
+			Timestamp:        time.Now().UTC().Format(time.RFC3339),
+		}
+		// The snapshot's Unix ms timestamp, as in its folder name; default latest
+		if raw, ok := options["snapshot"]; ok {
+			ts, err := toInt(raw)
+			if err != nil || ts <= 0 {
+				return sendFollowUp(interaction.ApplicationID, interaction.Token, "snapshot must be a snapshot timestamp, e.g. 1767225600000.")
+			}
+			verify.SnapshotTimestamp = int64(ts)
+		}
+		return publishMessage(ctx, snapshotEventsTopic, verify, map[string]string{
+			"type": messages.TypeSnapshotVerify,
+		})
+	}
+
 	if !isAdmin(interaction.Member) {
+		auditDenied(ctx, interaction, "snapshot.create", "canvas")
 		return sendFollowUp(interaction.ApplicationID, interaction.Token, "You do not have permission to create snapshots.")
 	}
 
-	messageData := map[string]interface{}{
-		"channelId":        interaction.ChannelID,
-		"userId":           interaction.Member.User.ID,
-		"username":         interaction.Member.User.Username,
-		"interactionToken": interaction.Token,
-		"applicationId":    interaction.ApplicationID,
-		"timestamp":        time.Now().UTC().Format(time.RFC3339),
+	messageData := messages.SnapshotRequest{
+		ChannelID:        interaction.ChannelID,
+		UserID:           interaction.Member.User.ID,
+		Username:         interaction.Member.User.Username,
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+	}
+	messageData.Zones = options["zones"] == true
+	messageData.Layered = options["layered"] == true
+	if raw, ok := options["thumbnail_size"]; ok {
+		size, err := toInt(raw)
+		if err != nil || size < 100 || size > 4096 {
+			return sendFollowUp(interaction.ApplicationID, interaction.Token, "thumbnail_size must be between 100 and 4096.")
+		}
+		messageData.ThumbnailSize = size
 	}
 
 	return publishMessage(ctx, snapshotEventsTopic, messageData, map[string]string{
-		"type": "snapshot_request",
+		"type": messages.TypeSnapshotRequest,
 	})
 }
 
-func routeSessionCommand(ctx context.Context, interaction Interaction) error {
+// routeSnapshotRegionCommand hands /snapshot-region x1 y1 x2 y2 to the
+// snapshot worker, which checks the box against the canvas.
+func routeSnapshotRegionCommand(ctx context.Context, interaction Interaction) error {
 	var span trace.Span
-	ctx, span = tracer.Start(ctx, "routeSessionCommand")
+	ctx, span = tracer.Start(ctx, "routeSnapshotRegionCommand")
 	defer span.End()
 
 	if !isAdmin(interaction.Member) {
-		return sendFollowUp(interaction.ApplicationID, interaction.Token, "You do not have permission to manage sessions.")
+		auditDenied(ctx, interaction, "snapshot.region", "canvas")
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "You do not have permission to create snapshots.")
+	}
+
+	corners := make(map[string]int)
+	for _, opt := range interaction.Data.Options {
+		if opt.Name == "debug" {
+			continue
+		}
+		v, err := toInt(opt.Value)
+		if err != nil || v < 0 {
+			return sendFollowUp(interaction.ApplicationID, interaction.Token, "Region corners must be non-negative integers.")
+		}
+		corners[opt.Name] = v
+	}
+
+	messageData := messages.SnapshotRegionRequest{
+		MinX:             min(corners["x1"], corners["x2"]),
+		MinY:             min(corners["y1"], corners["y2"]),
+		MaxX:             max(corners["x1"], corners["x2"]),
+		MaxY:             max(corners["y1"], corners["y2"]),
+		UserID:           interaction.Member.User.ID,
+		Username:         interaction.Member.User.Username,
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+	}
+	span.SetAttributes(
+		attribute.Int("region.min_x", messageData.MinX),
+		attribute.Int("region.min_y", messageData.MinY),
+		attribute.Int("region.max_x", messageData.MaxX),
+		attribute.Int("region.max_y", messageData.MaxY),
+	)
+
+	return publishMessage(ctx, snapshotEventsTopic, messageData, map[string]string{
+		"type": messages.TypeSnapshotRegion,
+	})
+}
+
+// routeRegionStats publishes /canvas view:region, which needs all four
+// corners
+func routeRegionStats(ctx context.Context, interaction Interaction) error {
+	corners := make(map[string]int)
+	for _, opt := range interaction.Data.Options {
+		switch opt.Name {
+		case "x1", "y1", "x2", "y2":
+			v, err := toInt(opt.Value)
+			if err != nil || v < 0 {
+				return sendFollowUp(interaction.ApplicationID, interaction.Token, "Region corners must be non-negative integers.")
+			}
+			corners[opt.Name] = v
+		}
+	}
+	if len(corners) != 4 {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Give the region's corners with x1, y1, x2 and y2.")
+	}
+
+	return publishMessage(ctx, snapshotEventsTopic, messages.RegionStatsRequest{
+		MinX:             min(corners["x1"], corners["x2"]),
+		MinY:             min(corners["y1"], corners["y2"]),
+		MaxX:             max(corners["x1"], corners["x2"]),
+		MaxY:             max(corners["y1"], corners["y2"]),
+		UserID:           interaction.Member.User.ID,
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+	}, map[string]string{
+		"type": messages.TypeRegionStats,
+	})
+}
+
+func routeTileCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "routeTileCommand")
+	defer span.End()
+
+	messageData := messages.TileRequest{
+		UserID:           interaction.Member.User.ID,
+		Username:         interaction.Member.User.Username,
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+	}
+
+	// Option names map onto the worker's request fields
+	fields := map[string]**int{"tile_x": &messageData.TileX, "tile_y": &messageData.TileY, "x": &messageData.X, "y": &messageData.Y}
+	for _, opt := range interaction.Data.Options {
+		if field, ok := fields[opt.Name]; ok {
+			if v, err := toInt(opt.Value); err == nil {
+				*field = &v
+			}
+		}
+	}
+
+	return publishMessage(ctx, snapshotEventsTopic, messageData, map[string]string{
+		"type": messages.TypeTileRequest,
+	})
+}
+
+func routeHistoryCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "routeHistoryCommand")
+	defer span.End()
+
+	messageData := messages.PixelHistoryRequest{
+		UserID:           interaction.Member.User.ID,
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
 	}
+	for _, opt := range interaction.Data.Options {
+		v, err := toInt(opt.Value)
+		if err != nil {
+			continue
+		}
+		switch opt.Name {
+		case "x":
+			messageData.X = v
+		case "y":
+			messageData.Y = v
+		}
+	}
+
+	return publishMessage(ctx, snapshotEventsTopic, messageData, map[string]string{
+		"type": messages.TypePixelHistory,
+	})
+}
+
+// sessionAction returns the "action" option of /session, empty for other
+// commands
+func sessionAction(interaction Interaction) string {
+	if interaction.Data.Name != "session" || len(interaction.Data.Options) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v", interaction.Data.Options[0].Value)
+}
+
+// isSessionExport reports whether the interaction is /session action:export,
+// whose link to the whole canvas only the invoking admin should see
+func isSessionExport(interaction Interaction) bool {
+	return sessionAction(interaction) == "export"
+}
+
+func routeSessionCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "routeSessionCommand")
+	defer span.End()
 
 	// Get the action value from the "action" option (STRING type with choices)
 	action := fmt.Sprintf("%v", interaction.Data.Options[0].Value)
 
+	if !isAdmin(interaction.Member) {
+		auditDenied(ctx, interaction, "session."+action, "current")
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "You do not have permission to manage sessions.")
+	}
+
 	if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
 		span.SetAttributes(attribute.String("session.action", action))
 	}
 
-	messageData := map[string]interface{}{
-		"action":           action,
-		"userId":           interaction.Member.User.ID,
-		"username":         interaction.Member.User.Username,
-		"interactionToken": interaction.Token,
-		"applicationId":    interaction.ApplicationID,
-		"timestamp":        time.Now().UTC().Format(time.RFC3339),
+	// Exports read the whole canvas, which is the snapshot worker's job
+	if action == "export" {
+		return publishMessage(ctx, snapshotEventsTopic, messages.SessionExportRequest{
+			UserID:           interaction.Member.User.ID,
+			Username:         interaction.Member.User.Username,
+			InteractionToken: interaction.Token,
+			ApplicationID:    interaction.ApplicationID,
+			Timestamp:        time.Now().UTC().Format(time.RFC3339),
+		}, map[string]string{
+			"type": messages.TypeSessionExport,
+		})
+	}
+
+	messageData := messages.SessionCommand{
+		Action:           action,
+		ChannelID:        interaction.ChannelID,
+		UserID:           interaction.Member.User.ID,
+		Username:         interaction.Member.User.Username,
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
 	}
 
-	// Extract optional width and height parameters (for "start" action)
+	// Extract optional width, height, bucket, origin and grid parameters (for "start" action)
 	if action == "start" && len(interaction.Data.Options) > 1 {
 		for _, option := range interaction.Data.Options[1:] {
 			if option.Name == "width" {
 				if width, err := toInt(option.Value); err == nil && width >= 10 && width <= 100000 {
-					messageData["canvasWidth"] = width
+					messageData.CanvasWidth = width
 				}
 			} else if option.Name == "height" {
 				if height, err := toInt(option.Value); err == nil && height >= 10 && height <= 100000 {
-					messageData["canvasHeight"] = height
+					messageData.CanvasHeight = height
 				}
+			} else if option.Name == "snapshots_bucket" {
+				// Checked against the allowlist by the snapshot worker
+				messageData.SnapshotsBucket = strings.TrimSpace(fmt.Sprintf("%v", option.Value))
+			} else if option.Name == "origin" {
+				if origin, ok := coords.Parse(fmt.Sprintf("%v", option.Value)); ok {
+					messageData.Origin = string(origin)
+				}
+			} else if option.Name == "grid_snap" {
+				if snap, err := toInt(option.Value); err == nil && snap > 1 && snap <= 100 {
+					messageData.GridSnap = snap
+				}
+			}
+		}
+	}
+
+	if action == "stop" {
+		for _, option := range interaction.Data.Options[1:] {
+			if option.Name == "purge" {
+				messageData.Purge, _ = option.Value.(bool)
 			}
 		}
 	}
 
+	if action == "schedule" {
+		if errMsg := parseScheduleOptions(interaction.Data.Options[1:], &messageData); errMsg != "" {
+			return sendFollowUp(interaction.ApplicationID, interaction.Token, errMsg)
+		}
+	}
+
 	return publishMessage(ctx, sessionEventsTopic, messageData, map[string]string{
-		"type": "session_command",
+		"type": messages.TypeSessionCommand,
 	})
 }
 
+// routeVerifyCommand hands /verify [repair] to the session worker, which
+// compares pixels against pixel_history in time-bounded passes.
+func routeVerifyCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "routeVerifyCommand")
+	defer span.End()
+
+	if !isAdmin(interaction.Member) {
+		auditDenied(ctx, interaction, "canvas.verify", "pixels")
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "You do not have permission to verify the canvas.")
+	}
+
+	repair := false
+	for _, opt := range interaction.Data.Options {
+		if opt.Name == "repair" {
+			repair, _ = opt.Value.(bool)
+		}
+	}
+	span.SetAttributes(attribute.Bool("verify.repair", repair))
+
+	messageData := messages.SessionCommand{
+		Action:           "verify",
+		Repair:           repair,
+		ChannelID:        interaction.ChannelID,
+		UserID:           interaction.Member.User.ID,
+		Username:         interaction.Member.User.Username,
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+	}
+
+	return publishMessage(ctx, sessionEventsTopic, messageData, map[string]string{
+		"type": messages.TypeSessionCommand,
+	})
+}
+
+// parseScheduleOptions reads opens_at, closes_at and closed_message for
+// /session schedule into cmd. Times must be RFC 3339 and are normalized to
+// UTC; the value "clear" removes a bound. Returns a user-facing error message
+// when the options are invalid.
+func parseScheduleOptions(options []Option, cmd *messages.SessionCommand) string {
+	var opens, closes time.Time
+	for _, option := range options {
+		value := strings.TrimSpace(fmt.Sprintf("%v", option.Value))
+		switch option.Name {
+		case "opens_at", "closes_at":
+			field, bound := &cmd.OpensAt, &opens
+			if option.Name == "closes_at" {
+				field, bound = &cmd.ClosesAt, &closes
+			}
+			if strings.EqualFold(value, "clear") {
+				cleared := ""
+				*field = &cleared
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return fmt.Sprintf("Invalid %s: use RFC 3339, e.g. 2026-06-01T18:00:00Z, or \"clear\"", option.Name)
+			}
+			*bound = t.UTC()
+			formatted := bound.Format(time.RFC3339)
+			*field = &formatted
+		case "closed_message":
+			cmd.ClosedMessage = &value
+		}
+	}
+	if cmd.OpensAt == nil && cmd.ClosesAt == nil && cmd.ClosedMessage == nil {
+		return "Give opens_at, closes_at or closed_message to schedule the canvas."
+	}
+	if !opens.IsZero() && !closes.IsZero() && !closes.After(opens) {
+		return "closes_at must be after opens_at."
+	}
+	return ""
+}
+
+func routeMyDataCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "routeMyDataCommand")
+	defer span.End()
+
+	subcommand := ""
+	if len(interaction.Data.Options) > 0 {
+		subcommand = interaction.Data.Options[0].Name
+	}
+	if subcommand != "export" {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, fmt.Sprintf("Unknown subcommand: %s", subcommand))
+	}
+
+	messageData := messages.DataExportRequest{
+		UserID:           interaction.Member.User.ID,
+		Username:         interaction.Member.User.Username,
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+	}
+
+	return publishMessage(ctx, snapshotEventsTopic, messageData, map[string]string{
+		"type": messages.TypeUserDataExport,
+	})
+}
+
+// myDataDeleteTarget returns whose data /mydata delete targets: the invoker,
+// or the user passed in the optional "user" option (admins only).
+func myDataDeleteTarget(interaction Interaction) (string, bool) {
+	target := myDataDeleteOption(interaction)
+	if target != interaction.Member.User.ID && !isAdmin(interaction.Member) {
+		return "", false
+	}
+	return target, true
+}
+
+// myDataDeleteOption returns the "user" option of /mydata delete, defaulting
+// to the invoker
+func myDataDeleteOption(interaction Interaction) string {
+	if len(interaction.Data.Options) > 0 {
+		for _, opt := range interaction.Data.Options[0].Options {
+			if opt.Name == "user" {
+				return fmt.Sprintf("%v", opt.Value)
+			}
+		}
+	}
+	return interaction.Member.User.ID
+}
+
+// sendDeletePrompt answers /mydata delete with an ephemeral confirmation button
+func sendDeletePrompt(ctx context.Context, w http.ResponseWriter, interaction Interaction) {
+	target, ok := myDataDeleteTarget(interaction)
+	if !ok {
+		auditDenied(ctx, interaction, "user_data.delete", myDataDeleteOption(interaction))
+		sendEphemeral(w, "You do not have permission to delete another user's data.")
+		return
+	}
+
+	whose := "your"
+	if target != interaction.Member.User.ID {
+		whose = fmt.Sprintf("<@%s>'s", target)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type": 4,
+		"data": map[string]interface{}{
+			"flags": 64,
+			"content": fmt.Sprintf("This permanently deletes %s profile and rate-limit data and removes attribution from %s pixels. The pixels themselves stay on the canvas. Continue?",
+				whose, whose),
+			"components": []map[string]interface{}{{
+				"type": 1,
+				"components": []map[string]interface{}{
+					{"type": 2, "style": 4, "label": "Delete data", "custom_id": "mydata_delete_confirm:" + target},
+					{"type": 2, "style": 2, "label": "Cancel", "custom_id": "mydata_delete_cancel"},
+				},
+			}},
+		},
+	})
+}
+
+// handleComponent processes button clicks (interaction type 3)
+func handleComponent(ctx context.Context, w http.ResponseWriter, interaction Interaction) {
+	customID := interaction.Data.CustomID
+
+	updateMessage := func(content string) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"type": 7,
+			"data": map[string]interface{}{
+				"content":    content,
+				"components": []interface{}{},
+			},
+		})
+	}
+
+	switch {
+	case customID == "mydata_delete_cancel":
+		updateMessage("Data deletion cancelled.")
+
+	case strings.HasPrefix(customID, "mydata_delete_confirm:"):
+		target := strings.TrimPrefix(customID, "mydata_delete_confirm:")
+		if target != interaction.Member.User.ID && !isAdmin(interaction.Member) {
+			auditDenied(ctx, interaction, "user_data.delete", target)
+			updateMessage("You do not have permission to delete another user's data.")
+			return
+		}
+		updateMessage("Deleting data... you will get a message when it is done.")
+
+		messageData := messages.DataDeletionRequest{
+			UserID:           target,
+			RequestedBy:      interaction.Member.User.ID,
+			RequestedByName:  interaction.Member.User.Username,
+			InteractionToken: interaction.Token,
+			ApplicationID:    interaction.ApplicationID,
+			Timestamp:        time.Now().UTC().Format(time.RFC3339),
+		}
+		if err := publishMessage(ctx, snapshotEventsTopic, messageData, map[string]string{
+			"type": messages.TypeUserDataDelete,
+		}); err != nil {
+			slog.Error("command_failed", "command", "mydata_delete", "error", err.Error())
+		}
+
+	case customID == canvasClearCancelID:
+		updateMessage("Canvas clear cancelled.")
+
+	case customID == canvasClearConfirmID:
+		if !isAdmin(interaction.Member) {
+			auditDenied(ctx, interaction, "canvas.clear", "pixels")
+			updateMessage("You do not have permission to clear the canvas.")
+			return
+		}
+		updateMessage("Clearing the canvas... a backup snapshot is taken first, you will get a message when it is done.")
+
+		if err := publishCanvasClear(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "canvas_clear", "error", err.Error())
+		}
+
+	case strings.HasPrefix(customID, sessionImportCancelPrefix):
+		cancelSessionImport(ctx, strings.TrimPrefix(customID, sessionImportCancelPrefix))
+		updateMessage("Session import cancelled.")
+
+	case strings.HasPrefix(customID, sessionImportConfirmPrefix):
+		if !isAdmin(interaction.Member) {
+			auditDenied(ctx, interaction, "session.import", "current")
+			updateMessage("You do not have permission to import a session.")
+			return
+		}
+		updateMessage("Importing the session... placements are refused until it is done, you will get a message then.")
+
+		if err := publishSessionImport(ctx, interaction, strings.TrimPrefix(customID, sessionImportConfirmPrefix)); err != nil {
+			slog.Error("command_failed", "command", "session_import", "error", err.Error())
+		}
+
+	case strings.HasPrefix(customID, leaderboardButtonPrefix):
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"type": 7,
+			"data": leaderboardPageUpdate(ctx, customID),
+		})
+
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"type": 6})
+	}
+}
+
+const auditRecentLimit = 10
+
+// handleAuditCommand answers /audit recent directly from Firestore; there is
+// no worker in between since it only reads a handful of documents.
+func handleAuditCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "handleAuditCommand")
+	defer span.End()
+
+	if !isAdmin(interaction.Member) {
+		auditDenied(ctx, interaction, "audit.view", "audit_log")
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "You do not have permission to view the audit log.")
+	}
+
+	subcommand := ""
+	if len(interaction.Data.Options) > 0 {
+		subcommand = interaction.Data.Options[0].Name
+	}
+	if subcommand != "recent" {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, fmt.Sprintf("Unknown subcommand: %s", subcommand))
+	}
+
+	client := getFirestoreClient()
+	if client == nil {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Audit log is unavailable.")
+	}
+	entries, err := audit.Recent(ctx, client, auditRecentLimit)
+	if err != nil {
+		sendFollowUp(interaction.ApplicationID, interaction.Token, "Failed to read the audit log.")
+		return err
+	}
+
+	return sendFollowUpEmbed(interaction.ApplicationID, interaction.Token, buildAuditEmbed(entries))
+}
+
+func buildAuditEmbed(entries []audit.Entry) map[string]interface{} {
+	if len(entries) == 0 {
+		return map[string]interface{}{
+			"title":       "Audit log",
+			"description": "No entries yet.",
+		}
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		actor := e.ActorName
+		if actor == "" {
+			actor = e.ActorID
+		}
+		line := fmt.Sprintf("`%s` **%s** %s", e.Timestamp, actor, e.Action)
+		if e.Target != "" {
+			line += " → " + e.Target
+		}
+		if denied, _ := e.Params["denied"].(bool); denied {
+			line += " (denied)"
+		}
+		lines = append(lines, line)
+	}
+
+	return map[string]interface{}{
+		"title":       fmt.Sprintf("Audit log: last %d entries", len(entries)),
+		"description": strings.Join(lines, "\n"),
+		"color":       0x5865F2,
+	}
+}
+
+// toCoordinate reads a /draw coordinate. Discord sends integer options as
+// JSON numbers; unlike toInt it refuses strings and fractions, which only a
+// broken client would send.
+func toCoordinate(v interface{}) (int, error) {
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) {
+		return 0, fmt.Errorf("coordinate must be an integer, got %T", v)
+	}
+	return int(f), nil
+}
+
 func toInt(v interface{}) (int, error) {
 	switch val := v.(type) {
 	case float64:
@@ -352,8 +1031,63 @@ func sendACK(w http.ResponseWriter) {
 	}
 }
 
+// sendEphemeralACK writes a deferred response (type 5) whose follow-ups are only
+// visible to the invoking user
+func sendEphemeralACK(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type": 5,
+		"data": map[string]int{"flags": 64},
+	})
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// sendEphemeral writes an immediate response (type 4) visible only to the invoking user
+func sendEphemeral(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type": 4,
+		"data": map[string]interface{}{
+			"content": content,
+			"flags":   64,
+		},
+	})
+}
+
+// traceFlushTimeout bounds the trace export at the end of a request, which
+// Cloud Functions bills as part of it
+const traceFlushTimeout = 2 * time.Second
+
+// flushTraces sends whatever response is buffered, then exports the
+// request's spans (required for serverless). The export gets its own timeout,
+// detached from the request context, so a slow exporter costs at most
+// traceFlushTimeout and never holds back the reply to Discord.
+func flushTraces(ctx context.Context, w http.ResponseWriter) {
+	if tracerProvider == nil {
+		return
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), traceFlushTimeout)
+	defer cancel()
+	if err := tracerProvider.ForceFlush(ctx); err != nil {
+		slog.Warn("trace_flush_failed", "error", err.Error())
+	}
+}
+
 func Handler(w http.ResponseWriter, r *http.Request) {
+	// Uptime checks are neither traced nor signed
+	if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/health") {
+		serveHealth(w, r)
+		return
+	}
+
 	ctx := r.Context()
+	// Deferred first so it runs after span.End and exports the request span
+	defer flushTraces(ctx, w)
 
 	// Start parent span for the request
 	var span trace.Span
@@ -365,6 +1099,12 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A secret that failed to load cannot fix itself on this instance
+	if secretsErr != nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
@@ -384,6 +1124,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid signature", http.StatusUnauthorized)
 		return
 	}
+	checkBotToken()
 
 	var interaction Interaction
 	if err := json.Unmarshal(bodyBytes, &interaction); err != nil {
@@ -398,6 +1139,40 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Everything below reads the member, e.g. for admin roles and to
+	// attribute placements; refused here rather than run for an empty user
+	if interaction.inDM() {
+		slog.Info("command_rejected",
+			"reason", "direct_message",
+			"command", interaction.Data.Name,
+			"user_id", interaction.User().ID,
+		)
+		sendEphemeral(w, "This command only works in a server.")
+		return
+	}
+
+	// Buttons are held to the allowlist like the commands that posted them
+	if !isChannelAllowed(interaction.ChannelID) {
+		slog.Warn("command_rejected",
+			"reason", "channel_not_allowed",
+			"command", interaction.Data.Name,
+			"custom_id", interaction.Data.CustomID,
+			"channel_id", interaction.ChannelID,
+		)
+		sendEphemeral(w, "Commands are not enabled in this channel.")
+		return
+	}
+
+	// Message components (buttons)
+	if interaction.Type == 3 {
+		slog.Info("component_received",
+			"custom_id", interaction.Data.CustomID,
+			"user_id", interaction.Member.User.ID,
+		)
+		handleComponent(ctx, w, interaction)
+		return
+	}
+
 	// Only handle application commands (type 2)
 	if interaction.Type != 2 {
 		w.Header().Set("Content-Type", "application/json")
@@ -413,6 +1188,12 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		"username", interaction.Member.User.Username,
 	)
 
+	if debugTraceRequested(interaction) {
+		var debugSpan trace.Span
+		ctx, debugSpan = startDebugTrace(ctx, commandName)
+		defer debugSpan.End()
+	}
+
 	// Add command attributes to span
 	if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
 		span.SetAttributes(
@@ -422,9 +1203,27 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		)
 	}
 
+	// Deletion needs an explicit confirmation before anything is published
+	if commandName == "mydata" && len(interaction.Data.Options) > 0 && interaction.Data.Options[0].Name == "delete" {
+		sendDeletePrompt(ctx, w, interaction)
+		return
+	}
+	if commandName == "canvas" && canvasView(interaction) == "clear" {
+		sendClearPrompt(ctx, w, interaction)
+		return
+	}
+	if sessionAction(interaction) == "import" {
+		sendImportPrompt(ctx, w, interaction)
+		return
+	}
+
 	// All commands: ACK with type 5, then publish to Pub/Sub
 	// Workers will send the follow-up message to Discord
-	sendACK(w)
+	if commandName == "mydata" || commandName == "audit" || commandName == "notify" || commandName == "nickname" || commandName == "color" || commandName == "protected" || isSessionExport(interaction) {
+		sendEphemeralACK(w)
+	} else {
+		sendACK(w)
+	}
 
 	switch commandName {
 	case "draw":
@@ -454,6 +1253,33 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+	case "tile":
+		if err := routeTileCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "tile", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "snapshot-region":
+		if err := routeSnapshotRegionCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "snapshot-region", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "history":
+		if err := routeHistoryCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "history", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
 	case "session":
 		if err := routeSessionCommand(ctx, interaction); err != nil {
 			slog.Error("command_failed", "command", "session", "error", err.Error())
@@ -462,10 +1288,113 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 				span.SetStatus(codes.Error, err.Error())
 			}
 		}
-	}
 
-	// Flush traces before function exits (required for serverless)
-	if tracerProvider != nil {
-		tracerProvider.ForceFlush(ctx)
+	case "zone":
+		if err := routeZoneCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "zone", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "verify":
+		if err := routeVerifyCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "verify", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "mydata":
+		if err := routeMyDataCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "mydata", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "leaderboard":
+		if err := handleLeaderboardCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "leaderboard", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "userstats":
+		if err := handleUserStatsCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "userstats", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "color":
+		if err := handleColorCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "color", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "protected":
+		if err := handleProtectedCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "protected", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "streak":
+		if err := handleStreakCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "streak", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "notify":
+		if err := handleNotifyCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "notify", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "nickname":
+		if err := handleNicknameCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "nickname", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "audit":
+		if err := handleAuditCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "audit", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+	case "import-pixels":
+		if err := handleImportPixelsCommand(ctx, interaction); err != nil {
+			slog.Error("command_failed", "command", "import-pixels", "error", err.Error())
+			if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
 	}
 }
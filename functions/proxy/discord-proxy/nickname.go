@@ -0,0 +1,112 @@
+package discordproxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Display names are echoed into Discord messages and embeds, so they follow
+// Discord's own username length limits
+const (
+	minDisplayNameLength = 2
+	maxDisplayNameLength = 32
+)
+
+// Mentions that would ping or link when a display name is echoed
+var displayNameMentionPatterns = []string{"@everyone", "@here", "<@", "<#"}
+
+// sanitizeDisplayName removes control characters, markdown and mentions, the
+// same as the pixel worker does for client-supplied usernames.
+func sanitizeDisplayName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune("*_~`", r) {
+			return -1
+		}
+		return r
+	}, s)
+
+	// Repeat until stable so removals cannot splice a new mention together
+	for {
+		prev := s
+		for _, p := range displayNameMentionPatterns {
+			s = strings.ReplaceAll(s, p, "")
+		}
+		if s == prev {
+			break
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+// userDisplayName is the name a users document is credited under: the
+// display name set with /nickname, else the Discord username.
+func userDisplayName(data map[string]interface{}) string {
+	if name, _ := data["displayName"].(string); name != "" {
+		return name
+	}
+	name, _ := data["username"].(string)
+	return name
+}
+
+// handleNicknameCommand answers /nickname [name], which sets or, without a
+// name, clears users.displayName. The pixel worker credits later placements
+// to it, and /leaderboard, /userstats and /streak show it instead of the
+// Discord username, which can change.
+func handleNicknameCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "handleNicknameCommand")
+	defer span.End()
+
+	raw := ""
+	for _, opt := range interaction.Data.Options {
+		if opt.Name == "name" {
+			raw = fmt.Sprintf("%v", opt.Value)
+		}
+	}
+	name := sanitizeDisplayName(raw)
+	clear := strings.TrimSpace(raw) == ""
+	userID := interaction.Member.User.ID
+	span.SetAttributes(
+		attribute.String("nickname.user_id", userID),
+		attribute.Bool("nickname.clear", clear),
+	)
+
+	if n := len([]rune(name)); !clear && (n < minDisplayNameLength || n > maxDisplayNameLength) {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token,
+			fmt.Sprintf("Display names must be %d to %d characters, not counting markdown or mentions.", minDisplayNameLength, maxDisplayNameLength))
+	}
+
+	client := getFirestoreClient()
+	if client == nil {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Display names are unavailable.")
+	}
+
+	var displayName interface{} = name
+	if clear {
+		displayName = firestore.Delete
+	}
+	// Merge, so users who have not drawn yet get a document with just their
+	// name and the display name
+	_, err := client.Collection("users").Doc(userID).Set(ctx, map[string]interface{}{
+		"id":          userID,
+		"username":    interaction.Member.User.Username,
+		"displayName": displayName,
+	}, firestore.MergeAll)
+	if err != nil {
+		sendFollowUp(interaction.ApplicationID, interaction.Token, "Failed to save the display name.")
+		return err
+	}
+
+	if clear {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token,
+			"Display name cleared. Your pixels are credited to your Discord username again.")
+	}
+	return sendFollowUp(interaction.ApplicationID, interaction.Token,
+		fmt.Sprintf("Your pixels are now credited to **%s**. Pixels placed earlier keep the name they were placed under.", name))
+}
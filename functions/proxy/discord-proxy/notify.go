@@ -0,0 +1,61 @@
+package discordproxy
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// handleNotifyCommand answers /notify on|off, which sets users.notifyOverwrites.
+// With it on, the snapshot worker DMs the user when others paint over their
+// pixels. Turning it on also clears the count of refused DMs that turns it
+// off automatically.
+func handleNotifyCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "handleNotifyCommand")
+	defer span.End()
+
+	setting := ""
+	for _, opt := range interaction.Data.Options {
+		if opt.Name == "setting" {
+			setting = fmt.Sprintf("%v", opt.Value)
+		}
+	}
+	if setting != "on" && setting != "off" {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Use /notify on or /notify off.")
+	}
+	on := setting == "on"
+	userID := interaction.Member.User.ID
+	span.SetAttributes(
+		attribute.String("notify.user_id", userID),
+		attribute.Bool("notify.on", on),
+	)
+
+	client := getFirestoreClient()
+	if client == nil {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Notifications are unavailable.")
+	}
+
+	// Merge, so users who have not drawn yet get a document with just their
+	// name and the preference
+	_, err := client.Collection("users").Doc(userID).Set(ctx, map[string]interface{}{
+		"id":               userID,
+		"username":         interaction.Member.User.Username,
+		"notifyOverwrites": on,
+		"notifyFailures":   0,
+	}, firestore.MergeAll)
+	if err != nil {
+		sendFollowUp(interaction.ApplicationID, interaction.Token, "Failed to save the setting.")
+		return err
+	}
+
+	if !on {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Overwrite notifications are off.")
+	}
+	return sendFollowUp(interaction.ApplicationID, interaction.Token,
+		"Overwrite notifications are on. You will get a DM when others paint over your pixels, at most once every 10 minutes. "+
+			"DMs from server members must be allowed in your privacy settings.")
+}
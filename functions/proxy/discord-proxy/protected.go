@@ -0,0 +1,108 @@
+package discordproxy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/team11/discord-proxy/internal/coords"
+)
+
+// protectedListMax caps the zones listed by /protected, to stay well within
+// Discord's 2000-character message limit
+const protectedListMax = 15
+
+// protectedZone is the part of a zones document /protected shows
+type protectedZone struct {
+	Label        string   `firestore:"label"`
+	MinX         int      `firestore:"minX"`
+	MinY         int      `firestore:"minY"`
+	MaxX         int      `firestore:"maxX"`
+	MaxY         int      `firestore:"maxY"`
+	Locked       bool     `firestore:"locked"`
+	AllowedUsers []string `firestore:"allowedUsers"`
+}
+
+// activeZones keeps the locked zones, by label; unlocked zones are kept by
+// /zone unlock for re-locking but restrict nothing
+func activeZones(zones []protectedZone) []protectedZone {
+	var active []protectedZone
+	for _, z := range zones {
+		if z.Locked {
+			active = append(active, z)
+		}
+	}
+	slices.SortFunc(active, func(a, b protectedZone) int { return strings.Compare(a.Label, b.Label) })
+	return active
+}
+
+// handleProtectedCommand answers /protected with the locked zones, where
+// only their allowed users may draw. Unlike /zone list it is open to
+// everyone and leaves unlocked zones out. Bounds are shown in the session's
+// origin.
+func handleProtectedCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "handleProtectedCommand")
+	defer span.End()
+
+	client := getFirestoreClient()
+	if client == nil {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Protected areas are unavailable.")
+	}
+
+	docs, err := client.Collection("zones").Documents(ctx).GetAll()
+	if err != nil {
+		sendFollowUp(interaction.ApplicationID, interaction.Token, "Failed to read the protected areas.")
+		return err
+	}
+	zones := make([]protectedZone, 0, len(docs))
+	for _, doc := range docs {
+		var z protectedZone
+		if err := doc.DataTo(&z); err == nil {
+			zones = append(zones, z)
+		}
+	}
+	active := activeZones(zones)
+	span.SetAttributes(attribute.Int("zones.active", len(active)))
+	if len(active) == 0 {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "No protected areas: you can draw anywhere on the canvas.")
+	}
+
+	origin, height := coords.TopLeft, 0
+	if session, err := client.Collection("sessions").Doc("current").Get(ctx); err == nil {
+		origin = coords.FromSession(session.Data())
+		h, _ := session.Data()["canvasHeight"].(int64)
+		height = int(h)
+	}
+	return sendFollowUp(interaction.ApplicationID, interaction.Token, formatProtectedZones(active, origin, height, interaction.Member.User.ID))
+}
+
+// formatProtectedZones lists up to protectedListMax zones, marking the ones
+// userID may still draw in
+func formatProtectedZones(zones []protectedZone, origin coords.Origin, height int, userID string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**Protected areas** (%d)", len(zones))
+	for i, z := range zones {
+		if i == protectedListMax {
+			fmt.Fprintf(&b, "\n…and %d more", len(zones)-protectedListMax)
+			break
+		}
+		minX, minY, maxX, maxY := origin.ToUserRect(z.MinX, z.MinY, z.MaxX, z.MaxY, height)
+		fmt.Fprintf(&b, "\n🔒 **%s** (%d, %d) to (%d, %d)", z.Label, minX, minY, maxX, maxY)
+		switch {
+		case slices.Contains(z.AllowedUsers, userID):
+			b.WriteString(": you may draw here")
+		case len(z.AllowedUsers) > 0:
+			mentions := make([]string, len(z.AllowedUsers))
+			for j, id := range z.AllowedUsers {
+				mentions[j] = "<@" + id + ">"
+			}
+			b.WriteString(", allowed: " + strings.Join(mentions, " "))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,83 @@
+package discordproxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+const secretManagerEndpoint = "https://secretmanager.googleapis.com/v1/"
+
+// secretsErr is set when a secret named by a *_SECRET variable could not be
+// read at init. The instance then cannot verify or answer Discord, so
+// Handler refuses every request with 503 instead of failing signatures.
+var secretsErr error
+
+// secretOrEnv returns the value of a setting that may be a secret: when
+// name_SECRET holds a Secret Manager resource name, the secret is read once
+// here and kept for the life of the instance; otherwise name is read from
+// the environment.
+func secretOrEnv(ctx context.Context, name string) string {
+	resource := strings.TrimSpace(os.Getenv(name + "_SECRET"))
+	if resource == "" {
+		return strings.TrimSpace(os.Getenv(name))
+	}
+	value, err := accessSecret(ctx, resource)
+	if err != nil {
+		secretsErr = errors.Join(secretsErr, fmt.Errorf("%s_SECRET: %w", name, err))
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
+
+// accessSecret reads a secret version through the Secret Manager REST API
+// with the function's default credentials. A resource name without a
+// version, projects/p/secrets/s, reads the latest one.
+func accessSecret(ctx context.Context, resource string) (string, error) {
+	if !strings.Contains(resource, "/versions/") {
+		resource += "/versions/latest"
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretManagerEndpoint+resource+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("access %s: %s: %s", resource, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var version struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return "", fmt.Errorf("access %s: %w", resource, err)
+	}
+	data, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("access %s: %w", resource, err)
+	}
+	return string(data), nil
+}
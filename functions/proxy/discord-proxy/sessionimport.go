@@ -0,0 +1,103 @@
+package discordproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/team11/discord-proxy/internal/messages"
+)
+
+const (
+	sessionImportConfirmPrefix = "session_import_confirm:"
+	sessionImportCancelPrefix  = "session_import_cancel:"
+	// sessionImportMaxBytes keeps a file within what the session worker can
+	// parse in memory
+	sessionImportMaxBytes = 25 << 20
+)
+
+// sendImportPrompt answers /session action:import with an ephemeral
+// confirmation button. The attachment URL is too long for a button's custom
+// ID, so it waits in import_jobs/{interactionId} until the admin confirms.
+func sendImportPrompt(ctx context.Context, w http.ResponseWriter, interaction Interaction) {
+	if !isAdmin(interaction.Member) {
+		auditDenied(ctx, interaction, "session.import", "current")
+		sendEphemeral(w, "You do not have permission to manage sessions.")
+		return
+	}
+	file, ok := importAttachment(interaction)
+	switch {
+	case !ok:
+		sendEphemeral(w, "Attach the JSON file of a /session export in the file option.")
+		return
+	case !strings.HasSuffix(strings.ToLower(file.Filename), ".json"):
+		sendEphemeral(w, fmt.Sprintf("%s is not a JSON file.", file.Filename))
+		return
+	case file.Size > sessionImportMaxBytes:
+		sendEphemeral(w, fmt.Sprintf("%s is too large to import (%d MB at most).", file.Filename, sessionImportMaxBytes>>20))
+		return
+	}
+
+	client := getFirestoreClient()
+	if client == nil {
+		sendEphemeral(w, "Session import is unavailable.")
+		return
+	}
+	if _, err := client.Collection("import_jobs").Doc(interaction.ID).Set(ctx, map[string]interface{}{
+		"url":             file.URL,
+		"filename":        file.Filename,
+		"size":            file.Size,
+		"requestedBy":     interaction.Member.User.ID,
+		"requestedByName": interaction.Member.User.Username,
+		"createdAt":       time.Now().UTC(),
+	}); err != nil {
+		slog.Error("command_failed", "command", "session_import", "error", err.Error())
+		sendEphemeral(w, "Failed to prepare the import. Please try again.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type": 4,
+		"data": map[string]interface{}{
+			"flags": 64,
+			"content": fmt.Sprintf("Import %s? It replaces the session settings, overwrites pixels and user stats with the ones in the file, "+
+				"and refuses placements until it is done. The session must be paused or ended first.", file.Filename),
+			"components": []map[string]interface{}{{
+				"type": 1,
+				"components": []map[string]interface{}{
+					{"type": 2, "style": 4, "label": "Import session", "custom_id": sessionImportConfirmPrefix + interaction.ID},
+					{"type": 2, "style": 2, "label": "Cancel", "custom_id": sessionImportCancelPrefix + interaction.ID},
+				},
+			}},
+		},
+	})
+}
+
+// publishSessionImport hands a confirmed import to the session worker
+func publishSessionImport(ctx context.Context, interaction Interaction, importID string) error {
+	return publishMessage(ctx, sessionEventsTopic, messages.SessionCommand{
+		Action:           "import",
+		ImportID:         importID,
+		ChannelID:        interaction.ChannelID,
+		UserID:           interaction.Member.User.ID,
+		Username:         interaction.Member.User.Username,
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+	}, map[string]string{
+		"type": messages.TypeSessionCommand,
+	})
+}
+
+// cancelSessionImport forgets a prompt's file; a leftover document is
+// harmless, it only holds an expiring attachment URL
+func cancelSessionImport(ctx context.Context, importID string) {
+	if client := getFirestoreClient(); client != nil && importID != "" {
+		client.Collection("import_jobs").Doc(importID).Delete(ctx)
+	}
+}
@@ -0,0 +1,99 @@
+package discordproxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// streakDayLayout matches users.lastActiveDay, a UTC calendar day
+const streakDayLayout = "2006-01-02"
+
+// handleStreakCommand answers /streak [user] with the user's current and
+// best drawing streak, kept on the user document by the pixel worker.
+func handleStreakCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "handleStreakCommand")
+	defer span.End()
+
+	userID := interaction.Member.User.ID
+	for _, opt := range interaction.Data.Options {
+		if opt.Name == "user" {
+			userID = fmt.Sprintf("%v", opt.Value)
+		}
+	}
+	span.SetAttributes(attribute.String("streak.user_id", userID))
+
+	client := getFirestoreClient()
+	if client == nil {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Streaks are unavailable.")
+	}
+
+	doc, err := client.Collection("users").Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, fmt.Sprintf("<@%s> has not placed any pixels yet, so there is no streak to show.", userID))
+	}
+	if err != nil {
+		sendFollowUp(interaction.ApplicationID, interaction.Token, "Failed to load the streak.")
+		return err
+	}
+
+	return sendFollowUpEmbed(interaction.ApplicationID, interaction.Token, buildStreakEmbed(userID, doc.Data(), time.Now()))
+}
+
+// currentStreak is the stored streak while it can still be extended: the
+// user drew today or yesterday (UTC). After a missed day it is 0, even
+// though the document keeps the old value until the next placement.
+func currentStreak(data map[string]interface{}, now time.Time) int {
+	lastDay, _ := data["lastActiveDay"].(string)
+	days, _ := data["streakDays"].(int64)
+	today := now.UTC().Format(streakDayLayout)
+	yesterday := now.UTC().AddDate(0, 0, -1).Format(streakDayLayout)
+	if lastDay != today && lastDay != yesterday {
+		return 0
+	}
+	return int(days)
+}
+
+func buildStreakEmbed(userID string, data map[string]interface{}, now time.Time) map[string]interface{} {
+	name := userDisplayName(data)
+	if name == "" {
+		name = userID
+	}
+	current := currentStreak(data, now)
+	best, _ := data["bestStreakDays"].(int64)
+	lastDay, _ := data["lastActiveDay"].(string)
+
+	days := func(n int) string {
+		if n == 1 {
+			return "1 day"
+		}
+		return fmt.Sprintf("%d days", n)
+	}
+	description := "Place a pixel today to start a streak."
+	switch {
+	case lastDay == "":
+		// Users who last drew before streaks were tracked
+		description = "No streak recorded yet. Place a pixel to start one."
+	case current > 0 && lastDay != now.UTC().Format(streakDayLayout):
+		description = "Place a pixel today to keep the streak going."
+	case current > 0:
+		description = "Already drawn today."
+	}
+
+	return map[string]interface{}{
+		"title":       fmt.Sprintf("Drawing streak for %s", name),
+		"description": description,
+		"color":       0xE67E22,
+		"fields": []map[string]interface{}{
+			{"name": "Current streak", "value": days(current), "inline": true},
+			{"name": "Best streak", "value": days(int(best)), "inline": true},
+		},
+		"footer": map[string]string{"text": "Days are counted in UTC"},
+	}
+}
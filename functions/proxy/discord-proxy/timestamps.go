@@ -0,0 +1,17 @@
+package discordproxy
+
+import "time"
+
+// storedTime reads a time field written either as a Firestore Timestamp
+// (current writes) or as an RFC 3339 string (documents written before the
+// switch to Timestamps).
+func storedTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		parsed, err := time.Parse(time.RFC3339, t)
+		return parsed, err == nil
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,70 @@
+package discordproxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// handleUserStatsCommand answers /userstats [user] with an embed built from
+// the user's document.
+func handleUserStatsCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "handleUserStatsCommand")
+	defer span.End()
+
+	userID := interaction.Member.User.ID
+	for _, opt := range interaction.Data.Options {
+		if opt.Name == "user" {
+			userID = fmt.Sprintf("%v", opt.Value)
+		}
+	}
+	span.SetAttributes(attribute.String("userstats.user_id", userID))
+
+	client := getFirestoreClient()
+	if client == nil {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "User stats are unavailable.")
+	}
+
+	doc, err := client.Collection("users").Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, fmt.Sprintf("<@%s> has not placed any pixels yet.", userID))
+	}
+	if err != nil {
+		sendFollowUp(interaction.ApplicationID, interaction.Token, "Failed to load user stats.")
+		return err
+	}
+
+	return sendFollowUpEmbed(interaction.ApplicationID, interaction.Token, buildUserStatsEmbed(userID, doc.Data()))
+}
+
+func buildUserStatsEmbed(userID string, data map[string]interface{}) map[string]interface{} {
+	name := userDisplayName(data)
+	if name == "" {
+		name = userID
+	}
+	count := func(field string) string {
+		n, _ := data[field].(int64)
+		return fmt.Sprintf("%d", n)
+	}
+	lastPixel := "never"
+	if t, ok := storedTime(data["lastPixelAt"]); ok {
+		lastPixel = t.UTC().Format(time.RFC3339)
+	}
+
+	return map[string]interface{}{
+		"title": fmt.Sprintf("Stats for %s", name),
+		"color": 0x2ECC71,
+		"fields": []map[string]interface{}{
+			{"name": "Pixels placed", "value": count("pixelCount"), "inline": true},
+			{"name": "Pixels conquered", "value": count("pixelsOverwritten"), "inline": true},
+			{"name": "Pixels lost", "value": count("pixelsLost"), "inline": true},
+			{"name": "Last pixel", "value": lastPixel, "inline": false},
+		},
+	}
+}
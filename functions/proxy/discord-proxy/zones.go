@@ -0,0 +1,82 @@
+package discordproxy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/team11/discord-proxy/internal/messages"
+)
+
+// zoneUserIDPattern finds user IDs in the allow option, which accepts raw
+// IDs as well as <@id> mentions
+var zoneUserIDPattern = regexp.MustCompile(`\d{17,19}`)
+
+// routeZoneCommand hands /zone lock|unlock|list to the session worker, which
+// owns the zones collection. The pixel worker enforces locked zones.
+func routeZoneCommand(ctx context.Context, interaction Interaction) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "routeZoneCommand")
+	defer span.End()
+
+	if len(interaction.Data.Options) == 0 {
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Use /zone lock, /zone unlock or /zone list.")
+	}
+	sub := interaction.Data.Options[0]
+	span.SetAttributes(attribute.String("zone.action", sub.Name))
+
+	if !isAdmin(interaction.Member) {
+		auditDenied(ctx, interaction, "zone."+sub.Name, "zones")
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "You do not have permission to manage zones.")
+	}
+
+	messageData := messages.SessionCommand{
+		Action:           "zone",
+		ZoneAction:       sub.Name,
+		ChannelID:        interaction.ChannelID,
+		UserID:           interaction.Member.User.ID,
+		Username:         interaction.Member.User.Username,
+		InteractionToken: interaction.Token,
+		ApplicationID:    interaction.ApplicationID,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+	}
+
+	bounds := make(map[string]int)
+	for _, opt := range sub.Options {
+		switch opt.Name {
+		case "label":
+			messageData.Label = strings.TrimSpace(fmt.Sprintf("%v", opt.Value))
+		case "x1", "y1", "x2", "y2":
+			v, err := toInt(opt.Value)
+			if err != nil || v < 0 {
+				return sendFollowUp(interaction.ApplicationID, interaction.Token, "Zone corners must be non-negative integers.")
+			}
+			bounds[opt.Name] = v
+		case "allow":
+			// Present but empty clears the allow list
+			allowed := append([]string{}, zoneUserIDPattern.FindAllString(fmt.Sprintf("%v", opt.Value), -1)...)
+			messageData.AllowedUsers = &allowed
+		}
+	}
+
+	// Corners are optional when re-locking an existing zone, but all or none
+	switch len(bounds) {
+	case 0:
+	case 4:
+		minX, maxX := min(bounds["x1"], bounds["x2"]), max(bounds["x1"], bounds["x2"])
+		minY, maxY := min(bounds["y1"], bounds["y2"]), max(bounds["y1"], bounds["y2"])
+		messageData.MinX, messageData.MaxX = &minX, &maxX
+		messageData.MinY, messageData.MaxY = &minY, &maxY
+	default:
+		return sendFollowUp(interaction.ApplicationID, interaction.Token, "Give all four corners (x1, y1, x2, y2) or none.")
+	}
+
+	return publishMessage(ctx, sessionEventsTopic, messageData, map[string]string{
+		"type": messages.TypeSessionCommand,
+	})
+}
@@ -1,12 +1,15 @@
 const functions = require('@google-cloud/functions-framework');
 const { PubSub } = require('@google-cloud/pubsub');
-const { Firestore } = require('@google-cloud/firestore');
+const { Firestore, Timestamp } = require('@google-cloud/firestore');
 const jwt = require('jsonwebtoken');
 const cookieParser = require('cookie-parser');
 
 const PROJECT_ID = process.env.PROJECT_ID;
 const PIXEL_EVENTS_TOPIC = process.env.PIXEL_EVENTS_TOPIC;
 const JWT_SECRET = process.env.JWT_SECRET;
+// Most changed pixels /api/canvas/bootstrap returns on top of the snapshot;
+// past it the client is told to ask for a fresh snapshot instead
+const BOOTSTRAP_MAX_DELTAS = parseInt(process.env.BOOTSTRAP_MAX_DELTAS, 10) || 5000;
 
 const pubsub = new PubSub({ projectId: PROJECT_ID });
 const firestore = new Firestore({ projectId: PROJECT_ID, databaseId: 'team11-database' });
@@ -43,6 +46,32 @@ function verifyToken(req) {
 }
 
 
+//Here we turn a stored time into an ISO string. Workers now write Firestore
+//Timestamps; older documents still hold RFC 3339 strings.
+function toIsoString(value) {
+  if (value && typeof value.toDate === 'function') {
+    return value.toDate().toISOString();
+  }
+  return value;
+}
+
+
+//Here we turn a pixels document into its API form.
+function pixelFromDoc(doc) {
+  const data = doc.data();
+  const [x, y] = doc.id.split('_');
+
+  return {
+    x: parseInt(x),
+    y: parseInt(y),
+    color: data.color,
+    userId: data.userId,
+    username: data.username,
+    updatedAt: toIsoString(data.updatedAt)
+  };
+}
+
+
 //Handle GET /api/pixels - Get all pixels
 
 async function getPixels(req, res) {
@@ -52,19 +81,7 @@ async function getPixels(req, res) {
     const snapshot = await query.limit(10000).get();
 
     const pixels = [];
-    snapshot.forEach(doc => {
-      const data = doc.data();
-      const [x, y] = doc.id.split('_');
-
-      pixels.push({
-        x: parseInt(x),
-        y: parseInt(y),
-        color: data.color,
-        userId: data.userId,
-        username: data.username,
-        updatedAt: data.updatedAt
-      });
-    });
+    snapshot.forEach(doc => pixels.push(pixelFromDoc(doc)));
 
     res.status(200).json({
       pixels,
@@ -109,16 +126,95 @@ async function getCanvas(req, res) {
 }
 
 
+//Here we read the pixels changed after `since` (Unix ms), at most `limit`.
+//updatedAt holds Timestamps, or RFC 3339 strings in pixels not repainted
+//since the switch; range filters only match one type, so both are queried.
+async function getPixelsChangedSince(since, limit) {
+  const pixels = firestore.collection('pixels');
+  const [timestamps, strings] = await Promise.all([
+    pixels.where('updatedAt', '>', Timestamp.fromMillis(since)).orderBy('updatedAt').limit(limit).get(),
+    pixels.where('updatedAt', '>', new Date(since).toISOString()).orderBy('updatedAt').limit(limit).get()
+  ]);
+  return [...strings.docs, ...timestamps.docs].map(pixelFromDoc);
+}
+
+
+//Handle GET /api/canvas/bootstrap - the latest snapshot plus every pixel
+//changed since it, so the web viewer can start from one response. Without a
+//usable snapshot the pixels are the whole canvas.
+
+async function getCanvasBootstrap(req, res) {
+  try {
+    const [latestDoc, sessionDoc] = await Promise.all([
+      firestore.collection('snapshots').doc('latest').get(),
+      firestore.collection('sessions').doc('current').get()
+    ]);
+
+    let snapshot = null;
+    if (latestDoc.exists) {
+      const latest = latestDoc.data();
+      // A snapshot from before the last reset or clear shows pixels that are gone
+      const resetAt = sessionDoc.exists ? Date.parse(sessionDoc.data().resetAt) : NaN;
+      if (!(resetAt > latest.timestamp)) {
+        snapshot = {
+          timestamp: latest.timestamp,
+          manifestUrl: latest.manifestUrl,
+          thumbnailUrl: latest.thumbnailUrl,
+          canvasWidth: latest.canvasWidth,
+          canvasHeight: latest.canvasHeight
+        };
+      }
+    }
+
+    // One extra pixel tells whether the cap was hit
+    let pixels;
+    if (snapshot) {
+      pixels = await getPixelsChangedSince(snapshot.timestamp, BOOTSTRAP_MAX_DELTAS + 1);
+    } else {
+      const all = await firestore.collection('pixels').limit(BOOTSTRAP_MAX_DELTAS + 1).get();
+      pixels = all.docs.map(pixelFromDoc);
+    }
+    // Too many changes to replay: the client should wait for a fresh snapshot
+    // rather than draw an incomplete canvas
+    const snapshotRecommended = pixels.length > BOOTSTRAP_MAX_DELTAS;
+    if (snapshotRecommended) {
+      pixels = pixels.slice(0, BOOTSTRAP_MAX_DELTAS);
+    }
+
+    // Short-lived: the client follows up with real-time updates, which only
+    // cover changes made after it subscribed
+    res.set('Cache-Control', 'public, max-age=5');
+    res.status(200).json({
+      snapshot,
+      pixels,
+      count: pixels.length,
+      snapshotRecommended
+    });
+
+  } catch (error) {
+    console.error('Error getting canvas bootstrap:', error);
+    res.set('Cache-Control', 'no-store');
+    res.status(500).json({ error: 'Failed to get canvas bootstrap' });
+  }
+}
+
+
 //Handle POST /api/pixels
 
 async function placePixel(req, res, user) {
   try {
-    const { x, y, color } = req.body;
+    const { x, y, color, expectedUpdatedAt, requestId } = req.body;
 
     if (typeof x !== 'number' || typeof y !== 'number' || typeof color !== 'string') {
       return res.status(400).json({ error: 'Invalid pixel data' });
     }
 
+    // Optional: the pixel's updatedAt as the client saw it; the worker drops
+    // the placement if the pixel changed since
+    if (expectedUpdatedAt !== undefined && (typeof expectedUpdatedAt !== 'string' || isNaN(Date.parse(expectedUpdatedAt)))) {
+      return res.status(400).json({ error: 'expectedUpdatedAt must be an RFC 3339 timestamp' });
+    }
+
     if (!/^#[0-9A-Fa-f]{6}$/.test(color)) {
       return res.status(400).json({ error: 'Invalid color format. Use #RRGGBB' });
     }
@@ -158,6 +254,9 @@ async function placePixel(req, res, user) {
       username: user.username,
       timestamp: new Date().toISOString()
     };
+    if (expectedUpdatedAt) messageData.expectedUpdatedAt = expectedUpdatedAt;
+    // Echoed back in a pixel_rejected event if the worker refuses the pixel
+    if (typeof requestId === 'string' && requestId) messageData.requestId = requestId.slice(0, 64);
 
     const dataBuffer = Buffer.from(JSON.stringify(messageData));
 
@@ -165,6 +264,8 @@ async function placePixel(req, res, user) {
       data: dataBuffer,
       attributes: {
         type: 'pixel_placement',
+        // PixelEvent schema version, see internal/messages in the Go functions
+        schemaVersion: '2',
         user_id: user.sub
       }
     });
@@ -177,6 +278,40 @@ async function placePixel(req, res, user) {
 }
 
 
+//Handle POST /api/presence - transient cursor position, never stored
+
+async function publishPresence(req, res, user) {
+  try {
+    const { x, y } = req.body;
+
+    if (!Number.isInteger(x) || !Number.isInteger(y)) {
+      return res.status(400).json({ error: 'Invalid presence data' });
+    }
+
+    res.status(202).json({ status: 'accepted' });
+
+    const messageData = {
+      x,
+      y,
+      userId: user.sub,
+      username: user.username,
+      source: 'web'
+    };
+
+    await pubsub.topic(PIXEL_EVENTS_TOPIC).publishMessage({
+      data: Buffer.from(JSON.stringify(messageData)),
+      attributes: {
+        type: 'presence',
+        user_id: user.sub
+      }
+    });
+
+  } catch (error) {
+    console.error('Error publishing presence:', error);
+  }
+}
+
+
 //Main HTTP handler
 
 functions.http('handler', async (req, res) => {
@@ -200,6 +335,9 @@ functions.http('handler', async (req, res) => {
       if (path.startsWith('/api/pixels')) {
         return await getPixels(req, res);
       }
+      if (path.startsWith('/api/canvas/bootstrap')) {
+        return await getCanvasBootstrap(req, res);
+      }
       if (path.startsWith('/api/canvas')) {
         return await getCanvas(req, res);
       }
@@ -217,10 +355,16 @@ functions.http('handler', async (req, res) => {
       if (path.startsWith('/api/pixels')) {
         return await placePixel(req, res, user);
       }
+      if (path.startsWith('/api/presence')) {
+        return await publishPresence(req, res, user);
+      }
 
       return res.status(404).json({ error: 'Not found' });
     }
 
     res.status(405).json({ error: 'Method not allowed' });
   });
-});
\ No newline at end of file
+});
+module.exports = {
+  getCanvasBootstrap,
+};
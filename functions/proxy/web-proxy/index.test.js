@@ -0,0 +1,151 @@
+/**
+ * Web proxy tests against the Firestore emulator. Start it with
+ * `docker compose up firestore`, then run `npm test` with
+ * FIRESTORE_EMULATOR_HOST=localhost:8080; without it they are skipped.
+ */
+const { describe, it, before, beforeEach } = require('node:test');
+const assert = require('node:assert/strict');
+
+const EMULATOR_HOST = process.env.FIRESTORE_EMULATOR_HOST;
+process.env.PROJECT_ID = process.env.PROJECT_ID || 'team11-local';
+// A small cap, so the overflow case needs only a few pixels
+process.env.BOOTSTRAP_MAX_DELTAS = '3';
+const DATABASE_ID = 'team11-database';
+
+const skip = !EMULATOR_HOST && 'FIRESTORE_EMULATOR_HOST is not set';
+
+let proxy;
+let firestore;
+let Timestamp;
+
+// The proxy and the Firestore client are only loaded when the emulator is
+// there, so `npm test` passes without it
+function load() {
+  if (!proxy) {
+    let Firestore;
+    ({ Firestore, Timestamp } = require('@google-cloud/firestore'));
+    proxy = require('./index');
+    firestore = new Firestore({ projectId: process.env.PROJECT_ID, databaseId: DATABASE_ID });
+  }
+}
+
+async function clearEmulator() {
+  const url = `http://${EMULATOR_HOST}/emulator/v1/projects/${process.env.PROJECT_ID}/databases/${DATABASE_ID}/documents`;
+  const response = await fetch(url, { method: 'DELETE' });
+  assert.ok(response.ok, `clearing the emulator failed: ${response.status}`);
+}
+
+async function seed(collection, docs) {
+  const batch = firestore.batch();
+  Object.entries(docs).forEach(([id, data]) => batch.set(firestore.collection(collection).doc(id), data));
+  await batch.commit();
+}
+
+// Here we record what a handler sends, like the Express response it gets.
+function fakeResponse() {
+  return {
+    statusCode: 0,
+    headers: {},
+    body: null,
+    set(name, value) { this.headers[name] = value; return this; },
+    status(code) { this.statusCode = code; return this; },
+    json(body) { this.body = body; return this; },
+  };
+}
+
+async function bootstrap() {
+  const res = fakeResponse();
+  await proxy.getCanvasBootstrap({ method: 'GET', path: '/api/canvas/bootstrap', query: {} }, res);
+  assert.equal(res.statusCode, 200, JSON.stringify(res.body));
+  return res;
+}
+
+const SNAPSHOT_AT = Date.parse('2026-01-01T12:00:00Z');
+
+function pixel(x, y, updatedAt) {
+  return { x, y, color: 'FF0000', userId: 'u1', username: 'alice', updatedAt };
+}
+
+function coordinates(pixels) {
+  return pixels.map(p => `${p.x}_${p.y}`).sort();
+}
+
+describe('getCanvasBootstrap', { skip }, () => {
+  before(load);
+  beforeEach(clearEmulator);
+
+  it('returns the whole canvas when there is no snapshot yet', async () => {
+    await seed('pixels', {
+      '0_0': pixel(0, 0, Timestamp.fromMillis(SNAPSHOT_AT - 1000)),
+      '1_0': pixel(1, 0, Timestamp.fromMillis(SNAPSHOT_AT + 1000)),
+    });
+
+    const res = await bootstrap();
+
+    assert.equal(res.body.snapshot, null);
+    assert.deepEqual(coordinates(res.body.pixels), ['0_0', '1_0']);
+    assert.equal(res.body.count, 2);
+    assert.equal(res.body.snapshotRecommended, false);
+    assert.equal(res.headers['Cache-Control'], 'public, max-age=5');
+  });
+
+  it('returns the snapshot and only the pixels changed since it', async () => {
+    await seed('snapshots', {
+      latest: {
+        timestamp: SNAPSHOT_AT,
+        manifestUrl: 'https://example.test/manifest.json',
+        thumbnailUrl: 'https://example.test/thumb.png',
+        canvasWidth: 100,
+        canvasHeight: 100,
+      },
+    });
+    await seed('pixels', {
+      '0_0': pixel(0, 0, Timestamp.fromMillis(SNAPSHOT_AT - 1000)),
+      '1_0': pixel(1, 0, Timestamp.fromMillis(SNAPSHOT_AT + 1000)),
+      // Written before the switch to Timestamps
+      '2_0': pixel(2, 0, new Date(SNAPSHOT_AT + 2000).toISOString()),
+      '3_0': pixel(3, 0, new Date(SNAPSHOT_AT - 2000).toISOString()),
+    });
+
+    const res = await bootstrap();
+
+    assert.deepEqual(res.body.snapshot, {
+      timestamp: SNAPSHOT_AT,
+      manifestUrl: 'https://example.test/manifest.json',
+      thumbnailUrl: 'https://example.test/thumb.png',
+      canvasWidth: 100,
+      canvasHeight: 100,
+    });
+    assert.deepEqual(coordinates(res.body.pixels), ['1_0', '2_0']);
+    assert.equal(res.body.snapshotRecommended, false);
+    const changed = res.body.pixels.find(p => p.x === 1);
+    assert.equal(changed.updatedAt, new Date(SNAPSHOT_AT + 1000).toISOString());
+  });
+
+  it('caps the changes and recommends a fresh snapshot past BOOTSTRAP_MAX_DELTAS', async () => {
+    await seed('snapshots', { latest: { timestamp: SNAPSHOT_AT, manifestUrl: 'https://example.test/manifest.json' } });
+    const pixels = {};
+    for (let x = 0; x < 5; x++) {
+      pixels[`${x}_0`] = pixel(x, 0, Timestamp.fromMillis(SNAPSHOT_AT + 1000 * (x + 1)));
+    }
+    await seed('pixels', pixels);
+
+    const res = await bootstrap();
+
+    assert.notEqual(res.body.snapshot, null);
+    assert.equal(res.body.pixels.length, 3);
+    assert.equal(res.body.count, 3);
+    assert.equal(res.body.snapshotRecommended, true);
+  });
+
+  it('ignores a snapshot taken before the canvas was reset', async () => {
+    await seed('snapshots', { latest: { timestamp: SNAPSHOT_AT, manifestUrl: 'https://example.test/manifest.json' } });
+    await seed('sessions', { current: { status: 'active', resetAt: new Date(SNAPSHOT_AT + 60000).toISOString() } });
+    await seed('pixels', { '0_0': pixel(0, 0, Timestamp.fromMillis(SNAPSHOT_AT - 1000)) });
+
+    const res = await bootstrap();
+
+    assert.equal(res.body.snapshot, null);
+    assert.deepEqual(coordinates(res.body.pixels), ['0_0']);
+  });
+});
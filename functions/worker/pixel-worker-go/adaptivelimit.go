@@ -0,0 +1,119 @@
+package pixelworker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/team11/pixel-worker/internal/pixelrules"
+)
+
+const (
+	// systemStateCacheTTL bounds how stale the load factor may be on one
+	// instance. Instances write system_state/current about every
+	// loadReportInterval.
+	systemStateCacheTTL = 15 * time.Second
+	// systemStateMaxAge is how long a reported load counts. A report older
+	// than that means no instance has handled a message since, so there is
+	// no backlog left to slow down for.
+	systemStateMaxAge = 2 * time.Minute
+
+	// loadReportInterval is how often an instance reports the load it saw
+	loadReportInterval = 30 * time.Second
+	// loadFullAge is the message age that counts as fully loaded: the limit
+	// reaches half at half of it
+	loadFullAge = 20 * time.Second
+)
+
+var (
+	systemStateMu    sync.Mutex
+	loadFactorCached float64
+	loadFactorAt     time.Time
+
+	loadReportMu  sync.Mutex
+	loadWindowMax time.Duration
+	loadReportAt  time.Time
+)
+
+// getLoadFactor reads system_state/current.loadFactor, from 0 (idle) to 1
+// (overloaded), cached per instance. The document is read outside the lock,
+// so requests arriving during a refresh keep going; concurrent misses may
+// each read it. A missing document or field, a report older than
+// systemStateMaxAge or a read error means no load, matching the limiter's
+// fail-open behavior.
+func getLoadFactor(ctx context.Context) float64 {
+	systemStateMu.Lock()
+	cached, fresh := loadFactorCached, time.Since(loadFactorAt) < systemStateCacheTTL
+	systemStateMu.Unlock()
+	if fresh {
+		return cached
+	}
+
+	load := readLoadFactor(ctx)
+	systemStateMu.Lock()
+	loadFactorCached, loadFactorAt = load, time.Now()
+	systemStateMu.Unlock()
+	return load
+}
+
+func readLoadFactor(ctx context.Context) float64 {
+	doc, err := getFirestore().Collection("system_state").Doc("current").Get(ctx)
+	if err != nil {
+		return 0
+	}
+	data := doc.Data()
+	// A document set by hand without updatedAt does not expire
+	if at, ok := pixelrules.StoredTime(data["updatedAt"]); ok && time.Since(at) > systemStateMaxAge {
+		return 0
+	}
+	load := 0.0
+	switch v := data["loadFactor"].(type) {
+	case float64:
+		load = v
+	case int64:
+		load = float64(v)
+	}
+	return min(max(load, 0), 1)
+}
+
+// observeMessageAge is the backlog monitor behind system_state/current. Each
+// instance keeps the oldest message it handled and, about every
+// loadReportInterval, writes the load that age means. Instances drain the
+// same subscription, so their reports agree closely and the last one wins.
+func observeMessageAge(ctx context.Context, age time.Duration) {
+	loadReportMu.Lock()
+	loadWindowMax = max(loadWindowMax, age)
+	if time.Since(loadReportAt) < loadReportInterval {
+		loadReportMu.Unlock()
+		return
+	}
+	oldest := loadWindowMax
+	loadWindowMax, loadReportAt = 0, time.Now()
+	loadReportMu.Unlock()
+
+	load := loadFactorFor(oldest)
+	if _, err := getFirestore().Collection("system_state").Doc("current").Set(ctx, map[string]interface{}{
+		"loadFactor": load,
+		"updatedAt":  time.Now().UTC(),
+	}); err != nil {
+		slog.Warn("system_state_write_failed", "load_factor", load, "error", err.Error())
+	}
+}
+
+// loadFactorFor maps the age of the oldest message handled to a load factor
+func loadFactorFor(age time.Duration) float64 {
+	return min(max(age.Seconds()/loadFullAge.Seconds(), 0), 1)
+}
+
+// adaptiveRateLimit is the per-window pixel limit under the current load:
+// baseLimit scaled down as load grows, to half at most
+func adaptiveRateLimit(ctx context.Context, baseLimit int) int {
+	return scaleRateLimit(baseLimit, getLoadFactor(ctx))
+}
+
+// scaleRateLimit applies a load factor to baseLimit: 0 keeps it, 0.5 and
+// above halve it
+func scaleRateLimit(baseLimit int, loadFactor float64) int {
+	return int(float64(baseLimit) * max(0.5, 1.0-loadFactor))
+}
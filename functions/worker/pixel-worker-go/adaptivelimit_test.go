@@ -0,0 +1,63 @@
+package pixelworker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScaleRateLimit(t *testing.T) {
+	tests := []struct {
+		load float64
+		want int
+	}{
+		{0.0, 20},
+		{0.5, 10},
+		{0.8, 10},
+		{1.0, 10},
+	}
+	for _, tt := range tests {
+		if got := scaleRateLimit(20, tt.load); got != tt.want {
+			t.Errorf("scaleRateLimit(20, %.1f) = %d, want %d", tt.load, got, tt.want)
+		}
+	}
+}
+
+func TestLoadFactorFor(t *testing.T) {
+	tests := []struct {
+		age  time.Duration
+		want float64
+	}{
+		{0, 0},
+		{5 * time.Second, 0.25},
+		{10 * time.Second, 0.5},
+		{time.Minute, 1},
+		{-time.Second, 0},
+	}
+	for _, tt := range tests {
+		if got := loadFactorFor(tt.age); got != tt.want {
+			t.Errorf("loadFactorFor(%s) = %v, want %v", tt.age, got, tt.want)
+		}
+	}
+}
+
+func TestAdaptiveRateLimitFromReport(t *testing.T) {
+	requireEmulator(t)
+	ctx := t.Context()
+
+	observeMessageAge(ctx, 15*time.Second)
+	if got := readDoc(t, "system_state/current")["loadFactor"]; got != 0.75 {
+		t.Fatalf("reported loadFactor = %v, want 0.75", got)
+	}
+	if got := adaptiveRateLimit(ctx, 20); got != 10 {
+		t.Errorf("adaptiveRateLimit(20) = %d under load, want 10", got)
+	}
+
+	// A report nobody renewed no longer slows anyone down
+	seedDoc(t, "system_state/current", map[string]interface{}{"loadFactor": 1.0, "updatedAt": time.Now().Add(-time.Hour)})
+	systemStateMu.Lock()
+	loadFactorAt = time.Time{}
+	systemStateMu.Unlock()
+	if got := adaptiveRateLimit(ctx, 20); got != 20 {
+		t.Errorf("adaptiveRateLimit(20) = %d with a stale report, want 20", got)
+	}
+}
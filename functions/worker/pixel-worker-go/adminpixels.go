@@ -0,0 +1,24 @@
+package pixelworker
+
+import (
+	"errors"
+	"os"
+)
+
+// protectAdminPixels refuses non-admins a pixel whose stored adminPlaced flag
+// is set, so reference pixels drawn by admins need no zone. Pixels record the
+// flag either way.
+var protectAdminPixels = os.Getenv("PROTECT_ADMIN_PIXELS") == "true"
+
+// errPixelProtected means a non-admin tried to overwrite an admin's pixel
+var errPixelProtected = errors.New("pixel is protected")
+
+// isProtectedFrom reports whether the stored pixel data is closed to the
+// placement
+func isProtectedFrom(data map[string]interface{}, isAdmin bool) bool {
+	if !protectAdminPixels || isAdmin {
+		return false
+	}
+	adminPlaced, _ := data["adminPlaced"].(bool)
+	return adminPlaced
+}
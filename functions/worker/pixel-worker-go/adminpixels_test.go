@@ -0,0 +1,68 @@
+package pixelworker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/team11/pixel-worker/internal/pixelrules"
+)
+
+func TestIsProtectedFrom(t *testing.T) {
+	adminPixel := map[string]interface{}{"color": "FF0000", "adminPlaced": true}
+	userPixel := map[string]interface{}{"color": "FF0000", "adminPlaced": false}
+	legacyPixel := map[string]interface{}{"color": "FF0000"}
+
+	tests := []struct {
+		name    string
+		protect bool
+		data    map[string]interface{}
+		isAdmin bool
+		want    bool
+	}{
+		{"non-admin over an admin pixel", true, adminPixel, false, true},
+		{"admin over an admin pixel", true, adminPixel, true, false},
+		{"non-admin over a user pixel", true, userPixel, false, false},
+		{"pixel from before the flag", true, legacyPixel, false, false},
+		{"protection off", false, adminPixel, false, false},
+	}
+	defer func(v bool) { protectAdminPixels = v }(protectAdminPixels)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protectAdminPixels = tt.protect
+			if got := isProtectedFrom(tt.data, tt.isAdmin); got != tt.want {
+				t.Errorf("isProtectedFrom() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdatePixelAdminProtection(t *testing.T) {
+	requireEmulator(t)
+	defer func(v bool) { protectAdminPixels = v }(protectAdminPixels)
+	protectAdminPixels = true
+	ctx := t.Context()
+
+	if _, err := updatePixel(ctx, 4, 4, "FF0000", pixelrules.BlendReplace, "admin", "boss", "discord", "", "", true); err != nil {
+		t.Fatalf("admin placement: %v", err)
+	}
+	if got := readDoc(t, "pixels/4_4")["adminPlaced"]; got != true {
+		t.Fatalf("adminPlaced = %v, want true", got)
+	}
+
+	// A non-admin is refused and the pixel is left alone
+	_, err := updatePixel(ctx, 4, 4, "0000FF", pixelrules.BlendReplace, "u1", "one", "web", "", "", false)
+	if !errors.Is(err, errPixelProtected) {
+		t.Fatalf("non-admin overwrite error = %v, want errPixelProtected", err)
+	}
+	if got := readDoc(t, "pixels/4_4"); got["color"] != "FF0000" || got["userId"] != "admin" {
+		t.Errorf("pixel after a refused overwrite = %v, want the admin's FF0000", got)
+	}
+
+	// Another admin may overwrite it
+	if _, err := updatePixel(ctx, 4, 4, "00FF00", pixelrules.BlendReplace, "admin2", "boss2", "discord", "", "", true); err != nil {
+		t.Fatalf("admin overwrite: %v", err)
+	}
+	if got := readDoc(t, "pixels/4_4"); got["color"] != "00FF00" || got["userId"] != "admin2" {
+		t.Errorf("pixel after an admin overwrite = %v, want admin2's 00FF00", got)
+	}
+}
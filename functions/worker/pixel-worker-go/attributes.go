@@ -0,0 +1,66 @@
+package pixelworker
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// strictAttributeValidation drops messages carrying attributes outside
+// allowedAttributes (STRICT_ATTRIBUTE_VALIDATION=true). Off by default:
+// unknown attributes are only logged.
+var strictAttributeValidation = os.Getenv("STRICT_ATTRIBUTE_VALIDATION") == "true"
+
+// allowedAttributes are the Pub/Sub attribute keys publishers of
+// pixel-events set: the message type, source and schema version, trace
+// context (ours and W3C) and forced sampling, redelivery bookkeeping, and
+// the web proxies' user_id.
+var allowedAttributes = map[string]bool{
+	"type":        true,
+	"source":      true,
+	"traceId":     true,
+	"spanId":      true,
+	"traceparent": true,
+	"tracestate":  true,
+	"retryCount":  true,
+	"messageId":   true,
+	"user_id":     true,
+	// messages.SchemaVersionAttribute
+	"schemaVersion": true,
+	// sampling.Attribute
+	"debugTrace": true,
+}
+
+// validateAttributes returns an error naming the attribute keys outside
+// allowedAttributes, sorted, or nil when there are none
+func validateAttributes(attrs map[string]string) error {
+	var unknown []string
+	for key := range attrs {
+		if !allowedAttributes[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	slices.Sort(unknown)
+	return fmt.Errorf("unknown message attributes: %s", strings.Join(unknown, ", "))
+}
+
+// checkAttributes reports whether a message may be processed. Unknown
+// attributes are logged; in strict mode the message is also refused, and
+// acked by the caller since a redelivery would carry the same attributes.
+func checkAttributes(attrs map[string]string) bool {
+	err := validateAttributes(attrs)
+	if err == nil {
+		return true
+	}
+	if strictAttributeValidation {
+		slog.Warn("message_attributes_rejected", "type", attrs["type"], "error", err.Error())
+		return false
+	}
+	slog.Warn("message_attributes_unknown", "type", attrs["type"], "error", err.Error())
+	return true
+}
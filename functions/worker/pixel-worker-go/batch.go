@@ -0,0 +1,831 @@
+package pixelworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/team11/pixel-worker/internal/events"
+	"github.com/team11/pixel-worker/internal/messages"
+	"github.com/team11/pixel-worker/internal/pixelrules"
+)
+
+const (
+	// batchRejectedListMax caps the rejected pixels listed in a batch
+	// follow-up; the rest are only counted
+	batchRejectedListMax = 10
+	// discordMessageLimit is the longest message content Discord accepts
+	discordMessageLimit = 2000
+)
+
+// pixelOutcome records what happened to one pixel of a batch so follow-ups
+// stay accurate per user. Event holds storage coordinates; UserX/UserY are
+// the same pixel as the user sees it (see the session's origin).
+type pixelOutcome struct {
+	Event    messages.PixelEvent
+	UserX    int
+	UserY    int
+	Accepted bool
+	Reason   string
+	// Code classifies Reason for web clients
+	Code events.RejectReason
+	// RateLimit is the user's window after the batch was charged
+	RateLimit rateLimitResult
+	// GridSnap is the session's, to describe the pixel's grid cell
+	GridSnap int
+}
+
+func (o *pixelOutcome) reject(r *rejection) {
+	o.Code, o.Reason = r.Reason, r.Message
+}
+
+// processPixelBatch validates every pixel individually, charges rate limits
+// once per user, writes accepted pixels in one transaction and publishes a
+// single aggregated public update.
+func processPixelBatch(ctx context.Context, placements []messages.PixelEvent) []pixelOutcome {
+	ctx, span := tracer.Start(ctx, "processPixelBatch")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int("batch.size", len(placements)))
+
+	outcomes := make([]pixelOutcome, len(placements))
+	for i, ev := range placements {
+		if ev.Source == "" {
+			ev.Source = "web"
+		}
+		r := verifySource(ctx, &ev)
+		ev.Username = sanitizeUsername(ev.Username)
+		outcomes[i] = pixelOutcome{Event: ev}
+		if r != nil {
+			outcomes[i].reject(r)
+		}
+	}
+
+	session, err := getSessionState(ctx)
+	for i := range outcomes {
+		o := &outcomes[i]
+		o.Event.X, o.Event.Y = session.placementToCanvas(o.Event.X, o.Event.Y, o.Event.Source)
+		o.UserX, o.UserY = session.canvasToUser(o.Event.X, o.Event.Y)
+		if session != nil {
+			o.GridSnap = session.GridSnap
+		}
+	}
+
+	// Per-pixel validation; remember which pixels each user still needs charged
+	zones := getZones(ctx)
+	constraints := getTimeConstraints(ctx)
+	now := time.Now()
+	pending := make(map[string][]int)
+	var userOrder []string
+	for i := range outcomes {
+		ev := outcomes[i].Event
+		// Already refused as unverified
+		if outcomes[i].Code != "" {
+			continue
+		}
+		if !isValidUserID(ev.UserID) {
+			outcomes[i].reject(newRejection(events.ReasonInvalidUser))
+			continue
+		}
+		if !hexColorRegex.MatchString(ev.Color) {
+			outcomes[i].reject(newRejection(events.ReasonInvalidColor, ev.Color))
+			continue
+		}
+		if r := checkTimeConstraints(ev.Color, constraints, now); r != nil {
+			outcomes[i].reject(r)
+			continue
+		}
+		if err != nil {
+			outcomes[i].reject(newRejection(events.ReasonNoSession))
+			continue
+		}
+		if r := session.checkPlacement(ev.X, ev.Y); r != nil {
+			outcomes[i].reject(r)
+			continue
+		}
+		if ok, reason := checkZones(zones, ev.X, ev.Y, ev.UserID); !ok {
+			outcomes[i].reject(&rejection{events.ReasonZoneProtected, reason})
+			continue
+		}
+		if _, seen := pending[ev.UserID]; !seen {
+			userOrder = append(userOrder, ev.UserID)
+		}
+		pending[ev.UserID] = append(pending[ev.UserID], i)
+	}
+
+	// One rate-limit transaction per user; the earliest pixels win the quota.
+	// Same-color cooldowns are checked first so refused pixels cost no quota;
+	// writePixelBatch claims them. Admin imports skip both.
+	for _, userID := range userOrder {
+		idx := pending[userID]
+		// A batch comes from one publisher, so a user's pixels share a source
+		if isAdminImport(outcomes[idx[0]].Event) {
+			for _, i := range idx {
+				outcomes[i].Accepted = true
+			}
+			continue
+		}
+		if sameColorCooldown > 0 {
+			colors := make([]string, len(idx))
+			for n, i := range idx {
+				colors[n] = outcomes[i].Event.Color
+			}
+			retryAt := checkColorCooldowns(ctx, userID, colors)
+			kept := idx[:0]
+			for n, i := range idx {
+				if !retryAt[n].IsZero() {
+					outcomes[i].reject(&rejection{events.ReasonColorCooldown, colorCooldownMessage(outcomes[i].Event.Color, retryAt[n])})
+					continue
+				}
+				kept = append(kept, i)
+			}
+			if idx = kept; len(idx) == 0 {
+				continue
+			}
+		}
+		coords := make([]pixelCoord, len(idx))
+		for n, i := range idx {
+			coords[n] = pixelCoord{X: outcomes[i].Event.X, Y: outcomes[i].Event.Y}
+		}
+		rl := checkRateLimitN(ctx, userID, outcomes[idx[0]].Event.Source, coords)
+		// Granted pixels are the earliest ones not refused regionally
+		remaining := rl.Granted
+		regionRejected := 0
+		for n, i := range idx {
+			outcomes[i].RateLimit = rl
+			switch {
+			case rl.regionDenied(n):
+				outcomes[i].reject(&rejection{events.ReasonRateLimited, regionLimitMessage(rl)})
+				regionRejected++
+			case remaining > 0:
+				outcomes[i].Accepted = true
+				remaining--
+			default:
+				outcomes[i].reject(rateLimitRejection(rl))
+			}
+		}
+		if regionRejected > 0 {
+			slog.Warn("region_rate_limit_exceeded", "user_id", userID, "region_size", rl.RegionSize, "max", rl.RegionMax, "rejected", regionRejected)
+		}
+		if rl.Granted+regionRejected < len(idx) {
+			slog.Warn("rate_limit_exceeded", "user_id", userID, "count", rl.Count, "max", rl.Max, "source_limited", rl.SourceLimited, "rejected", len(idx)-rl.Granted-regionRejected)
+		}
+	}
+
+	blendMode := pixelrules.BlendReplace
+	if session != nil {
+		blendMode = session.BlendMode
+	}
+	if !writePixelBatch(ctx, outcomes, blendMode) {
+		// Each user's pixels were charged in one transaction, so one refund each
+		refunds := make(map[string][]pixelCoord)
+		charges := make(map[string]rateLimitResult)
+		for i := range outcomes {
+			if outcomes[i].Accepted {
+				outcomes[i].Accepted = false
+				outcomes[i].reject(newRejection(events.ReasonWriteFailed))
+				userID := outcomes[i].Event.UserID
+				refunds[userID] = append(refunds[userID], pixelCoord{X: outcomes[i].Event.X, Y: outcomes[i].Event.Y})
+				charges[userID] = outcomes[i].RateLimit
+			}
+		}
+		for _, userID := range userOrder {
+			if coords := refunds[userID]; len(coords) > 0 {
+				refundRateLimit(ctx, userID, charges[userID], coords)
+			}
+		}
+	}
+
+	accepted := 0
+	for _, o := range outcomes {
+		if o.Accepted {
+			accepted++
+		} else {
+			recordRejection(ctx, o.Event, rejection{o.Code, o.Reason})
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("batch.accepted", accepted),
+		attribute.Int("batch.rejected", len(outcomes)-accepted),
+	)
+	slog.Info("pixel_batch_processed", "size", len(outcomes), "accepted", accepted)
+
+	if accepted > 0 {
+		publishPixelBatchUpdate(ctx, outcomes)
+	}
+	notifyBatchOutcomes(ctx, outcomes)
+
+	return outcomes
+}
+
+// isAdminImport reports whether a batched placement comes from an admin's
+// /import-pixels file. The proxy only publishes Discord batches for imports
+// and verifySource has cleared the admin flag of any it could not trust.
+// Imports are validated pixel by pixel like other placements, but neither
+// charged to the per-minute quota nor held to same-color cooldowns: a file
+// holds up to 500 pixels, mostly of a few colors, well over both.
+func isAdminImport(ev messages.PixelEvent) bool {
+	return ev.Source == "discord" && ev.IsAdmin
+}
+
+// writePixelBatch stores accepted pixels and their history in transactions
+// of at most maxTransactionWrites writes, then user stats with a BulkWriter.
+// Each transaction reads its cells first and refuses, like a single placement, pixels that changed since
+// their client saw them, admins' pixels under protection and colors whose
+// cooldown another placement claimed since the check; their quota is
+// refunded. The cooldowns of written pixels are claimed in the same
+// transaction. When several accepted pixels share a coordinate the last one
+// is written; with a blend mode they are blended in order onto the existing
+// color, and each outcome's color is updated to what was blended at that
+// point. Conquest stats follow the same order, so a cell painted by two
+// users in one batch counts as the second taking it from the first. A
+// transaction that fails refuses only its own pixels, as write failures;
+// false is returned when the WAL could not be written and nothing was.
+func writePixelBatch(ctx context.Context, outcomes []pixelOutcome, blendMode string) bool {
+	ctx, span := tracer.Start(ctx, "writePixelBatch")
+	defer span.End()
+
+	placedAt := time.Now().UTC()
+
+	// Drawers are read once for their display names and streaks, outside any
+	// transaction; concurrent writers on the same day compute the same streak
+	var drawers []string
+	seenDrawers := make(map[string]bool)
+	var cells []string
+	seenCells := make(map[string]bool)
+	for _, o := range outcomes {
+		if !o.Accepted {
+			continue
+		}
+		if !seenDrawers[o.Event.UserID] {
+			seenDrawers[o.Event.UserID] = true
+			drawers = append(drawers, o.Event.UserID)
+		}
+		if pixelID := fmt.Sprintf("%d_%d", o.Event.X, o.Event.Y); !seenCells[pixelID] {
+			seenCells[pixelID] = true
+			cells = append(cells, pixelID)
+		}
+	}
+	if len(cells) == 0 {
+		return true
+	}
+	users := readUsers(ctx, drawers)
+
+	// One entry per cell, for its last placement; recovery blends that one
+	// onto the cell and checks it against what the cell's first placement saw
+	last := make(map[string]messages.PixelEvent, len(cells))
+	expected := make(map[string]string, len(cells))
+	for _, o := range outcomes {
+		if o.Accepted {
+			pixelID := fmt.Sprintf("%d_%d", o.Event.X, o.Event.Y)
+			if _, seen := last[pixelID]; !seen {
+				expected[pixelID] = o.Event.ExpectedUpdatedAt
+			}
+			last[pixelID] = o.Event
+		}
+	}
+	walEntries := make([]walEntry, len(cells))
+	for i, pixelID := range cells {
+		ev := last[pixelID]
+		walEntries[i] = walEntry{X: ev.X, Y: ev.Y, Color: ev.Color, BlendMode: blendMode, ExpectedUpdatedAt: expected[pixelID],
+			UserID: ev.UserID, Username: creditedName(users[ev.UserID], ev.Username), Source: ev.Source, AdminPlaced: ev.IsAdmin,
+			Imported: isAdminImport(ev), CreatedAt: placedAt}
+	}
+	wal, err := writeWAL(ctx, walEntries)
+	if err != nil {
+		span.SetAttributes(attribute.Bool("success", false))
+		return false
+	}
+
+	// Cells go to transactions in order and whole, so every placement on a
+	// cell is checked and written in the same one
+	placements := make(map[string][]messages.PixelEvent, len(cells))
+	for _, o := range outcomes {
+		if o.Accepted {
+			pixelID := fmt.Sprintf("%d_%d", o.Event.X, o.Event.Y)
+			placements[pixelID] = append(placements[pixelID], o.Event)
+		}
+	}
+	writes := make([]int, len(cells))
+	for i, pixelID := range cells {
+		writes[i] = cellWrites(placements[pixelID])
+	}
+	groups := groupCells(writes, maxTransactionWrites)
+	cellGroup := make(map[string]int, len(cells))
+	for g, r := range groups {
+		for _, pixelID := range cells[r[0]:r[1]] {
+			cellGroup[pixelID] = g
+		}
+	}
+
+	done := newBatchWrite()
+	for g, r := range groups {
+		group := cells[r[0]:r[1]]
+		var groupWAL []*firestore.DocumentRef
+		if wal != nil {
+			groupWAL = wal[r[0]:r[1]]
+		}
+		inGroup := func(ev messages.PixelEvent) bool {
+			return cellGroup[fmt.Sprintf("%d_%d", ev.X, ev.Y)] == g
+		}
+
+		// Filled by the transaction; a retried attempt starts over
+		var w *batchWrite
+		err = getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			w = newBatchWrite()
+
+			refs := make([]*firestore.DocumentRef, len(group))
+			for i, pixelID := range group {
+				refs[i] = getFirestore().Collection("pixels").Doc(pixelID)
+			}
+			docs, err := tx.GetAll(refs)
+			if err != nil {
+				return err
+			}
+			stored := make(map[string]map[string]interface{}, len(docs))
+			for _, doc := range docs {
+				if doc.Exists() {
+					stored[doc.Ref.ID] = doc.Data()
+				}
+			}
+			var keys []colorCooldownKey
+			for _, o := range outcomes {
+				if o.Accepted && inGroup(o.Event) && !isAdminImport(o.Event) {
+					keys = append(keys, colorCooldownKey{o.Event.UserID, o.Event.Color})
+				}
+			}
+			cooldowns, err := readColorCooldowns(ctx, tx, keys)
+			if err != nil {
+				return err
+			}
+
+			for i := range outcomes {
+				if !outcomes[i].Accepted || !inGroup(outcomes[i].Event) {
+					continue
+				}
+				ev := outcomes[i].Event
+				ev.Username = creditedName(users[ev.UserID], ev.Username)
+				pixelID := fmt.Sprintf("%d_%d", ev.X, ev.Y)
+				prev, seen := w.latest[pixelID]
+				base := existingFrom(stored[pixelID])
+				if seen {
+					base = existingPixel{Color: prev.Color, UserID: prev.UserID, AdminPlaced: prev.IsAdmin}
+				}
+				// Clients saw the canvas before this batch, so they are held to
+				// the stored pixel
+				if pixelrules.IsStale(stored[pixelID]["updatedAt"], ev.ExpectedUpdatedAt) {
+					w.refused[i] = newRejection(events.ReasonConflict)
+					continue
+				}
+				if isProtectedFrom(map[string]interface{}{"adminPlaced": base.AdminPlaced}, ev.IsAdmin) {
+					w.refused[i] = newRejection(events.ReasonPixelProtected)
+					continue
+				}
+				if !isAdminImport(ev) {
+					if retryAt := cooldowns.claim(ev.UserID, ev.Color); !retryAt.IsZero() {
+						w.refused[i] = &rejection{events.ReasonColorCooldown, colorCooldownMessage(ev.Color, retryAt)}
+						continue
+					}
+				}
+				if !seen {
+					w.pixelOrder = append(w.pixelOrder, pixelID)
+				}
+				if isConquest(base.UserID, ev.UserID) {
+					w.overwritten[ev.UserID]++
+					w.lost[base.UserID]++
+					w.tally.add(base.UserID, ev.UserID)
+				}
+				if blendMode != pixelrules.BlendReplace && base.Color != "" {
+					ev.Color = pixelrules.BlendHex(base.Color, ev.Color, blendMode)
+				}
+				w.colors[i] = ev.Color
+				w.latest[pixelID] = ev
+				if historyEnabled {
+					// Distinct timestamps keep the batch's order for readers that
+					// take the latest entry per coordinate
+					w.history = append(w.history, historyEntry{
+						X:             ev.X,
+						Y:             ev.Y,
+						Color:         ev.Color,
+						PreviousColor: base.Color,
+						UserID:        ev.UserID,
+						Username:      ev.Username,
+						Source:        ev.Source,
+						Timestamp:     placedAt.Add(time.Duration(len(done.history)+len(w.history)) * time.Microsecond),
+					})
+				}
+				w.userCounts[ev.UserID]++
+				w.usernames[ev.UserID] = ev.Username
+				if ev.GuildID != "" {
+					w.guilds[ev.UserID] = ev.GuildID
+				}
+			}
+
+			for _, pixelID := range w.pixelOrder {
+				ev := w.latest[pixelID]
+				if err := tx.Set(getFirestore().Collection("pixels").Doc(pixelID), map[string]interface{}{
+					"x":           ev.X,
+					"y":           ev.Y,
+					"color":       ev.Color,
+					"userId":      ev.UserID,
+					"username":    ev.Username,
+					"source":      ev.Source,
+					"updatedAt":   placedAt,
+					"adminPlaced": ev.IsAdmin,
+				}); err != nil {
+					return err
+				}
+			}
+			if err := cooldowns.write(tx); err != nil {
+				return err
+			}
+			for _, h := range w.history {
+				if err := tx.Create(newHistoryRef(), h); err != nil {
+					return err
+				}
+			}
+			// A cell whose every placement was refused is not written
+			for i, ref := range groupWAL {
+				settled := walAborted
+				if _, written := w.latest[group[i]]; written {
+					settled = walCommitted
+				}
+				if err := tx.Update(ref, walSettled(settled)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Error("pixel_batch_write_failed", "pixels", len(group), "group", g, "groups", len(groups), "error", err.Error())
+			// The placements are reported as failed, so none may be replayed
+			settleWAL(ctx, groupWAL, walAborted)
+			for i, o := range outcomes {
+				if o.Accepted && inGroup(o.Event) {
+					done.refused[i] = newRejection(events.ReasonWriteFailed)
+				}
+			}
+			continue
+		}
+		done.merge(w)
+	}
+
+	refusedPixels := make(map[string][]pixelCoord)
+	charges := make(map[string]rateLimitResult)
+	var refusedUsers []string
+	for i := range outcomes {
+		if color, ok := done.colors[i]; ok {
+			outcomes[i].Event.Color = color
+		}
+		r, ok := done.refused[i]
+		if !ok {
+			continue
+		}
+		ev := outcomes[i].Event
+		switch r.Reason {
+		case events.ReasonConflict:
+			slog.Info("pixel_placement_conflict", "x", ev.X, "y", ev.Y, "user_id", ev.UserID, "expected_updated_at", ev.ExpectedUpdatedAt)
+		case events.ReasonPixelProtected:
+			slog.Info("pixel_placement_protected", "x", ev.X, "y", ev.Y, "user_id", ev.UserID)
+		case events.ReasonWriteFailed:
+			// Logged once for its transaction
+		default:
+			slog.Warn("color_cooldown_active", "user_id", ev.UserID, "color", ev.Color)
+		}
+		outcomes[i].Accepted = false
+		outcomes[i].reject(r)
+		if _, seen := refusedPixels[ev.UserID]; !seen {
+			refusedUsers = append(refusedUsers, ev.UserID)
+		}
+		refusedPixels[ev.UserID] = append(refusedPixels[ev.UserID], pixelCoord{X: ev.X, Y: ev.Y})
+		charges[ev.UserID] = outcomes[i].RateLimit
+	}
+	// The refused pixels never landed; with RATE_LIMIT_REFUND they cost no quota
+	for _, userID := range refusedUsers {
+		refundRateLimit(ctx, userID, charges[userID], refusedPixels[userID])
+	}
+	if len(done.pixelOrder) == 0 {
+		return true
+	}
+
+	bw := getFirestore().BulkWriter(ctx)
+
+	// One write per user document: BulkWriter refuses a second write to the
+	// same document, so losses of users who also drew are folded in.
+	streaks := make(map[string]userStreak, len(done.userCounts))
+	userJobs := make(map[string]*firestore.BulkWriterJob)
+	for userID, n := range done.userCounts {
+		streaks[userID] = streakFromUser(users[userID]).advance(placedAt)
+		updates := append([]firestore.Update{
+			{Path: "lastPixelAt", Value: placedAt},
+			{Path: "pixelCount", Value: firestore.Increment(n)},
+			{Path: "pixelsOverwritten", Value: firestore.Increment(done.overwritten[userID])},
+			{Path: "pixelsLost", Value: firestore.Increment(done.lost[userID])},
+		}, streaks[userID].updates()...)
+		// Role rewards are granted in the guild of the last Discord placement
+		if done.guilds[userID] != "" {
+			updates = append(updates, firestore.Update{Path: "guildId", Value: done.guilds[userID]})
+		}
+		job, err := bw.Update(getFirestore().Collection("users").Doc(userID), updates)
+		if err == nil {
+			userJobs[userID] = job
+		}
+	}
+	// Owners that did not draw in this batch; missing (deleted) users are skipped
+	for userID, n := range done.lost {
+		if _, drew := done.userCounts[userID]; drew {
+			continue
+		}
+		bw.Update(getFirestore().Collection("users").Doc(userID), []firestore.Update{
+			{Path: "pixelsLost", Value: firestore.Increment(n)},
+		})
+	}
+	bw.End()
+
+	// Users seen for the first time have no document to update yet
+	for userID, job := range userJobs {
+		if _, err := job.Results(); status.Code(err) == codes.NotFound {
+			user := map[string]interface{}{
+				"id":                userID,
+				"username":          done.usernames[userID],
+				"lastPixelAt":       placedAt,
+				"pixelCount":        done.userCounts[userID],
+				"pixelsOverwritten": done.overwritten[userID],
+				"pixelsLost":        done.lost[userID],
+				"createdAt":         placedAt,
+				"lastActiveDay":     streaks[userID].LastActiveDay,
+				"streakDays":        streaks[userID].Days,
+				"bestStreakDays":    streaks[userID].Best,
+			}
+			if done.guilds[userID] != "" {
+				user["guildId"] = done.guilds[userID]
+			}
+			getFirestore().Collection("users").Doc(userID).Set(ctx, user)
+		}
+	}
+
+	requestLeaderboardRefresh(ctx)
+	publishOverwriteNotices(ctx, done.tally, done.usernames)
+	// The totals are read back once the user updates above have committed
+	publishRoleRewardChecks(ctx, nil, done.userCounts)
+
+	span.SetAttributes(
+		attribute.Int("batch.pixels_written", len(done.pixelOrder)),
+		attribute.Int("batch.transactions", len(groups)),
+		attribute.Bool("success", true),
+	)
+	return true
+}
+
+// maxTransactionWrites is the most writes Firestore commits in one transaction
+const maxTransactionWrites = 500
+
+// cellWrites is how many writes a transaction makes for the placements on
+// one cell: the pixel and its WAL entry, then per placement a history entry
+// and a color cooldown. Placements of one color share a cooldown, so this
+// may count more than are made, never fewer.
+func cellWrites(placements []messages.PixelEvent) int {
+	n := 1
+	if walEnabled {
+		n++
+	}
+	for _, ev := range placements {
+		if historyEnabled {
+			n++
+		}
+		if sameColorCooldown > 0 && !isAdminImport(ev) {
+			n++
+		}
+	}
+	return n
+}
+
+// groupCells splits cells, given their writes in order, into consecutive
+// [start, end) runs of at most limit writes. A cell is never split, so one
+// over the limit on its own gets a run of its own.
+func groupCells(writes []int, limit int) [][2]int {
+	var groups [][2]int
+	start, total := 0, 0
+	for i, n := range writes {
+		if i > start && total+n > limit {
+			groups = append(groups, [2]int{start, i})
+			start, total = i, 0
+		}
+		total += n
+	}
+	if start < len(writes) {
+		groups = append(groups, [2]int{start, len(writes)})
+	}
+	return groups
+}
+
+// batchWrite is what writePixelBatch's transactions wrote: outcomes by
+// index, cells by pixel ID and stats by user ID
+type batchWrite struct {
+	latest      map[string]messages.PixelEvent
+	colors      map[int]string
+	refused     map[int]*rejection
+	history     []historyEntry
+	pixelOrder  []string
+	userCounts  map[string]int
+	usernames   map[string]string
+	guilds      map[string]string
+	overwritten map[string]int
+	lost        map[string]int
+	tally       overwriteTally
+}
+
+func newBatchWrite() *batchWrite {
+	return &batchWrite{
+		latest:      make(map[string]messages.PixelEvent),
+		colors:      make(map[int]string),
+		refused:     make(map[int]*rejection),
+		userCounts:  make(map[string]int),
+		usernames:   make(map[string]string),
+		guilds:      make(map[string]string),
+		overwritten: make(map[string]int),
+		lost:        make(map[string]int),
+		tally:       make(overwriteTally),
+	}
+}
+
+// merge adds a committed transaction's writes; transactions cover distinct
+// cells, so only the per-user stats add up
+func (w *batchWrite) merge(o *batchWrite) {
+	maps.Copy(w.latest, o.latest)
+	maps.Copy(w.colors, o.colors)
+	maps.Copy(w.refused, o.refused)
+	w.history = append(w.history, o.history...)
+	w.pixelOrder = append(w.pixelOrder, o.pixelOrder...)
+	for userID, n := range o.userCounts {
+		w.userCounts[userID] += n
+	}
+	maps.Copy(w.usernames, o.usernames)
+	maps.Copy(w.guilds, o.guilds)
+	for userID, n := range o.overwritten {
+		w.overwritten[userID] += n
+	}
+	for userID, n := range o.lost {
+		w.lost[userID] += n
+	}
+	for ownerID, by := range o.tally {
+		if w.tally[ownerID] == nil {
+			w.tally[ownerID] = make(map[string]int)
+		}
+		for userID, n := range by {
+			w.tally[ownerID][userID] += n
+		}
+	}
+}
+
+// existingPixel is what a cell held before the batch
+type existingPixel struct {
+	Color       string
+	UserID      string
+	AdminPlaced bool
+}
+
+// existingFrom reads a stored pixel; nil is an empty cell
+func existingFrom(data map[string]interface{}) existingPixel {
+	color, _ := data["color"].(string)
+	userID, _ := data["userId"].(string)
+	adminPlaced, _ := data["adminPlaced"].(bool)
+	return existingPixel{Color: color, UserID: userID, AdminPlaced: adminPlaced}
+}
+
+func publishPixelBatchUpdate(ctx context.Context, outcomes []pixelOutcome) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	pixels := make([]map[string]interface{}, 0, len(outcomes))
+	for _, o := range outcomes {
+		if !o.Accepted {
+			continue
+		}
+		pixels = append(pixels, map[string]interface{}{
+			"x":         o.Event.X,
+			"y":         o.Event.Y,
+			"color":     o.Event.Color,
+			"userId":    o.Event.UserID,
+			"username":  o.Event.Username,
+			"timestamp": now,
+		})
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"pixels":    pixels,
+		"timestamp": now,
+	})
+
+	if err := fanOutPublish(ctx, downstreamTopics, data, map[string]string{"type": "pixel_batch_update"}); err != nil {
+		slog.Warn("pixel_update_publish_failed", "error", err.Error())
+	}
+}
+
+// notifyBatchOutcomes sends one follow-up per Discord interaction and one
+// channel message per web user summarizing their pixels. Each rejected web
+// pixel gets its own pixel_rejected event.
+func notifyBatchOutcomes(ctx context.Context, outcomes []pixelOutcome) {
+	type interactionKey struct{ appID, token string }
+	byInteraction := make(map[interactionKey][]pixelOutcome)
+	var interactionOrder []interactionKey
+	webPlaced := make(map[string][]pixelOutcome)
+	var webOrder []string
+
+	for _, o := range outcomes {
+		ev := o.Event
+		if ev.Source == "discord" {
+			k := interactionKey{ev.ApplicationID, ev.InteractionToken}
+			if _, seen := byInteraction[k]; !seen {
+				interactionOrder = append(interactionOrder, k)
+			}
+			byInteraction[k] = append(byInteraction[k], o)
+		} else if !o.Accepted {
+			// Web and unverified placements learn of refusals on the public topic
+			publishRejection(ctx, ev, rejection{o.Code, o.Reason})
+		} else if ev.Source == "web" {
+			if _, seen := webPlaced[ev.Username]; !seen {
+				webOrder = append(webOrder, ev.Username)
+			}
+			webPlaced[ev.Username] = append(webPlaced[ev.Username], o)
+		}
+	}
+
+	for _, k := range interactionOrder {
+		outcomes := byInteraction[k]
+		if allAccepted(outcomes) {
+			replySuccess(k.appID, k.token, summarizeOutcomes(outcomes))
+		} else {
+			sendFollowUp(k.appID, k.token, summarizeOutcomes(outcomes))
+		}
+	}
+
+	for _, username := range webOrder {
+		placed := webPlaced[username]
+		if len(placed) == 1 {
+			o := placed[0]
+			sendChannelMessage(username, fmt.Sprintf("placed a pixel at (%d, %d) with color #%s", o.UserX, o.UserY, o.Event.Color))
+		} else {
+			sendChannelMessage(username, fmt.Sprintf("placed %d pixels", len(placed)))
+		}
+	}
+}
+
+func allAccepted(outcomes []pixelOutcome) bool {
+	for _, o := range outcomes {
+		if !o.Accepted {
+			return false
+		}
+	}
+	return true
+}
+
+// summarizeOutcomes describes an interaction's pixels in one message. Up to
+// batchRejectedListMax rejected pixels are listed with their reason, fewer
+// when long reasons would take the message past Discord's limit.
+func summarizeOutcomes(outcomes []pixelOutcome) string {
+	if len(outcomes) == 1 {
+		o := outcomes[0]
+		if o.Accepted {
+			return formatPlacementSuccess(o.UserX, o.UserY, o.Event.Color, o.GridSnap, o.RateLimit)
+		}
+		return o.Reason
+	}
+
+	accepted := 0
+	var rejected []string
+	var rl rateLimitResult
+	for _, o := range outcomes {
+		if o.RateLimit.Max > 0 {
+			rl = o.RateLimit
+		}
+		if o.Accepted {
+			accepted++
+		} else {
+			rejected = append(rejected, fmt.Sprintf("(%d, %d): %s", o.UserX, o.UserY, o.Reason))
+		}
+	}
+
+	msg := fmt.Sprintf("Placed %d/%d pixels", accepted, len(outcomes))
+	if accepted > 0 && rl.Max > 0 && showRemainingBudget {
+		msg += quotaFooter(rl)
+	}
+	if len(rejected) > 0 {
+		msg += "\nRejected:"
+		for i, line := range rejected {
+			more := fmt.Sprintf("\n…and %d more", len(rejected)-i)
+			if i == batchRejectedListMax || len(msg)+1+len(line)+len(more) > discordMessageLimit {
+				msg += more
+				break
+			}
+			msg += "\n" + line
+		}
+	}
+	return msg
+}
@@ -0,0 +1,228 @@
+package pixelworker
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+func TestIsAdminImport(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   messages.PixelEvent
+		want bool
+	}{
+		{"admin import", messages.PixelEvent{Source: "discord", IsAdmin: true}, true},
+		{"member import", messages.PixelEvent{Source: "discord"}, false},
+		{"web admin", messages.PixelEvent{Source: "web", IsAdmin: true}, false},
+		{"unverified admin", messages.PixelEvent{Source: sourceUnverified, IsAdmin: true}, false},
+	}
+	for _, tt := range tests {
+		if got := isAdminImport(tt.ev); got != tt.want {
+			t.Errorf("%s: isAdminImport() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSummarizeOutcomesTruncatesRejections(t *testing.T) {
+	outcomes := make([]pixelOutcome, 100)
+	for i := range outcomes {
+		outcomes[i] = pixelOutcome{UserX: i, UserY: i, Reason: "Rate limit exceeded"}
+	}
+	got := summarizeOutcomes(outcomes)
+	if !strings.HasPrefix(got, "Placed 0/100 pixels\nRejected:\n(0, 0): Rate limit exceeded\n") {
+		t.Errorf("summary starts %q", got[:min(len(got), 80)])
+	}
+	if !strings.HasSuffix(got, "\n(9, 9): Rate limit exceeded\n…and 90 more") {
+		t.Errorf("summary = %q, want 10 rejections then the count of the rest", got)
+	}
+
+	// Long reasons stop the list before the 10th
+	long := strings.Repeat("x", 400)
+	for i := range outcomes {
+		outcomes[i].Reason = long
+	}
+	got = summarizeOutcomes(outcomes)
+	if len(got) > discordMessageLimit {
+		t.Errorf("summary is %d bytes, over Discord's %d", len(got), discordMessageLimit)
+	}
+	if !strings.HasSuffix(got, "…and 96 more") {
+		t.Errorf("summary ends %q, want 4 rejections listed", got[max(0, len(got)-40):])
+	}
+}
+
+func TestCellWrites(t *testing.T) {
+	defer func(wal, history bool) { walEnabled, historyEnabled = wal, history }(walEnabled, historyEnabled)
+	withColorCooldown(t, time.Minute)
+	drawn := []messages.PixelEvent{{Source: "web"}, {Source: "web"}}
+	imported := []messages.PixelEvent{{Source: "discord", IsAdmin: true}}
+
+	walEnabled, historyEnabled = false, false
+	if got := cellWrites(drawn); got != 3 {
+		t.Errorf("pixel and cooldowns = %d writes, want 3", got)
+	}
+	walEnabled, historyEnabled = true, true
+	if got := cellWrites(drawn); got != 6 {
+		t.Errorf("with WAL and history = %d writes, want 6", got)
+	}
+	// Imports claim no cooldown
+	if got := cellWrites(imported); got != 3 {
+		t.Errorf("import = %d writes, want 3", got)
+	}
+}
+
+func TestGroupCells(t *testing.T) {
+	ones := func(n int) []int {
+		w := make([]int, n)
+		for i := range w {
+			w[i] = 1
+		}
+		return w
+	}
+	tests := []struct {
+		name   string
+		writes []int
+		want   [][2]int
+	}{
+		{"none", nil, nil},
+		{"at the limit", ones(maxTransactionWrites), [][2]int{{0, 500}}},
+		{"one over", ones(maxTransactionWrites + 1), [][2]int{{0, 500}, {500, 501}}},
+		{"cells stay whole", []int{200, 200, 200}, [][2]int{{0, 2}, {2, 3}}},
+		{"cell over the limit", []int{1, 600, 1}, [][2]int{{0, 1}, {1, 2}, {2, 3}}},
+	}
+	for _, tt := range tests {
+		if got := groupCells(tt.writes, maxTransactionWrites); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: groupCells() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPixelBatchOverTransactionLimit(t *testing.T) {
+	requireEmulator(t)
+	usePubsubFake(t)
+	defer func(wal, history bool) { walEnabled, historyEnabled = wal, history }(walEnabled, historyEnabled)
+	walEnabled, historyEnabled = true, true
+	seedSession(t, 20, 20, nil)
+
+	// Three writes a pixel, so two transactions
+	const admin = "123456789012345678"
+	n := maxTransactionWrites/3 + 100
+	var imported []messages.PixelEvent
+	for i := 0; i < n; i++ {
+		imported = append(imported, messages.PixelEvent{UserID: admin, Username: "boss", X: i % 20, Y: i / 20, Color: "00FF00",
+			Source: "discord", IsAdmin: true, ApplicationID: "app", InteractionToken: "tok"})
+	}
+	for i, o := range processPixelBatch(t.Context(), imported) {
+		if !o.Accepted {
+			t.Errorf("pixel %d refused: %s", i, o.Reason)
+		}
+	}
+
+	if got := toInt(readDoc(t, "users/"+admin)["pixelCount"]); got != n {
+		t.Errorf("pixelCount = %d, want %d", got, n)
+	}
+	history, err := getFirestore().Collection("pixel_history").Documents(t.Context()).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != n {
+		t.Errorf("%d history entries, want %d", len(history), n)
+	}
+	statuses := walStatuses(t)
+	committed := 0
+	for _, s := range statuses {
+		if s == walCommitted {
+			committed++
+		}
+	}
+	if len(statuses) != n || committed != n {
+		t.Errorf("%d of %d WAL entries committed, want %d", committed, len(statuses), n)
+	}
+}
+
+func TestAdminImportNotRateLimited(t *testing.T) {
+	requireEmulator(t)
+	usePubsubFake(t)
+	withColorCooldown(t, time.Minute)
+	seedSession(t, 10, 10, nil)
+	window := awaitFreshWindow(t)
+	ctx := t.Context()
+
+	const admin, alice = "123456789012345678", "223456789012345678"
+	n := rateLimitMax + 10
+	var imported, drawn []messages.PixelEvent
+	for i := 0; i < n; i++ {
+		imported = append(imported, messages.PixelEvent{UserID: admin, Username: "boss", X: i % 10, Y: i / 10, Color: "00FF00",
+			Source: "discord", IsAdmin: true, ApplicationID: "app", InteractionToken: "tok"})
+		drawn = append(drawn, messages.PixelEvent{UserID: alice, Username: "alice", X: i % 10, Y: 5 + i/10, Color: fmt.Sprintf("%06X", i+1),
+			Source: "discord", ApplicationID: "app", InteractionToken: "tok2"})
+	}
+
+	for i, o := range processPixelBatch(ctx, imported) {
+		if !o.Accepted {
+			t.Errorf("imported pixel %d refused: %s", i, o.Reason)
+		}
+	}
+	for _, ev := range imported {
+		if got := readDoc(t, fmt.Sprintf("pixels/%d_%d", ev.X, ev.Y))["color"]; got != "00FF00" {
+			t.Errorf("pixel (%d, %d) = %v, want 00FF00", ev.X, ev.Y, got)
+		}
+	}
+	if got := toInt(readDoc(t, "users/"+admin)["pixelCount"]); got != n {
+		t.Errorf("admin pixelCount = %d, want %d", got, n)
+	}
+	if doc := readDoc(t, fmt.Sprintf("rate_limits/%s_%d", admin, window)); doc != nil {
+		t.Errorf("import charged the rate limit: %v", doc)
+	}
+
+	// The same batch from a member is held to the quota
+	accepted := 0
+	for _, o := range processPixelBatch(ctx, drawn) {
+		if o.Accepted {
+			accepted++
+		}
+	}
+	if accepted != rateLimitMax {
+		t.Errorf("member placed %d pixels, want %d", accepted, rateLimitMax)
+	}
+}
+
+func TestPixelBatchRoleRewardReadsCommittedCount(t *testing.T) {
+	requireEmulator(t)
+	ps := usePubsubFake(t)
+	seedSession(t, 10, 10, nil)
+	seedDoc(t, "config/rewards", map[string]interface{}{"roles": map[string]interface{}{"5": "role-5"}})
+	rewardsMu.Lock()
+	rewardThresholdsFetched = time.Time{}
+	rewardsMu.Unlock()
+
+	const alice = "123456789012345678"
+	seedDoc(t, "users/"+alice, map[string]interface{}{"id": alice, "username": "alice", "pixelCount": 3})
+	var placements []messages.PixelEvent
+	for i := 0; i < 2; i++ {
+		placements = append(placements, messages.PixelEvent{UserID: alice, Username: "alice", X: i, Y: 0, Color: fmt.Sprintf("%06X", i+1),
+			Source: "discord", GuildID: "g1", ApplicationID: "app", InteractionToken: "tok"})
+	}
+	processPixelBatch(t.Context(), placements)
+
+	got := ps.publishedOfType(messages.TypeRoleReward)
+	if len(got) != 1 {
+		t.Fatalf("published %d role reward checks, want 1", len(got))
+	}
+	var check messages.RoleRewardCheck
+	if err := json.Unmarshal(got[0].Data, &check); err != nil {
+		t.Fatal(err)
+	}
+	if check.UserID != alice || check.PixelCount != 5 {
+		t.Errorf("role reward check = %+v, want alice at 5", check)
+	}
+	// The snapshot worker grants the role in this guild
+	if got := readDoc(t, "users/"+alice)["guildId"]; got != "g1" {
+		t.Errorf("guildId = %v, want g1", got)
+	}
+}
@@ -0,0 +1,155 @@
+package pixelworker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/team11/pixel-worker/internal/pixelrules"
+)
+
+// colorCooldownKey names one user's cooldown for one color
+type colorCooldownKey struct {
+	UserID string
+	Color  string
+}
+
+func (k colorCooldownKey) id() string {
+	return pixelrules.CooldownID(k.UserID, k.Color)
+}
+
+// colorCooldowns decides same-color cooldowns (SAME_COLOR_COOLDOWN) for a
+// set of placements, from when each color was last placed. Within one set
+// only the first pixel of a user's color is granted.
+type colorCooldowns struct {
+	now     time.Time
+	last    map[string]time.Time
+	claimed map[string]colorCooldownKey
+}
+
+func newColorCooldowns(now time.Time) *colorCooldowns {
+	return &colorCooldowns{now: now, last: make(map[string]time.Time), claimed: make(map[string]colorCooldownKey)}
+}
+
+// claim grants the color and returns the zero time, or returns when the
+// user may place it again
+func (c *colorCooldowns) claim(userID, color string) time.Time {
+	if sameColorCooldown <= 0 {
+		return time.Time{}
+	}
+	k := colorCooldownKey{userID, color}
+	id := k.id()
+	if _, ok := c.claimed[id]; ok {
+		return c.now.Add(sameColorCooldown)
+	}
+	if last := c.last[id]; c.now.Sub(last) < sameColorCooldown {
+		return last.Add(sameColorCooldown)
+	}
+	c.claimed[id] = k
+	return time.Time{}
+}
+
+// readColorCooldowns reads the cooldowns of keys, through tx when it is not
+// nil. Placements read them before charging quota, so refused pixels cost
+// none, and again in their write transaction, which claims them.
+func readColorCooldowns(ctx context.Context, tx *firestore.Transaction, keys []colorCooldownKey) (*colorCooldowns, error) {
+	c := newColorCooldowns(time.Now())
+	if sameColorCooldown <= 0 || len(keys) == 0 {
+		return c, nil
+	}
+	refs := make([]*firestore.DocumentRef, 0, len(keys))
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if id := k.id(); !seen[id] {
+			seen[id] = true
+			refs = append(refs, getFirestore().Collection("color_cooldowns").Doc(id))
+		}
+	}
+	var docs []*firestore.DocumentSnapshot
+	var err error
+	if tx != nil {
+		docs, err = tx.GetAll(refs)
+	} else {
+		docs, err = getFirestore().GetAll(ctx, refs)
+	}
+	if err != nil {
+		return c, err
+	}
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		if t, ok := pixelrules.StoredTime(doc.Data()["lastPlacedAt"]); ok {
+			c.last[doc.Ref.ID] = t
+		}
+	}
+	return c, nil
+}
+
+// write starts a cooldown for every color claimed; call it after the
+// transaction's reads
+func (c *colorCooldowns) write(tx *firestore.Transaction) error {
+	for id, k := range c.claimed {
+		if err := tx.Set(getFirestore().Collection("color_cooldowns").Doc(id), pixelrules.CooldownDoc(k.UserID, k.Color, c.now, sameColorCooldown)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkColorCooldowns checks colors, in order, against the user's cooldowns
+// without claiming them. It returns, per color, the zero time when the
+// color is free, or when the user may place it again. Errors fail open,
+// like the rate limiter.
+func checkColorCooldowns(ctx context.Context, userID string, colors []string) []time.Time {
+	retryAt := make([]time.Time, len(colors))
+	if sameColorCooldown <= 0 || len(colors) == 0 {
+		return retryAt
+	}
+
+	ctx, span := tracer.Start(ctx, "checkColorCooldowns")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("user.id", userID),
+		attribute.Int("color_cooldown.requested", len(colors)),
+	)
+
+	keys := make([]colorCooldownKey, len(colors))
+	for i, color := range colors {
+		keys[i] = colorCooldownKey{userID, color}
+	}
+	c, err := readColorCooldowns(ctx, nil, keys)
+	if err != nil {
+		return retryAt // fail open
+	}
+	denied := 0
+	for i, color := range colors {
+		if retryAt[i] = c.claim(userID, color); !retryAt[i].IsZero() {
+			denied++
+		}
+	}
+	span.SetAttributes(attribute.Int("color_cooldown.denied", denied))
+	return retryAt
+}
+
+// colorCooldownError means the write transaction found the color cooling
+// down after all: another placement claimed it since the check
+type colorCooldownError struct {
+	retryAt time.Time
+}
+
+func (e *colorCooldownError) Error() string {
+	return "color is cooling down until " + e.retryAt.UTC().Format(time.RFC3339)
+}
+
+// colorCooldownMessage explains a same-color rejection. Web clients get the
+// same text, so it avoids Discord timestamp markup.
+func colorCooldownMessage(color string, retryAt time.Time) string {
+	wait := max(time.Until(retryAt).Round(time.Second), time.Second)
+	return fmt.Sprintf("You placed #%s too recently: each color has a %s cooldown. Try another color, or use this one again in %s.",
+		strings.ToUpper(color), sameColorCooldown, wait)
+}
@@ -0,0 +1,97 @@
+package pixelworker
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/team11/pixel-worker/internal/events"
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+// withColorCooldown sets SAME_COLOR_COOLDOWN for the rest of the test
+func withColorCooldown(t *testing.T, d time.Duration) {
+	t.Helper()
+	prev := sameColorCooldown
+	sameColorCooldown = d
+	t.Cleanup(func() { sameColorCooldown = prev })
+}
+
+func TestColorCooldownsClaim(t *testing.T) {
+	withColorCooldown(t, time.Minute)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := newColorCooldowns(now)
+	c.last["u1_FF0000"] = now.Add(-30 * time.Second)
+	c.last["u1_0000FF"] = now.Add(-2 * time.Minute)
+
+	tests := []struct {
+		name   string
+		user   string
+		color  string
+		wantAt time.Time
+	}{
+		{"same color within the cooldown", "u1", "ff0000", now.Add(30 * time.Second)},
+		{"different color", "u1", "00FF00", time.Time{}},
+		{"that color again in the same set", "u1", "00ff00", now.Add(time.Minute)},
+		{"cooldown over", "u1", "0000FF", time.Time{}},
+		{"another user's color", "u2", "FF0000", time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := c.claim(tt.user, tt.color); !got.Equal(tt.wantAt) {
+			t.Errorf("%s: claim(%s, %s) = %v, want %v", tt.name, tt.user, tt.color, got, tt.wantAt)
+		}
+	}
+	if len(c.claimed) != 3 {
+		t.Errorf("claimed %d cooldowns, want 3: %v", len(c.claimed), c.claimed)
+	}
+}
+
+func TestColorCooldownsOff(t *testing.T) {
+	withColorCooldown(t, 0)
+	c := newColorCooldowns(time.Now())
+	for range 2 {
+		if got := c.claim("u1", "FF0000"); !got.IsZero() {
+			t.Fatalf("claim() = %v with the cooldown off, want the zero time", got)
+		}
+	}
+	if len(c.claimed) != 0 {
+		t.Errorf("claimed %v with the cooldown off", c.claimed)
+	}
+}
+
+func TestColorCooldownPlacements(t *testing.T) {
+	requireEmulator(t)
+	withColorCooldown(t, time.Minute)
+	seedSession(t, 10, 10, nil)
+	window := awaitFreshWindow(t)
+	const user = "123456789012345678"
+	ps := usePubsubFake(t)
+
+	placePixel(t, messages.PixelEvent{UserID: user, X: 1, Y: 1, Color: "FF0000", Source: "web"})
+	placePixel(t, messages.PixelEvent{UserID: user, X: 2, Y: 2, Color: "ff0000", Source: "web", RequestID: "again"})
+	placePixel(t, messages.PixelEvent{UserID: user, X: 3, Y: 3, Color: "0000FF", Source: "web"})
+
+	got := ps.publishedOfType(events.TypePixelRejected)
+	if len(got) != 1 {
+		t.Fatalf("published %d rejections, want 1", len(got))
+	}
+	var rej events.PixelRejected
+	json.Unmarshal(got[0].Data, &rej)
+	if rej.RequestID != "again" || rej.Reason != events.ReasonColorCooldown {
+		t.Errorf("rejection = %+v, want the repeated color refused with %s", rej, events.ReasonColorCooldown)
+	}
+	if doc := readDoc(t, "pixels/2_2"); doc != nil {
+		t.Errorf("pixel written for a cooled-down color: %v", doc)
+	}
+	if got := readDoc(t, "pixels/3_3")["color"]; got != "0000FF" {
+		t.Errorf("different color = %v, want 0000FF placed", got)
+	}
+	// The refused pixel cost no quota
+	if got := toInt(readDoc(t, fmt.Sprintf("rate_limits/%s_%d", user, window))["count"]); got != 2 {
+		t.Errorf("rate limit count = %d, want 2", got)
+	}
+	if got := readDoc(t, "color_cooldowns/"+user+"_FF0000"); got == nil {
+		t.Error("no cooldown claimed for FF0000")
+	}
+}
@@ -0,0 +1,92 @@
+package pixelworker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/team11/pixel-worker/internal/events"
+	"github.com/team11/pixel-worker/internal/messages"
+	"github.com/team11/pixel-worker/internal/pixelrules"
+)
+
+func TestUpdatePixelConflict(t *testing.T) {
+	requireEmulator(t)
+	ctx := t.Context()
+
+	if _, err := updatePixel(ctx, 4, 4, "FF0000", pixelrules.BlendReplace, "u1", "one", "web", "", "", false); err != nil {
+		t.Fatalf("first placement: %v", err)
+	}
+	placed, _ := pixelrules.StoredTime(readDoc(t, "pixels/4_4")["updatedAt"])
+	seen := placed.Format(time.RFC3339Nano)
+	before := placed.Add(-time.Second).Format(time.RFC3339Nano)
+
+	// A client that saw the canvas before the first placement is refused
+	_, err := updatePixel(ctx, 4, 4, "0000FF", pixelrules.BlendReplace, "u2", "two", "web", before, "", false)
+	if !errors.Is(err, errPixelConflict) {
+		t.Fatalf("stale placement error = %v, want errPixelConflict", err)
+	}
+	if got := readDoc(t, "pixels/4_4"); got["color"] != "FF0000" || got["userId"] != "u1" {
+		t.Errorf("pixel after conflict = %v, want u1's FF0000", got)
+	}
+	if got := toInt(readDoc(t, "users/u2")["pixelCount"]); got != 0 {
+		t.Errorf("u2 pixelCount = %d after a refused placement, want 0", got)
+	}
+
+	// One that saw it is not, and neither is one without an expectation
+	if _, err := updatePixel(ctx, 4, 4, "0000FF", pixelrules.BlendReplace, "u2", "two", "web", seen, "", false); err != nil {
+		t.Fatalf("placement over the seen version: %v", err)
+	}
+	if got := readDoc(t, "pixels/4_4"); got["color"] != "0000FF" || got["userId"] != "u2" {
+		t.Errorf("pixel = %v, want u2's 0000FF", got)
+	}
+	if _, err := updatePixel(ctx, 4, 4, "00FF00", pixelrules.BlendReplace, "u1", "one", "web", "", "", false); err != nil {
+		t.Fatalf("last-write-wins placement: %v", err)
+	}
+	if got := readDoc(t, "pixels/4_4")["color"]; got != "00FF00" {
+		t.Errorf("color = %v, want 00FF00", got)
+	}
+}
+
+func TestPixelBatchConflictAndProtection(t *testing.T) {
+	requireEmulator(t)
+	usePubsubFake(t)
+	defer func(v bool) { protectAdminPixels = v }(protectAdminPixels)
+	protectAdminPixels = true
+	seedSession(t, 10, 10, nil)
+	ctx := t.Context()
+
+	if _, err := updatePixel(ctx, 4, 4, "FF0000", pixelrules.BlendReplace, "u1", "one", "web", "", "", false); err != nil {
+		t.Fatalf("first placement: %v", err)
+	}
+	if _, err := updatePixel(ctx, 5, 5, "FF0000", pixelrules.BlendReplace, "admin", "boss", "discord", "", "", true); err != nil {
+		t.Fatalf("admin placement: %v", err)
+	}
+	placed, _ := pixelrules.StoredTime(readDoc(t, "pixels/4_4")["updatedAt"])
+	before := placed.Add(-time.Second).Format(time.RFC3339Nano)
+	seen := placed.Format(time.RFC3339Nano)
+
+	const alice, bob = "123456789012345678", "223456789012345678"
+	outcomes := processPixelBatch(ctx, []messages.PixelEvent{
+		{UserID: alice, X: 4, Y: 4, Color: "0000FF", Source: "web", ExpectedUpdatedAt: before},
+		{UserID: alice, X: 5, Y: 5, Color: "0000FF", Source: "web"},
+		{UserID: alice, X: 6, Y: 6, Color: "0000FF", Source: "web"},
+		{UserID: bob, X: 4, Y: 4, Color: "00FF00", Source: "web", ExpectedUpdatedAt: seen},
+	})
+
+	want := []events.RejectReason{events.ReasonConflict, events.ReasonPixelProtected, "", ""}
+	for i, o := range outcomes {
+		if o.Code != want[i] || o.Accepted != (want[i] == "") {
+			t.Errorf("pixel %d: accepted %v, code %q, want code %q", i, o.Accepted, o.Code, want[i])
+		}
+	}
+	if got := readDoc(t, "pixels/4_4"); got["color"] != "00FF00" || got["userId"] != bob {
+		t.Errorf("pixel 4_4 = %v, want bob's 00FF00", got)
+	}
+	if got := readDoc(t, "pixels/5_5"); got["color"] != "FF0000" || got["userId"] != "admin" {
+		t.Errorf("pixel 5_5 = %v, want the admin's FF0000", got)
+	}
+	if got := toInt(readDoc(t, "users/"+alice)["pixelCount"]); got != 1 {
+		t.Errorf("alice pixelCount = %d, want 1", got)
+	}
+}
@@ -0,0 +1,66 @@
+package pixelworker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/team11/pixel-worker/internal/pixelrules"
+)
+
+func TestIsConquest(t *testing.T) {
+	tests := []struct {
+		previous, user string
+		want           bool
+	}{
+		{"", "u1", false},
+		{"u1", "u1", false},
+		{"u2", "u1", true},
+	}
+	for _, tt := range tests {
+		if got := isConquest(tt.previous, tt.user); got != tt.want {
+			t.Errorf("isConquest(%q, %q) = %v, want %v", tt.previous, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestUpdatePixelConquestStats(t *testing.T) {
+	requireEmulator(t)
+	ctx := context.Background()
+	seedDoc(t, "users/owner", map[string]interface{}{"id": "owner", "pixelCount": 1, "pixelsOverwritten": 0, "pixelsLost": 0})
+	seedDoc(t, "users/rival", map[string]interface{}{"id": "rival", "pixelCount": 0, "pixelsOverwritten": 0, "pixelsLost": 0})
+	seedDoc(t, "pixels/1_1", map[string]interface{}{"x": 1, "y": 1, "color": "FF0000", "userId": "owner"})
+
+	// Repainting your own pixel changes neither counter
+	if _, err := updatePixel(ctx, 1, 1, "00FF00", pixelrules.BlendReplace, "owner", "owner", "discord", "", "", false); err != nil {
+		t.Fatalf("self overwrite: %v", err)
+	}
+	if o := readDoc(t, "users/owner"); toInt(o["pixelsOverwritten"]) != 0 || toInt(o["pixelsLost"]) != 0 {
+		t.Fatalf("self overwrite changed the counters: %v", o)
+	}
+
+	// Painting over someone else's pixel is a conquest and a loss
+	if _, err := updatePixel(ctx, 1, 1, "0000FF", pixelrules.BlendReplace, "rival", "rival", "discord", "", "", false); err != nil {
+		t.Fatalf("other overwrite: %v", err)
+	}
+	if r := readDoc(t, "users/rival"); toInt(r["pixelsOverwritten"]) != 1 || toInt(r["pixelsLost"]) != 0 {
+		t.Fatalf("conqueror counters = %v, want pixelsOverwritten 1", r)
+	}
+	if o := readDoc(t, "users/owner"); toInt(o["pixelsLost"]) != 1 || toInt(o["pixelsOverwritten"]) != 0 {
+		t.Fatalf("previous owner counters = %v, want pixelsLost 1", o)
+	}
+}
+
+func TestUpdatePixelOnEmptyCellKeepsStats(t *testing.T) {
+	requireEmulator(t)
+	ctx := context.Background()
+	seedDoc(t, "users/owner", map[string]interface{}{"id": "owner", "pixelCount": 5, "pixelsOverwritten": 2, "pixelsLost": 1, "notifyOverwrites": true})
+
+	// An empty cell is no reason to recreate the user's document
+	if _, err := updatePixel(ctx, 2, 2, "00FF00", pixelrules.BlendReplace, "owner", "owner", "discord", "", "", false); err != nil {
+		t.Fatalf("placement: %v", err)
+	}
+	o := readDoc(t, "users/owner")
+	if toInt(o["pixelCount"]) != 6 || toInt(o["pixelsOverwritten"]) != 2 || toInt(o["pixelsLost"]) != 1 || o["notifyOverwrites"] != true {
+		t.Errorf("user after a placement on an empty cell = %v, want pixelCount 6 and the rest kept", o)
+	}
+}
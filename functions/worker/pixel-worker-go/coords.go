@@ -0,0 +1,54 @@
+package pixelworker
+
+import "fmt"
+
+// Pixels are always stored and rendered in top-left image coordinates; the
+// session's origin (see internal/coords) only changes how coordinates typed
+// by, and shown to, Discord users are interpreted. Web placements are in
+// storage coordinates already.
+
+// userToCanvas converts user-facing coordinates to storage coordinates.
+// Without a session (nil) there is nothing to flip against.
+func (s *sessionState) userToCanvas(x, y int) (int, int) {
+	if s == nil {
+		return x, y
+	}
+	return s.Origin.ToCanvas(x, y, s.CanvasHeight)
+}
+
+// canvasToUser converts storage coordinates back for display
+func (s *sessionState) canvasToUser(x, y int) (int, int) {
+	if s == nil {
+		return x, y
+	}
+	return s.Origin.ToUser(x, y, s.CanvasHeight)
+}
+
+// placementToCanvas returns where a placement from source lands in storage
+// coordinates. Discord placements are typed in user coordinates, web ones
+// in storage coordinates; both snap to the grid as users see it.
+func (s *sessionState) placementToCanvas(x, y int, source string) (int, int) {
+	if source != "discord" {
+		x, y = s.canvasToUser(x, y)
+	}
+	return s.userToCanvas(s.snapToGrid(x, y))
+}
+
+// snapToGrid moves user coordinates to the top-left corner of their cell
+// when the session snaps placements to a grid (sessions/current.gridSnap).
+// Negative coordinates are left for the bounds check to refuse.
+func (s *sessionState) snapToGrid(x, y int) (int, int) {
+	if s == nil || s.GridSnap <= 1 || x < 0 || y < 0 {
+		return x, y
+	}
+	return x - x%s.GridSnap, y - y%s.GridSnap
+}
+
+// coordinateInfo tells a user which grid cell their pixel landed in, so a
+// snapped placement explains why its coordinates differ from the typed ones.
+// x and y are user coordinates.
+func coordinateInfo(x, y, snap int) string {
+	gx, gy := x/snap, y/snap
+	x1, y1 := gx*snap, gy*snap
+	return fmt.Sprintf("Placed at grid cell (%d, %d) covering (%d, %d)–(%d, %d)", gx, gy, x1, y1, x1+snap-1, y1+snap-1)
+}
@@ -0,0 +1,77 @@
+package pixelworker
+
+import "testing"
+
+func TestUserToCanvasOrigin(t *testing.T) {
+	tests := []struct {
+		name         string
+		session      *sessionState
+		userX, userY int
+		wantX, wantY int
+	}{
+		{"top-left origin", parseSessionState(map[string]interface{}{"canvasHeight": int64(100)}), 0, 0, 0, 0},
+		{"bottom-left origin", parseSessionState(map[string]interface{}{"canvasHeight": int64(100), "origin": "bottom-left"}), 0, 0, 0, 99},
+		{"bottom-left top row", parseSessionState(map[string]interface{}{"canvasHeight": int64(100), "origin": "bottom-left"}), 7, 99, 7, 0},
+		{"bottom-left without height", parseSessionState(map[string]interface{}{"origin": "bottom-left"}), 3, 4, 3, 4},
+		{"no session", nil, 3, 4, 3, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, y := tt.session.userToCanvas(tt.userX, tt.userY)
+			if x != tt.wantX || y != tt.wantY {
+				t.Fatalf("userToCanvas(%d, %d) = (%d, %d), want (%d, %d)", tt.userX, tt.userY, x, y, tt.wantX, tt.wantY)
+			}
+			// Replies echo the coordinates the user typed
+			if ux, uy := tt.session.canvasToUser(x, y); ux != tt.userX || uy != tt.userY {
+				t.Fatalf("canvasToUser(%d, %d) = (%d, %d), want (%d, %d)", x, y, ux, uy, tt.userX, tt.userY)
+			}
+		})
+	}
+}
+
+func TestPlacementToCanvasGridSnap(t *testing.T) {
+	snapped := parseSessionState(map[string]interface{}{"canvasHeight": int64(100), "gridSnap": int64(4)})
+	flipped := parseSessionState(map[string]interface{}{"canvasHeight": int64(100), "gridSnap": int64(4), "origin": "bottom-left"})
+	tests := []struct {
+		name         string
+		session      *sessionState
+		x, y         int
+		source       string
+		wantX, wantY int
+	}{
+		{"no grid", parseSessionState(map[string]interface{}{"canvasHeight": int64(100)}), 7, 9, "discord", 7, 9},
+		{"grid of 1", parseSessionState(map[string]interface{}{"gridSnap": int64(1)}), 7, 9, "discord", 7, 9},
+		{"discord snaps down", snapped, 7, 9, "discord", 4, 8},
+		{"cell corner stays", snapped, 8, 8, "discord", 8, 8},
+		{"web snaps down", snapped, 7, 9, "web", 4, 8},
+		{"negative left to bounds", snapped, -3, 5, "discord", -3, 5},
+		// User (7, 9) is cell (4, 8) for the user, stored at row 99-8
+		{"discord bottom-left", flipped, 7, 9, "discord", 4, 91},
+		{"web bottom-left", flipped, 7, 90, "web", 4, 91},
+		{"no session", nil, 7, 9, "discord", 7, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if x, y := tt.session.placementToCanvas(tt.x, tt.y, tt.source); x != tt.wantX || y != tt.wantY {
+				t.Errorf("placementToCanvas(%d, %d, %s) = (%d, %d), want (%d, %d)", tt.x, tt.y, tt.source, x, y, tt.wantX, tt.wantY)
+			}
+		})
+	}
+}
+
+func TestCoordinateInfo(t *testing.T) {
+	tests := []struct {
+		x, y, snap int
+		want       string
+	}{
+		{4, 8, 4, "Placed at grid cell (1, 2) covering (4, 8)–(7, 11)"},
+		{0, 0, 10, "Placed at grid cell (0, 0) covering (0, 0)–(9, 9)"},
+		{23, 5, 8, "Placed at grid cell (2, 0) covering (16, 0)–(23, 7)"},
+		{99, 99, 2, "Placed at grid cell (49, 49) covering (98, 98)–(99, 99)"},
+	}
+	for _, tt := range tests {
+		if got := coordinateInfo(tt.x, tt.y, tt.snap); got != tt.want {
+			t.Errorf("coordinateInfo(%d, %d, %d) = %q, want %q", tt.x, tt.y, tt.snap, got, tt.want)
+		}
+	}
+}
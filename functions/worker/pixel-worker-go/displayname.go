@@ -0,0 +1,11 @@
+package pixelworker
+
+// creditedName is the name a placement is credited to on pixels,
+// pixel_history and the leaderboard: the display name the user set with
+// /nickname, or else the username the placement came with.
+func creditedName(user map[string]interface{}, username string) string {
+	if name, _ := user["displayName"].(string); name != "" {
+		return name
+	}
+	return username
+}
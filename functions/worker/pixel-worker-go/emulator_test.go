@@ -0,0 +1,102 @@
+package pixelworker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Emulator tests run real Firestore transactions against the Firestore
+// emulator: `docker compose up firestore`, then go test with
+// FIRESTORE_EMULATOR_HOST=localhost:8080. They are skipped without it and
+// with -short.
+
+// requireEmulator skips the test unless the emulator is configured, and
+// otherwise empties it and the caches filled from it. Placements publish
+// leaderboard refreshes, so Pub/Sub is faked too; a test that checks what
+// was published installs its own fake after this.
+func requireEmulator(t testing.TB) *firestore.Client {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("emulator test skipped with -short")
+	}
+	host := os.Getenv("FIRESTORE_EMULATOR_HOST")
+	if host == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	if projectID == "" {
+		projectID = "team11-local"
+	}
+
+	url := fmt.Sprintf("http://%s/emulator/v1/projects/%s/databases/team11-database/documents", host, projectID)
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("clear emulator: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("clear emulator: %s", resp.Status)
+	}
+
+	sessionCache.Store(nil)
+	rateLimitConfigMu.Lock()
+	rateLimitConfigAt = time.Time{}
+	rateLimitConfigMu.Unlock()
+	systemStateMu.Lock()
+	loadFactorAt = time.Time{}
+	systemStateMu.Unlock()
+	loadReportMu.Lock()
+	loadWindowMax, loadReportAt = 0, time.Time{}
+	loadReportMu.Unlock()
+	usePubsubFake(t)
+	return getFirestore()
+}
+
+// seedDoc writes a document at path, e.g. "users/u1"
+func seedDoc(t *testing.T, path string, data map[string]interface{}) {
+	t.Helper()
+	if _, err := getFirestore().Doc(path).Set(context.Background(), data); err != nil {
+		t.Fatalf("seed %s: %v", path, err)
+	}
+}
+
+// readDoc returns the document at path, or nil when it does not exist
+func readDoc(t *testing.T, path string) map[string]interface{} {
+	t.Helper()
+	doc, err := getFirestore().Doc(path).Get(context.Background())
+	if err != nil {
+		if !doc.Exists() {
+			return nil
+		}
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return doc.Data()
+}
+
+// awaitFreshWindow waits for the next rate-limit window when the current one
+// ends too soon for a test's charges to land in the same window.
+func awaitFreshWindow(t *testing.T) int64 {
+	t.Helper()
+	now := time.Now()
+	if left := rateLimitWindow - now.Unix()%rateLimitWindow; left < 5 {
+		time.Sleep(time.Duration(left) * time.Second)
+	}
+	return time.Now().Unix() / rateLimitWindow
+}
+
+// seedSession writes an active sessions/current of the given size
+func seedSession(t *testing.T, width, height int, extra map[string]interface{}) {
+	t.Helper()
+	data := map[string]interface{}{"status": "active", "canvasWidth": width, "canvasHeight": height}
+	for k, v := range extra {
+		data[k] = v
+	}
+	seedDoc(t, "sessions/current", data)
+	sessionCache.Store(nil)
+}
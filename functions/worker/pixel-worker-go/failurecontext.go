@@ -0,0 +1,125 @@
+package pixelworker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+const (
+	// defaultMaxDeliveryAttempts matches max_delivery_attempts of the
+	// pixel-events dead-letter policy in terraform/modules/pubsub
+	defaultMaxDeliveryAttempts = 5
+	// failureContextTTL is how long a failure_context document is kept; its
+	// expiresAt is meant for a TTL policy, like rate_limits
+	failureContextTTL = 7 * 24 * time.Hour
+)
+
+// maxDeliveryAttempts is the dead-letter policy's limit, set with
+// MAX_DELIVERY_ATTEMPTS when the subscription uses another value
+var maxDeliveryAttempts = func() int {
+	if v, err := strconv.Atoi(os.Getenv("MAX_DELIVERY_ATTEMPTS")); err == nil && v > 1 {
+		return v
+	}
+	return defaultMaxDeliveryAttempts
+}()
+
+// stageError names the step of handling a delivery that failed
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string { return e.err.Error() }
+func (e *stageError) Unwrap() error { return e.err }
+
+// atStage marks err as failing at stage; unmarked errors failed in "handle"
+func atStage(stage string, err error) error {
+	return &stageError{stage: stage, err: err}
+}
+
+func failureStage(err error) string {
+	var se *stageError
+	if errors.As(err, &se) {
+		return se.stage
+	}
+	return "handle"
+}
+
+// classifyFailure sorts an error into a stable class that replay tooling can
+// match on, unlike the message
+func classifyFailure(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, errFlowControlRejected):
+		return "flow_control"
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return "invalid_payload"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	// FromError also finds a status wrapped with %w
+	if s, ok := status.FromError(err); ok && s.Code() != codes.OK {
+		return "grpc_" + s.Code().String()
+	}
+	return "unknown"
+}
+
+// recordFailureContext writes failure_context/{messageId} when a delivery
+// that is about to be dead-lettered fails, so whoever reads the dead-letter
+// subscription can tell why without replaying it. Attempts from the one
+// before last are recorded, since the last may time out before it gets
+// here; each adds to attempts. Deliveries without a delivery attempt (no
+// dead-letter policy) are never recorded. A failed write is only logged: the
+// delivery fails either way.
+func recordFailureContext(ctx context.Context, msg *messages.MessagePublishedData, msgType string, err error) {
+	if msg.DeliveryAttempt < maxDeliveryAttempts-1 || msg.Message.MessageID == "" {
+		return
+	}
+	now := time.Now().UTC()
+	class, stage := classifyFailure(err), failureStage(err)
+	doc := map[string]interface{}{
+		"messageId":           msg.Message.MessageID,
+		"type":                msgType,
+		"errorClass":          class,
+		"error":               err.Error(),
+		"stage":               stage,
+		"maxDeliveryAttempts": maxDeliveryAttempts,
+		"attempts": firestore.ArrayUnion(map[string]interface{}{
+			"attempt":    msg.DeliveryAttempt,
+			"errorClass": class,
+			"stage":      stage,
+			"failedAt":   now,
+		}),
+		"updatedAt": now,
+		"expiresAt": now.Add(failureContextTTL),
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		doc["traceId"] = sc.TraceID().String()
+	}
+	if _, werr := getFirestore().Collection("failure_context").Doc(msg.Message.MessageID).Set(ctx, doc, firestore.MergeAll); werr != nil {
+		slog.Warn("failure_context_write_failed", "message_id", msg.Message.MessageID, "error", werr.Error())
+		return
+	}
+	slog.Info("failure_context_recorded",
+		"message_id", msg.Message.MessageID,
+		"type", msgType,
+		"attempt", msg.DeliveryAttempt,
+		"error_class", class,
+		"stage", stage,
+	)
+}
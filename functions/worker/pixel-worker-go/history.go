@@ -0,0 +1,33 @@
+package pixelworker
+
+import (
+	"os"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/team11/pixel-worker/internal/snowflake"
+)
+
+// historyEnabled records every placement in pixel_history (PIXEL_HISTORY=true).
+// Off by default: it adds one document write per pixel.
+var historyEnabled = os.Getenv("PIXEL_HISTORY") == "true"
+
+// historyEntry is one placement in pixel_history. Color is what was stored,
+// after blending; PreviousColor is empty for a previously blank cell.
+type historyEntry struct {
+	X             int       `firestore:"x"`
+	Y             int       `firestore:"y"`
+	Color         string    `firestore:"color"`
+	PreviousColor string    `firestore:"previousColor"`
+	UserID        string    `firestore:"userId"`
+	Username      string    `firestore:"username"`
+	Source        string    `firestore:"source"`
+	Timestamp     time.Time `firestore:"timestamp"`
+}
+
+// newHistoryRef names a history document by a snowflake ID, so document IDs
+// sort in placement order
+func newHistoryRef() *firestore.DocumentRef {
+	return getFirestore().Collection("pixel_history").Doc(snowflake.Generate())
+}
@@ -0,0 +1,72 @@
+// Package coords converts between the coordinates Discord users type and
+// see, and the top-left coordinates pixels are stored and rendered in. The
+// origin is a session setting (sessions/current.origin), chosen at
+// /session start. The session worker (Node.js) converts zone corners the
+// same way; change it together.
+//
+// The same package lives in each Go function module; keep the copies in sync.
+package coords
+
+// Origin is where (0, 0) is for users. X always grows to the right.
+type Origin string
+
+const (
+	// TopLeft is the storage convention: Y grows downwards
+	TopLeft Origin = "top-left"
+	// BottomLeft puts (0, 0) in the bottom-left corner: Y grows upwards
+	BottomLeft Origin = "bottom-left"
+)
+
+// Parse returns the origin named v; empty means TopLeft
+func Parse(v string) (Origin, bool) {
+	switch Origin(v) {
+	case "", TopLeft:
+		return TopLeft, true
+	case BottomLeft:
+		return BottomLeft, true
+	}
+	return TopLeft, false
+}
+
+// FromSession returns the origin of a sessions document. Sessions started
+// before the setting existed, and unknown values, are TopLeft.
+func FromSession(data map[string]interface{}) Origin {
+	v, _ := data["origin"].(string)
+	o, _ := Parse(v)
+	return o
+}
+
+// flipsY reports whether the origin mirrors the Y axis of a canvas of the
+// given height. Without a known height there is nothing to flip against.
+func (o Origin) flipsY(height int) bool {
+	return o == BottomLeft && height > 0
+}
+
+// ToCanvas converts user coordinates to storage coordinates
+func (o Origin) ToCanvas(x, y, height int) (int, int) {
+	if o.flipsY(height) {
+		return x, height - 1 - y
+	}
+	return x, y
+}
+
+// ToUser converts storage coordinates back for display. Flipping the Y axis
+// is its own inverse.
+func (o Origin) ToUser(x, y, height int) (int, int) {
+	return o.ToCanvas(x, y, height)
+}
+
+// ToCanvasRect converts the inclusive corners of a rectangle, with
+// minY <= maxY on both sides: flipping the Y axis swaps which row is the
+// smaller one.
+func (o Origin) ToCanvasRect(minX, minY, maxX, maxY, height int) (int, int, int, int) {
+	if o.flipsY(height) {
+		return minX, height - 1 - maxY, maxX, height - 1 - minY
+	}
+	return minX, minY, maxX, maxY
+}
+
+// ToUserRect converts the inclusive corners of a stored rectangle for display
+func (o Origin) ToUserRect(minX, minY, maxX, maxY, height int) (int, int, int, int) {
+	return o.ToCanvasRect(minX, minY, maxX, maxY, height)
+}
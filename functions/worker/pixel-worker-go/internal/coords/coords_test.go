@@ -0,0 +1,146 @@
+package coords
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   Origin
+		wantOK bool
+	}{
+		{"", TopLeft, true},
+		{"top-left", TopLeft, true},
+		{"bottom-left", BottomLeft, true},
+		{"Bottom-Left", TopLeft, false},
+		{"bottom-right", TopLeft, false},
+		{"center", TopLeft, false},
+	}
+	for _, tt := range tests {
+		if got, ok := Parse(tt.in); got != tt.want || ok != tt.wantOK {
+			t.Errorf("Parse(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestFromSession(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want Origin
+	}{
+		{"no session", nil, TopLeft},
+		{"started before origins", map[string]interface{}{"status": "active"}, TopLeft},
+		{"top-left", map[string]interface{}{"origin": "top-left"}, TopLeft},
+		{"bottom-left", map[string]interface{}{"origin": "bottom-left"}, BottomLeft},
+		{"unknown value", map[string]interface{}{"origin": "middle"}, TopLeft},
+		{"not a string", map[string]interface{}{"origin": int64(1)}, TopLeft},
+	}
+	for _, tt := range tests {
+		if got := FromSession(tt.data); got != tt.want {
+			t.Errorf("%s: FromSession() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestToCanvas(t *testing.T) {
+	tests := []struct {
+		name         string
+		origin       Origin
+		x, y, height int
+		wantX, wantY int
+	}{
+		{"top-left keeps coordinates", TopLeft, 3, 4, 10, 3, 4},
+		{"bottom-left origin is the last row", BottomLeft, 0, 0, 10, 0, 9},
+		{"bottom-left top row is row 0", BottomLeft, 5, 9, 10, 5, 0},
+		{"bottom-left middle", BottomLeft, 2, 4, 10, 2, 5},
+		{"bottom-left one-row canvas", BottomLeft, 7, 0, 1, 7, 0},
+		{"bottom-left without a height", BottomLeft, 3, 4, 0, 3, 4},
+		{"bottom-left negative height", BottomLeft, 3, 4, -1, 3, 4},
+		{"bottom-left outside the canvas stays outside", BottomLeft, 1, 10, 10, 1, -1},
+		{"bottom-left below the canvas stays outside", BottomLeft, 1, -1, 10, 1, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if x, y := tt.origin.ToCanvas(tt.x, tt.y, tt.height); x != tt.wantX || y != tt.wantY {
+				t.Errorf("ToCanvas(%d, %d, %d) = (%d, %d), want (%d, %d)", tt.x, tt.y, tt.height, x, y, tt.wantX, tt.wantY)
+			}
+		})
+	}
+}
+
+// Every cell of small canvases maps to a distinct cell of the canvas, and
+// back to where it came from
+func TestToCanvasEveryCell(t *testing.T) {
+	for _, o := range []Origin{TopLeft, BottomLeft} {
+		for height := 1; height <= 8; height++ {
+			const width = 3
+			seen := make(map[[2]int]bool)
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					cx, cy := o.ToCanvas(x, y, height)
+					if cx != x || cy < 0 || cy >= height {
+						t.Fatalf("%s h=%d: ToCanvas(%d, %d) = (%d, %d), outside the canvas", o, height, x, y, cx, cy)
+					}
+					if seen[[2]int{cx, cy}] {
+						t.Fatalf("%s h=%d: (%d, %d) is the image of two cells", o, height, cx, cy)
+					}
+					seen[[2]int{cx, cy}] = true
+					if ux, uy := o.ToUser(cx, cy, height); ux != x || uy != y {
+						t.Fatalf("%s h=%d: ToUser(ToCanvas(%d, %d)) = (%d, %d)", o, height, x, y, ux, uy)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestToCanvasRect(t *testing.T) {
+	tests := []struct {
+		name                   string
+		origin                 Origin
+		minX, minY, maxX, maxY int
+		height                 int
+		want                   [4]int
+	}{
+		{"top-left keeps corners", TopLeft, 1, 2, 3, 4, 10, [4]int{1, 2, 3, 4}},
+		{"bottom-left swaps rows", BottomLeft, 1, 2, 3, 4, 10, [4]int{1, 5, 3, 7}},
+		{"bottom-left whole canvas", BottomLeft, 0, 0, 9, 9, 10, [4]int{0, 0, 9, 9}},
+		{"bottom-left single row", BottomLeft, 0, 0, 9, 0, 10, [4]int{0, 9, 9, 9}},
+		{"bottom-left without a height", BottomLeft, 1, 2, 3, 4, 0, [4]int{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b, c, d := tt.origin.ToCanvasRect(tt.minX, tt.minY, tt.maxX, tt.maxY, tt.height)
+			if got := [4]int{a, b, c, d}; got != tt.want {
+				t.Errorf("ToCanvasRect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A rectangle covers the same cells whether its corners or its cells are
+// converted, and converts back to itself
+func TestToCanvasRectEveryRect(t *testing.T) {
+	const height = 6
+	for _, o := range []Origin{TopLeft, BottomLeft} {
+		for minY := 0; minY < height; minY++ {
+			for maxY := minY; maxY < height; maxY++ {
+				_, cMinY, _, cMaxY := o.ToCanvasRect(0, minY, 0, maxY, height)
+				if cMinY > cMaxY {
+					t.Fatalf("%s: rows %d-%d became %d-%d", o, minY, maxY, cMinY, cMaxY)
+				}
+				for y := minY; y <= maxY; y++ {
+					if _, cy := o.ToCanvas(0, y, height); cy < cMinY || cy > cMaxY {
+						t.Fatalf("%s: row %d of %d-%d is at %d, outside %d-%d", o, y, minY, maxY, cy, cMinY, cMaxY)
+					}
+				}
+				if cMaxY-cMinY != maxY-minY {
+					t.Fatalf("%s: rows %d-%d became %d-%d, a different height", o, minY, maxY, cMinY, cMaxY)
+				}
+				if _, uMinY, _, uMaxY := o.ToUserRect(0, cMinY, 0, cMaxY, height); uMinY != minY || uMaxY != maxY {
+					t.Fatalf("%s: rows %d-%d came back as %d-%d", o, minY, maxY, uMinY, uMaxY)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubRequest is one request the stub Discord API received
+type stubRequest struct {
+	Method string
+	Path   string
+	Auth   string
+	Body   string
+}
+
+// stubServer answers with the scripted responses in order, repeating the
+// last one, and records every request.
+type stubServer struct {
+	mu        sync.Mutex
+	requests  []stubRequest
+	responses []stubResponse
+}
+
+type stubResponse struct {
+	status     int
+	body       string
+	retryAfter string
+}
+
+func newStub(t *testing.T, responses ...stubResponse) (*stubServer, *Client) {
+	t.Helper()
+	s := &stubServer{responses: responses}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, &Client{BotToken: "token", BaseURL: srv.URL, HTTP: srv.Client()}
+}
+
+func (s *stubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	s.requests = append(s.requests, stubRequest{r.Method, r.URL.Path, r.Header.Get("Authorization"), string(body)})
+	resp := s.responses[min(len(s.requests), len(s.responses))-1]
+	s.mu.Unlock()
+
+	if resp.retryAfter != "" {
+		w.Header().Set("Retry-After", resp.retryAfter)
+	}
+	w.WriteHeader(resp.status)
+	io.WriteString(w, resp.body)
+}
+
+func (s *stubServer) received() []stubRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]stubRequest(nil), s.requests...)
+}
+
+func TestRequests(t *testing.T) {
+	msg := Message{Content: "hi", Flags: FlagEphemeral}
+	tests := []struct {
+		name       string
+		call       func(c *Client) error
+		wantMethod string
+		wantPath   string
+		wantBody   string
+	}{
+		{
+			name:       "follow-up",
+			call:       func(c *Client) error { return c.FollowUp(context.Background(), "app", "tok", msg) },
+			wantMethod: http.MethodPost,
+			wantPath:   "/webhooks/app/tok",
+			wantBody:   `{"content":"hi","flags":64}`,
+		},
+		{
+			name:       "edit original",
+			call:       func(c *Client) error { return c.EditOriginal(context.Background(), "app", "tok", msg) },
+			wantMethod: http.MethodPatch,
+			wantPath:   "/webhooks/app/tok/messages/@original",
+			wantBody:   `{"content":"hi","flags":64}`,
+		},
+		{
+			name:       "delete original",
+			call:       func(c *Client) error { return c.DeleteOriginal(context.Background(), "app", "tok") },
+			wantMethod: http.MethodDelete,
+			wantPath:   "/webhooks/app/tok/messages/@original",
+		},
+		{
+			name:       "channel message",
+			call:       func(c *Client) error { return c.ChannelMessage(context.Background(), "chan", msg) },
+			wantMethod: http.MethodPost,
+			wantPath:   "/channels/chan/messages",
+			wantBody:   `{"content":"hi","flags":64}`,
+		},
+		{
+			name:       "member role",
+			call:       func(c *Client) error { return c.AddMemberRole(context.Background(), "g", "u", "r") },
+			wantMethod: http.MethodPut,
+			wantPath:   "/guilds/g/members/u/roles/r",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, c := newStub(t, stubResponse{status: http.StatusNoContent})
+			if err := tt.call(c); err != nil {
+				t.Fatalf("call: %v", err)
+			}
+			reqs := stub.received()
+			if len(reqs) != 1 {
+				t.Fatalf("got %d requests, want 1", len(reqs))
+			}
+			r := reqs[0]
+			if r.Method != tt.wantMethod || r.Path != tt.wantPath || r.Body != tt.wantBody || r.Auth != "Bot token" {
+				t.Errorf("request = %+v, want %s %s %s with the bot token", r, tt.wantMethod, tt.wantPath, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMissingInteraction(t *testing.T) {
+	stub, c := newStub(t, stubResponse{status: http.StatusNoContent})
+	if err := c.FollowUp(context.Background(), "", "tok", Message{}); !errors.Is(err, ErrMissingInteraction) {
+		t.Errorf("FollowUp without an app ID = %v, want ErrMissingInteraction", err)
+	}
+	if err := c.EditOriginal(context.Background(), "app", "", Message{}); !errors.Is(err, ErrMissingInteraction) {
+		t.Errorf("EditOriginal without a token = %v, want ErrMissingInteraction", err)
+	}
+	if n := len(stub.received()); n != 0 {
+		t.Errorf("made %d requests, want none", n)
+	}
+}
+
+func TestCreateDM(t *testing.T) {
+	stub, c := newStub(t, stubResponse{status: http.StatusOK, body: `{"id":"dm-1","type":1}`})
+	id, err := c.CreateDM(context.Background(), "u1")
+	if err != nil || id != "dm-1" {
+		t.Fatalf("CreateDM = %q, %v; want dm-1", id, err)
+	}
+	var body map[string]string
+	json.Unmarshal([]byte(stub.received()[0].Body), &body)
+	if body["recipient_id"] != "u1" {
+		t.Errorf("body = %v, want recipient_id u1", body)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		responses  []stubResponse
+		wantStatus int // 0: success
+		wantCalls  int
+	}{
+		{"rate limited then sent", []stubResponse{{status: 429, retryAfter: "0.01"}, {status: 204}}, 0, 2},
+		{"server error then sent", []stubResponse{{status: 502}, {status: 204}}, 0, 2},
+		{"rate limited too long", []stubResponse{{status: 429, retryAfter: "30"}}, 429, 1},
+		{"rate limited every time", []stubResponse{{status: 429, retryAfter: "0.01"}}, 429, maxAttempts},
+		{"refused", []stubResponse{{status: 403}}, 403, 1},
+		{"not found", []stubResponse{{status: 404}}, 404, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, c := newStub(t, tt.responses...)
+			err := c.ChannelMessage(context.Background(), "chan", Message{Content: "hi"})
+
+			if tt.wantStatus == 0 && err != nil {
+				t.Errorf("err = %v, want success", err)
+			}
+			var apiErr *APIError
+			if tt.wantStatus != 0 && (!errors.As(err, &apiErr) || apiErr.Status != tt.wantStatus) {
+				t.Errorf("err = %v, want APIError %d", err, tt.wantStatus)
+			}
+			if n := len(stub.received()); n != tt.wantCalls {
+				t.Errorf("made %d requests, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWithContext(t *testing.T) {
+	stub, c := newStub(t, stubResponse{status: 503})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := c.ChannelMessage(ctx, "chan", Message{Content: "hi"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's deadline", err)
+	}
+	if n := len(stub.received()); n != 1 {
+		t.Errorf("made %d requests, want 1 before the deadline", n)
+	}
+}
+
+func TestTransportErrorNotRetried(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	c := &Client{BotToken: "token", BaseURL: srv.URL, HTTP: &http.Client{Timeout: time.Second}}
+
+	var apiErr *APIError
+	if err := c.ChannelMessage(context.Background(), "chan", Message{Content: "hi"}); err == nil || errors.As(err, &apiErr) {
+		t.Errorf("err = %v, want a transport error", err)
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	const bot = `{"id":"b1","username":"pixels","bot":true}`
+	const app = `{"id":"app1","name":"Pixels","bot":{"id":"b1"},"flags":16384}`
+
+	tests := []struct {
+		name        string
+		responses   []stubResponse
+		appID       string
+		intents     int
+		wantProblem string
+		wantStatus  int
+	}{
+		{"valid", []stubResponse{{200, bot, ""}, {200, app, ""}}, "app1", 0, "", 0},
+		{"valid with its intents", []stubResponse{{200, bot, ""}, {200, app, ""}}, "app1", IntentGuildMembers, "", 0},
+		{"limited intent counts", []stubResponse{{200, bot, ""}, {200, `{"id":"app1","flags":524288}`, ""}}, "app1", IntentMessageContent, "", 0},
+		{"invalid token", []stubResponse{{401, `{"message":"401: Unauthorized"}`, ""}}, "app1", 0, ProblemTokenInvalid, 401},
+		{"user token", []stubResponse{{200, `{"id":"u1","username":"alice"}`, ""}}, "app1", 0, ProblemTokenInvalid, 0},
+		{"another application", []stubResponse{{200, bot, ""}, {200, app, ""}}, "app2", 0, ProblemApplicationMismatch, 0},
+		{"another application's bot", []stubResponse{{200, bot, ""}, {200, `{"id":"app1","bot":{"id":"b2"}}`, ""}}, "app1", 0, ProblemApplicationMismatch, 0},
+		{"missing intents", []stubResponse{{200, bot, ""}, {200, app, ""}}, "app1", IntentGuildMembers | IntentMessageContent, ProblemMissingIntents, 0},
+		{"server error", []stubResponse{{500, "", ""}}, "app1", 0, ProblemUnreachable, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, c := newStub(t, tt.responses...)
+			err := c.SelfCheck(context.Background(), tt.appID, tt.intents)
+			if tt.wantProblem == "" {
+				if err != nil {
+					t.Fatalf("SelfCheck() = %v, want nil", err)
+				}
+				return
+			}
+			var checkErr *SelfCheckError
+			if !errors.As(err, &checkErr) {
+				t.Fatalf("SelfCheck() = %v, want a SelfCheckError", err)
+			}
+			if checkErr.Problem != tt.wantProblem || checkErr.Status != tt.wantStatus {
+				t.Errorf("SelfCheck() problem %q, status %d, want %q, %d", checkErr.Problem, checkErr.Status, tt.wantProblem, tt.wantStatus)
+			}
+		})
+	}
+
+	t.Run("no token", func(t *testing.T) {
+		s, c := newStub(t, stubResponse{200, bot, ""})
+		c.BotToken = ""
+		var checkErr *SelfCheckError
+		if err := c.SelfCheck(context.Background(), "app1", 0); !errors.As(err, &checkErr) || checkErr.Problem != ProblemTokenMissing {
+			t.Errorf("SelfCheck() = %v, want %s", err, ProblemTokenMissing)
+		}
+		if n := len(s.received()); n != 0 {
+			t.Errorf("made %d requests without a token", n)
+		}
+	})
+
+	t.Run("missing intents are named", func(t *testing.T) {
+		_, c := newStub(t, stubResponse{200, bot, ""}, stubResponse{200, app, ""})
+		err := c.SelfCheck(context.Background(), "app1", IntentPresence|IntentGuildMembers|IntentMessageContent)
+		var checkErr *SelfCheckError
+		if !errors.As(err, &checkErr) || checkErr.Detail != "application app1 lacks the presence, message_content intents" {
+			t.Errorf("SelfCheck() = %v, want presence and message_content named", err)
+		}
+	})
+}
+
+func TestParseIntents(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"guild_members", IntentGuildMembers, false},
+		{" guild_members , message_content ", IntentGuildMembers | IntentMessageContent, false},
+		{"presence,", IntentPresence, false},
+		{"members", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseIntents(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseIntents(%q) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Package events defines the messages the pixel worker publishes on the
+// public-pixel topic for web clients, told apart by the "type" attribute.
+package events
+
+// Message types, set as the "type" attribute
+const (
+	TypePixelUpdate   = "pixel_update"
+	TypePixelRejected = "pixel_rejected"
+)
+
+// RejectReason says why a placement was refused, for clients to branch on.
+// The human-readable text travels next to it.
+type RejectReason string
+
+const (
+	ReasonInvalidUser     RejectReason = "invalid_user"
+	ReasonUnverified      RejectReason = "unverified_source"
+	ReasonInvalidColor    RejectReason = "invalid_color"
+	ReasonNoSession       RejectReason = "no_session"
+	ReasonSessionClosed   RejectReason = "session_closed"
+	ReasonOutOfBounds     RejectReason = "out_of_bounds"
+	ReasonZoneProtected   RejectReason = "zone_protected"
+	ReasonRateLimited     RejectReason = "rate_limited"
+	ReasonColorCooldown   RejectReason = "color_cooldown"
+	ReasonColorRestricted RejectReason = "color_restricted"
+	ReasonPixelProtected  RejectReason = "pixel_protected"
+	ReasonConflict        RejectReason = "conflict"
+	ReasonWriteFailed     RejectReason = "write_failed"
+)
+
+// PixelRejected tells a web client its placement was not stored. RequestID
+// echoes the client's own ID for the request, when it sent one.
+type PixelRejected struct {
+	RequestID string       `json:"requestId,omitempty"`
+	UserID    string       `json:"userId"`
+	X         int          `json:"x"`
+	Y         int          `json:"y"`
+	Reason    RejectReason `json:"reason"`
+	Message   string       `json:"message"`
+	Timestamp string       `json:"timestamp"`
+}
@@ -0,0 +1,82 @@
+// Package flowcontrol bounds how much Pub/Sub work one function instance
+// accepts at a time.
+//
+// The workers are push subscribers (Eventarc), so the pull client's
+// ReceiveSettings never apply. When an instance serves several requests
+// concurrently, a Limiter gives the same MaxOutstandingMessages /
+// MaxOutstandingBytes semantics: deliveries over the limit are refused and
+// Pub/Sub redelivers them with backoff instead of piling onto Firestore.
+//
+// The same package lives in each Go worker module; keep the copies in sync.
+package flowcontrol
+
+import (
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Settings mirrors the flow-control fields of pubsub.ReceiveSettings.
+// Zero or negative values mean no limit.
+type Settings struct {
+	MaxOutstandingMessages int
+	MaxOutstandingBytes    int
+}
+
+// SettingsFromEnv reads MAX_OUTSTANDING_MESSAGES and MAX_OUTSTANDING_BYTES
+func SettingsFromEnv() Settings {
+	var s Settings
+	if v, err := strconv.Atoi(os.Getenv("MAX_OUTSTANDING_MESSAGES")); err == nil {
+		s.MaxOutstandingMessages = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("MAX_OUTSTANDING_BYTES")); err == nil {
+		s.MaxOutstandingBytes = v
+	}
+	return s
+}
+
+// Limiter tracks messages currently being processed by this instance
+type Limiter struct {
+	settings Settings
+
+	mu       sync.Mutex
+	messages int
+	bytes    int
+}
+
+func NewLimiter(s Settings) *Limiter {
+	return &Limiter{settings: s}
+}
+
+// Settings returns the limits the Limiter was built with
+func (l *Limiter) Settings() Settings {
+	return l.settings
+}
+
+// TryAcquire admits a message of size bytes, or reports false when admitting
+// it would exceed a limit. A single message larger than MaxOutstandingBytes
+// is still admitted when nothing else is outstanding, so it cannot be
+// refused forever.
+func (l *Limiter) TryAcquire(size int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if max := l.settings.MaxOutstandingMessages; max > 0 && l.messages >= max {
+		return false
+	}
+	if max := l.settings.MaxOutstandingBytes; max > 0 && l.messages > 0 && l.bytes+size > max {
+		return false
+	}
+	l.messages++
+	l.bytes += size
+	return true
+}
+
+// Release returns a message admitted by TryAcquire
+func (l *Limiter) Release(size int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.messages--
+	l.bytes -= size
+}
@@ -0,0 +1,189 @@
+// Package pixelrules holds the rules a pixel write is checked and painted
+// by: blend modes, conflicts with what the client saw, the session schedule,
+// time constraints on colors and same-color cooldowns. The pixel worker
+// applies them to placements; wal-recovery applies them again to the writes
+// it replays, which must paint what the placement would have.
+//
+// The same package lives in each Go function module that writes pixels;
+// keep the copies in sync.
+package pixelrules
+
+import (
+	"fmt"
+	"image/color"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Blend modes stored in sessions/current.blendMode
+const (
+	BlendReplace = "replace"
+	BlendAverage = "average"
+	BlendOverlay = "overlay"
+)
+
+var hexColorRegex = regexp.MustCompile(`^[0-9A-Fa-f]{6}$`)
+
+// blendColors combines the color already on the canvas with the requested
+// one. Unknown modes behave like replace.
+func blendColors(existing, requested color.RGBA, mode string) color.RGBA {
+	var channel func(a, b uint8) uint8
+	switch mode {
+	case BlendAverage:
+		channel = func(a, b uint8) uint8 {
+			return uint8((int(a) + int(b)) / 2)
+		}
+	case BlendOverlay:
+		// Photoshop overlay with the existing color as the base layer
+		channel = func(a, b uint8) uint8 {
+			if a < 128 {
+				return uint8(2 * int(a) * int(b) / 255)
+			}
+			return uint8(255 - 2*(255-int(a))*(255-int(b))/255)
+		}
+	default:
+		return requested
+	}
+
+	return color.RGBA{
+		R: channel(existing.R, requested.R),
+		G: channel(existing.G, requested.G),
+		B: channel(existing.B, requested.B),
+		A: 255,
+	}
+}
+
+// BlendHex applies a blend mode to 6-digit hex colors as stored on pixels.
+// If either color is unreadable the requested color wins.
+func BlendHex(existing, requested, mode string) string {
+	if mode == "" || mode == BlendReplace {
+		return requested
+	}
+	a, ok := parseHexColor(existing)
+	if !ok {
+		return requested
+	}
+	b, ok := parseHexColor(requested)
+	if !ok {
+		return requested
+	}
+	c := blendColors(a, b, mode)
+	return fmt.Sprintf("%02X%02X%02X", c.R, c.G, c.B)
+}
+
+func parseHexColor(s string) (color.RGBA, bool) {
+	if !hexColorRegex.MatchString(s) {
+		return color.RGBA{}, false
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, true
+}
+
+// StoredTime reads a time field written either as a Firestore Timestamp
+// (current writes) or as an RFC 3339 string (documents written before the
+// switch to Timestamps).
+func StoredTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		parsed, err := time.Parse(time.RFC3339, t)
+		return parsed, err == nil
+	}
+	return time.Time{}, false
+}
+
+// IsStale reports whether the stored pixel is newer than the updatedAt the
+// client expected. An empty expectation keeps last-write-wins. Clients see
+// times with millisecond precision, so the stored time is compared at that
+// precision; older pixels only have seconds.
+func IsStale(storedUpdatedAt interface{}, expectedUpdatedAt string) bool {
+	if expectedUpdatedAt == "" {
+		return false
+	}
+	stored, ok := StoredTime(storedUpdatedAt)
+	if !ok {
+		return false
+	}
+	expected, err := time.Parse(time.RFC3339, expectedUpdatedAt)
+	if err != nil {
+		return false
+	}
+	return stored.Truncate(time.Millisecond).After(expected)
+}
+
+// ParseScheduleTime reads a session's opensAt/closesAt value. Session
+// timestamps are stored as RFC 3339 strings in UTC; Firestore timestamps are
+// accepted too. Anything else means the bound is not set.
+func ParseScheduleTime(v interface{}) time.Time {
+	switch t := v.(type) {
+	case time.Time:
+		return t
+	case string:
+		if parsed, err := time.Parse(time.RFC3339, t); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
+// NotOpenYet reports whether now is before the session's opensAt, if set
+func NotOpenYet(opensAt, now time.Time) bool {
+	return !opensAt.IsZero() && now.Before(opensAt)
+}
+
+// Closed reports whether now is at or after the session's closesAt, if set
+func Closed(closesAt, now time.Time) bool {
+	return !closesAt.IsZero() && !now.Before(closesAt)
+}
+
+// TimeConstraint is a time_constraints document. It limits the colors
+// matching ColorPattern to the UTC hours in AllowedHours, e.g. red only from
+// 12:00 to 12:59. Message, if set, is what a refused user sees.
+type TimeConstraint struct {
+	ColorPattern string `firestore:"colorPattern"`
+	AllowedHours []int  `firestore:"allowedHours"`
+	Message      string `firestore:"message"`
+
+	pattern *regexp.Regexp
+}
+
+// Compile prepares ColorPattern for Restricts. Colors arrive in either case
+// and patterns are written for hex digits, so it matches without case.
+func (c *TimeConstraint) Compile() error {
+	re, err := regexp.Compile("(?i)" + c.ColorPattern)
+	if err != nil {
+		return err
+	}
+	c.pattern = re
+	return nil
+}
+
+// Restricts reports whether the constraint refuses color at now. A
+// constraint without hours keeps its colors off the canvas entirely; one
+// that was not compiled refuses nothing.
+func (c *TimeConstraint) Restricts(color string, now time.Time) bool {
+	return c.pattern != nil && c.pattern.MatchString(color) && !slices.Contains(c.AllowedHours, now.UTC().Hour())
+}
+
+// CooldownID is the color_cooldowns document of one user's color
+func CooldownID(userID, color string) string {
+	return userID + "_" + strings.ToUpper(color)
+}
+
+// CooldownDoc is the color_cooldowns document a placement at placedAt
+// writes to start a cooldown of d
+func CooldownDoc(userID, color string, placedAt time.Time, d time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"userId":       userID,
+		"color":        strings.ToUpper(color),
+		"lastPlacedAt": placedAt.UTC(),
+		"expiresAt":    placedAt.Add(d).UTC(),
+	}
+}
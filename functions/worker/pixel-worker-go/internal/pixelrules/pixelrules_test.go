@@ -0,0 +1,124 @@
+package pixelrules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlendHex(t *testing.T) {
+	tests := []struct {
+		existing, requested, mode string
+		want                      string
+	}{
+		{"FF0000", "0000FF", BlendReplace, "0000FF"},
+		{"FF0000", "0000FF", "", "0000FF"},
+		{"FF0000", "0000FF", BlendAverage, "7F007F"},
+		{"808080", "FFFFFF", BlendOverlay, "FFFFFF"},
+		{"404040", "808080", BlendOverlay, "404040"},
+		{"FF0000", "0000FF", "multiply", "0000FF"},
+		{"", "0000FF", BlendAverage, "0000FF"},
+		{"red", "0000FF", BlendAverage, "0000FF"},
+	}
+	for _, tt := range tests {
+		if got := BlendHex(tt.existing, tt.requested, tt.mode); got != tt.want {
+			t.Errorf("BlendHex(%q, %q, %q) = %q, want %q", tt.existing, tt.requested, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	stored := time.Date(2026, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	tests := []struct {
+		name     string
+		stored   interface{}
+		expected string
+		want     bool
+	}{
+		{"no expectation keeps last-write-wins", stored, "", false},
+		{"client saw this version", stored, "2026-03-01T12:00:00.123Z", false},
+		{"client saw an older version", stored, "2026-03-01T12:00:00.122Z", true},
+		{"client saw a newer version", stored, "2026-03-01T12:00:01Z", false},
+		{"legacy string timestamp", "2026-03-01T12:00:00Z", "2026-03-01T11:59:59Z", true},
+		{"legacy string timestamp seen", "2026-03-01T12:00:00Z", "2026-03-01T12:00:00Z", false},
+		{"no stored time", nil, "2026-03-01T12:00:00Z", false},
+		{"unparseable expectation", stored, "yesterday", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsStale(tt.stored, tt.expected); got != tt.want {
+				t.Errorf("IsStale(%v, %q) = %v, want %v", tt.stored, tt.expected, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScheduleTime(t *testing.T) {
+	want := time.Date(2026, 6, 1, 18, 0, 0, 0, time.UTC)
+	for _, v := range []interface{}{"2026-06-01T18:00:00Z", "2026-06-01T20:00:00+02:00", want} {
+		if got := ParseScheduleTime(v); !got.Equal(want) {
+			t.Errorf("ParseScheduleTime(%v) = %v, want %v", v, got, want)
+		}
+	}
+	for _, v := range []interface{}{nil, "", "tomorrow", int64(1780336800)} {
+		if got := ParseScheduleTime(v); !got.IsZero() {
+			t.Errorf("ParseScheduleTime(%v) = %v, want unset", v, got)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	opens := time.Date(2026, 6, 1, 18, 0, 0, 0, time.UTC)
+	closes := opens.Add(4 * time.Hour)
+	if !NotOpenYet(opens, opens.Add(-time.Second)) || NotOpenYet(opens, opens) || NotOpenYet(time.Time{}, opens) {
+		t.Error("NotOpenYet should hold only before a set opensAt")
+	}
+	if Closed(closes, closes.Add(-time.Second)) || !Closed(closes, closes) || Closed(time.Time{}, closes) {
+		t.Error("Closed should hold only from a set closesAt on")
+	}
+}
+
+func TestTimeConstraintRestricts(t *testing.T) {
+	noon := time.Date(2026, 6, 1, 12, 30, 0, 0, time.UTC)
+	red := TimeConstraint{ColorPattern: "^FF0000$", AllowedHours: []int{12}}
+	if err := red.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	never := TimeConstraint{ColorPattern: "^0000FF$"}
+	if err := never.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		c     TimeConstraint
+		color string
+		now   time.Time
+		want  bool
+	}{
+		{"allowed hour", red, "FF0000", noon, false},
+		{"other hour", red, "FF0000", noon.Add(time.Hour), true},
+		{"lower case color", red, "ff0000", noon.Add(time.Hour), true},
+		{"other hour in another zone", red, "FF0000", noon.In(time.FixedZone("UTC+2", 2*3600)), false},
+		{"other color", red, "00FF00", noon.Add(time.Hour), false},
+		{"no hours", never, "0000FF", noon, true},
+		{"not compiled", TimeConstraint{ColorPattern: "^FF0000$"}, "FF0000", noon.Add(time.Hour), false},
+	}
+	for _, tt := range tests {
+		if got := tt.c.Restricts(tt.color, tt.now); got != tt.want {
+			t.Errorf("%s: Restricts(%q) = %v, want %v", tt.name, tt.color, got, tt.want)
+		}
+	}
+	if err := (&TimeConstraint{ColorPattern: "("}).Compile(); err == nil {
+		t.Error("Compile accepted an invalid pattern")
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	if got := CooldownID("u1", "ff00aa"); got != "u1_FF00AA" {
+		t.Errorf("CooldownID() = %q, want u1_FF00AA", got)
+	}
+	placed := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	doc := CooldownDoc("u1", "ff00aa", placed, time.Minute)
+	if doc["color"] != "FF00AA" || doc["lastPlacedAt"] != placed || doc["expiresAt"] != placed.Add(time.Minute) {
+		t.Errorf("CooldownDoc() = %v", doc)
+	}
+}
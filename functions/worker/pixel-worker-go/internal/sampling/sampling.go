@@ -0,0 +1,73 @@
+// Package sampling decides which traces are exported. New traces are
+// sampled at TRACE_SAMPLE_RATIO and child spans follow their parent, but an
+// admin can force the trace of a single interaction with the debug command
+// option: the proxy marks its context with Force and tells the workers
+// through the debugTrace message attribute, and Sampler samples every span
+// started under a forced context whatever the ratio.
+//
+// The same package lives in each Go function module; keep the copies in sync.
+package sampling
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute is the Pub/Sub attribute, set to "true", of messages published
+// for a forced trace
+const Attribute = "debugTrace"
+
+// SpanAttribute tags the spans of forced traces, to find them in Cloud Trace
+const SpanAttribute = "debug.forced"
+
+type forcedKey struct{}
+
+// Force marks ctx so spans started under it are sampled
+func Force(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcedKey{}, true)
+}
+
+// Forced reports whether ctx was marked by Force
+func Forced(ctx context.Context) bool {
+	forced, _ := ctx.Value(forcedKey{}).(bool)
+	return forced
+}
+
+// Ratio reads TRACE_SAMPLE_RATIO, the fraction of new traces sampled. Unset
+// or out of [0, 1] samples every trace, as before the setting existed.
+func Ratio() float64 {
+	ratio, err := strconv.ParseFloat(os.Getenv("TRACE_SAMPLE_RATIO"), 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return 1
+	}
+	return ratio
+}
+
+// New returns a sampler for the given ratio that honors Force
+func New(ratio float64) sdktrace.Sampler {
+	return sampler{base: sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))}
+}
+
+type sampler struct {
+	base sdktrace.Sampler
+}
+
+func (s sampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if !Forced(p.ParentContext) {
+		return s.base.ShouldSample(p)
+	}
+	return sdktrace.SamplingResult{
+		Decision:   sdktrace.RecordAndSample,
+		Attributes: []attribute.KeyValue{attribute.Bool(SpanAttribute, true)},
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+func (s sampler) Description() string {
+	return "Forced{" + s.base.Description() + "}"
+}
@@ -0,0 +1,97 @@
+// Package snowflake generates unique, time-ordered IDs for pixel_history
+// documents, after Twitter's Snowflake: 41 bits of milliseconds since Epoch,
+// 10 bits of machine ID and a 12-bit sequence per millisecond.
+//
+// IDs are formatted as 20-digit zero-padded decimals, so Firestore's
+// lexicographic document ID order is their numeric order. One Generator
+// never repeats an ID or goes backwards, even if the clock does; IDs from
+// two instances with the same machine ID can collide, which the caller sees
+// as an AlreadyExists error on Create.
+package snowflake
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+)
+
+// Epoch is the zero of the timestamp bits, 2026-01-01 UTC; 41 bits of
+// milliseconds last about 69 years from it.
+var Epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	machineBits  = 10
+	sequenceBits = 12
+	maxMachine   = 1<<machineBits - 1
+	maxSequence  = 1<<sequenceBits - 1
+)
+
+// Generator hands out IDs for one machine ID
+type Generator struct {
+	machine uint64
+
+	mu       sync.Mutex
+	lastMS   int64
+	sequence uint64
+	now      func() time.Time
+}
+
+// New returns a generator for machineID, reduced to 10 bits
+func New(machineID uint64) *Generator {
+	return &Generator{machine: machineID & maxMachine, now: time.Now}
+}
+
+// Next returns the next ID. When a millisecond's 4096 sequence numbers are
+// used up, or the clock moved back, it borrows the following millisecond so
+// IDs keep increasing.
+func (g *Generator) Next() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := g.now().Sub(Epoch).Milliseconds()
+	if ms < g.lastMS {
+		ms = g.lastMS
+	}
+	if ms == g.lastMS {
+		g.sequence++
+		if g.sequence > maxSequence {
+			ms++
+			g.sequence = 0
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastMS = ms
+	return uint64(ms)<<(machineBits+sequenceBits) | g.machine<<sequenceBits | g.sequence
+}
+
+// Format writes an ID as a document ID
+func Format(id uint64) string {
+	return fmt.Sprintf("%020d", id)
+}
+
+var defaultGenerator = New(machineID())
+
+// Generate returns the next document ID of this instance
+func Generate() string {
+	return Format(defaultGenerator.Next())
+}
+
+// machineID hashes FUNCTION_INSTANCE_ID, or random bytes where the runtime
+// does not set it (Cloud Functions gen2 does not), so concurrent instances
+// usually differ.
+func machineID() uint64 {
+	h := fnv.New64a()
+	if id := os.Getenv("FUNCTION_INSTANCE_ID"); id != "" {
+		h.Write([]byte(id))
+	} else {
+		var b [8]byte
+		rand.Read(b[:])
+		h.Write(b[:])
+	}
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
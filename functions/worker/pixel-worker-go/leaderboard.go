@@ -0,0 +1,29 @@
+package pixelworker
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+// requestLeaderboardRefresh asks the snapshot worker to rebuild
+// leaderboards/session_leaderboard, after placements changed pixelCount.
+// Writing the one board document from every placement would serialize them
+// all on it; the snapshot worker instead rebuilds it from the committed
+// user documents and skips requests an earlier rebuild already covered. A
+// failure only leaves the board behind until the next placement.
+func requestLeaderboardRefresh(ctx context.Context) {
+	data, _ := json.Marshal(messages.LeaderboardRefresh{RequestedAt: time.Now().UTC().Format(time.RFC3339Nano)})
+	result := getPubsub().Topic(snapshotEventsTopic).Publish(ctx, &pubsub.Message{
+		Data:       data,
+		Attributes: map[string]string{"type": messages.TypeLeaderboardRefresh},
+	})
+	if _, err := result.Get(ctx); err != nil {
+		slog.Warn("leaderboard_refresh_publish_failed", "error", err.Error())
+	}
+}
@@ -0,0 +1,58 @@
+package pixelworker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/team11/pixel-worker/internal/messages"
+	"github.com/team11/pixel-worker/internal/pixelrules"
+)
+
+func TestUpdatePixelRequestsLeaderboardRefresh(t *testing.T) {
+	requireEmulator(t)
+	ps := usePubsubFake(t)
+	ctx := t.Context()
+	seedDoc(t, "users/u1", map[string]interface{}{"id": "u1", "pixelCount": 4})
+
+	before := time.Now()
+	if _, err := updatePixel(ctx, 2, 3, "FF0000", pixelrules.BlendReplace, "u1", "alice", "discord", "", "", false); err != nil {
+		t.Fatalf("updatePixel: %v", err)
+	}
+
+	got := ps.publishedOfType(messages.TypeLeaderboardRefresh)
+	if len(got) != 1 {
+		t.Fatalf("published %d leaderboard refreshes, want 1", len(got))
+	}
+	if want := "projects/team11-local/topics/" + snapshotEventsTopic; got[0].Topic != want {
+		t.Errorf("topic = %s, want %s", got[0].Topic, want)
+	}
+	var req messages.LeaderboardRefresh
+	if err := json.Unmarshal(got[0].Data, &req); err != nil {
+		t.Fatal(err)
+	}
+	// Requested after the placement committed, so the rebuild counts it
+	if at, err := time.Parse(time.RFC3339Nano, req.RequestedAt); err != nil || at.Before(before) {
+		t.Errorf("requestedAt = %q, want a time after %v", req.RequestedAt, before)
+	}
+	if got := readDoc(t, "leaderboards/session_leaderboard"); got != nil {
+		t.Errorf("pixel worker wrote the leaderboard: %v", got)
+	}
+}
+
+func TestPixelBatchRequestsOneLeaderboardRefresh(t *testing.T) {
+	requireEmulator(t)
+	ps := usePubsubFake(t)
+	seedSession(t, 10, 10, nil)
+
+	const alice, bob = "123456789012345678", "223456789012345678"
+	processPixelBatch(t.Context(), []messages.PixelEvent{
+		{UserID: alice, X: 1, Y: 1, Color: "0000FF", Source: "web"},
+		{UserID: alice, X: 2, Y: 2, Color: "0000FF", Source: "web"},
+		{UserID: bob, X: 3, Y: 3, Color: "00FF00", Source: "web"},
+	})
+
+	if n := len(ps.publishedOfType(messages.TypeLeaderboardRefresh)); n != 1 {
+		t.Errorf("published %d leaderboard refreshes, want 1", n)
+	}
+}
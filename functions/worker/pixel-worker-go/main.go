@@ -1,18 +1,20 @@
 package pixelworker
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"math"
-	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/firestore"
@@ -26,20 +28,53 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/team11/pixel-worker/internal/coords"
+	"github.com/team11/pixel-worker/internal/discord"
+	"github.com/team11/pixel-worker/internal/events"
+	"github.com/team11/pixel-worker/internal/flowcontrol"
+	"github.com/team11/pixel-worker/internal/messages"
+	"github.com/team11/pixel-worker/internal/pixelrules"
+	"github.com/team11/pixel-worker/internal/sampling"
 )
 
 const (
 	rateLimitWindow = 60 // seconds
 	rateLimitMax    = 20 // pixels per window
 	maxCoordinate   = 100000
-	discordAPI      = "https://discord.com/api/v10"
+
+	// gRPC tuning for the Firestore client. gRPC opens streams and
+	// connections with 64KB flow-control windows and grows them from its own
+	// bandwidth estimates; fixed windows turn that off. 4MB per connection
+	// gives each of 50 in-flight transactions about 80KB, still more than a
+	// stream starts with by default, and 1MB caps any one stream.
+	// TestFirestoreClientOptions checks what the client announces;
+	// BenchmarkConcurrentTransactions times 50 concurrent transactions on one
+	// connection with and without these windows, against the emulator, and is
+	// what to rerun before changing them. The pool size can be raised with
+	// FIRESTORE_GRPC_POOL_SIZE when max instance concurrency is increased.
+	defaultGRPCPoolSize       = 4
+	grpcInitialWindowSize     = 1 << 20
+	grpcInitialConnWindowSize = 4 << 20
 )
 
 var (
 	projectID           string
 	discordBotToken     string
+	discordClient       *discord.Client
 	publicPixelTopic    string
+	downstreamTopics    []string
+	drawSilentSuccess   bool
+	showRemainingBudget bool
+	presenceTopic       string
+	intake              *flowcontrol.Limiter
 	discordChannelID    string
+	grpcPoolSize        int
+	sameColorCooldown   time.Duration
 	fsClient            *firestore.Client
 	psClient            *pubsub.Client
 	fsOnce              sync.Once
@@ -52,11 +87,35 @@ var (
 func init() {
 	projectID = os.Getenv("PROJECT_ID")
 	discordBotToken = strings.TrimSpace(os.Getenv("DISCORD_BOT_TOKEN"))
+	discordClient = discord.New(discordBotToken)
 	publicPixelTopic = os.Getenv("PUBLIC_PIXEL_TOPIC")
 	discordChannelID = strings.TrimSpace(os.Getenv("DISCORD_CHANNEL_ID"))
 	if publicPixelTopic == "" {
 		publicPixelTopic = "public-pixel"
 	}
+	drawSilentSuccess = os.Getenv("DRAW_SILENT_SUCCESS") == "true"
+	// On unless SHOW_REMAINING_BUDGET=false
+	showRemainingBudget = os.Getenv("SHOW_REMAINING_BUDGET") != "false"
+	presenceTopic = os.Getenv("PRESENCE_TOPIC")
+	if presenceTopic == "" {
+		presenceTopic = "presence"
+	}
+	// Pixel updates go to public-pixel plus any extra DOWNSTREAM_TOPICS
+	downstreamTopics = []string{publicPixelTopic}
+	for _, t := range strings.Split(os.Getenv("DOWNSTREAM_TOPICS"), ",") {
+		if t = strings.TrimSpace(t); t != "" && t != publicPixelTopic {
+			downstreamTopics = append(downstreamTopics, t)
+		}
+	}
+	intake = flowcontrol.NewLimiter(flowcontrol.SettingsFromEnv())
+	grpcPoolSize = defaultGRPCPoolSize
+	if v, err := strconv.Atoi(os.Getenv("FIRESTORE_GRPC_POOL_SIZE")); err == nil && v > 0 {
+		grpcPoolSize = v
+	}
+	// Seconds between two placements of the same color by one user; 0 disables
+	if v, err := strconv.Atoi(os.Getenv("SAME_COLOR_COOLDOWN")); err == nil && v > 0 {
+		sameColorCooldown = time.Duration(v) * time.Second
+	}
 	functions.CloudEvent("handler", handleCloudEvent)
 
 	ctx := context.Background()
@@ -69,6 +128,7 @@ func init() {
 		tracerProvider = sdktrace.NewTracerProvider(
 			sdktrace.WithBatcher(exporter),
 			sdktrace.WithResource(res),
+			sdktrace.WithSampler(sampling.New(sampling.Ratio())),
 		)
 		otel.SetTracerProvider(tracerProvider)
 	}
@@ -84,12 +144,18 @@ func init() {
 			return a
 		},
 	})))
+
+	if projectID != "" {
+		// Cloud Run sends SIGTERM before stopping an instance
+		watchCtx, _ := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+		go watchSession(watchCtx)
+	}
 }
 
 func getFirestore() *firestore.Client {
 	fsOnce.Do(func() {
 		var err error
-		fsClient, err = firestore.NewClientWithDatabase(context.Background(), projectID, "team11-database")
+		fsClient, err = firestore.NewClientWithDatabase(context.Background(), projectID, "team11-database", firestoreClientOptions()...)
 		if err != nil {
 			log.Fatalf("Firestore client: %v", err)
 		}
@@ -97,6 +163,16 @@ func getFirestore() *firestore.Client {
 	return fsClient
 }
 
+// firestoreClientOptions returns the gRPC channel configuration used for the
+// Firestore client (see the tuning notes on the constants above).
+func firestoreClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithGRPCConnectionPool(grpcPoolSize),
+		option.WithGRPCDialOption(grpc.WithInitialWindowSize(grpcInitialWindowSize)),
+		option.WithGRPCDialOption(grpc.WithInitialConnWindowSize(grpcInitialConnWindowSize)),
+	}
+}
+
 func getPubsub() *pubsub.Client {
 	psOnce.Do(func() {
 		var err error
@@ -108,145 +184,409 @@ func getPubsub() *pubsub.Client {
 	return psClient
 }
 
-// CloudEvent Pub/Sub data
-type MessagePublishedData struct {
-	Message struct {
-		Data       []byte            `json:"data"`
-		Attributes map[string]string `json:"attributes"`
-	} `json:"message"`
-}
-
-type PixelEvent struct {
-	X                int    `json:"x"`
-	Y                int    `json:"y"`
-	Color            string `json:"color"`
-	UserID           string `json:"userId"`
-	Username         string `json:"username"`
-	Source           string `json:"source"`
-	InteractionToken string `json:"interactionToken"`
-	ApplicationID    string `json:"applicationId"`
+func sendFollowUp(appID, token, content string) {
+	if discordBotToken == "" {
+		return
+	}
+	discordClient.FollowUp(context.Background(), appID, token, discord.Message{Content: content})
 }
 
-func sendFollowUp(appID, token, content string) {
-	if appID == "" || token == "" || discordBotToken == "" {
+// deleteOriginalResponse removes the deferred "thinking..." response so a
+// silent success leaves nothing behind in the channel.
+func deleteOriginalResponse(appID, token string) {
+	if discordBotToken == "" {
 		return
 	}
-	body, _ := json.Marshal(map[string]string{"content": content})
-	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/webhooks/%s/%s", discordAPI, appID, token), bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bot "+discordBotToken)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
+	discordClient.DeleteOriginal(context.Background(), appID, token)
+}
+
+// replySuccess confirms a placement, or stays silent when DRAW_SILENT_SUCCESS is set
+func replySuccess(appID, token, content string) {
+	if drawSilentSuccess {
+		deleteOriginalResponse(appID, token)
 		return
 	}
-	resp.Body.Close()
+	sendFollowUp(appID, token, content)
 }
 
 func sendChannelMessage(username, message string) {
 	if discordChannelID == "" || discordBotToken == "" {
 		return
 	}
-	payload := map[string]interface{}{
-		"content": fmt.Sprintf("🎨 **%s** %s", username, message),
-	}
-	body, _ := json.Marshal(payload)
-	url := fmt.Sprintf("%s/channels/%s/messages", discordAPI, discordChannelID)
-	req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bot "+discordBotToken)
-	resp, err := http.DefaultClient.Do(req)
+	err := discordClient.ChannelMessage(context.Background(), discordChannelID, discord.Message{
+		Content: fmt.Sprintf("🎨 **%s** %s", username, message),
+	})
 	if err != nil {
 		slog.Warn("discord_channel_message_failed", "error", err.Error())
-		return
 	}
-	resp.Body.Close()
 }
 
-func checkRateLimit(ctx context.Context, userID string) (bool, int) {
+// rateLimitResult is the state of a user's window after a charge. Max is zero
+// when the limiter failed open and the window state is unknown.
+type rateLimitResult struct {
+	Granted int
+	Count   int
+	Max     int
+	ResetAt time.Time
+
+	// RegionDenied marks, per requested pixel, those refused by the regional
+	// limit. They are not charged against the global window. Nil when the
+	// regional limit is off.
+	RegionDenied []bool
+	RegionSize   int
+	RegionMax    int
+
+	// Window is the minute the pixels were charged to; ChargeID names this
+	// charge for refundRateLimit. Empty when nothing was charged.
+	Window   int64
+	ChargeID string
+
+	// Source is set when config/rate_limits limits the placements' source,
+	// whose own counter was then charged too. SourceLimited means Count and
+	// Max are that counter's, as the one with less left.
+	Source        string
+	SourceLimited bool
+}
+
+// Remaining is how many pixels the user may still place in this window
+func (r rateLimitResult) Remaining() int {
+	return max(0, r.Max-r.Count)
+}
+
+// regionDenied reports whether the i-th requested pixel hit the regional limit
+func (r rateLimitResult) regionDenied(i int) bool {
+	return i < len(r.RegionDenied) && r.RegionDenied[i]
+}
+
+func checkRateLimit(ctx context.Context, userID, source string, x, y int) (bool, rateLimitResult) {
+	res := checkRateLimitN(ctx, userID, source, []pixelCoord{{X: x, Y: y}})
+	return res.Granted == 1, res
+}
+
+// checkRateLimitN charges pixels, in order, against the user's current window
+// in a single transaction and returns how many were granted along with the
+// resulting window count, the applicable limit and when the window resets.
+// When a regional limit is configured, each pixel is also charged to a
+// userId_region_window counter in the same transaction; a pixel over either
+// limit is refused. Likewise, when config/rate_limits sets a limit for the
+// placements' source, they are charged to a userId_source_window counter
+// too and must fit under both.
+func checkRateLimitN(ctx context.Context, userID, source string, pixels []pixelCoord) rateLimitResult {
 	ctx, span := tracer.Start(ctx, "checkRateLimit")
 	defer span.End()
 
-	span.SetAttributes(attribute.String("user.id", userID))
+	n := len(pixels)
+	span.SetAttributes(
+		attribute.String("user.id", userID),
+		attribute.Int("rate_limit.requested", n),
+	)
 
 	now := time.Now()
 	minute := now.Unix() / rateLimitWindow
-	docID := fmt.Sprintf("%s_%d", userID, minute)
-	ref := getFirestore().Collection("rate_limits").Doc(docID)
+	ref := rateLimitRef(userID, minute)
+	resetAt := time.Unix((minute+1)*rateLimitWindow, 0)
+	expiresAt := now.Add(time.Duration(rateLimitWindow*2) * time.Second).UTC()
+
+	cfg := getRateLimitConfig(ctx)
+	// Lowered while the system is under load, see adaptiveRateLimit
+	limit := adaptiveRateLimit(ctx, cfg.userLimit())
+	region := cfg.Region
+	source = chargedSource(source)
+	sourceLimit := cfg.sourceLimit(source)
+	if sourceLimit > 0 {
+		sourceLimit = adaptiveRateLimit(ctx, sourceLimit)
+	}
+	sourceRef := sourceRateLimitRef(userID, source, minute)
+	var regionIDs []string
+	if region.enabled() {
+		regionIDs = make([]string, n)
+		for i, p := range pixels {
+			regionIDs[i] = region.regionID(p)
+		}
+	}
+	regionRef := func(id string) *firestore.DocumentRef {
+		return regionRateLimitRef(userID, id, minute)
+	}
 
-	allowed := true
+	granted := 0
 	count := 0
+	sourceCount := 0
+	var regionDenied []bool
 
 	err := getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// Reads inside the transaction lock the documents (or their absence),
+		// so concurrent instances charging the same window are serialized and
+		// retried by Firestore; count can never pass limit. Only a
+		// genuinely missing document may be created — any other read error
+		// must abort the attempt rather than reset the counter.
 		doc, err := tx.Get(ref)
-		if err != nil {
-			// Document doesn't exist — create it
-			tx.Set(ref, map[string]interface{}{
-				"count":     1,
+		exists := true
+		c := 0
+		if status.Code(err) == codes.NotFound {
+			exists = false
+		} else if err != nil {
+			return err
+		} else {
+			c = toInt(doc.Data()["count"])
+		}
+
+		sourceExists := false
+		sc := 0
+		if sourceLimit > 0 {
+			sdoc, err := tx.Get(sourceRef)
+			if err == nil {
+				sourceExists = true
+				sc = toInt(sdoc.Data()["count"])
+			} else if status.Code(err) != codes.NotFound {
+				return err
+			}
+		}
+
+		regionCounts := make(map[string]int)
+		regionExists := make(map[string]bool)
+		for _, id := range regionIDs {
+			if _, seen := regionCounts[id]; seen {
+				continue
+			}
+			rdoc, err := tx.Get(regionRef(id))
+			if status.Code(err) == codes.NotFound {
+				regionCounts[id] = 0
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			regionCounts[id] = toInt(rdoc.Data()["count"])
+			regionExists[id] = true
+		}
+
+		// Earliest pixels win; a pixel refused regionally does not use
+		// global quota, so later pixels elsewhere can still be placed.
+		granted = 0
+		regionDenied = nil
+		if regionIDs != nil {
+			regionDenied = make([]bool, n)
+		}
+		charged := make(map[string]int)
+		for i := range pixels {
+			if regionIDs != nil && regionCounts[regionIDs[i]]+charged[regionIDs[i]] >= region.Max {
+				regionDenied[i] = true
+				continue
+			}
+			if c+granted >= limit || (sourceLimit > 0 && sc+granted >= sourceLimit) {
+				continue
+			}
+			granted++
+			if regionIDs != nil {
+				charged[regionIDs[i]]++
+			}
+		}
+		count = c + granted
+		sourceCount = sc + granted
+
+		if !exists {
+			if err := tx.Create(ref, map[string]interface{}{
+				"count":     granted,
 				"userId":    userID,
 				"window":    minute,
-				"expiresAt": now.Add(time.Duration(rateLimitWindow*2) * time.Second).Format(time.RFC3339),
-			})
-			allowed = true
-			count = 1
-			return nil
+				"expiresAt": expiresAt,
+			}); err != nil {
+				return err
+			}
+		} else if granted > 0 {
+			if err := tx.Update(ref, []firestore.Update{
+				{Path: "count", Value: firestore.Increment(granted)},
+			}); err != nil {
+				return err
+			}
 		}
 
-		data := doc.Data()
-		c := toInt(data["count"])
-		if c >= rateLimitMax {
-			allowed = false
-			count = c
-			return nil
+		if sourceLimit > 0 && !sourceExists {
+			if err := tx.Create(sourceRef, map[string]interface{}{
+				"count":     granted,
+				"userId":    userID,
+				"source":    source,
+				"window":    minute,
+				"expiresAt": expiresAt,
+			}); err != nil {
+				return err
+			}
+		} else if sourceLimit > 0 && granted > 0 {
+			if err := tx.Update(sourceRef, []firestore.Update{
+				{Path: "count", Value: firestore.Increment(granted)},
+			}); err != nil {
+				return err
+			}
 		}
 
-		tx.Update(ref, []firestore.Update{
-			{Path: "count", Value: firestore.Increment(1)},
-		})
-		allowed = true
-		count = c + 1
+		for id, k := range charged {
+			if !regionExists[id] {
+				err = tx.Create(regionRef(id), map[string]interface{}{
+					"count":     k,
+					"userId":    userID,
+					"region":    id,
+					"window":    minute,
+					"expiresAt": expiresAt,
+				})
+			} else {
+				err = tx.Update(regionRef(id), []firestore.Update{
+					{Path: "count", Value: firestore.Increment(k)},
+				})
+			}
+			if err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 
 	if err != nil {
-		return true, 0 // fail open
+		return rateLimitResult{Granted: n, ResetAt: resetAt} // fail open
 	}
 
 	span.SetAttributes(
-		attribute.Bool("rate_limit.allowed", allowed),
+		attribute.Bool("rate_limit.allowed", granted == n),
+		attribute.Int("rate_limit.granted", granted),
 		attribute.Int("rate_limit.count", count),
+		attribute.Int("rate_limit.max", limit),
 	)
-	return allowed, count
+	res := rateLimitResult{
+		Granted:      granted,
+		Count:        count,
+		Max:          limit,
+		ResetAt:      resetAt,
+		RegionDenied: regionDenied,
+		RegionSize:   region.Size,
+		RegionMax:    region.Max,
+		Window:       minute,
+	}
+	if sourceLimit > 0 {
+		res.Source = source
+		if sourceLimit-sourceCount < limit-count {
+			res.Count, res.Max, res.SourceLimited = sourceCount, sourceLimit, true
+		}
+		span.SetAttributes(
+			attribute.String("rate_limit.source", source),
+			attribute.Int("rate_limit.source_count", sourceCount),
+			attribute.Int("rate_limit.source_max", sourceLimit),
+		)
+	}
+	if granted > 0 {
+		res.ChargeID = newChargeID()
+	}
+	return res
 }
 
-func validateBounds(ctx context.Context, x, y int) (bool, string) {
-	doc, err := getFirestore().Collection("sessions").Doc("current").Get(ctx)
-	if err != nil {
-		return false, "No active session"
+// formatPlacementSuccess builds the confirmation for a placed pixel, with a
+// remaining-quota footer when the window state is known and
+// SHOW_REMAINING_BUDGET is not turned off, and the pixel's grid cell when
+// the session snaps to a grid of snap pixels.
+func formatPlacementSuccess(x, y int, color string, snap int, rl rateLimitResult) string {
+	msg := fmt.Sprintf("Pixel placed at (%d, %d) with color #%s", x, y, color)
+	if rl.Max > 0 && showRemainingBudget {
+		msg += quotaFooter(rl)
+	}
+	if snap > 1 {
+		msg += "\n" + coordinateInfo(x, y, snap)
 	}
+	return msg
+}
 
-	data := doc.Data()
+// quotaFooter renders " — R/M remaining this minute, resets <t:unix:R>"
+func quotaFooter(rl rateLimitResult) string {
+	return fmt.Sprintf(" — %d/%d remaining this minute, resets <t:%d:R>", rl.Remaining(), rl.Max, rl.ResetAt.Unix())
+}
+
+// sessionState is the part of sessions/current needed to validate placements
+type sessionState struct {
+	Status       string
+	CanvasWidth  int
+	CanvasHeight int
+	BlendMode    string
+	// Where (0, 0) is for Discord users
+	Origin coords.Origin
+	// Side of the grid cells placements snap to; 0 or 1 places pixels as typed
+	GridSnap int
+
+	// Scheduled opening window; zero values mean unbounded
+	OpensAt       time.Time
+	ClosesAt      time.Time
+	ClosedMessage string
+}
+
+// parseSessionState reads a sessions/current document
+func parseSessionState(data map[string]interface{}) *sessionState {
 	status, _ := data["status"].(string)
-	if status != "active" {
-		return false, fmt.Sprintf("Session is %s", status)
+	// /session stop archives the pixels before the session becomes stopped
+	if pending, _ := data["pendingStop"].(bool); pending {
+		status = "stopping"
+	}
+	blendMode, _ := data["blendMode"].(string)
+	if blendMode == "" {
+		blendMode = pixelrules.BlendReplace
+	}
+	closedMessage, _ := data["closedMessage"].(string)
+	return &sessionState{
+		BlendMode:     blendMode,
+		Status:        status,
+		CanvasWidth:   toInt(data["canvasWidth"]),
+		CanvasHeight:  toInt(data["canvasHeight"]),
+		Origin:        coords.FromSession(data),
+		GridSnap:      toInt(data["gridSnap"]),
+		OpensAt:       pixelrules.ParseScheduleTime(data["opensAt"]),
+		ClosesAt:      pixelrules.ParseScheduleTime(data["closesAt"]),
+		ClosedMessage: closedMessage,
+	}
+}
+
+// validateBounds checks ev against the current session and locked zones.
+// Discord placements are typed in user coordinates, so they are converted to
+// storage coordinates first, after snapping to the session's grid. The
+// session is returned for the rest of the placement.
+func validateBounds(ctx context.Context, ev *messages.PixelEvent) (*sessionState, *rejection) {
+	session, err := getSessionState(ctx)
+	if err != nil {
+		return nil, newRejection(events.ReasonNoSession)
+	}
+	ev.X, ev.Y = session.placementToCanvas(ev.X, ev.Y, ev.Source)
+	if r := session.checkPlacement(ev.X, ev.Y); r != nil {
+		return session, r
 	}
+	if ok, reason := checkZones(getZones(ctx), ev.X, ev.Y, ev.UserID); !ok {
+		return session, &rejection{events.ReasonZoneProtected, reason}
+	}
+	return session, nil
+}
 
-	cw := toInt(data["canvasWidth"])
-	ch := toInt(data["canvasHeight"])
+// checkPlacement returns why the session refuses a pixel at (x, y), or nil.
+func (s *sessionState) checkPlacement(x, y int) *rejection {
+	if s.Status != "active" {
+		return newRejection(events.ReasonSessionClosed, s.Status)
+	}
+	if ok, reason := s.checkSchedule(time.Now()); !ok {
+		return &rejection{events.ReasonSessionClosed, reason}
+	}
 
+	cw, ch := s.CanvasWidth, s.CanvasHeight
 	if cw > 0 && ch > 0 {
 		if x < 0 || x >= cw || y < 0 || y >= ch {
-			return false, fmt.Sprintf("Coordinates out of bounds (0-%d, 0-%d)", cw-1, ch-1)
+			return newRejection(events.ReasonOutOfBounds, cw-1, ch-1)
 		}
 	}
 
 	if int(math.Abs(float64(x))) > maxCoordinate || int(math.Abs(float64(y))) > maxCoordinate {
-		return false, "Coordinates too large"
+		return &rejection{events.ReasonOutOfBounds, "Coordinates too large"}
 	}
 
-	return true, ""
+	return nil
 }
 
-func updatePixel(ctx context.Context, x, y int, color, userID, username, source string) bool {
+// errPixelConflict means the pixel changed after the client last saw it.
+var errPixelConflict = errors.New("pixel changed since it was last seen")
+
+// updatePixel stores the pixel and returns the color actually written, which
+// differs from the requested one when the session blends colors.
+func updatePixel(ctx context.Context, x, y int, color, blendMode, userID, username, source, expectedUpdatedAt, guildID string, isAdmin bool) (string, error) {
 	ctx, span := tracer.Start(ctx, "updatePixel")
 	defer span.End()
 
@@ -255,51 +595,170 @@ func updatePixel(ctx context.Context, x, y int, color, userID, username, source
 		attribute.Int("pixel.y", y),
 		attribute.String("pixel.color", color),
 		attribute.String("user.id", userID),
+		attribute.String("pixel.blend_mode", blendMode),
 	)
 
 	pixelID := fmt.Sprintf("%d_%d", x, y)
 	pixelRef := getFirestore().Collection("pixels").Doc(pixelID)
 	userRef := getFirestore().Collection("users").Doc(userID)
-	now := time.Now().UTC().Format(time.RFC3339)
+	placedAt := time.Now().UTC()
+	wal, err := writeWAL(ctx, []walEntry{{X: x, Y: y, Color: color, BlendMode: blendMode, ExpectedUpdatedAt: expectedUpdatedAt,
+		UserID: userID, Username: username, Source: source, AdminPlaced: isAdmin, CreatedAt: placedAt}})
+	if err != nil {
+		span.SetAttributes(attribute.Bool("success", false))
+		return "", fmt.Errorf("write-ahead log: %w", err)
+	}
 
-	err := getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+	var stored, noticeOwnerID, credited string
+	var pixelCount int
+	err = getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
 		userDoc, err := tx.Get(userRef)
+		noticeOwnerID = ""
+		pixelCount = 1
+		credited = username
+		if err == nil && userDoc.Exists() {
+			pixelCount = toInt(userDoc.Data()["pixelCount"]) + 1
+			credited = creditedName(userDoc.Data(), username)
+		}
 
+		// The previous owner and color decide conquest stats and blending;
+		// all reads must precede writes
+		stored = color
+		var previousUserID, previousColor string
+		pixelDoc, pixelErr := tx.Get(pixelRef)
+		if pixelErr != nil && status.Code(pixelErr) != codes.NotFound {
+			return pixelErr
+		}
+		if pixelErr == nil {
+			data := pixelDoc.Data()
+			previousUserID, _ = data["userId"].(string)
+			previousColor, _ = data["color"].(string)
+			if pixelrules.IsStale(data["updatedAt"], expectedUpdatedAt) {
+				return errPixelConflict
+			}
+			if isProtectedFrom(data, isAdmin) {
+				return errPixelProtected
+			}
+			if blendMode != pixelrules.BlendReplace {
+				stored = pixelrules.BlendHex(previousColor, color, blendMode)
+			}
+		}
+		conquered := isConquest(previousUserID, userID)
+		var previousUserRef *firestore.DocumentRef
+		if conquered {
+			ref := getFirestore().Collection("users").Doc(previousUserID)
+			// Anonymized or deleted users have no document to charge
+			if doc, err := tx.Get(ref); err == nil && doc.Exists() {
+				previousUserRef = ref
+				if wantsOverwriteNotice(doc.Data()) {
+					noticeOwnerID = previousUserID
+				}
+			}
+		}
+		// The cooldown checked before quota is claimed with the pixel
+		cooldowns, cooldownErr := readColorCooldowns(ctx, tx, []colorCooldownKey{{userID, color}})
+		if cooldownErr != nil {
+			return cooldownErr
+		}
+		if retryAt := cooldowns.claim(userID, color); !retryAt.IsZero() {
+			return &colorCooldownError{retryAt}
+		}
+		if err := cooldowns.write(tx); err != nil {
+			return err
+		}
 		// Set pixel
 		tx.Set(pixelRef, map[string]interface{}{
-			"x":         x,
-			"y":         y,
-			"color":     color,
-			"userId":    userID,
-			"username":  username,
-			"source":    source,
-			"updatedAt": now,
+			"x":           x,
+			"y":           y,
+			"color":       stored,
+			"userId":      userID,
+			"username":    credited,
+			"source":      source,
+			"updatedAt":   placedAt,
+			"adminPlaced": isAdmin,
 		})
+		if historyEnabled {
+			tx.Create(newHistoryRef(), historyEntry{
+				X:             x,
+				Y:             y,
+				Color:         stored,
+				PreviousColor: previousColor,
+				UserID:        userID,
+				Username:      credited,
+				Source:        source,
+				Timestamp:     placedAt,
+			})
+		}
 
 		// Update user stats
+		overwritten := 0
+		if conquered {
+			overwritten = 1
+		}
 		if err == nil && userDoc.Exists() {
-			tx.Update(userRef, []firestore.Update{
-				{Path: "lastPixelAt", Value: now},
+			streak := streakFromUser(userDoc.Data()).advance(placedAt)
+			updates := append([]firestore.Update{
+				{Path: "lastPixelAt", Value: placedAt},
 				{Path: "pixelCount", Value: firestore.Increment(1)},
-			})
+				{Path: "pixelsOverwritten", Value: firestore.Increment(overwritten)},
+			}, streak.updates()...)
+			if guildID != "" {
+				updates = append(updates, firestore.Update{Path: "guildId", Value: guildID})
+			}
+			tx.Update(userRef, updates)
 		} else {
-			tx.Set(userRef, map[string]interface{}{
-				"id":          userID,
-				"username":    username,
-				"lastPixelAt": now,
-				"pixelCount":  1,
-				"createdAt":   now,
+			streak := userStreak{}.advance(placedAt)
+			user := map[string]interface{}{
+				"id":                userID,
+				"username":          username,
+				"lastPixelAt":       placedAt,
+				"pixelCount":        1,
+				"pixelsOverwritten": overwritten,
+				"pixelsLost":        0,
+				"createdAt":         placedAt,
+				"lastActiveDay":     streak.LastActiveDay,
+				"streakDays":        streak.Days,
+				"bestStreakDays":    streak.Best,
+			}
+			if guildID != "" {
+				user["guildId"] = guildID
+			}
+			tx.Set(userRef, user)
+		}
+		if previousUserRef != nil {
+			tx.Update(previousUserRef, []firestore.Update{
+				{Path: "pixelsLost", Value: firestore.Increment(1)},
 			})
 		}
+		// Committed with the pixel, so a landed write never stays pending
+		if len(wal) > 0 {
+			tx.Update(wal[0], walSettled(walCommitted))
+		}
 		return nil
 	})
 
 	if err != nil {
-		span.SetAttributes(attribute.Bool("success", false))
-		return false
+		settleWAL(ctx, wal, walAborted)
+		span.SetAttributes(
+			attribute.Bool("success", false),
+			attribute.Bool("pixel.conflict", errors.Is(err, errPixelConflict)),
+			attribute.Bool("pixel.protected", errors.Is(err, errPixelProtected)),
+		)
+		return "", err
 	}
 	span.SetAttributes(attribute.Bool("success", true))
-	return true
+	requestLeaderboardRefresh(ctx)
+	if noticeOwnerID != "" {
+		publishOverwriteNotice(ctx, noticeOwnerID, map[string]int{userID: 1}, map[string]string{userID: credited})
+	}
+	publishRoleRewardChecks(ctx, map[string]int{userID: pixelCount}, map[string]int{userID: 1})
+	return stored, nil
+}
+
+// isConquest reports whether placing over a pixel owned by previousUserID
+// takes it from another user. Repainting your own pixel is not a conquest.
+func isConquest(previousUserID, userID string) bool {
+	return previousUserID != "" && previousUserID != userID
 }
 
 func publishPixelUpdate(ctx context.Context, x, y int, color, userID, username string) {
@@ -312,13 +771,32 @@ func publishPixelUpdate(ctx context.Context, x, y int, color, userID, username s
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 	})
 
-	topic := getPubsub().Topic(publicPixelTopic)
-	result := topic.Publish(ctx, &pubsub.Message{
-		Data:       data,
-		Attributes: map[string]string{"type": "pixel_update"},
-	})
+	if err := fanOutPublish(ctx, downstreamTopics, data, map[string]string{"type": events.TypePixelUpdate}); err != nil {
+		slog.Warn("pixel_update_publish_failed", "error", err.Error())
+	}
+}
 
-	result.Get(ctx)
+// fanOutPublish publishes the same message to every topic in parallel. A
+// failing topic does not prevent delivery to the others; all failures are
+// returned joined together.
+func fanOutPublish(ctx context.Context, topics []string, data []byte, attrs map[string]string) error {
+	errs := make([]error, len(topics))
+	var wg sync.WaitGroup
+	for i, name := range topics {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			result := getPubsub().Topic(name).Publish(ctx, &pubsub.Message{
+				Data:       data,
+				Attributes: attrs,
+			})
+			if _, err := result.Get(ctx); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", name, err)
+			}
+		}(i, name)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
 }
 
 func toInt(v interface{}) int {
@@ -332,12 +810,19 @@ func toInt(v interface{}) int {
 	}
 }
 
+var errFlowControlRejected = errors.New("instance at flow-control limit, message will be redelivered")
+
 func handleCloudEvent(ctx context.Context, e event.Event) error {
-	var msg MessagePublishedData
+	var msg messages.MessagePublishedData
 	if err := e.DataAs(&msg); err != nil {
 		return fmt.Errorf("parse event: %w", err)
 	}
 
+	// Checked before any attribute is trusted, trace context included
+	if !checkAttributes(msg.Message.Attributes) {
+		return nil
+	}
+
 	// Extract trace context from Pub/Sub attributes
 	if traceID := msg.Message.Attributes["traceId"]; traceID != "" {
 		if spanID := msg.Message.Attributes["spanId"]; spanID != "" {
@@ -352,66 +837,241 @@ func handleCloudEvent(ctx context.Context, e event.Event) error {
 			ctx = trace.ContextWithRemoteSpanContext(ctx, parentCtx)
 		}
 	}
+	if msg.Message.Attributes[sampling.Attribute] == "true" {
+		ctx = sampling.Force(ctx)
+	}
 
 	ctx, span := tracer.Start(ctx, "pixel_worker.handle_event")
 	defer span.End()
 
-	var ev PixelEvent
-	if err := json.Unmarshal(msg.Message.Data, &ev); err != nil {
-		return fmt.Errorf("parse pixel event: %w", err)
+	msgType := msg.Message.Attributes["type"]
+	if msgType == "" {
+		// Publishers predating the type attribute only sent single placements
+		msgType = messages.TypePixelPlacement
 	}
+	span.SetAttributes(attribute.String("message.type", msgType))
 
-	if ev.Source == "" {
-		ev.Source = "web"
+	ctx = withSchemaVersion(ctx, pixelSchemaVersion(msg.Message.Attributes))
+
+	route, ok := messageRoutes[msgType]
+	if !ok {
+		// Ack unknown types: returning an error would only loop them to the DLQ
+		slog.Warn("unknown_message_type", "type", msgType, "bytes", len(msg.Message.Data))
+		return nil
 	}
 
-	reply := func(msg string) {
-		if ev.Source == "discord" {
-			sendFollowUp(ev.ApplicationID, ev.InteractionToken, msg)
+	// Refused deliveries are retried by Pub/Sub with backoff
+	if route.flowControlled {
+		size := len(msg.Message.Data)
+		if !intake.TryAcquire(size) {
+			slog.Warn("flow_control_rejected", "type", msgType, "bytes", size)
+			span.SetAttributes(attribute.Bool("flow_control.rejected", true))
+			recordFailureContext(ctx, &msg, msgType, atStage("intake", errFlowControlRejected))
+			return errFlowControlRejected
+		}
+		defer intake.Release(size)
+
+		// Time from publish to processing, for the pixel_message_age metric:
+		// it grows when the worker falls behind the topic
+		if published := e.Time(); !published.IsZero() {
+			age := time.Since(published)
+			span.SetAttributes(attribute.Int64("message.age_ms", age.Milliseconds()))
+			slog.Info("pixel_message_age", "type", msgType, "age_ms", age.Milliseconds())
+			observeMessageAge(ctx, age)
 		}
 	}
 
-	// Validate color
-	if !hexColorRegex.MatchString(ev.Color) {
-		slog.Warn("pixel_validation_failed", "reason", "invalid_color", "color", ev.Color, "user_id", ev.UserID)
-		reply(fmt.Sprintf("Invalid color format: %s. Use 6-digit hex (e.g., FF0000)", ev.Color))
+	if err := route.handle(ctx, msg.Message.Data); err != nil {
+		recordFailureContext(ctx, &msg, msgType, err)
+		return err
+	}
+	return nil
+}
+
+// messageRoute handles one Pub/Sub "type" attribute value
+type messageRoute struct {
+	handle func(ctx context.Context, data []byte) error
+	// Work that reaches Firestore counts against MAX_OUTSTANDING_*
+	flowControlled bool
+}
+
+// messageRoutes maps the "type" attribute to its handler. Adding a message
+// type is an entry here plus a handler.
+var messageRoutes = map[string]messageRoute{
+	messages.TypePixelPlacement: {handle: handlePixelPlacement, flowControlled: true},
+	messages.TypePixelBatch:     {handle: handlePixelBatch, flowControlled: true},
+	messages.TypePresence:       {handle: handlePresence},
+}
+
+func handlePresence(ctx context.Context, data []byte) error {
+	var ev messages.PresenceEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return atStage("decode", fmt.Errorf("parse presence event: %w", err))
+	}
+	if !isValidUserID(ev.UserID) {
+		slog.Warn("presence_rejected", "reason", "invalid_user_id", "user_id", ev.UserID)
 		return nil
 	}
+	ev.Username = sanitizeUsername(ev.Username)
+	forwardPresence(ctx, ev)
+	return nil
+}
+
+func handlePixelBatch(ctx context.Context, data []byte) error {
+	var batch messages.PixelBatch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return atStage("decode", fmt.Errorf("parse pixel batch: %w", err))
+	}
+	version := schemaVersionFrom(ctx)
+	for i := range batch.Pixels {
+		applyVersionDefaults(&batch.Pixels[i], version)
+	}
+	processPixelBatch(ctx, batch.Pixels)
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
+
+// placement is a single pixel going through placementChecks. The checks fill
+// in the session and the rate-limit charge as they pass.
+type placement struct {
+	ev      messages.PixelEvent
+	session *sessionState
+	rl      rateLimitResult
+}
+
+// placementCheck returns why a placement is refused, or nil to go on
+type placementCheck func(ctx context.Context, p *placement) *rejection
+
+// placementChecks run in order and stop at the first rejection. The checks
+// that spend something (color cooldowns, then rate-limit quota) come last, so
+// a pixel refused for any other reason costs nothing.
+var placementChecks = []placementCheck{
+	checkSource,
+	checkUserID,
+	checkColor,
+	checkColorHours,
+	checkSession,
+	checkColorCooldown,
+	checkQuota,
+}
+
+func checkSource(ctx context.Context, p *placement) *rejection {
+	return verifySource(ctx, &p.ev)
+}
+
+func checkUserID(_ context.Context, p *placement) *rejection {
+	if !isValidUserID(p.ev.UserID) {
+		return newRejection(events.ReasonInvalidUser)
+	}
+	return nil
+}
+
+func checkColor(_ context.Context, p *placement) *rejection {
+	if !hexColorRegex.MatchString(p.ev.Color) {
+		return newRejection(events.ReasonInvalidColor, p.ev.Color)
+	}
+	return nil
+}
+
+func checkColorHours(ctx context.Context, p *placement) *rejection {
+	return checkTimeConstraints(p.ev.Color, getTimeConstraints(ctx), time.Now())
+}
+
+// checkSession also converts Discord coordinates to storage coordinates
+func checkSession(ctx context.Context, p *placement) *rejection {
+	session, r := validateBounds(ctx, &p.ev)
+	p.session = session
+	return r
+}
 
-	// Validate bounds
-	valid, reason := validateBounds(ctx, ev.X, ev.Y)
-	if !valid {
-		slog.Warn("pixel_validation_failed", "reason", reason, "x", ev.X, "y", ev.Y, "user_id", ev.UserID)
-		reply(reason)
+func checkColorCooldown(ctx context.Context, p *placement) *rejection {
+	retryAt := checkColorCooldowns(ctx, p.ev.UserID, []string{p.ev.Color})[0]
+	if retryAt.IsZero() {
 		return nil
 	}
+	slog.Warn("color_cooldown_active", "user_id", p.ev.UserID, "color", p.ev.Color, "retry_at", retryAt.UTC().Format(time.RFC3339))
+	return &rejection{events.ReasonColorCooldown, colorCooldownMessage(p.ev.Color, retryAt)}
+}
 
-	// Rate limit
-	allowed, count := checkRateLimit(ctx, ev.UserID)
+func checkQuota(ctx context.Context, p *placement) *rejection {
+	allowed, rl := checkRateLimit(ctx, p.ev.UserID, p.ev.Source, p.ev.X, p.ev.Y)
+	p.rl = rl
+	if rl.regionDenied(0) {
+		slog.Warn("region_rate_limit_exceeded", "user_id", p.ev.UserID, "x", p.ev.X, "y", p.ev.Y, "region_size", rl.RegionSize, "max", rl.RegionMax)
+		return &rejection{events.ReasonRateLimited, regionLimitMessage(rl)}
+	}
 	if !allowed {
-		slog.Warn("rate_limit_exceeded", "user_id", ev.UserID, "count", count, "max", rateLimitMax)
-		reply(fmt.Sprintf("Rate limit exceeded (%d/%d per minute)", count, rateLimitMax))
-		return nil
+		slog.Warn("rate_limit_exceeded", "user_id", p.ev.UserID, "count", rl.Count, "max", rl.Max, "source_limited", rl.SourceLimited)
+		return rateLimitRejection(rl)
+	}
+	return nil
+}
+
+func handlePixelPlacement(ctx context.Context, data []byte) error {
+	var ev messages.PixelEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return atStage("decode", fmt.Errorf("parse pixel event: %w", err))
+	}
+
+	if ev.Source == "" {
+		ev.Source = "web"
 	}
+	applyVersionDefaults(&ev, schemaVersionFrom(ctx))
+	ev.Username = sanitizeUsername(ev.Username)
+
+	p := &placement{ev: ev}
+	for _, check := range placementChecks {
+		if r := check(ctx, p); r != nil {
+			reject(ctx, p.ev, *r)
+			return nil
+		}
+	}
+	ev, session, rl := p.ev, p.session, p.rl
 
 	// Update pixel
-	if !updatePixel(ctx, ev.X, ev.Y, ev.Color, ev.UserID, ev.Username, ev.Source) {
-		slog.Error("pixel_placement_failed", "x", ev.X, "y", ev.Y, "user_id", ev.UserID)
-		reply("Failed to place pixel")
+	stored, err := updatePixel(ctx, ev.X, ev.Y, ev.Color, session.BlendMode, ev.UserID, ev.Username, ev.Source, ev.ExpectedUpdatedAt, ev.GuildID, ev.IsAdmin)
+	if err != nil {
+		// The pixel never landed; with RATE_LIMIT_REFUND it costs no quota
+		refundRateLimit(ctx, ev.UserID, rl, []pixelCoord{{X: ev.X, Y: ev.Y}})
+		if errors.Is(err, errPixelConflict) {
+			slog.Info("pixel_placement_conflict", "x", ev.X, "y", ev.Y, "user_id", ev.UserID, "expected_updated_at", ev.ExpectedUpdatedAt)
+			reject(ctx, ev, *newRejection(events.ReasonConflict))
+			return nil
+		}
+		if errors.Is(err, errPixelProtected) {
+			slog.Info("pixel_placement_protected", "x", ev.X, "y", ev.Y, "user_id", ev.UserID)
+			reject(ctx, ev, *newRejection(events.ReasonPixelProtected))
+			return nil
+		}
+		var cooldown *colorCooldownError
+		if errors.As(err, &cooldown) {
+			slog.Warn("color_cooldown_active", "user_id", ev.UserID, "color", ev.Color, "retry_at", cooldown.retryAt.UTC().Format(time.RFC3339))
+			reject(ctx, ev, rejection{events.ReasonColorCooldown, colorCooldownMessage(ev.Color, cooldown.retryAt)})
+			return nil
+		}
+		slog.Error("pixel_placement_failed", "x", ev.X, "y", ev.Y, "user_id", ev.UserID, "error", err.Error())
+		reject(ctx, ev, *newRejection(events.ReasonWriteFailed))
 		return nil
 	}
+	// Report the color that was actually stored (blend modes change it)
+	ev.Color = stored
 
 	slog.Info("pixel_placed", "x", ev.X, "y", ev.Y, "color", ev.Color, "user_id", ev.UserID, "source", ev.Source)
 
 	// Publish for real-time web updates
 	publishPixelUpdate(ctx, ev.X, ev.Y, ev.Color, ev.UserID, ev.Username)
 
-	successMsg := fmt.Sprintf("Pixel placed at (%d, %d) with color #%s", ev.X, ev.Y, ev.Color)
-	reply(successMsg)
+	userX, userY := session.canvasToUser(ev.X, ev.Y)
+	if ev.Source == "discord" {
+		replySuccess(ev.ApplicationID, ev.InteractionToken, formatPlacementSuccess(userX, userY, ev.Color, session.GridSnap, rl))
+	}
 
 	// Send Discord notification for web pixels
 	if ev.Source == "web" {
-		sendChannelMessage(ev.Username, fmt.Sprintf("placed a pixel at (%d, %d) with color #%s", ev.X, ev.Y, ev.Color))
+		sendChannelMessage(ev.Username, fmt.Sprintf("placed a pixel at (%d, %d) with color #%s", userX, userY, ev.Color))
 	}
 
 	// Flush traces before function exits (required for serverless)
@@ -0,0 +1,273 @@
+package pixelworker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/net/http2"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestFormatPlacementSuccess(t *testing.T) {
+	resetAt := time.Unix(1700000060, 0)
+	tests := []struct {
+		name      string
+		rl        rateLimitResult
+		showQuota bool
+		want      string
+	}{
+		{
+			name:      "remaining quota",
+			rl:        rateLimitResult{Granted: 1, Count: 13, Max: 20, ResetAt: resetAt},
+			showQuota: true,
+			want:      "Pixel placed at (10, 10) with color #FF0000 — 7/20 remaining this minute, resets <t:1700000060:R>",
+		},
+		{
+			name:      "tier limit",
+			rl:        rateLimitResult{Granted: 1, Count: 1, Max: 60, ResetAt: resetAt},
+			showQuota: true,
+			want:      "Pixel placed at (10, 10) with color #FF0000 — 59/60 remaining this minute, resets <t:1700000060:R>",
+		},
+		{
+			name:      "over the limit never shows a negative quota",
+			rl:        rateLimitResult{Granted: 1, Count: 25, Max: 20, ResetAt: resetAt},
+			showQuota: true,
+			want:      "Pixel placed at (10, 10) with color #FF0000 — 0/20 remaining this minute, resets <t:1700000060:R>",
+		},
+		{
+			name:      "unknown window state",
+			rl:        rateLimitResult{Granted: 1},
+			showQuota: true,
+			want:      "Pixel placed at (10, 10) with color #FF0000",
+		},
+		{
+			name:      "SHOW_REMAINING_BUDGET=false",
+			rl:        rateLimitResult{Granted: 1, Count: 13, Max: 20, ResetAt: resetAt},
+			showQuota: false,
+			want:      "Pixel placed at (10, 10) with color #FF0000",
+		},
+	}
+	defer func(v bool) { showRemainingBudget = v }(showRemainingBudget)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			showRemainingBudget = tt.showQuota
+			if got := formatPlacementSuccess(10, 10, "FF0000", 0, tt.rl); got != tt.want {
+				t.Fatalf("formatPlacementSuccess() = %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatPlacementSuccessGridSnap(t *testing.T) {
+	defer func(v bool) { showRemainingBudget = v }(showRemainingBudget)
+	showRemainingBudget = true
+	resetAt := time.Unix(1700000060, 0)
+	got := formatPlacementSuccess(8, 4, "FF0000", 4, rateLimitResult{Granted: 1, Count: 5, Max: 20, ResetAt: resetAt})
+	want := "Pixel placed at (8, 4) with color #FF0000 — 15/20 remaining this minute, resets <t:1700000060:R>\n" +
+		"Placed at grid cell (2, 1) covering (8, 4)–(11, 7)"
+	if got != want {
+		t.Errorf("formatPlacementSuccess() = %q\nwant %q", got, want)
+	}
+}
+
+// dialSettings is what a gRPC client announced when it connected
+type dialSettings struct {
+	streamWindow uint32
+	connWindow   uint32
+}
+
+// dialRecorder accepts connections and records the flow-control windows
+// each client announces in its HTTP/2 preface. Connections are held open
+// until close, so a client reconnecting shows up as an extra one.
+type dialRecorder struct {
+	lis   net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+	dials []dialSettings
+}
+
+func newDialRecorder(t *testing.T) *dialRecorder {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	r := &dialRecorder{lis: lis}
+	go r.serve()
+	t.Cleanup(r.close)
+	return r
+}
+
+func (r *dialRecorder) serve() {
+	for {
+		conn, err := r.lis.Accept()
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		r.conns = append(r.conns, conn)
+		r.mu.Unlock()
+		go r.read(conn)
+	}
+}
+
+func (r *dialRecorder) read(conn net.Conn) {
+	if _, err := io.ReadFull(conn, make([]byte, len(http2.ClientPreface))); err != nil {
+		return
+	}
+	fr := http2.NewFramer(conn, conn)
+	// gRPC's defaults, unless the preface says otherwise
+	d := dialSettings{streamWindow: 65535, connWindow: 65535}
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			return
+		}
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if f.IsAck() {
+				continue
+			}
+			if v, ok := f.Value(http2.SettingInitialWindowSize); ok {
+				d.streamWindow = v
+			}
+			fr.WriteSettings()
+			fr.WriteSettingsAck()
+		case *http2.WindowUpdateFrame:
+			if f.StreamID == 0 {
+				d.connWindow += f.Increment
+			}
+		case *http2.HeadersFrame:
+			// The first call is sent once the preface is done
+			r.mu.Lock()
+			r.dials = append(r.dials, d)
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+// recorded returns the connections that have sent a call so far
+func (r *dialRecorder) recorded() []dialSettings {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]dialSettings(nil), r.dials...)
+}
+
+func (r *dialRecorder) close() {
+	r.lis.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, conn := range r.conns {
+		conn.Close()
+	}
+}
+
+func TestFirestoreClientOptions(t *testing.T) {
+	t.Setenv("FIRESTORE_EMULATOR_HOST", "")
+	defer func(v int) { grpcPoolSize = v }(grpcPoolSize)
+	grpcPoolSize = 3
+
+	rec := newDialRecorder(t)
+
+	opts := append(firestoreClientOptions(),
+		option.WithEndpoint(rec.lis.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+	client, err := firestore.NewClientWithDatabase(t.Context(), "team11-local", "team11-database", opts...)
+	if err != nil {
+		t.Fatalf("Firestore client: %v", err)
+	}
+	defer client.Close()
+
+	// Calls go round the pool, so one per connection opens them all. The
+	// recorder never answers them; they are cancelled once all have arrived.
+	ctx, cancel := context.WithCancel(t.Context())
+	var wg sync.WaitGroup
+	for i := 0; i < grpcPoolSize; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Doc(fmt.Sprintf("pixels/%d_0", i)).Get(ctx)
+		}()
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(rec.recorded()) < grpcPoolSize && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Time for a call to open a connection it should not have
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	got := rec.recorded()
+	if len(got) != grpcPoolSize {
+		t.Fatalf("client opened %d connections, want %d", len(got), grpcPoolSize)
+	}
+	for i, d := range got {
+		if d.streamWindow != grpcInitialWindowSize || d.connWindow != grpcInitialConnWindowSize {
+			t.Errorf("connection %d windows = %d per stream and %d per connection, want %d and %d",
+				i, d.streamWindow, d.connWindow, grpcInitialWindowSize, grpcInitialConnWindowSize)
+		}
+	}
+}
+
+// BenchmarkConcurrentTransactions runs 50 transactions at once on one
+// connection, each reading and updating its own document so that they never
+// contend, with gRPC's default windows and with firestoreClientOptions.
+// ns/op is the time for all 50 to commit.
+func BenchmarkConcurrentTransactions(b *testing.B) {
+	requireEmulator(b)
+	const inFlight = 50
+
+	for _, bc := range []struct {
+		name string
+		opts []option.ClientOption
+	}{
+		{"default", []option.ClientOption{option.WithGRPCConnectionPool(1)}},
+		{"tuned", append(firestoreClientOptions(), option.WithGRPCConnectionPool(1))},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			client, err := firestore.NewClientWithDatabase(b.Context(), projectID, "team11-database", bc.opts...)
+			if err != nil {
+				b.Fatalf("Firestore client: %v", err)
+			}
+			defer client.Close()
+
+			for b.Loop() {
+				var wg sync.WaitGroup
+				errs := make(chan error, inFlight)
+				for i := 0; i < inFlight; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						ref := client.Doc(fmt.Sprintf("bench/%s_%d", bc.name, i))
+						errs <- client.RunTransaction(b.Context(), func(ctx context.Context, tx *firestore.Transaction) error {
+							doc, err := tx.Get(ref)
+							n := int64(0)
+							if err == nil {
+								n, _ = doc.Data()["n"].(int64)
+							}
+							return tx.Set(ref, map[string]interface{}{"n": n + 1})
+						})
+					}()
+				}
+				wg.Wait()
+				close(errs)
+				for err := range errs {
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
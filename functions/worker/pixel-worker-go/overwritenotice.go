@@ -0,0 +1,84 @@
+package pixelworker
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+// snapshotEventsTopic receives overwrite notices and role reward checks; the
+// snapshot worker sends the DMs and grants the roles (SNAPSHOT_EVENTS_TOPIC,
+// default snapshot-events)
+var snapshotEventsTopic = cmp.Or(os.Getenv("SNAPSHOT_EVENTS_TOPIC"), "snapshot-events")
+
+// overwriteTally counts, per owner who lost pixels, the pixels each other
+// user painted over
+type overwriteTally map[string]map[string]int
+
+func (t overwriteTally) add(ownerID, userID string) {
+	if t[ownerID] == nil {
+		t[ownerID] = make(map[string]int)
+	}
+	t[ownerID][userID]++
+}
+
+// wantsOverwriteNotice reports whether a user document has /notify on
+func wantsOverwriteNotice(data map[string]interface{}) bool {
+	on, _ := data["notifyOverwrites"].(bool)
+	return on
+}
+
+// publishOverwriteNotices tells the snapshot worker about the pixels lost by
+// owners who turned on /notify. Only owners in the tally are read, once per
+// batch; a failure only costs the notice, never the placement.
+func publishOverwriteNotices(ctx context.Context, tally overwriteTally, usernames map[string]string) {
+	if len(tally) == 0 {
+		return
+	}
+	refs := make([]*firestore.DocumentRef, 0, len(tally))
+	for ownerID := range tally {
+		refs = append(refs, getFirestore().Collection("users").Doc(ownerID))
+	}
+	docs, err := getFirestore().GetAll(ctx, refs)
+	if err != nil {
+		slog.Warn("overwrite_notice_lookup_failed", "owners", len(refs), "error", err.Error())
+		return
+	}
+	for _, doc := range docs {
+		if doc.Exists() && wantsOverwriteNotice(doc.Data()) {
+			publishOverwriteNotice(ctx, doc.Ref.ID, tally[doc.Ref.ID], usernames)
+		}
+	}
+}
+
+// publishOverwriteNotice publishes one owner's notice, largest counts first
+func publishOverwriteNotice(ctx context.Context, ownerID string, counts map[string]int, usernames map[string]string) {
+	notice := messages.OverwriteNotice{
+		OwnerID:   ownerID,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	for userID, n := range counts {
+		notice.Overwriters = append(notice.Overwriters, messages.Overwriter{UserID: userID, Username: usernames[userID], Count: n})
+	}
+	slices.SortFunc(notice.Overwriters, func(a, b messages.Overwriter) int {
+		return cmp.Or(b.Count-a.Count, cmp.Compare(a.UserID, b.UserID))
+	})
+
+	data, _ := json.Marshal(notice)
+	result := getPubsub().Topic(snapshotEventsTopic).Publish(ctx, &pubsub.Message{
+		Data:       data,
+		Attributes: map[string]string{"type": messages.TypeOverwriteNotice},
+	})
+	if _, err := result.Get(ctx); err != nil {
+		slog.Warn("overwrite_notice_publish_failed", "owner_id", ownerID, "error", err.Error())
+	}
+}
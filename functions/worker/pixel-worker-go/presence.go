@@ -0,0 +1,99 @@
+package pixelworker
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+const (
+	presenceTTL         = 5 * time.Second
+	presenceMinInterval = 250 * time.Millisecond
+)
+
+// presenceMessage is what viewers receive on the presence topic
+type presenceMessage struct {
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	UserID    string `json:"userId"`
+	Username  string `json:"username"`
+	Timestamp string `json:"timestamp"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// presenceThrottle bounds how often each user's presence is forwarded. It is
+// per instance, which is enough to keep a single noisy client in check.
+type presenceThrottle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     map[string]time.Time
+}
+
+func newPresenceThrottle(interval time.Duration) *presenceThrottle {
+	return &presenceThrottle{interval: interval, last: make(map[string]time.Time)}
+}
+
+func (t *presenceThrottle) allow(userID string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.last[userID]; ok && now.Sub(last) < t.interval {
+		return false
+	}
+	t.last[userID] = now
+
+	// Forget users that have been idle long enough to not matter
+	for id, ts := range t.last {
+		if now.Sub(ts) > presenceTTL {
+			delete(t.last, id)
+		}
+	}
+	return true
+}
+
+var presenceLimiter = newPresenceThrottle(presenceMinInterval)
+
+func buildPresenceMessage(ev messages.PresenceEvent, now time.Time) presenceMessage {
+	return presenceMessage{
+		X:         ev.X,
+		Y:         ev.Y,
+		UserID:    ev.UserID,
+		Username:  ev.Username,
+		Timestamp: now.UTC().Format(time.RFC3339Nano),
+		ExpiresAt: now.Add(presenceTTL).UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// forwardPresence relays a presence event to viewers without touching Firestore.
+func forwardPresence(ctx context.Context, ev messages.PresenceEvent) {
+	ctx, span := tracer.Start(ctx, "forwardPresence")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("user.id", ev.UserID),
+		attribute.Int("presence.x", ev.X),
+		attribute.Int("presence.y", ev.Y),
+	)
+
+	now := time.Now()
+	if !presenceLimiter.allow(ev.UserID, now) {
+		span.SetAttributes(attribute.Bool("presence.throttled", true))
+		return
+	}
+
+	data, _ := json.Marshal(buildPresenceMessage(ev, now))
+	result := getPubsub().Topic(presenceTopic).Publish(ctx, &pubsub.Message{
+		Data:       data,
+		Attributes: map[string]string{"type": "presence"},
+	})
+	if _, err := result.Get(ctx); err != nil {
+		slog.Warn("presence_publish_failed", "user_id", ev.UserID, "error", err.Error())
+	}
+}
@@ -0,0 +1,112 @@
+package pixelworker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+// withPresenceThrottle gives the test a fresh presence limiter
+func withPresenceThrottle(t *testing.T) {
+	t.Helper()
+	prev := presenceLimiter
+	presenceLimiter = newPresenceThrottle(presenceMinInterval)
+	t.Cleanup(func() { presenceLimiter = prev })
+}
+
+func TestBuildPresenceMessage(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg := buildPresenceMessage(messages.PresenceEvent{X: 7, Y: 9, UserID: "123456789012345678", Username: "alice", Source: "web"}, now)
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"x":         7.0,
+		"y":         9.0,
+		"userId":    "123456789012345678",
+		"username":  "alice",
+		"timestamp": "2026-01-02T03:04:05Z",
+		"expiresAt": "2026-01-02T03:04:10Z",
+	}
+	if len(got) != len(want) {
+		t.Errorf("presence message = %s, want exactly the fields %v", data, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestPresenceThrottle(t *testing.T) {
+	th := newPresenceThrottle(250 * time.Millisecond)
+	now := time.Now()
+
+	steps := []struct {
+		name   string
+		userID string
+		at     time.Duration
+		want   bool
+	}{
+		{"first update", "u1", 0, true},
+		{"too soon", "u1", 100 * time.Millisecond, false},
+		{"another user meanwhile", "u2", 100 * time.Millisecond, true},
+		{"just before the interval", "u1", 249 * time.Millisecond, false},
+		{"after the interval", "u1", 250 * time.Millisecond, true},
+	}
+	for _, s := range steps {
+		if got := th.allow(s.userID, now.Add(s.at)); got != s.want {
+			t.Errorf("%s: allow(%s, +%s) = %v, want %v", s.name, s.userID, s.at, got, s.want)
+		}
+	}
+}
+
+func TestPresenceThrottleForgetsIdleUsers(t *testing.T) {
+	th := newPresenceThrottle(time.Second)
+	now := time.Now()
+	th.allow("u1", now)
+	th.allow("u2", now.Add(presenceTTL+time.Second))
+	if _, ok := th.last["u1"]; ok {
+		t.Error("an idle user is still tracked")
+	}
+}
+
+func TestHandlePresence(t *testing.T) {
+	withPresenceThrottle(t)
+	ps := usePubsubFake(t)
+	ctx := t.Context()
+
+	valid, _ := json.Marshal(messages.PresenceEvent{X: 3, Y: 4, UserID: "123456789012345678", Username: "*alice*", Source: "web"})
+	spoofed, _ := json.Marshal(messages.PresenceEvent{X: 3, Y: 4, UserID: "nobody", Source: "web"})
+	for _, data := range [][]byte{valid, valid, spoofed} {
+		if err := handlePresence(ctx, data); err != nil {
+			t.Fatalf("handlePresence: %v", err)
+		}
+	}
+
+	got := ps.published()
+	if len(got) != 1 {
+		t.Fatalf("published %d presence messages, want 1 (one throttled, one invalid)", len(got))
+	}
+	if want := "projects/team11-local/topics/" + presenceTopic; got[0].Topic != want {
+		t.Errorf("topic = %s, want %s", got[0].Topic, want)
+	}
+	if got[0].Attributes["type"] != messages.TypePresence {
+		t.Errorf("type attribute = %q, want %q", got[0].Attributes["type"], messages.TypePresence)
+	}
+	var msg presenceMessage
+	if err := json.Unmarshal(got[0].Data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.X != 3 || msg.Y != 4 || msg.UserID != "123456789012345678" || msg.Username != "alice" {
+		t.Errorf("presence message = %+v", msg)
+	}
+}
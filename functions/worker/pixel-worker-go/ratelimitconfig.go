@@ -0,0 +1,103 @@
+package pixelworker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/team11/pixel-worker/internal/events"
+)
+
+// rateLimitConfigCacheTTL bounds how stale config/rate_limits may be on one
+// instance; changes apply within this delay.
+const rateLimitConfigCacheTTL = 30 * time.Second
+
+// rateLimitConfig is config/rate_limits. Every limit in it is off when zero
+// or missing, as is the whole document, leaving rateLimitMax per user.
+type rateLimitConfig struct {
+	Region regionLimit
+	// AggregateMax replaces rateLimitMax as the per-user limit across all
+	// sources
+	AggregateMax int
+	// SourceMax limits the pixels of each listed source ("web", "discord")
+	// on top of the aggregate limit, with a counter per source
+	SourceMax map[string]int
+}
+
+var (
+	rateLimitConfigMu     sync.Mutex
+	rateLimitConfigCached rateLimitConfig
+	rateLimitConfigAt     time.Time
+)
+
+// getRateLimitConfig reads config/rate_limits, cached per instance. Read
+// errors disable the configured limits, matching the limiter's fail-open
+// behavior.
+func getRateLimitConfig(ctx context.Context) rateLimitConfig {
+	rateLimitConfigMu.Lock()
+	defer rateLimitConfigMu.Unlock()
+	if time.Since(rateLimitConfigAt) < rateLimitConfigCacheTTL {
+		return rateLimitConfigCached
+	}
+
+	var cfg rateLimitConfig
+	if doc, err := getFirestore().Collection("config").Doc("rate_limits").Get(ctx); err == nil {
+		data := doc.Data()
+		cfg.Region = regionLimit{Size: toInt(data["regionSize"]), Max: toInt(data["regionMax"])}
+		cfg.AggregateMax = toInt(data["aggregateMax"])
+		if sources, ok := data["sourceMax"].(map[string]interface{}); ok {
+			cfg.SourceMax = make(map[string]int, len(sources))
+			for source, v := range sources {
+				if n := toInt(v); n > 0 {
+					cfg.SourceMax[source] = n
+				}
+			}
+		}
+	}
+	rateLimitConfigCached, rateLimitConfigAt = cfg, time.Now()
+	return cfg
+}
+
+// userLimit is the per-user limit across sources
+func (c rateLimitConfig) userLimit() int {
+	if c.AggregateMax > 0 {
+		return c.AggregateMax
+	}
+	return rateLimitMax
+}
+
+// sourceLimit is the limit of source alone, or 0 when it has none
+func (c rateLimitConfig) sourceLimit(source string) int {
+	return c.SourceMax[chargedSource(source)]
+}
+
+// chargedSource is the source pixels are charged to: placements without one
+// come from the web proxies
+func chargedSource(source string) string {
+	if source == "" {
+		return "web"
+	}
+	return source
+}
+
+func sourceRateLimitRef(userID, source string, window int64) *firestore.DocumentRef {
+	return getFirestore().Collection("rate_limits").Doc(fmt.Sprintf("%s_%s_%d", userID, source, window))
+}
+
+// sourceLimitMessage explains a rejection by the per-source limit, which
+// can refuse a user the aggregate limit would still let through
+func sourceLimitMessage(r rateLimitResult) string {
+	return fmt.Sprintf("Rate limit for %s placements exceeded (%d/%d per minute)", r.Source, r.Count, r.Max)
+}
+
+// rateLimitRejection is the rejection for a pixel over the per-user or the
+// per-source limit, whichever r reports
+func rateLimitRejection(r rateLimitResult) *rejection {
+	if r.SourceLimited {
+		return &rejection{events.ReasonRateLimited, sourceLimitMessage(r)}
+	}
+	return newRejection(events.ReasonRateLimited, r.Count, r.Max)
+}
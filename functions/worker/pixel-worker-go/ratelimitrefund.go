@@ -0,0 +1,124 @@
+package pixelworker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// rateLimitRefund gives quota back for charged pixels whose write failed
+// (RATE_LIMIT_REFUND=true). Off by default: a failed write then costs an
+// extra transaction on the user's window.
+var rateLimitRefund = os.Getenv("RATE_LIMIT_REFUND") == "true"
+
+// newChargeID names one rate-limit charge, so its refund can be recorded
+func newChargeID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func rateLimitRef(userID string, window int64) *firestore.DocumentRef {
+	return getFirestore().Collection("rate_limits").Doc(fmt.Sprintf("%s_%d", userID, window))
+}
+
+func regionRateLimitRef(userID, regionID string, window int64) *firestore.DocumentRef {
+	return getFirestore().Collection("rate_limits").Doc(fmt.Sprintf("%s_%s_%d", userID, regionID, window))
+}
+
+// refundRateLimit returns the quota rl charged for pixels that were never
+// written, in the window they were charged to. The refund is recorded under
+// rl.ChargeID on the window document, so a charge is refunded at most once.
+// Pixels refused before the charge were never counted and are not passed
+// here. Regional and per-source counters are refunded with it. Errors are logged and the quota stays used.
+func refundRateLimit(ctx context.Context, userID string, rl rateLimitResult, pixels []pixelCoord) {
+	// Max is zero when the limiter failed open and nothing is known to be charged
+	if !rateLimitRefund || rl.Max == 0 || rl.ChargeID == "" || len(pixels) == 0 {
+		return
+	}
+
+	ctx, span := tracer.Start(ctx, "refundRateLimit")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("user.id", userID),
+		attribute.Int("rate_limit.refunded", len(pixels)),
+	)
+
+	ref := rateLimitRef(userID, rl.Window)
+	regionCounts := make(map[string]int)
+	if rl.RegionDenied != nil {
+		region := regionLimit{Size: rl.RegionSize, Max: rl.RegionMax}
+		for _, p := range pixels {
+			regionCounts[region.regionID(p)]++
+		}
+	}
+
+	err := getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if refunds, _ := doc.Data()["refunds"].(map[string]interface{}); refunds[rl.ChargeID] != nil {
+			return nil
+		}
+
+		// All reads come before the writes
+		regionDocs := make(map[string]*firestore.DocumentSnapshot)
+		for id := range regionCounts {
+			rdoc, err := tx.Get(regionRateLimitRef(userID, id, rl.Window))
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			regionDocs[id] = rdoc
+		}
+		var sourceDoc *firestore.DocumentSnapshot
+		if rl.Source != "" {
+			sdoc, err := tx.Get(sourceRateLimitRef(userID, rl.Source, rl.Window))
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			if err == nil {
+				sourceDoc = sdoc
+			}
+		}
+
+		if err := tx.Update(ref, []firestore.Update{
+			{Path: "count", Value: max(0, toInt(doc.Data()["count"])-len(pixels))},
+			{Path: "refunds." + rl.ChargeID, Value: len(pixels)},
+		}); err != nil {
+			return err
+		}
+		for id, rdoc := range regionDocs {
+			if err := tx.Update(rdoc.Ref, []firestore.Update{
+				{Path: "count", Value: max(0, toInt(rdoc.Data()["count"])-regionCounts[id])},
+			}); err != nil {
+				return err
+			}
+		}
+		if sourceDoc != nil {
+			return tx.Update(sourceDoc.Ref, []firestore.Update{
+				{Path: "count", Value: max(0, toInt(sourceDoc.Data()["count"])-len(pixels))},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("rate_limit_refund_failed", "user_id", userID, "pixels", len(pixels), "error", err.Error())
+		return
+	}
+	slog.Info("rate_limit_refunded", "user_id", userID, "pixels", len(pixels), "window", rl.Window)
+}
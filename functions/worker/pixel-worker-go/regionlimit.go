@@ -0,0 +1,41 @@
+package pixelworker
+
+import (
+	"fmt"
+)
+
+// regionLimit is the optional anti-grief limit from config/rate_limits: at
+// most Max pixels per user in each Size×Size region per window. Max 0 (the
+// default, and a missing document) disables it.
+type regionLimit struct {
+	Size int
+	Max  int
+}
+
+func (r regionLimit) enabled() bool {
+	return r.Size > 0 && r.Max > 0
+}
+
+// pixelCoord is a storage coordinate charged against the rate limits
+type pixelCoord struct {
+	X, Y int
+}
+
+// regionID names the region containing c: "x/K_y/K"
+func (r regionLimit) regionID(c pixelCoord) string {
+	return fmt.Sprintf("%d_%d", floorDiv(c.X, r.Size), floorDiv(c.Y, r.Size))
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// regionLimitMessage explains a regional rejection, distinct from the global one
+func regionLimitMessage(r rateLimitResult) string {
+	return fmt.Sprintf("Regional limit reached: at most %d pixels per %dx%d area per minute. Try another part of the canvas.",
+		r.RegionMax, r.RegionSize, r.RegionSize)
+}
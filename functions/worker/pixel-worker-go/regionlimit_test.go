@@ -0,0 +1,115 @@
+package pixelworker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/team11/pixel-worker/internal/events"
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+func TestRegionID(t *testing.T) {
+	r := regionLimit{Size: 10, Max: 3}
+	tests := []struct {
+		c    pixelCoord
+		want string
+	}{
+		{pixelCoord{0, 0}, "0_0"},
+		{pixelCoord{9, 9}, "0_0"},
+		{pixelCoord{10, 9}, "1_0"},
+		{pixelCoord{25, 31}, "2_3"},
+		{pixelCoord{-1, 0}, "-1_0"},
+	}
+	for _, tt := range tests {
+		if got := r.regionID(tt.c); got != tt.want {
+			t.Errorf("regionID(%v) = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestRegionAndGlobalLimits(t *testing.T) {
+	requireEmulator(t)
+	ctx := t.Context()
+	seedDoc(t, "config/rate_limits", map[string]interface{}{"regionSize": 10, "regionMax": 3})
+	window := awaitFreshWindow(t)
+
+	// Five pixels in one region: the regional limit refuses the last two,
+	// which are not charged globally
+	var sameRegion []pixelCoord
+	for i := range 5 {
+		sameRegion = append(sameRegion, pixelCoord{X: i, Y: 0})
+	}
+	res := checkRateLimitN(ctx, "u1", "discord", sameRegion)
+	if res.Granted != 3 || res.Count != 3 {
+		t.Fatalf("granted %d, count %d; want 3 and 3", res.Granted, res.Count)
+	}
+	for i := range sameRegion {
+		if want := i >= 3; res.regionDenied(i) != want {
+			t.Errorf("pixel %d regionDenied = %v, want %v", i, res.regionDenied(i), want)
+		}
+	}
+
+	// Pixels in fresh regions use up the rest of the global limit
+	var spread []pixelCoord
+	for i := range rateLimitMax {
+		spread = append(spread, pixelCoord{X: 100 + 10*i, Y: 100})
+	}
+	res = checkRateLimitN(ctx, "u1", "discord", spread)
+	if res.Granted != rateLimitMax-3 || res.Count != rateLimitMax {
+		t.Fatalf("granted %d, count %d; want %d and %d", res.Granted, res.Count, rateLimitMax-3, rateLimitMax)
+	}
+	for i := range spread {
+		if res.regionDenied(i) {
+			t.Errorf("pixel %d in a fresh region was refused regionally", i)
+		}
+	}
+
+	if got := toInt(readDoc(t, fmt.Sprintf("rate_limits/u1_%d", window))["count"]); got != rateLimitMax {
+		t.Errorf("global counter = %d, want %d", got, rateLimitMax)
+	}
+	if got := toInt(readDoc(t, fmt.Sprintf("rate_limits/u1_0_0_%d", window))["count"]); got != 3 {
+		t.Errorf("region 0_0 counter = %d, want 3", got)
+	}
+
+	// Each limit explains itself
+	tests := []struct {
+		name string
+		x, y int
+		want string
+	}{
+		{"full region", 1, 1, "Regional limit reached"},
+		{"fresh region over the global limit", 500, 500, "Rate limit exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &placement{ev: messages.PixelEvent{UserID: "u1", Source: "discord", X: tt.x, Y: tt.y}}
+			rej := checkQuota(ctx, p)
+			if rej == nil || rej.Reason != events.ReasonRateLimited {
+				t.Fatalf("checkQuota() = %+v, want a rate-limit rejection", rej)
+			}
+			if !strings.Contains(rej.Message, tt.want) {
+				t.Errorf("message = %q, want it to contain %q", rej.Message, tt.want)
+			}
+		})
+	}
+
+	// Another user's regional counter is their own
+	if res := checkRateLimitN(ctx, "u2", "discord", sameRegion[:3]); res.Granted != 3 {
+		t.Errorf("u2 granted %d in region 0_0, want 3", res.Granted)
+	}
+}
+
+func TestRegionLimitOffByDefault(t *testing.T) {
+	requireEmulator(t)
+	awaitFreshWindow(t)
+
+	var sameRegion []pixelCoord
+	for i := range 10 {
+		sameRegion = append(sameRegion, pixelCoord{X: i, Y: 0})
+	}
+	res := checkRateLimitN(t.Context(), "u1", "discord", sameRegion)
+	if res.Granted != 10 || res.RegionDenied != nil {
+		t.Errorf("granted %d, RegionDenied %v; want 10 and nil without config", res.Granted, res.RegionDenied)
+	}
+}
@@ -0,0 +1,92 @@
+package pixelworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/team11/pixel-worker/internal/events"
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+// rejection is why a placement was refused: a code for web clients and the
+// message shown to the user.
+type rejection struct {
+	Reason  events.RejectReason
+	Message string
+}
+
+// rejectionMessages holds the fixed user-facing text of each reason, as fmt
+// templates filled in by newRejection. Rejections whose text is built from
+// their own state (zones, schedules, regional limits, color cooldowns, time
+// constraints) set Message directly.
+var rejectionMessages = map[events.RejectReason]string{
+	events.ReasonInvalidUser:    "Invalid user ID",
+	events.ReasonUnverified:     "This placement claims to come from Discord but carries no valid interaction",
+	events.ReasonInvalidColor:   "Invalid color format: %s. Use 6-digit hex (e.g., FF0000)",
+	events.ReasonNoSession:      "No active session",
+	events.ReasonSessionClosed:  "Session is %s",
+	events.ReasonOutOfBounds:    "Coordinates out of bounds (0-%d, 0-%d)",
+	events.ReasonRateLimited:    "Rate limit exceeded (%d/%d per minute)",
+	events.ReasonConflict:       "Someone else drew here first. Refresh the canvas and try again.",
+	events.ReasonPixelProtected: "This pixel was placed by an admin and is protected.",
+	events.ReasonWriteFailed:    "Failed to place pixel",
+}
+
+// newRejection builds a rejection with the reason's message from
+// rejectionMessages
+func newRejection(reason events.RejectReason, args ...interface{}) *rejection {
+	return &rejection{reason, fmt.Sprintf(rejectionMessages[reason], args...)}
+}
+
+// recordRejection makes a refusal countable by reason: a span event and a
+// pixel_rejected log line, which backs the pixel_rejections log-based metric.
+func recordRejection(ctx context.Context, ev messages.PixelEvent, r rejection) {
+	trace.SpanFromContext(ctx).AddEvent("pixel_rejected", trace.WithAttributes(
+		attribute.String("pixel.reject_reason", string(r.Reason)),
+		attribute.Int("pixel.x", ev.X),
+		attribute.Int("pixel.y", ev.Y),
+	))
+	slog.Warn("pixel_rejected",
+		"reason", string(r.Reason),
+		"detail", r.Message,
+		"x", ev.X,
+		"y", ev.Y,
+		"user_id", ev.UserID,
+		"source", ev.Source,
+	)
+}
+
+// reject records why a single placement was refused and tells the placer:
+// Discord users get a follow-up, web users a pixel_rejected event on the
+// public topic.
+func reject(ctx context.Context, ev messages.PixelEvent, r rejection) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("pixel.reject_reason", string(r.Reason)))
+	recordRejection(ctx, ev, r)
+	if ev.Source == "discord" {
+		sendFollowUp(ev.ApplicationID, ev.InteractionToken, r.Message)
+		return
+	}
+	publishRejection(ctx, ev, r)
+}
+
+func publishRejection(ctx context.Context, ev messages.PixelEvent, r rejection) {
+	data, _ := json.Marshal(events.PixelRejected{
+		RequestID: ev.RequestID,
+		UserID:    ev.UserID,
+		X:         ev.X,
+		Y:         ev.Y,
+		Reason:    r.Reason,
+		Message:   r.Message,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	attrs := map[string]string{"type": events.TypePixelRejected}
+	if err := fanOutPublish(ctx, []string{publicPixelTopic}, data, attrs); err != nil {
+		slog.Warn("pixel_rejection_publish_failed", "reason", string(r.Reason), "user_id", ev.UserID, "error", err.Error())
+	}
+}
@@ -0,0 +1,100 @@
+package pixelworker
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/team11/pixel-worker/internal/events"
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+// placePixel runs a placement through handlePixelPlacement as published
+func placePixel(t *testing.T, ev messages.PixelEvent) {
+	t.Helper()
+	data, _ := json.Marshal(ev)
+	if err := handlePixelPlacement(t.Context(), data); err != nil {
+		t.Fatalf("handlePixelPlacement: %v", err)
+	}
+}
+
+func TestWebRejectionPublished(t *testing.T) {
+	tests := []struct {
+		name   string
+		ev     messages.PixelEvent
+		reason events.RejectReason
+	}{
+		{"invalid color", messages.PixelEvent{UserID: "123456789012345678", X: 1, Y: 1, Color: "nope", Source: "web", RequestID: "req-1"}, events.ReasonInvalidColor},
+		{"invalid user", messages.PixelEvent{UserID: "", X: 1, Y: 1, Color: "FF0000", Source: "web", RequestID: "req-2"}, events.ReasonInvalidUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := usePubsubFake(t)
+			placePixel(t, tt.ev)
+
+			got := ps.publishedOfType(events.TypePixelRejected)
+			if len(got) != 1 {
+				t.Fatalf("published %d rejections, want 1", len(got))
+			}
+			if want := "projects/team11-local/topics/" + publicPixelTopic; got[0].Topic != want {
+				t.Errorf("topic = %s, want %s", got[0].Topic, want)
+			}
+			if n := len(ps.publishedOfType(events.TypePixelUpdate)); n != 0 {
+				t.Errorf("published %d pixel updates for a rejected placement", n)
+			}
+			var rej events.PixelRejected
+			if err := json.Unmarshal(got[0].Data, &rej); err != nil {
+				t.Fatal(err)
+			}
+			if rej.RequestID != tt.ev.RequestID || rej.Reason != tt.reason || rej.Message == "" {
+				t.Errorf("rejection = %+v, want request %s, reason %s and a message", rej, tt.ev.RequestID, tt.reason)
+			}
+		})
+	}
+}
+
+func TestDiscordRejectionNotPublished(t *testing.T) {
+	ps := usePubsubFake(t)
+	placePixel(t, messages.PixelEvent{UserID: "123456789012345678", X: 1, Y: 1, Color: "nope", Source: "discord",
+		ApplicationID: "app", InteractionToken: "token"})
+
+	if n := len(ps.published()); n != 0 {
+		t.Errorf("published %d messages for a Discord rejection, want 0 (it is a follow-up)", n)
+	}
+}
+
+func TestWebRejectionWritesNoPixel(t *testing.T) {
+	requireEmulator(t)
+	seedSession(t, 10, 10, nil)
+	window := awaitFreshWindow(t)
+	seedDoc(t, fmt.Sprintf("rate_limits/123456789012345678_%d", window), map[string]interface{}{"count": rateLimitMax})
+
+	tests := []struct {
+		name   string
+		user   string
+		x, y   int
+		reason events.RejectReason
+	}{
+		{"out of bounds", "223456789012345678", 50, 0, events.ReasonOutOfBounds},
+		{"rate limited", "123456789012345678", 3, 3, events.ReasonRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := usePubsubFake(t)
+			placePixel(t, messages.PixelEvent{UserID: tt.user, X: tt.x, Y: tt.y, Color: "FF0000", Source: "web", RequestID: "req"})
+
+			got := ps.publishedOfType(events.TypePixelRejected)
+			if len(got) != 1 {
+				t.Fatalf("published %d rejections, want 1", len(got))
+			}
+			var rej events.PixelRejected
+			json.Unmarshal(got[0].Data, &rej)
+			if rej.Reason != tt.reason {
+				t.Errorf("reason = %s, want %s", rej.Reason, tt.reason)
+			}
+			if doc := readDoc(t, fmt.Sprintf("pixels/%d_%d", tt.x, tt.y)); doc != nil {
+				t.Errorf("pixel written for a rejected placement: %v", doc)
+			}
+		})
+	}
+}
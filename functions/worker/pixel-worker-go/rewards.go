@@ -0,0 +1,119 @@
+package pixelworker
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+// rewardsCacheTTL bounds how long a change to config/rewards takes to reach
+// a warm instance
+const rewardsCacheTTL = 5 * time.Minute
+
+var (
+	rewardsMu               sync.Mutex
+	rewardThresholdsCached  []int
+	rewardThresholdsFetched time.Time
+)
+
+// getRewardThresholds returns the pixel counts that earn a role, the keys of
+// config/rewards.roles, in ascending order and cached per instance. A missing
+// document means no rewards; a failed read keeps the previous list.
+func getRewardThresholds(ctx context.Context) []int {
+	rewardsMu.Lock()
+	defer rewardsMu.Unlock()
+	if time.Since(rewardThresholdsFetched) < rewardsCacheTTL {
+		return rewardThresholdsCached
+	}
+	rewardThresholdsFetched = time.Now()
+
+	doc, err := getFirestore().Collection("config").Doc("rewards").Get(ctx)
+	if err != nil {
+		if doc == nil || doc.Exists() {
+			slog.Warn("rewards_config_fetch_failed", "error", err.Error())
+			return rewardThresholdsCached
+		}
+		rewardThresholdsCached = nil
+		return nil
+	}
+	roles, _ := doc.Data()["roles"].(map[string]interface{})
+	thresholds := make([]int, 0, len(roles))
+	for count := range roles {
+		if n, err := strconv.Atoi(count); err == nil && n > 0 {
+			thresholds = append(thresholds, n)
+		}
+	}
+	slices.Sort(thresholds)
+	rewardThresholdsCached = thresholds
+	return thresholds
+}
+
+// crossesReward reports whether a pixelCount going from before to after
+// reaches a threshold it had not reached yet
+func crossesReward(thresholds []int, before, after int) bool {
+	for _, t := range thresholds {
+		if before < t && t <= after {
+			return true
+		}
+	}
+	return false
+}
+
+// publishRoleRewardChecks asks the snapshot worker to grant the roles of
+// users whose count crossed a threshold; counts are the new totals and added
+// the pixels that got them there. Nil counts are read from the users'
+// documents, which must hold the committed totals. Like overwrite notices, a
+// failure only costs the reward until the next crossing, never the
+// placement.
+func publishRoleRewardChecks(ctx context.Context, counts, added map[string]int) {
+	thresholds := getRewardThresholds(ctx)
+	if len(thresholds) == 0 {
+		return
+	}
+	if counts == nil {
+		counts = readPixelCounts(ctx, added)
+	}
+	for userID, count := range counts {
+		if !crossesReward(thresholds, count-added[userID], count) {
+			continue
+		}
+		data, _ := json.Marshal(messages.RoleRewardCheck{UserID: userID, PixelCount: count})
+		result := getPubsub().Topic(snapshotEventsTopic).Publish(ctx, &pubsub.Message{
+			Data:       data,
+			Attributes: map[string]string{"type": messages.TypeRoleReward},
+		})
+		if _, err := result.Get(ctx); err != nil {
+			slog.Warn("role_reward_publish_failed", "user_id", userID, "error", err.Error())
+		}
+	}
+}
+
+// readPixelCounts returns the pixelCount of the users in added; users whose
+// document cannot be read are left out
+func readPixelCounts(ctx context.Context, added map[string]int) map[string]int {
+	refs := make([]*firestore.DocumentRef, 0, len(added))
+	for userID := range added {
+		refs = append(refs, getFirestore().Collection("users").Doc(userID))
+	}
+	docs, err := getFirestore().GetAll(ctx, refs)
+	if err != nil {
+		slog.Warn("role_reward_count_read_failed", "users", len(refs), "error", err.Error())
+		return nil
+	}
+	counts := make(map[string]int, len(docs))
+	for _, doc := range docs {
+		if doc.Exists() {
+			counts[doc.Ref.ID] = toInt(doc.Data()["pixelCount"])
+		}
+	}
+	return counts
+}
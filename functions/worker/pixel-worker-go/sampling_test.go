@@ -0,0 +1,79 @@
+package pixelworker
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/team11/pixel-worker/internal/messages"
+	"github.com/team11/pixel-worker/internal/sampling"
+)
+
+// useTraceRecorder traces the rest of the test with nothing sampled unless
+// forced, and returns the spans that were
+func useTraceRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sampling.New(0)), sdktrace.WithSpanProcessor(sr))
+	prev := tracer
+	tracer = tp.Tracer("pixel-worker")
+	t.Cleanup(func() { tracer = prev })
+	return sr
+}
+
+// The debugTrace attribute the proxy sets forces the worker's spans too,
+// joining the proxy's trace when it was passed on
+func TestDebugTraceAttributeForcesSampling(t *testing.T) {
+	const traceID, spanID = "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331"
+	tests := []struct {
+		name   string
+		attrs  map[string]string
+		forced bool
+	}{
+		{"forced", map[string]string{sampling.Attribute: "true"}, true},
+		{"forced, joining the proxy's trace", map[string]string{sampling.Attribute: "true", "traceId": traceID, "spanId": spanID}, true},
+		{"not forced", map[string]string{}, false},
+		{"not forced, other value", map[string]string{sampling.Attribute: "yes"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := useTraceRecorder(t)
+			usePubsubFake(t)
+			tt.attrs["type"] = messages.TypePixelPlacement
+			// An invalid color is refused before any Firestore read
+			ev := messages.PixelEvent{UserID: "123456789012345678", X: 1, Y: 1, Color: "nope", Source: "web", RequestID: "r1"}
+			if err := handleCloudEvent(t.Context(), pixelEventMessage(t, ev, tt.attrs)); err != nil {
+				t.Fatalf("handleCloudEvent: %v", err)
+			}
+
+			spans := sr.Ended()
+			if !tt.forced {
+				if len(spans) != 0 {
+					t.Errorf("sampled %d spans at ratio 0 without debugTrace", len(spans))
+				}
+				return
+			}
+			var handled sdktrace.ReadOnlySpan
+			for _, s := range spans {
+				if s.Name() == "pixel_worker.handle_event" {
+					handled = s
+				}
+			}
+			if handled == nil {
+				t.Fatalf("pixel_worker.handle_event was not sampled (%d spans)", len(spans))
+			}
+			tagged := false
+			for _, a := range handled.Attributes() {
+				tagged = tagged || a == attribute.Bool(sampling.SpanAttribute, true)
+			}
+			if !tagged {
+				t.Errorf("span not tagged %s", sampling.SpanAttribute)
+			}
+			if id := tt.attrs["traceId"]; id != "" && handled.SpanContext().TraceID().String() != id {
+				t.Errorf("trace = %s, want the proxy's %s", handled.SpanContext().TraceID(), id)
+			}
+		})
+	}
+}
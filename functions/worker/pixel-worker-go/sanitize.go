@@ -0,0 +1,51 @@
+package pixelworker
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// maxUsernameLength is Discord's username limit, in characters
+const maxUsernameLength = 32
+
+var (
+	snowflakeRegex = regexp.MustCompile(`^\d{17,19}$`)
+
+	// Mentions that would ping or link when echoed into a Discord message
+	usernameMentionPatterns = []string{"@everyone", "@here", "<@", "<#"}
+)
+
+// sanitizeUsername makes a client-supplied username safe to store and to echo
+// into Discord messages: control characters, markdown and mentions are
+// removed and the result is capped at Discord's length limit.
+func sanitizeUsername(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune("*_~`", r) {
+			return -1
+		}
+		return r
+	}, s)
+
+	// Repeat until stable so removals cannot splice a new mention together
+	for {
+		prev := s
+		for _, p := range usernameMentionPatterns {
+			s = strings.ReplaceAll(s, p, "")
+		}
+		if s == prev {
+			break
+		}
+	}
+
+	s = strings.TrimSpace(s)
+	if r := []rune(s); len(r) > maxUsernameLength {
+		s = strings.TrimSpace(string(r[:maxUsernameLength]))
+	}
+	return s
+}
+
+// isValidUserID reports whether id looks like a Discord snowflake
+func isValidUserID(id string) bool {
+	return snowflakeRegex.MatchString(id)
+}
@@ -0,0 +1,86 @@
+package pixelworker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeUsername(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "alice", "alice"},
+		{"everyone mention", "\n@everyone", ""},
+		{"here mention", "hi @here", "hi"},
+		{"user mention", "<@123456789012345678>", "123456789012345678>"},
+		{"role mention", "<@&42>", "&42>"},
+		{"channel link", "<#123456789012345678>", "123456789012345678>"},
+		{"bold", "**alice**", "alice"},
+		{"italic and underline", "_al_ice__", "alice"},
+		{"strikethrough", "~~alice~~", "alice"},
+		{"code", "`alice`", "alice"},
+		{"code block", "```alice```", "alice"},
+		{"newlines and tabs", "ali\nce\t\r", "alice"},
+		{"mention spliced by markdown", "@*everyone", ""},
+		{"mention spliced by a mention", "@ever@hereyone", ""},
+		{"mention spliced by a control character", "<\n@1>", "1>"},
+		{"non-ASCII kept", "Zoë 🎨", "Zoë 🎨"},
+		{"empty", "", ""},
+		{"surrounding spaces", "  alice  ", "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeUsername(tt.in); got != tt.want {
+				t.Errorf("sanitizeUsername(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeUsernameLength(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"at the limit", strings.Repeat("a", 32), strings.Repeat("a", 32)},
+		{"over the limit", strings.Repeat("a", 40), strings.Repeat("a", 32)},
+		{"counted in characters", strings.Repeat("é", 40), strings.Repeat("é", 32)},
+		{"counted after stripping", "**" + strings.Repeat("a", 32) + "**", strings.Repeat("a", 32)},
+		{"no trailing space after truncating", strings.Repeat("a", 31) + " bcd", strings.Repeat("a", 31)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeUsername(tt.in); got != tt.want {
+				t.Errorf("sanitizeUsername(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsValidUserID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"12345678901234567", true},
+		{"123456789012345678", true},
+		{"1234567890123456789", true},
+		{"1234567890123456", false},
+		{"12345678901234567890", false},
+		{"", false},
+		{"12345678901234567a", false},
+		{"<@123456789012345678>", false},
+		{"123456789012345678\n", false},
+		{" 123456789012345678", false},
+		{"-12345678901234567", false},
+		{"１２３４５６７８９０１２３４５６７８", false},
+	}
+	for _, tt := range tests {
+		if got := isValidUserID(tt.id); got != tt.want {
+			t.Errorf("isValidUserID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
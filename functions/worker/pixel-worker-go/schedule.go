@@ -0,0 +1,29 @@
+package pixelworker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/team11/pixel-worker/internal/pixelrules"
+)
+
+// defaultClosedMessage is shown after closesAt when the session has no closedMessage
+const defaultClosedMessage = "The canvas is closed."
+
+// checkSchedule rejects placements outside the session's opensAt/closesAt
+// window. This is separate from pausing: a scheduled session stays "active"
+// and simply gates on server time. Replies use Discord timestamp markup so
+// each reader sees the time in their own timezone.
+func (s *sessionState) checkSchedule(now time.Time) (bool, string) {
+	if pixelrules.NotOpenYet(s.OpensAt, now) {
+		unix := s.OpensAt.Unix()
+		return false, fmt.Sprintf("The canvas opens at <t:%d:F> (<t:%d:R>)", unix, unix)
+	}
+	if pixelrules.Closed(s.ClosesAt, now) {
+		if s.ClosedMessage != "" {
+			return false, s.ClosedMessage
+		}
+		return false, defaultClosedMessage
+	}
+	return true, ""
+}
@@ -0,0 +1,41 @@
+package pixelworker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckSchedule(t *testing.T) {
+	opens := time.Date(2026, 6, 1, 18, 0, 0, 0, time.UTC)
+	closes := time.Date(2026, 6, 1, 22, 0, 0, 0, time.UTC)
+	session := parseSessionState(map[string]interface{}{
+		"status":   "active",
+		"opensAt":  opens.Format(time.RFC3339),
+		"closesAt": closes,
+	})
+
+	tests := []struct {
+		name    string
+		session *sessionState
+		now     time.Time
+		wantOK  bool
+		wantMsg string
+	}{
+		{"before opening", session, opens.Add(-time.Second), false, "The canvas opens at <t:1780336800:F> (<t:1780336800:R>)"},
+		{"at opening", session, opens, true, ""},
+		{"during", session, opens.Add(2 * time.Hour), true, ""},
+		{"at closing", session, closes, false, defaultClosedMessage},
+		{"after closing", session, closes.Add(time.Hour), false, defaultClosedMessage},
+		{"custom closed message", &sessionState{ClosesAt: closes, ClosedMessage: "See you next week!"}, closes, false, "See you next week!"},
+		{"no schedule", &sessionState{}, opens, true, ""},
+		{"only a closing time", &sessionState{ClosesAt: closes}, opens.Add(-24 * time.Hour), true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, msg := tt.session.checkSchedule(tt.now)
+			if ok != tt.wantOK || msg != tt.wantMsg {
+				t.Fatalf("checkSchedule() = (%v, %q), want (%v, %q)", ok, msg, tt.wantOK, tt.wantMsg)
+			}
+		})
+	}
+}
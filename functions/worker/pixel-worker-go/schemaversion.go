@@ -0,0 +1,49 @@
+package pixelworker
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+type schemaVersionKey struct{}
+
+// pixelSchemaVersion reads the PixelEvent schema version of a message;
+// missing or malformed means version 1
+func pixelSchemaVersion(attrs map[string]string) int {
+	v, err := strconv.Atoi(attrs[messages.SchemaVersionAttribute])
+	if err != nil || v < 1 {
+		return 1
+	}
+	return v
+}
+
+// withSchemaVersion hands the message's schema version to its handler
+func withSchemaVersion(ctx context.Context, version int) context.Context {
+	if version > messages.PixelEventSchemaVersion {
+		// Published by a newer proxy than this worker: its extra fields are
+		// ignored and the rest is handled as the current version
+		slog.Warn("pixel_schema_version_unknown", "version", version, "supported", messages.PixelEventSchemaVersion)
+	}
+	return context.WithValue(ctx, schemaVersionKey{}, version)
+}
+
+func schemaVersionFrom(ctx context.Context) int {
+	if v, ok := ctx.Value(schemaVersionKey{}).(int); ok {
+		return v
+	}
+	return 1
+}
+
+// applyVersionDefaults fills in what a placement of an older schema version
+// could not say. Version 1 proxies never set IsAdmin or GuildID, so values
+// found there did not come from them and are dropped: a version 1 placement
+// is never admin-placed and leaves the user's guild alone.
+func applyVersionDefaults(ev *messages.PixelEvent, version int) {
+	if version < 2 {
+		ev.IsAdmin = false
+		ev.GuildID = ""
+	}
+}
@@ -0,0 +1,114 @@
+package pixelworker
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+func TestPixelSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs map[string]string
+		want  int
+	}{
+		{"missing", map[string]string{"type": "pixel_placement"}, 1},
+		{"no attributes", nil, 1},
+		{"v1", map[string]string{messages.SchemaVersionAttribute: "1"}, 1},
+		{"v2", map[string]string{messages.SchemaVersionAttribute: "2"}, 2},
+		{"newer", map[string]string{messages.SchemaVersionAttribute: "7"}, 7},
+		{"malformed", map[string]string{messages.SchemaVersionAttribute: "two"}, 1},
+		{"zero", map[string]string{messages.SchemaVersionAttribute: "0"}, 1},
+	}
+	for _, tt := range tests {
+		if got := pixelSchemaVersion(tt.attrs); got != tt.want {
+			t.Errorf("%s: pixelSchemaVersion() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyVersionDefaults(t *testing.T) {
+	for _, version := range []int{1, 2, 3} {
+		ev := messages.PixelEvent{UserID: "123456789012345678", IsAdmin: true, GuildID: "g1", Color: "FF0000"}
+		applyVersionDefaults(&ev, version)
+		keeps := version >= 2
+		if ev.IsAdmin != keeps || (ev.GuildID == "g1") != keeps {
+			t.Errorf("v%d: placement = %+v, want isAdmin and guildId kept: %v", version, ev, keeps)
+		}
+		if ev.UserID != "123456789012345678" || ev.Color != "FF0000" {
+			t.Errorf("v%d: other fields changed: %+v", version, ev)
+		}
+	}
+}
+
+func TestSchemaVersionFromContext(t *testing.T) {
+	if got := schemaVersionFrom(t.Context()); got != 1 {
+		t.Errorf("schemaVersionFrom() without a version = %d, want 1", got)
+	}
+	if got := schemaVersionFrom(withSchemaVersion(t.Context(), 2)); got != 2 {
+		t.Errorf("schemaVersionFrom() = %d, want 2", got)
+	}
+}
+
+// pixelEventMessage wraps a placement in the CloudEvent Pub/Sub delivers
+func pixelEventMessage(t *testing.T, ev messages.PixelEvent, attrs map[string]string) event.Event {
+	t.Helper()
+	var msg messages.MessagePublishedData
+	msg.Message.Data, _ = json.Marshal(ev)
+	msg.Message.Attributes = attrs
+	msg.Message.MessageID = "m-" + ev.RequestID
+	e := event.New()
+	e.SetID(msg.Message.MessageID)
+	e.SetType("google.cloud.pubsub.topic.v1.messagePublished")
+	e.SetSource("//pubsub.googleapis.com/projects/team11-local/topics/pixel-events")
+	if err := e.SetData(event.ApplicationJSON, msg); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestPixelSchemaVersions(t *testing.T) {
+	requireEmulator(t)
+	usePubsubFake(t)
+	seedSession(t, 10, 10, nil)
+
+	tests := []struct {
+		name      string
+		userID    string
+		x         int
+		version   string
+		wantAdmin bool
+		wantGuild interface{}
+	}{
+		{"v1 from an old proxy", "100000000000000001", 1, "", false, nil},
+		{"v2", "100000000000000002", 2, "2", true, "g1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := map[string]string{"type": messages.TypePixelPlacement, "source": "web"}
+			if tt.version != "" {
+				attrs[messages.SchemaVersionAttribute] = tt.version
+			}
+			ev := messages.PixelEvent{X: tt.x, Y: 1, Color: "FF0000", UserID: tt.userID, Username: "alice", Source: "web",
+				IsAdmin: true, GuildID: "g1", RequestID: tt.name}
+			if err := handleCloudEvent(t.Context(), pixelEventMessage(t, ev, attrs)); err != nil {
+				t.Fatalf("handleCloudEvent: %v", err)
+			}
+
+			pixel := readDoc(t, fmt.Sprintf("pixels/%d_1", tt.x))
+			if pixel == nil {
+				t.Fatal("the placement wrote no pixel")
+			}
+			if pixel["adminPlaced"] != tt.wantAdmin {
+				t.Errorf("adminPlaced = %v, want %v", pixel["adminPlaced"], tt.wantAdmin)
+			}
+			if got := readDoc(t, "users/"+tt.userID)["guildId"]; got != tt.wantGuild {
+				t.Errorf("guildId = %v, want %v", got, tt.wantGuild)
+			}
+		})
+	}
+}
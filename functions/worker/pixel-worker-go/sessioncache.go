@@ -0,0 +1,101 @@
+package pixelworker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// sessionCacheTTL bounds how stale the session may be while the watch
+	// is down; while it is up, the cache is replaced on every change
+	sessionCacheTTL = 30 * time.Second
+	// sessionWatchRetry is the pause before the watch is reopened
+	sessionWatchRetry = 5 * time.Second
+)
+
+// errNoSession means sessions/current does not exist
+var errNoSession = errors.New("no session")
+
+// cachedSession is sessions/current as last read; state is nil when the
+// document does not exist
+type cachedSession struct {
+	state *sessionState
+	at    time.Time
+}
+
+var (
+	sessionCache atomic.Pointer[cachedSession]
+	// sessionWatchLive is set while the watch delivers snapshots, which
+	// makes the cache current whatever its age
+	sessionWatchLive atomic.Bool
+)
+
+// getSessionState returns the current session without a Firestore read on
+// the placement path: from the cache kept up to date by watchSession, or,
+// when the watch is down and the cache older than sessionCacheTTL, from a
+// fresh read that refills the cache.
+func getSessionState(ctx context.Context) (*sessionState, error) {
+	if c := sessionCache.Load(); c != nil && (sessionWatchLive.Load() || time.Since(c.at) < sessionCacheTTL) {
+		return c.sessionState()
+	}
+
+	doc, err := getFirestore().Collection("sessions").Doc("current").Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		c := &cachedSession{at: time.Now()}
+		sessionCache.Store(c)
+		return c.sessionState()
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &cachedSession{state: parseSessionState(doc.Data()), at: time.Now()}
+	sessionCache.Store(c)
+	return c.sessionState()
+}
+
+func (c *cachedSession) sessionState() (*sessionState, error) {
+	if c.state == nil {
+		return nil, errNoSession
+	}
+	return c.state, nil
+}
+
+// watchSession listens to sessions/current and replaces the cache on every
+// change until ctx is done. A broken listener is reopened after
+// sessionWatchRetry; meanwhile getSessionState falls back to reads.
+func watchSession(ctx context.Context) {
+	ref := getFirestore().Collection("sessions").Doc("current")
+	for {
+		it := ref.Snapshots(ctx)
+		for {
+			snap, err := it.Next()
+			if err != nil {
+				sessionWatchLive.Store(false)
+				it.Stop()
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Warn("session_watch_failed", "error", err.Error())
+				break
+			}
+			c := &cachedSession{at: time.Now()}
+			if snap.Exists() {
+				c.state = parseSessionState(snap.Data())
+			}
+			sessionCache.Store(c)
+			sessionWatchLive.Store(true)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sessionWatchRetry):
+		}
+	}
+}
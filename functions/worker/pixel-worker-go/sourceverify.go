@@ -0,0 +1,72 @@
+package pixelworker
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/team11/pixel-worker/internal/events"
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+// sourceUnverified replaces "discord" on placements that cannot have come
+// from an interaction
+const sourceUnverified = "unverified"
+
+// discordApplicationID is the bot's application ID (DISCORD_APPLICATION_ID).
+// When set, Discord placements must name it; when unset, any non-empty
+// application ID passes.
+var discordApplicationID = strings.TrimSpace(os.Getenv("DISCORD_APPLICATION_ID"))
+
+// discordSourceProblem returns why a placement claiming source "discord"
+// lacks the interaction the proxy always attaches, or "" when it has one.
+// Events published before applicationId was included have none either, and
+// are refused like any other.
+func discordSourceProblem(ev messages.PixelEvent) string {
+	switch {
+	case ev.ApplicationID == "":
+		return "missing_application_id"
+	case ev.InteractionToken == "":
+		return "missing_interaction_token"
+	case discordApplicationID != "" && ev.ApplicationID != discordApplicationID:
+		return "application_id_mismatch"
+	}
+	return ""
+}
+
+// verifySource guards against anything with publish access to pixel-events
+// posing as a Discord user, admins included. A "discord" placement without
+// a valid interaction becomes "unverified": its admin flag, guild and
+// interaction are dropped, so nothing is trusted or replied to through
+// them. Web placements carry no credential the worker could check instead,
+// so the placement is then refused. Each reclassification is logged as
+// pixel_source_reclassified, which backs the pixel_source_reclassifications
+// metric.
+func verifySource(ctx context.Context, ev *messages.PixelEvent) *rejection {
+	if ev.Source != "discord" {
+		return nil
+	}
+	problem := discordSourceProblem(*ev)
+	if problem == "" {
+		return nil
+	}
+	slog.Warn("pixel_source_reclassified",
+		"reason", problem,
+		"user_id", ev.UserID,
+		"claimed_admin", ev.IsAdmin,
+		"application_id", ev.ApplicationID,
+	)
+	trace.SpanFromContext(ctx).AddEvent("pixel_source_reclassified", trace.WithAttributes(
+		attribute.String("reason", problem),
+	))
+	ev.Source = sourceUnverified
+	ev.IsAdmin = false
+	ev.GuildID = ""
+	ev.InteractionToken = ""
+	ev.ApplicationID = ""
+	return newRejection(events.ReasonUnverified)
+}
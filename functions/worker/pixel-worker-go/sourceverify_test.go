@@ -0,0 +1,94 @@
+package pixelworker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/team11/pixel-worker/internal/events"
+	"github.com/team11/pixel-worker/internal/messages"
+)
+
+func TestDiscordSourceProblem(t *testing.T) {
+	defer func(v string) { discordApplicationID = v }(discordApplicationID)
+	discordApplicationID = "app"
+
+	tests := []struct {
+		name string
+		ev   messages.PixelEvent
+		want string
+	}{
+		{"from the proxy", messages.PixelEvent{ApplicationID: "app", InteractionToken: "tok"}, ""},
+		{"legacy, no application", messages.PixelEvent{InteractionToken: "tok"}, "missing_application_id"},
+		{"no interaction", messages.PixelEvent{ApplicationID: "app"}, "missing_interaction_token"},
+		{"another application", messages.PixelEvent{ApplicationID: "other", InteractionToken: "tok"}, "application_id_mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := discordSourceProblem(tt.ev); got != tt.want {
+				t.Errorf("discordSourceProblem() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySource(t *testing.T) {
+	ev := messages.PixelEvent{UserID: "123456789012345678", Source: "discord", IsAdmin: true, GuildID: "g1"}
+	if r := verifySource(t.Context(), &ev); r == nil || r.Reason != events.ReasonUnverified {
+		t.Fatalf("verifySource() = %+v, want an %s rejection", r, events.ReasonUnverified)
+	}
+	if ev.Source != sourceUnverified || ev.IsAdmin || ev.GuildID != "" {
+		t.Errorf("spoofed event kept its claims: %+v", ev)
+	}
+
+	web := messages.PixelEvent{UserID: "123456789012345678", Source: "web"}
+	if r := verifySource(t.Context(), &web); r != nil || web.Source != "web" {
+		t.Errorf("verifySource(web) = %+v, source %s, want it untouched", r, web.Source)
+	}
+}
+
+// A placement that only claims to be from Discord is refused in public
+// before any check, and never reaches the canvas.
+func TestSpoofedDiscordPlacementRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   messages.PixelEvent
+	}{
+		{"spoofed admin", messages.PixelEvent{UserID: "123456789012345678", X: 1, Y: 1, Color: "FF0000",
+			Source: "discord", IsAdmin: true, RequestID: "req-1"}},
+		{"legacy, no application", messages.PixelEvent{UserID: "123456789012345678", X: 1, Y: 1, Color: "FF0000",
+			Source: "discord", InteractionToken: "tok", RequestID: "req-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := usePubsubFake(t)
+			placePixel(t, tt.ev)
+
+			got := ps.publishedOfType(events.TypePixelRejected)
+			if len(got) != 1 {
+				t.Fatalf("published %d rejections, want 1", len(got))
+			}
+			if n := len(ps.publishedOfType(events.TypePixelUpdate)); n != 0 {
+				t.Errorf("published %d pixel updates for a spoofed placement", n)
+			}
+			var rej events.PixelRejected
+			if err := json.Unmarshal(got[0].Data, &rej); err != nil {
+				t.Fatal(err)
+			}
+			if rej.RequestID != tt.ev.RequestID || rej.Reason != events.ReasonUnverified {
+				t.Errorf("rejection = %+v, want request %s and reason %s", rej, tt.ev.RequestID, events.ReasonUnverified)
+			}
+		})
+	}
+}
+
+// A genuine Discord placement passes verification: its invalid color is
+// answered as a follow-up, not refused in public as unverified.
+func TestDiscordPlacementVerified(t *testing.T) {
+	ps := usePubsubFake(t)
+	placePixel(t, messages.PixelEvent{UserID: "123456789012345678", X: 1, Y: 1, Color: "nope", Source: "discord",
+		ApplicationID: "app", InteractionToken: "tok"})
+
+	if n := len(ps.published()); n != 0 {
+		t.Errorf("published %d messages for a verified Discord placement, want 0", n)
+	}
+}
@@ -0,0 +1,79 @@
+package pixelworker
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// streakDayLayout names a UTC calendar day in users.lastActiveDay
+const streakDayLayout = "2006-01-02"
+
+// userStreak is the drawing streak kept on the user document: consecutive
+// UTC days with at least one placed pixel.
+type userStreak struct {
+	LastActiveDay string
+	Days          int
+	Best          int
+}
+
+func streakFromUser(data map[string]interface{}) userStreak {
+	lastDay, _ := data["lastActiveDay"].(string)
+	return userStreak{
+		LastActiveDay: lastDay,
+		Days:          toInt(data["streakDays"]),
+		Best:          toInt(data["bestStreakDays"]),
+	}
+}
+
+// advance returns the streak after a placement at t. A second placement on
+// the same day changes nothing, one on the next day extends the streak and
+// anything later starts a new one. A placement dated before the last active
+// day (clock skew between instances) is ignored.
+func (s userStreak) advance(t time.Time) userStreak {
+	day := t.UTC().Format(streakDayLayout)
+	last, err := time.Parse(streakDayLayout, s.LastActiveDay)
+	switch {
+	case err != nil || s.Days == 0:
+		s.Days = 1
+	case day <= s.LastActiveDay:
+		return s
+	case day == last.AddDate(0, 0, 1).Format(streakDayLayout):
+		s.Days++
+	default:
+		s.Days = 1
+	}
+	s.LastActiveDay = day
+	s.Best = max(s.Best, s.Days)
+	return s
+}
+
+func (s userStreak) updates() []firestore.Update {
+	return []firestore.Update{
+		{Path: "lastActiveDay", Value: s.LastActiveDay},
+		{Path: "streakDays", Value: s.Days},
+		{Path: "bestStreakDays", Value: s.Best},
+	}
+}
+
+// readUsers loads the user documents of the users of a batch, for their
+// streaks and display names. Users that are missing or unreadable are left
+// out, so they start from an empty streak.
+func readUsers(ctx context.Context, userIDs []string) map[string]map[string]interface{} {
+	users := make(map[string]map[string]interface{}, len(userIDs))
+	refs := make([]*firestore.DocumentRef, len(userIDs))
+	for i, id := range userIDs {
+		refs[i] = getFirestore().Collection("users").Doc(id)
+	}
+	docs, err := getFirestore().GetAll(ctx, refs)
+	if err != nil {
+		return users
+	}
+	for i, doc := range docs {
+		if doc.Exists() {
+			users[userIDs[i]] = doc.Data()
+		}
+	}
+	return users
+}
@@ -0,0 +1,95 @@
+package pixelworker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/team11/pixel-worker/internal/events"
+	"github.com/team11/pixel-worker/internal/pixelrules"
+)
+
+// timeConstraintsCacheTTL bounds how long a change to time_constraints takes
+// to reach a warm instance
+const timeConstraintsCacheTTL = 5 * time.Minute
+
+var (
+	timeConstraintsMu     sync.Mutex
+	timeConstraintsCached []pixelrules.TimeConstraint
+	timeConstraintsAt     time.Time
+)
+
+// getTimeConstraints returns the time_constraints documents, cached per
+// instance. Like zones, a failed read keeps the previous list (or none), and
+// a document whose pattern does not compile is skipped.
+func getTimeConstraints(ctx context.Context) []pixelrules.TimeConstraint {
+	timeConstraintsMu.Lock()
+	defer timeConstraintsMu.Unlock()
+	if time.Since(timeConstraintsAt) < timeConstraintsCacheTTL {
+		return timeConstraintsCached
+	}
+
+	docs, err := getFirestore().Collection("time_constraints").Documents(ctx).GetAll()
+	if err != nil {
+		slog.Warn("time_constraints_fetch_failed", "error", err.Error())
+		timeConstraintsAt = time.Now()
+		return timeConstraintsCached
+	}
+	constraints := make([]pixelrules.TimeConstraint, 0, len(docs))
+	for _, doc := range docs {
+		var c pixelrules.TimeConstraint
+		if err := doc.DataTo(&c); err != nil {
+			continue
+		}
+		if err := c.Compile(); err != nil {
+			slog.Warn("time_constraint_invalid", "id", doc.Ref.ID, "error", err.Error())
+			continue
+		}
+		constraints = append(constraints, c)
+	}
+	timeConstraintsCached, timeConstraintsAt = constraints, time.Now()
+	return constraints
+}
+
+// checkTimeConstraints refuses color at now when a constraint matches it and
+// the UTC hour is not one of its allowed hours. A constraint without hours
+// keeps its colors off the canvas entirely.
+func checkTimeConstraints(color string, constraints []pixelrules.TimeConstraint, now time.Time) *rejection {
+	for _, c := range constraints {
+		if !c.Restricts(color, now) {
+			continue
+		}
+		message := c.Message
+		if message == "" {
+			message = fmt.Sprintf("#%s can only be placed %s.", strings.ToUpper(color), describeHours(c.AllowedHours))
+		}
+		return &rejection{events.ReasonColorRestricted, message}
+	}
+	return nil
+}
+
+// describeHours lists UTC hours as ranges: "between 12:00 and 13:59 UTC"
+// for [12 13]
+func describeHours(hours []int) string {
+	if len(hours) == 0 {
+		return "at no time right now"
+	}
+	sorted := slices.Clone(hours)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	var ranges []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+		ranges = append(ranges, fmt.Sprintf("%02d:00 and %02d:59", sorted[i], sorted[j]))
+		i = j + 1
+	}
+	return "between " + strings.Join(ranges, ", or ") + " UTC"
+}
@@ -0,0 +1,136 @@
+package pixelworker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/team11/pixel-worker/internal/snowflake"
+)
+
+// walEnabled announces every pixel write in wal_entries before making it
+// (PIXEL_WAL=true), so wal-recovery can finish writes whose function died
+// midway. Off by default: it adds two document writes per pixel.
+var walEnabled = os.Getenv("PIXEL_WAL") == "true"
+
+// walEntryTTL sets expiresAt, meant for a TTL policy like failure_context's
+const walEntryTTL = 7 * 24 * time.Hour
+
+// WAL entry statuses. wal-recovery settles entries left pending.
+const (
+	walPending   = "pending"
+	walCommitted = "committed"
+	walAborted   = "aborted"
+)
+
+// walEntry is one wal_entries document. CreatedAt is also the updatedAt the
+// pixel is written with, so recovery can tell whether this write landed.
+// A replay runs the write's own checks again: ExpectedUpdatedAt for
+// conflicts, AdminPlaced for protected pixels, UserID and Color for
+// same-color cooldowns unless Imported, and Color blended onto the pixel
+// with BlendMode. Batches blend a cell's pixels in order, so they
+// record the blended color with pixelrules.BlendReplace.
+type walEntry struct {
+	X                 int       `firestore:"x"`
+	Y                 int       `firestore:"y"`
+	Color             string    `firestore:"color"`
+	BlendMode         string    `firestore:"blendMode"`
+	ExpectedUpdatedAt string    `firestore:"expectedUpdatedAt,omitempty"`
+	UserID            string    `firestore:"userId"`
+	Username          string    `firestore:"username"`
+	Source            string    `firestore:"source"`
+	AdminPlaced       bool      `firestore:"adminPlaced"`
+	Imported          bool      `firestore:"imported,omitempty"`
+	Status            string    `firestore:"status"`
+	CreatedAt         time.Time `firestore:"createdAt"`
+	ExpiresAt         time.Time `firestore:"expiresAt"`
+}
+
+// writeWAL stores entries as pending and returns their references, in order.
+// Nothing is written and nil is returned when the WAL is off. A pixel must
+// not be written without its entry, so when any entry fails the others are
+// marked aborted and the error is returned for the placement to fail.
+func writeWAL(ctx context.Context, entries []walEntry) ([]*firestore.DocumentRef, error) {
+	if !walEnabled || len(entries) == 0 {
+		return nil, nil
+	}
+	bw := getFirestore().BulkWriter(ctx)
+	refs := make([]*firestore.DocumentRef, len(entries))
+	jobs := make([]*firestore.BulkWriterJob, len(entries))
+	var errs []error
+	for i, e := range entries {
+		e.Status = walPending
+		e.ExpiresAt = e.CreatedAt.Add(walEntryTTL)
+		refs[i] = getFirestore().Collection("wal_entries").Doc(snowflake.Generate())
+		job, err := bw.Create(refs[i], e)
+		if err != nil {
+			errs = append(errs, err)
+			refs[i] = nil
+			continue
+		}
+		jobs[i] = job
+	}
+	bw.End()
+
+	for i, job := range jobs {
+		if job == nil {
+			continue
+		}
+		if _, err := job.Results(); err != nil {
+			errs = append(errs, err)
+			refs[i] = nil
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		slog.Error("wal_write_failed", "entries", len(entries), "failed", len(errs), "error", err.Error())
+		settleWAL(ctx, refs, walAborted)
+		return nil, err
+	}
+	return refs, nil
+}
+
+// walSettled is the update that takes an entry out of pending
+func walSettled(status string) []firestore.Update {
+	return []firestore.Update{
+		{Path: "status", Value: status},
+		{Path: "settledAt", Value: time.Now().UTC()},
+	}
+}
+
+// settleWAL marks entries committed or aborted once their pixel writes are
+// done. An entry that cannot be marked stays pending and is logged:
+// wal-recovery later settles it, replaying it only if the checks the write
+// went through still pass.
+func settleWAL(ctx context.Context, refs []*firestore.DocumentRef, status string) {
+	if len(refs) == 0 {
+		return
+	}
+	bw := getFirestore().BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	var errs []error
+	for _, ref := range refs {
+		if ref == nil {
+			continue
+		}
+		job, err := bw.Update(ref, walSettled(status))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		slog.Error("wal_settle_failed", "status", status, "failed", len(errs), "error", err.Error())
+	}
+}
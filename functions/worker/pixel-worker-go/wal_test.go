@@ -0,0 +1,54 @@
+package pixelworker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/team11/pixel-worker/internal/pixelrules"
+)
+
+// walStatuses returns the status of every wal_entries document
+func walStatuses(t *testing.T) []string {
+	t.Helper()
+	docs, err := getFirestore().Collection("wal_entries").Documents(context.Background()).GetAll()
+	if err != nil {
+		t.Fatalf("read wal_entries: %v", err)
+	}
+	var statuses []string
+	for _, doc := range docs {
+		s, _ := doc.Data()["status"].(string)
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+func TestUpdatePixelSettlesWAL(t *testing.T) {
+	requireEmulator(t)
+	defer func(v bool) { walEnabled = v }(walEnabled)
+	walEnabled = true
+	ctx := t.Context()
+
+	if _, err := updatePixel(ctx, 4, 4, "FF0000", pixelrules.BlendReplace, "u1", "one", "web", "", "", false); err != nil {
+		t.Fatalf("placement: %v", err)
+	}
+	if got := walStatuses(t); len(got) != 1 || got[0] != walCommitted {
+		t.Fatalf("wal statuses after a placement = %v, want [%s]", got, walCommitted)
+	}
+
+	stale := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	if _, err := updatePixel(ctx, 4, 4, "0000FF", pixelrules.BlendReplace, "u2", "two", "web", stale, "", false); !errors.Is(err, errPixelConflict) {
+		t.Fatalf("stale placement error = %v, want errPixelConflict", err)
+	}
+	got := walStatuses(t)
+	aborted := 0
+	for _, s := range got {
+		if s == walAborted {
+			aborted++
+		}
+	}
+	if len(got) != 2 || aborted != 1 {
+		t.Errorf("wal statuses after a conflict = %v, want one committed and one aborted", got)
+	}
+}
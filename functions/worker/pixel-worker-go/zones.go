@@ -0,0 +1,74 @@
+package pixelworker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// zonesCacheTTL bounds how long a /zone change takes to reach a warm
+// instance; zones change rarely, placements constantly.
+const zonesCacheTTL = 30 * time.Second
+
+// zone is an admin-managed rectangle from the zones collection. Bounds are
+// storage (top-left) coordinates, inclusive.
+type zone struct {
+	Label        string   `firestore:"label"`
+	MinX         int      `firestore:"minX"`
+	MinY         int      `firestore:"minY"`
+	MaxX         int      `firestore:"maxX"`
+	MaxY         int      `firestore:"maxY"`
+	Locked       bool     `firestore:"locked"`
+	AllowedUsers []string `firestore:"allowedUsers"`
+}
+
+func (z zone) contains(x, y int) bool {
+	return x >= z.MinX && x <= z.MaxX && y >= z.MinY && y <= z.MaxY
+}
+
+var (
+	zonesMu     sync.Mutex
+	zonesCached []zone
+	zonesAt     time.Time
+)
+
+// getZones returns the zones, cached per instance. A failed read keeps the
+// previous list (or none), so an outage never blocks drawing.
+func getZones(ctx context.Context) []zone {
+	zonesMu.Lock()
+	defer zonesMu.Unlock()
+	if time.Since(zonesAt) < zonesCacheTTL {
+		return zonesCached
+	}
+
+	docs, err := getFirestore().Collection("zones").Documents(ctx).GetAll()
+	if err != nil {
+		slog.Warn("zones_fetch_failed", "error", err.Error())
+		zonesAt = time.Now()
+		return zonesCached
+	}
+	zones := make([]zone, 0, len(docs))
+	for _, doc := range docs {
+		var z zone
+		if err := doc.DataTo(&z); err != nil {
+			continue
+		}
+		zones = append(zones, z)
+	}
+	zonesCached, zonesAt = zones, time.Now()
+	return zones
+}
+
+// checkZones rejects a placement inside any locked zone the user is not
+// allowlisted for. Overlapping zones are locked if any of them is.
+func checkZones(zones []zone, x, y int, userID string) (bool, string) {
+	for _, z := range zones {
+		if z.Locked && z.contains(x, y) && !slices.Contains(z.AllowedUsers, userID) {
+			return false, fmt.Sprintf("This area is protected (zone %q)", z.Label)
+		}
+	}
+	return true, ""
+}
@@ -2,10 +2,12 @@
  * Session Worker Function
  *
  * Pub/Sub-triggered function that:
- * 1. Manages canvas sessions (start, pause, reset)
+ * 1. Manages canvas sessions (start, pause, reset, stop, schedule) and zones
  * 2. Updates session state in Firestore
  * 3. Handles canvas resets
  * 4. Sends Discord follow-up messages
+ * 5. Runs admin data-repair migrations (pixelCount backfill, /verify)
+ * 6. Records admin actions in the audit_log collection
  */
 
 // Initialize tracing before other imports
@@ -29,15 +31,77 @@ function logJson(severity, message, fields = {}) {
 }
 
 const functions = require('@google-cloud/functions-framework');
-const { Firestore } = require('@google-cloud/firestore');
+const { Firestore, FieldPath, FieldValue, Timestamp } = require('@google-cloud/firestore');
+const { PubSub } = require('@google-cloud/pubsub');
 
 const PROJECT_ID = process.env.PROJECT_ID;
 const DISCORD_BOT_TOKEN = process.env.DISCORD_BOT_TOKEN;
+const SNAPSHOT_EVENTS_TOPIC = process.env.SNAPSHOT_EVENTS_TOPIC || 'snapshot-events';
+const SESSION_EVENTS_TOPIC = process.env.SESSION_EVENTS_TOPIC || 'session-events';
+
+// Backfill works in pages and hands off to a fresh invocation before the
+// function timeout (120s) so large canvases never run out of time. A page's
+// per-user tallies commit in one batch with the cursor, so a page holds
+// fewer pixels than the 500 writes of a batch.
+const BACKFILL_PAGE_SIZE = 400;
+const BACKFILL_WRITE_BATCH = 400;
+const BACKFILL_TIME_BUDGET_MS = 90 * 1000;
+
+// A running migration whose progress is older than this lost its
+// continuation (crash, or a retry that gave up) and may be started again
+const MIGRATION_STALE_MS = 10 * 60 * 1000;
+
+// /session stop copies the pixels into the session's history document in
+// pages of this size, under the same time budget as the backfill
+const ARCHIVE_PAGE_SIZE = 500;
+
+// /verify looks up the latest history entry of every pixel on a page in
+// parallel, so its pages are smaller than the backfill's
+const VERIFY_PAGE_SIZE = 200;
+
+// gRPC status of a write whose lastUpdateTime precondition failed
+const GRPC_FAILED_PRECONDITION = 9;
+// Returned for count aggregations by backends without them
+const GRPC_UNIMPLEMENTED = 12;
+
+// /session import reads files written by the snapshot worker's
+// /session export, up to this version
+const EXPORT_FORMAT = 'team11-session-export';
+const EXPORT_VERSION = 1;
+const IMPORT_WRITE_BATCH = 500;
+
+// /canvas status reuses the pixel count cached in stats/overview for this
+// long; counting the pixels collection costs one read per 1000 pixels
+const OVERVIEW_MAX_AGE_MS = 30 * 1000;
 
 const firestore = new Firestore({ projectId: PROJECT_ID, databaseId: 'team11-database' });
+const pubsub = new PubSub({ projectId: PROJECT_ID });
 
 const DISCORD_API_ENDPOINT = 'https://discord.com/api/v10';
 
+// Read-only actions that are not audited
+const UNAUDITED_ACTIONS = new Set(['status', 'grid']);
+
+/**
+ * Append an entry to audit_log. Best effort: a failure is logged and never
+ * fails the audited action. Entries are never updated or deleted.
+ */
+async function writeAuditEntry({ actorId, actorName, action, target, params, traceId }) {
+  try {
+    await firestore.collection('audit_log').add({
+      actorId: actorId || '',
+      actorName: actorName || '',
+      action,
+      target: target || '',
+      params: params || {},
+      timestamp: new Date().toISOString().replace(/\.\d{3}Z$/, 'Z'),
+      traceId: traceId || '',
+    });
+  } catch (error) {
+    logJson('WARNING', 'audit_log_write_failed', { action, actor_id: actorId, error: error.message });
+  }
+}
+
 /**
  * Send follow-up message to Discord
  */
@@ -68,6 +132,49 @@ async function sendDiscordFollowUp(applicationId, token, content) {
   }
 }
 
+/**
+ * Session statuses each status may change to. A missing sessions/current is
+ * "inactive"; /session end archives the session and makes it inactive again
+ * from any status. "clearing" is set by the snapshot worker during
+ * /canvas view:clear, and /session resume is the way out of a clear that
+ * keeps failing. "importing" is held by /session import, which only starts
+ * on a session nobody can draw on.
+ */
+const SESSION_TRANSITIONS = {
+  inactive: ['active', 'importing'],
+  active: ['paused', 'stopped'],
+  paused: ['active', 'stopped', 'importing'],
+  stopped: [],
+  clearing: ['active'],
+  importing: ['active'],
+};
+
+/**
+ * Check that the session may go from one status to another.
+ * Returns null when allowed, otherwise an Error describing why not.
+ */
+function validateTransition(from, to) {
+  if ((SESSION_TRANSITIONS[from] || []).includes(to)) {
+    return null;
+  }
+  if (from === to) {
+    return new Error(`the session is already ${from}`);
+  }
+  return new Error(`the session is ${from} and cannot become ${to}`);
+}
+
+function sessionStatus(sessionDoc) {
+  return sessionDoc.exists ? sessionDoc.data().status || 'inactive' : 'inactive';
+}
+
+/**
+ * Result for a command refused by validateTransition. It is not retried:
+ * Pub/Sub redelivery would be refused the same way.
+ */
+function transitionRejected(verb, error) {
+  return { success: false, rejected: true, message: `⚠️ Cannot ${verb}: ${error.message}.` };
+}
+
 /**
  * Start a new session
  */
@@ -77,17 +184,36 @@ async function startSession(metadata) {
 
     const canvasWidth = metadata.canvasWidth || 100;
     const canvasHeight = metadata.canvasHeight || 100;
+    const origin = ORIGINS.includes(metadata.origin) ? metadata.origin : 'top-left';
+    // The pixel worker snaps placements to cells of gridSnap pixels
+    const gridSnap = Number.isInteger(metadata.gridSnap) && metadata.gridSnap > 1 ? metadata.gridSnap : 0;
 
-    await sessionRef.set({
-      status: 'active',
-      startedAt: new Date().toISOString(),
-      canvasWidth: canvasWidth,
-      canvasHeight: canvasHeight,
-      createdBy: metadata.userId,
-      createdByUsername: metadata.username
+    const invalid = await firestore.runTransaction(async (tx) => {
+      const invalid = validateTransition(sessionStatus(await tx.get(sessionRef)), 'active');
+      if (invalid) return invalid;
+
+      const session = {
+        status: 'active',
+        startedAt: new Date().toISOString(),
+        canvasWidth: canvasWidth,
+        canvasHeight: canvasHeight,
+        origin: origin,
+        createdBy: metadata.userId,
+        createdByUsername: metadata.username
+      };
+      // Validated against SNAPSHOTS_BUCKET_ALLOWLIST by the snapshot worker
+      if (metadata.snapshotsBucket) session.snapshotsBucket = metadata.snapshotsBucket;
+      if (gridSnap) session.gridSnap = gridSnap;
+      tx.set(sessionRef, session);
+      return null;
     });
+    if (invalid) {
+      return transitionRejected('start the session', invalid);
+    }
 
-    return { success: true, message: `✅ Session started successfully (${canvasWidth}x${canvasHeight})` };
+    const corner = origin === 'bottom-left' ? ', (0, 0) at the bottom left' : '';
+    const grid = gridSnap ? `, ${gridSnap}x${gridSnap} grid` : '';
+    return { success: true, message: `✅ Session started successfully (${canvasWidth}x${canvasHeight}${corner}${grid})` };
   } catch (error) {
     return { success: false, message: `❌ Failed to start session: ${error.message}` };
   }
@@ -100,10 +226,19 @@ async function pauseSession() {
   try {
     const sessionRef = firestore.collection('sessions').doc('current');
 
-    await sessionRef.update({
-      status: 'paused',
-      pausedAt: new Date().toISOString()
+    const invalid = await firestore.runTransaction(async (tx) => {
+      const invalid = validateTransition(sessionStatus(await tx.get(sessionRef)), 'paused');
+      if (invalid) return invalid;
+
+      tx.update(sessionRef, {
+        status: 'paused',
+        pausedAt: new Date().toISOString()
+      });
+      return null;
     });
+    if (invalid) {
+      return transitionRejected('pause the session', invalid);
+    }
 
     return { success: true, message: '⏸️ Session paused' };
   } catch (error) {
@@ -118,10 +253,19 @@ async function resumeSession() {
   try {
     const sessionRef = firestore.collection('sessions').doc('current');
 
-    await sessionRef.update({
-      status: 'active',
-      resumedAt: new Date().toISOString()
+    const invalid = await firestore.runTransaction(async (tx) => {
+      const invalid = validateTransition(sessionStatus(await tx.get(sessionRef)), 'active');
+      if (invalid) return invalid;
+
+      tx.update(sessionRef, {
+        status: 'active',
+        resumedAt: new Date().toISOString()
+      });
+      return null;
     });
+    if (invalid) {
+      return transitionRejected('resume the session', invalid);
+    }
 
     return { success: true, message: '▶️ Session resumed' };
   } catch (error) {
@@ -129,6 +273,858 @@ async function resumeSession() {
   }
 }
 
+/**
+ * Set the opening window of the current session.
+ * Times arrive as UTC RFC 3339 strings; an empty string clears that bound.
+ * The pixel worker rejects placements outside the window.
+ */
+async function scheduleSession(metadata) {
+  try {
+    const sessionRef = firestore.collection('sessions').doc('current');
+    const sessionDoc = await sessionRef.get();
+
+    if (!sessionDoc.exists) {
+      return { success: false, message: '❌ No session to schedule' };
+    }
+
+    const session = sessionDoc.data();
+    const updates = {};
+    for (const field of ['opensAt', 'closesAt', 'closedMessage']) {
+      if (metadata[field] === undefined) continue;
+      updates[field] = metadata[field] === '' ? FieldValue.delete() : metadata[field];
+      session[field] = metadata[field];
+    }
+    await sessionRef.update(updates);
+
+    const describe = (iso) => (iso ? `<t:${Math.floor(Date.parse(iso) / 1000)}:F>` : 'not set');
+    return {
+      success: true,
+      message: `🗓️ Canvas schedule updated\nOpens: ${describe(session.opensAt)}\nCloses: ${describe(session.closesAt)}`
+    };
+  } catch (error) {
+    return { success: false, message: `❌ Failed to schedule session: ${error.message}` };
+  }
+}
+
+/**
+ * Copy pixel pages into sessions/history_{id}/pixels until the deadline,
+ * saving the cursor and per-user tally in the history document after each
+ * page. Returns true once every pixel has been copied.
+ */
+async function archivePixelPages(historyRef, state, deadline) {
+  const pixelsRef = firestore.collection('pixels');
+
+  while (Date.now() < deadline) {
+    let query = pixelsRef.orderBy(FieldPath.documentId()).limit(ARCHIVE_PAGE_SIZE);
+    if (state.archiveCursor) {
+      query = query.startAfter(state.archiveCursor);
+    }
+    const snapshot = await query.get();
+
+    if (snapshot.empty) {
+      return true;
+    }
+
+    const writer = firestore.bulkWriter();
+    snapshot.docs.forEach(doc => {
+      writer.set(historyRef.collection('pixels').doc(doc.id), doc.data());
+    });
+    await writer.close();
+
+    tallyPixelPage(state.archiveCounts, snapshot.docs);
+    state.pixelsArchived += snapshot.size;
+    state.archiveCursor = snapshot.docs[snapshot.docs.length - 1].id;
+    await historyRef.update({
+      archiveCursor: state.archiveCursor,
+      archiveCounts: state.archiveCounts,
+      pixelsArchived: state.pixelsArchived
+    });
+
+    if (snapshot.size < ARCHIVE_PAGE_SIZE) {
+      return true;
+    }
+  }
+  return false;
+}
+
+/**
+ * Stop the session in three steps. The stop marks the session pendingStop,
+ * which the pixel worker refuses, and creates sessions/history_{id} from it.
+ * The pixels are then copied there in time-bounded passes that hand off to a
+ * fresh invocation, like the backfill. Once the copy is complete the history
+ * document gets its final stats and the snapshot worker takes the final
+ * snapshot, which is what marks the session stopped. With purge, the live
+ * pixels are deleted after that snapshot, but only when the archive holds as
+ * many pixels as the canvas.
+ */
+async function stopSession(metadata) {
+  const deadline = Date.now() + BACKFILL_TIME_BUDGET_MS;
+  const sessionRef = firestore.collection('sessions').doc('current');
+
+  try {
+    let historyRef;
+    if (!metadata.continuation) {
+      const sessionId = String(Date.now());
+      historyRef = firestore.collection('sessions').doc(`history_${sessionId}`);
+
+      const invalid = await firestore.runTransaction(async (tx) => {
+        const sessionDoc = await tx.get(sessionRef);
+        const invalid = validateTransition(sessionStatus(sessionDoc), 'stopped');
+        if (invalid) return invalid;
+        if (sessionDoc.data().pendingStop) {
+          return new Error('the session is already stopping');
+        }
+
+        const stop = {
+          pendingStop: true,
+          stopRequestedAt: new Date().toISOString(),
+          stopRequestedBy: metadata.userId,
+          archiveId: sessionId,
+          purgeOnStop: Boolean(metadata.purge)
+        };
+        tx.update(sessionRef, stop);
+        tx.set(historyRef, {
+          ...sessionDoc.data(),
+          ...stop,
+          status: 'stopped',
+          archiveStatus: 'running',
+          archiveCursor: null,
+          archiveCounts: {},
+          pixelsArchived: 0
+        });
+        return null;
+      });
+      if (invalid) {
+        return transitionRejected('stop the session', invalid);
+      }
+    } else {
+      // A continuation belongs to the stop still pending on the session
+      const sessionDoc = await sessionRef.get();
+      const archiveId = sessionDoc.exists && sessionDoc.data().pendingStop && sessionDoc.data().archiveId;
+      if (!archiveId) {
+        return { success: true, message: null };
+      }
+      historyRef = firestore.collection('sessions').doc(`history_${archiveId}`);
+    }
+
+    const state = (await historyRef.get()).data();
+    if (state.archiveStatus === 'running') {
+      const done = await archivePixelPages(historyRef, state, deadline);
+      if (!done) {
+        await pubsub.topic(SESSION_EVENTS_TOPIC).publishMessage({
+          json: { ...metadata.message, continuation: true },
+          attributes: { type: 'session_command' }
+        });
+        logJson('INFO', 'session_archive_continued', { archive_id: state.archiveId, archived: state.pixelsArchived });
+        if (metadata.continuation) {
+          return { success: true, message: null };
+        }
+        return {
+          success: true,
+          message: `⏹️ Stopping session: archiving pixels (${state.pixelsArchived} copied so far). The final snapshot follows once the archive is complete.`
+        };
+      }
+
+      // Placements are refused while the stop is pending, so the counts only
+      // differ when the copy itself went wrong
+      const [live, archived] = await Promise.all([
+        firestore.collection('pixels').count().get(),
+        historyRef.collection('pixels').count().get()
+      ]);
+      state.pixelCount = archived.data().count;
+      state.archiveVerified = state.pixelCount === live.data().count;
+
+      await historyRef.update({
+        archiveStatus: 'completed',
+        archivedAt: new Date().toISOString(),
+        archiveVerified: state.archiveVerified,
+        pixelCount: state.pixelCount,
+        contributors: Object.keys(state.archiveCounts).length,
+        archiveCursor: FieldValue.delete(),
+        archiveCounts: FieldValue.delete()
+      });
+      if (state.purgeOnStop && state.archiveVerified) {
+        await sessionRef.update({ purgeArchive: state.archiveId });
+      }
+      logJson('INFO', 'session_archived', {
+        archive_id: state.archiveId,
+        pixels: state.pixelCount,
+        live_pixels: live.data().count,
+        verified: state.archiveVerified
+      });
+    }
+
+    await pubsub.topic(SNAPSHOT_EVENTS_TOPIC).publishMessage({
+      json: {
+        channelId: metadata.channelId,
+        userId: metadata.userId,
+        username: metadata.username,
+        timestamp: new Date().toISOString()
+      },
+      attributes: { type: 'snapshot_request', reason: 'session_stop', ...metadata.traceAttributes }
+    });
+
+    let purge = '';
+    if (state.purgeOnStop) {
+      purge = state.archiveVerified
+        ? ' The live pixels are deleted after the final snapshot.'
+        : ' The archive does not match the canvas, so the live pixels are kept.';
+    }
+    return {
+      success: true,
+      message: `⏹️ Stopping session: ${state.pixelCount} pixels archived to sessions/history_${state.archiveId}, generating final snapshot...${purge}`
+    };
+  } catch (error) {
+    return { success: false, message: `❌ Failed to stop session: ${error.message}` };
+  }
+}
+
+/**
+ * Whether a migration document describes a run still in progress. A run
+ * without progress for MIGRATION_STALE_MS is not: its continuation is gone.
+ */
+function migrationRunning(state) {
+  if (!state || state.status !== 'running') {
+    return false;
+  }
+  const updatedAt = Date.parse(state.updatedAt || state.startedAt);
+  return Date.now() - updatedAt <= MIGRATION_STALE_MS;
+}
+
+/**
+ * Record that a migration stopped on an error so the next command starts it
+ * again instead of answering that it is still running.
+ */
+async function markMigrationFailed(migrationRef, error, logMessage) {
+  logJson('ERROR', logMessage, { error: error.message });
+  try {
+    await migrationRef.update({ status: 'failed', error: error.message, failedAt: new Date().toISOString() });
+  } catch (updateError) {
+    logJson('ERROR', 'migration_status_update_failed', { migration: migrationRef.id, error: updateError.message });
+  }
+}
+
+/**
+ * Count pixels per user on one page of the pixels collection.
+ */
+function tallyPixelPage(counts, docs) {
+  docs.forEach(doc => {
+    const userId = doc.get('userId');
+    if (userId) {
+      counts[userId] = (counts[userId] || 0) + 1;
+    }
+  });
+  return counts;
+}
+
+/**
+ * Write authoritative pixelCount values from the tallies in countsRef to
+ * every user document. Users without any pixel on the canvas are set to 0.
+ */
+async function writePixelCounts(countsRef) {
+  const usersRef = firestore.collection('users');
+  let updated = 0;
+  let created = 0;
+  let users = 0;
+  let last = null;
+
+  while (true) {
+    let query = usersRef.orderBy(FieldPath.documentId()).limit(BACKFILL_WRITE_BATCH);
+    if (last) {
+      query = query.startAfter(last);
+    }
+    const snapshot = await query.get();
+    if (snapshot.empty) {
+      break;
+    }
+
+    const tallies = await firestore.getAll(...snapshot.docs.map(doc => countsRef.doc(doc.id)));
+    const batch = firestore.batch();
+    snapshot.docs.forEach((doc, i) => {
+      batch.update(doc.ref, { pixelCount: tallies[i].exists ? tallies[i].get('pixelCount') : 0 });
+    });
+    await batch.commit();
+    updated += snapshot.size;
+    last = snapshot.docs[snapshot.docs.length - 1];
+  }
+
+  // Pixels whose placer never got a user document
+  last = null;
+  while (true) {
+    let query = countsRef.orderBy(FieldPath.documentId()).limit(BACKFILL_WRITE_BATCH);
+    if (last) {
+      query = query.startAfter(last);
+    }
+    const snapshot = await query.get();
+    if (snapshot.empty) {
+      break;
+    }
+    users += snapshot.size;
+
+    const userDocs = await firestore.getAll(...snapshot.docs.map(doc => usersRef.doc(doc.id)));
+    const batch = firestore.batch();
+    let missing = 0;
+    snapshot.docs.forEach((doc, i) => {
+      if (!userDocs[i].exists) {
+        batch.set(usersRef.doc(doc.id), { id: doc.id, pixelCount: doc.get('pixelCount') }, { merge: true });
+        missing++;
+      }
+    });
+    if (missing > 0) {
+      await batch.commit();
+      created += missing;
+    }
+    last = snapshot.docs[snapshot.docs.length - 1];
+  }
+
+  return { updated, created, users };
+}
+
+/**
+ * Recompute users' pixelCount from the pixels collection.
+ * Idempotent: progress lives in migrations/backfill_pixel_counts and the
+ * per-user tallies in its counts subcollection (one document per user, so
+ * the migration document stays small), and when the time budget runs out
+ * the job republishes itself to continue from the cursor.
+ */
+async function backfillPixelCounts(metadata) {
+  const deadline = Date.now() + BACKFILL_TIME_BUDGET_MS;
+  const migrationRef = firestore.collection('migrations').doc('backfill_pixel_counts');
+  const countsRef = migrationRef.collection('counts');
+
+  try {
+    const migrationDoc = await migrationRef.get();
+    let state = migrationDoc.exists ? migrationDoc.data() : null;
+
+    // A continuation carries on whatever the age of the run; a new command
+    // only waits for a run that is still making progress
+    const running = metadata.continuation ? Boolean(state && state.status === 'running') : migrationRunning(state);
+    if (!running) {
+      if (metadata.continuation) {
+        return { success: true, message: null };
+      }
+      if (state && state.status === 'running') {
+        logJson('WARNING', 'backfill_restarted_stale', { scanned: state.scanned, updated_at: state.updatedAt });
+      }
+      // Tallies of an earlier run would be counted twice
+      await firestore.recursiveDelete(countsRef);
+      const now = new Date().toISOString();
+      state = {
+        status: 'running',
+        cursor: null,
+        scanned: 0,
+        startedAt: now,
+        updatedAt: now,
+        startedBy: metadata.userId
+      };
+      await migrationRef.set(state);
+    } else if (!metadata.continuation) {
+      return { success: true, message: `⏳ Backfill already running (${state.scanned} pixels scanned)` };
+    }
+
+    const pixelsRef = firestore.collection('pixels');
+    let done = false;
+
+    while (Date.now() < deadline) {
+      let query = pixelsRef.orderBy(FieldPath.documentId()).select('userId').limit(BACKFILL_PAGE_SIZE);
+      if (state.cursor) {
+        query = query.startAfter(state.cursor);
+      }
+      const snapshot = await query.get();
+
+      if (snapshot.empty) {
+        done = true;
+        break;
+      }
+
+      // The tallies and the cursor commit together, so a page is never
+      // counted twice
+      const counts = tallyPixelPage({}, snapshot.docs);
+      state.scanned += snapshot.size;
+      state.cursor = snapshot.docs[snapshot.docs.length - 1].id;
+      const batch = firestore.batch();
+      Object.entries(counts).forEach(([userId, count]) => {
+        batch.set(countsRef.doc(userId), { pixelCount: FieldValue.increment(count) }, { merge: true });
+      });
+      batch.update(migrationRef, { cursor: state.cursor, scanned: state.scanned, updatedAt: new Date().toISOString() });
+      await batch.commit();
+
+      if (snapshot.size < BACKFILL_PAGE_SIZE) {
+        done = true;
+        break;
+      }
+    }
+
+    if (!done) {
+      await pubsub.topic(SESSION_EVENTS_TOPIC).publishMessage({
+        json: { ...metadata.message, continuation: true },
+        attributes: { type: 'session_command' }
+      });
+      logJson('INFO', 'backfill_continued', { scanned: state.scanned });
+      return { success: true, message: null };
+    }
+
+    const { updated, created, users } = await writePixelCounts(countsRef);
+
+    await migrationRef.update({
+      status: 'completed',
+      completedAt: new Date().toISOString(),
+      usersUpdated: updated,
+      usersCreated: created
+    });
+
+    logJson('INFO', 'backfill_completed', { scanned: state.scanned, users, updated, created });
+    return {
+      success: true,
+      message: `✅ pixelCount backfill complete. Scanned ${state.scanned} pixels from ${users} users; updated ${updated} user docs, created ${created}`
+    };
+  } catch (error) {
+    await markMigrationFailed(migrationRef, error, 'backfill_failed');
+    return { success: false, message: `❌ Failed to backfill pixel counts: ${error.message}. Run /session backfill again to restart it.` };
+  }
+}
+
+/**
+ * Compare one page of pixels with the latest pixel_history entry of each
+ * coordinate. Pixels placed before history was enabled have no entry and
+ * are only counted as unverified.
+ */
+async function verifyPixelPage(docs) {
+  const historyRef = firestore.collection('pixel_history');
+  const latest = await Promise.all(docs.map(doc =>
+    historyRef
+      .where('x', '==', doc.get('x'))
+      .where('y', '==', doc.get('y'))
+      .orderBy('timestamp', 'desc')
+      .limit(1)
+      .get()
+  ));
+
+  const mismatches = [];
+  let unverified = 0;
+  docs.forEach((doc, i) => {
+    if (latest[i].empty) {
+      unverified++;
+      return;
+    }
+    const entry = latest[i].docs[0].data();
+    if (doc.get('color') !== entry.color || doc.get('userId') !== entry.userId) {
+      mismatches.push({ ref: doc.ref, updateTime: doc.updateTime, entry });
+    }
+  });
+  return { mismatches, unverified };
+}
+
+/**
+ * Rewrite mismatched pixels from their history entries, each only if it has
+ * not changed since it was read: a placement in between wrote a newer entry
+ * than the one compared, and must not be overwritten with the older one.
+ * Returns how many were repaired and how many skipped for that reason.
+ */
+async function repairPixels(mismatches) {
+  const results = await Promise.allSettled(mismatches.map(({ ref, updateTime, entry }) =>
+    ref.update({
+      color: entry.color,
+      userId: entry.userId,
+      username: entry.username,
+      source: entry.source
+    }, { lastUpdateTime: updateTime })
+  ));
+
+  let repaired = 0;
+  let skipped = 0;
+  results.forEach((result, i) => {
+    if (result.status === 'fulfilled') {
+      repaired++;
+    } else if (result.reason.code === GRPC_FAILED_PRECONDITION) {
+      skipped++;
+      logJson('INFO', 'pixel_repair_skipped', { pixel_id: mismatches[i].ref.id });
+    } else {
+      throw result.reason;
+    }
+  });
+  return { repaired, skipped };
+}
+
+/**
+ * Cross-check the pixels collection against pixel_history and, with repair,
+ * rewrite mismatched pixels from their latest history entry.
+ * Runs in time-bounded passes like the backfill; progress lives in
+ * migrations/verify_canvas, and a run that stalled or failed is started
+ * over by the next command.
+ */
+async function verifyCanvas(metadata) {
+  const deadline = Date.now() + BACKFILL_TIME_BUDGET_MS;
+  const migrationRef = firestore.collection('migrations').doc('verify_canvas');
+
+  try {
+    const migrationDoc = await migrationRef.get();
+    let state = migrationDoc.exists ? migrationDoc.data() : null;
+
+    const running = metadata.continuation ? Boolean(state && state.status === 'running') : migrationRunning(state);
+    if (!running) {
+      if (metadata.continuation) {
+        return { success: true, message: null };
+      }
+      if (state && state.status === 'running') {
+        logJson('WARNING', 'verify_restarted_stale', { scanned: state.scanned, updated_at: state.updatedAt || state.startedAt });
+      }
+      const history = await firestore.collection('pixel_history').limit(1).get();
+      if (history.empty) {
+        return { success: false, message: '❌ No pixel history recorded. Enable PIXEL_HISTORY on the pixel worker first.' };
+      }
+      const now = new Date().toISOString();
+      state = {
+        status: 'running',
+        repair: Boolean(metadata.repair),
+        cursor: null,
+        scanned: 0,
+        mismatched: 0,
+        repaired: 0,
+        skipped: 0,
+        unverified: 0,
+        startedAt: now,
+        updatedAt: now,
+        startedBy: metadata.userId
+      };
+      await migrationRef.set(state);
+    } else if (!metadata.continuation) {
+      return { success: true, message: `⏳ Verification already running (${state.scanned} pixels checked)` };
+    }
+
+    const pixelsRef = firestore.collection('pixels');
+    let done = false;
+
+    while (Date.now() < deadline) {
+      let query = pixelsRef.orderBy(FieldPath.documentId()).limit(VERIFY_PAGE_SIZE);
+      if (state.cursor) {
+        query = query.startAfter(state.cursor);
+      }
+      const snapshot = await query.get();
+
+      if (snapshot.empty) {
+        done = true;
+        break;
+      }
+
+      const { mismatches, unverified } = await verifyPixelPage(snapshot.docs);
+      if (state.repair && mismatches.length > 0) {
+        const { repaired, skipped } = await repairPixels(mismatches);
+        state.repaired += repaired;
+        state.skipped = (state.skipped || 0) + skipped;
+      }
+      mismatches.forEach(({ ref, entry }) => {
+        logJson('WARNING', 'pixel_history_mismatch', { pixel_id: ref.id, history_color: entry.color, repaired: state.repair });
+      });
+
+      state.scanned += snapshot.size;
+      state.mismatched += mismatches.length;
+      state.unverified += unverified;
+      state.cursor = snapshot.docs[snapshot.docs.length - 1].id;
+      await migrationRef.update({
+        cursor: state.cursor,
+        scanned: state.scanned,
+        mismatched: state.mismatched,
+        repaired: state.repaired,
+        skipped: state.skipped || 0,
+        unverified: state.unverified,
+        updatedAt: new Date().toISOString()
+      });
+
+      if (snapshot.size < VERIFY_PAGE_SIZE) {
+        done = true;
+        break;
+      }
+    }
+
+    if (!done) {
+      await pubsub.topic(SESSION_EVENTS_TOPIC).publishMessage({
+        json: { ...metadata.message, continuation: true },
+        attributes: { type: 'session_command' }
+      });
+      logJson('INFO', 'verify_continued', { scanned: state.scanned });
+      return { success: true, message: null };
+    }
+
+    await migrationRef.update({ status: 'completed', completedAt: new Date().toISOString() });
+
+    logJson('INFO', 'verify_completed', {
+      scanned: state.scanned,
+      mismatched: state.mismatched,
+      repaired: state.repaired,
+      skipped: state.skipped || 0,
+      unverified: state.unverified
+    });
+    let outcome = 'run with repair:true to fix them';
+    if (state.repair) {
+      outcome = `${state.repaired} repaired`;
+      if (state.skipped) {
+        outcome += `, ${state.skipped} changed since and left alone`;
+      }
+    }
+    return {
+      success: true,
+      message: `✅ Canvas verified. Checked ${state.scanned} pixels: ${state.mismatched} mismatched (${outcome}), ${state.unverified} without history`
+    };
+  } catch (error) {
+    await markMigrationFailed(migrationRef, error, 'verify_failed');
+    return { success: false, message: `❌ Failed to verify canvas: ${error.message}. Run /verify again to restart it.` };
+  }
+}
+
+/**
+ * Where (0, 0) is for Discord users, as in internal/coords of the Go
+ * functions. Pixels and zones are always stored top-left.
+ */
+const ORIGINS = ['top-left', 'bottom-left'];
+
+/**
+ * Converts the inclusive corners of a rectangle between the coordinates users
+ * type in a session and storage coordinates. Flipping Y is its own inverse and
+ * swaps which row is the smaller one; without a height nothing is flipped.
+ */
+function flipRect(session, rect) {
+  const height = session.canvasHeight || 0;
+  if (session.origin !== 'bottom-left' || height <= 0) return rect;
+  return { minX: rect.minX, minY: height - 1 - rect.maxY, maxX: rect.maxX, maxY: height - 1 - rect.minY };
+}
+
+/**
+ * Zone document ID derived from its label, so /zone unlock can find it
+ */
+function zoneId(label) {
+  return label.toLowerCase().replace(/[^a-z0-9_-]+/g, '-').replace(/^-+|-+$/g, '');
+}
+
+/**
+ * Lock, unlock or list admin-protected zones. Bounds are stored as canvas
+ * (top-left) coordinates, inclusive, and typed and shown in the session's
+ * origin. Locking an existing zone without corners keeps its bounds;
+ * unlocking keeps the zone so it can be re-locked later.
+ */
+async function manageZone(metadata) {
+  const zonesRef = firestore.collection('zones');
+
+  try {
+    const sessionDoc = await firestore.collection('sessions').doc('current').get();
+    const session = sessionDoc.exists ? sessionDoc.data() : {};
+
+    if (metadata.zoneAction === 'list') {
+      const snapshot = await zonesRef.orderBy('label').get();
+      if (snapshot.empty) {
+        return { success: true, message: 'No zones defined.' };
+      }
+      const lines = snapshot.docs.map(doc => {
+        const z = doc.data();
+        const state = z.locked ? '🔒' : '🔓';
+        const allowed = (z.allowedUsers || []).length ? `, allowed: ${z.allowedUsers.map(id => `<@${id}>`).join(' ')}` : '';
+        const r = flipRect(session, z);
+        return `${state} **${z.label}** (${r.minX}, ${r.minY}) to (${r.maxX}, ${r.maxY})${allowed}`;
+      });
+      return { success: true, message: `**Zones**\n${lines.join('\n')}` };
+    }
+
+    const label = (metadata.label || '').trim();
+    const id = zoneId(label);
+    if (!id) {
+      return { success: false, message: '❌ A zone needs a label' };
+    }
+    const zoneRef = zonesRef.doc(id);
+    const zoneDoc = await zoneRef.get();
+    const now = new Date().toISOString();
+
+    if (metadata.zoneAction === 'unlock') {
+      if (!zoneDoc.exists) {
+        return { success: false, message: `❌ No zone named "${label}"` };
+      }
+      await zoneRef.update({ locked: false, updatedBy: metadata.userId, updatedAt: now });
+      return { success: true, message: `🔓 Zone **${zoneDoc.get('label')}** unlocked` };
+    }
+
+    if (metadata.zoneAction !== 'lock') {
+      return { success: false, message: `❌ Unknown zone action: ${metadata.zoneAction}` };
+    }
+
+    const zone = { label, locked: true, updatedBy: metadata.userId, updatedAt: now };
+    if (metadata.minX !== undefined) {
+      Object.assign(zone, flipRect(session, { minX: metadata.minX, minY: metadata.minY, maxX: metadata.maxX, maxY: metadata.maxY }));
+    } else if (!zoneDoc.exists) {
+      return { success: false, message: `❌ No zone named "${label}"; give x1, y1, x2 and y2 to create it` };
+    }
+    if (metadata.allowedUsers !== undefined) {
+      zone.allowedUsers = metadata.allowedUsers || [];
+    } else if (!zoneDoc.exists) {
+      zone.allowedUsers = [];
+    }
+    if (!zoneDoc.exists) {
+      zone.createdBy = metadata.userId;
+      zone.createdAt = now;
+    }
+    await zoneRef.set(zone, { merge: true });
+
+    const z = { ...(zoneDoc.exists ? zoneDoc.data() : {}), ...zone };
+    const r = flipRect(session, z);
+    return {
+      success: true,
+      message: `🔒 Zone **${label}** locked: (${r.minX}, ${r.minY}) to (${r.maxX}, ${r.maxY}), ${z.allowedUsers.length} allowed user(s). Takes effect within 30 seconds.`
+    };
+  } catch (error) {
+    return { success: false, message: `❌ Failed to update zones: ${error.message}` };
+  }
+}
+
+/**
+ * Audit action and target for a command, or null when it is read-only
+ */
+function auditTargetFor(messageData) {
+  const { action } = messageData;
+  if (UNAUDITED_ACTIONS.has(action)) return null;
+  if (action === 'verify') return { action: 'canvas.verify', target: 'pixels' };
+  if (action === 'zone') {
+    if (messageData.zoneAction === 'list') return null;
+    return { action: `zone.${messageData.zoneAction}`, target: zoneId(messageData.label || '') };
+  }
+  return { action: `session.${action}`, target: 'current' };
+}
+
+/**
+ * Check a parsed export file. Returns null when it can be imported,
+ * otherwise what is wrong with it.
+ */
+function validateExport(data) {
+  if (!data || typeof data !== 'object' || data.format !== EXPORT_FORMAT) {
+    return 'the file is not a /session export';
+  }
+  if (!Number.isInteger(data.version) || data.version < 1 || data.version > EXPORT_VERSION) {
+    return `export version ${data.version} is not supported (up to ${EXPORT_VERSION})`;
+  }
+  if (!Array.isArray(data.pixels) || !Array.isArray(data.users)) {
+    return 'the file has no pixels or users';
+  }
+  const badPixel = data.pixels.findIndex(p => !p || !Number.isInteger(p.x) || !Number.isInteger(p.y) ||
+    p.x < 0 || p.y < 0 || !/^[0-9A-Fa-f]{6}$/.test(p.color || ''));
+  if (badPixel !== -1) {
+    return `pixel ${badPixel} has invalid coordinates or color`;
+  }
+  const badUser = data.users.findIndex(u => !u || typeof u.id !== 'string' || !u.id || u.id.includes('/'));
+  if (badUser !== -1) {
+    return `user ${badUser} has no valid id`;
+  }
+  return null;
+}
+
+/**
+ * Turn an exported document back into Firestore data: the "id" is the
+ * document ID, and the given fields go back from RFC 3339 strings to
+ * Timestamps.
+ */
+function importedFields(doc, timestampFields) {
+  const { id, ...data } = doc;
+  for (const field of timestampFields) {
+    if (typeof data[field] === 'string' && !isNaN(Date.parse(data[field]))) {
+      data[field] = Timestamp.fromDate(new Date(data[field]));
+    }
+  }
+  return data;
+}
+
+/**
+ * Write documents in batches of IMPORT_WRITE_BATCH. Writes are idempotent,
+ * so a redelivered import simply writes them again.
+ */
+async function writeImportBatches(docs, refFor, dataFor, options) {
+  for (let i = 0; i < docs.length; i += IMPORT_WRITE_BATCH) {
+    const batch = firestore.batch();
+    docs.slice(i, i + IMPORT_WRITE_BATCH).forEach(doc => {
+      batch.set(refFor(doc), dataFor(doc), options);
+    });
+    await batch.commit();
+  }
+  return docs.length;
+}
+
+/**
+ * Restore a /session export from the file confirmed in
+ * import_jobs/{importId}. The session takes the exported settings and stays
+ * "importing", which the pixel worker refuses, until every pixel and user is
+ * written; then it becomes active. Pixels replace whatever is at the same
+ * coordinates; user documents are merged so login data survives. A failed
+ * import throws and is redelivered, and the redelivery picks up its own
+ * "importing" session.
+ */
+async function importSession(metadata) {
+  const started = Date.now();
+  const sessionRef = firestore.collection('sessions').doc('current');
+  const jobRef = firestore.collection('import_jobs').doc(metadata.importId || '-');
+
+  try {
+    const jobDoc = await jobRef.get();
+    if (!jobDoc.exists) {
+      return { success: false, rejected: true, message: '⚠️ Cannot import: the import was cancelled or has already run.' };
+    }
+    const { url, filename } = jobDoc.data();
+
+    let exported;
+    try {
+      const response = await fetch(url);
+      if (!response.ok) {
+        throw new Error(`download failed with status ${response.status}`);
+      }
+      exported = await response.json();
+    } catch (error) {
+      return { success: false, rejected: true, message: `⚠️ Cannot import ${filename}: ${error.message}.` };
+    }
+    const invalidFile = validateExport(exported);
+    if (invalidFile) {
+      return { success: false, rejected: true, message: `⚠️ Cannot import ${filename}: ${invalidFile}.` };
+    }
+
+    const invalid = await firestore.runTransaction(async (tx) => {
+      const sessionDoc = await tx.get(sessionRef);
+      const from = sessionStatus(sessionDoc);
+      if (from !== 'importing' || sessionDoc.data().importId !== metadata.importId) {
+        const invalid = validateTransition(from, 'importing');
+        if (invalid) return invalid;
+      }
+      // The bucket belongs to the exporting deployment
+      const { status, snapshotsBucket, ...settings } = exported.session || {};
+      tx.set(sessionRef, {
+        ...settings,
+        status: 'importing',
+        importId: metadata.importId,
+        importedFrom: filename,
+        importedAt: new Date().toISOString(),
+        importedBy: metadata.userId,
+      });
+      return null;
+    });
+    if (invalid) {
+      return transitionRejected('import the session', invalid);
+    }
+
+    const pixels = await writeImportBatches(
+      exported.pixels,
+      p => firestore.collection('pixels').doc(`${p.x}_${p.y}`),
+      p => importedFields(p, ['updatedAt'])
+    );
+    const users = await writeImportBatches(
+      exported.users,
+      u => firestore.collection('users').doc(u.id),
+      u => ({ ...importedFields(u, ['lastPixelAt', 'createdAt']), id: u.id }),
+      { merge: true }
+    );
+
+    await sessionRef.update({ status: 'active' });
+    await jobRef.delete();
+
+    const seconds = ((Date.now() - started) / 1000).toFixed(1);
+    logJson('INFO', 'session_imported', { import_id: metadata.importId, pixels, users, duration_s: Number(seconds) });
+    return { success: true, message: `✅ Session imported from ${filename}: ${pixels} pixels and ${users} users restored in ${seconds}s` };
+  } catch (error) {
+    return { success: false, message: `❌ Failed to import session: ${error.message}` };
+  }
+}
+
 /**
  * Reset the canvas (delete all pixels)
  */
@@ -198,6 +1194,39 @@ async function endSession() {
   }
 }
 
+/**
+ * Count the pixels, reusing the count in stats/overview while it is younger
+ * than OVERVIEW_MAX_AGE_MS, or at any age where count aggregations are
+ * unavailable. The session itself is always read live, so the status never
+ * lags a start, pause or stop.
+ */
+async function getPixelCount() {
+  const overviewRef = firestore.collection('stats').doc('overview');
+  const overviewDoc = await overviewRef.get();
+  const overview = overviewDoc.exists ? overviewDoc.data() : null;
+  const storedAt = overview && overview.countedAt && overview.countedAt.toDate();
+  if (storedAt && Date.now() - storedAt.getTime() < OVERVIEW_MAX_AGE_MS) {
+    return { pixelCount: overview.pixelCount, countedAt: storedAt };
+  }
+
+  let pixelCount;
+  try {
+    pixelCount = (await firestore.collection('pixels').count().get()).data().count;
+  } catch (error) {
+    if (error.code !== GRPC_UNIMPLEMENTED || !storedAt) throw error;
+    logJson('WARNING', 'pixel_count_fallback', { source: 'stats/overview', error: error.message });
+    return { pixelCount: overview.pixelCount, countedAt: storedAt };
+  }
+  const countedAt = new Date();
+  try {
+    // Merged, to keep what pixel_count_reconcile recorded
+    await overviewRef.set({ pixelCount, countedAt }, { merge: true });
+  } catch (error) {
+    logJson('WARNING', 'overview_write_failed', { error: error.message });
+  }
+  return { pixelCount, countedAt };
+}
+
 /**
  * Get canvas status
  */
@@ -216,19 +1245,50 @@ async function getCanvasStatus() {
     const canvasWidth = session.canvasWidth || '∞';
     const canvasHeight = session.canvasHeight || '∞';
 
-    // Count pixels
-    const pixelCountQuery = await firestore.collection('pixels').count().get();
-    const pixelCount = pixelCountQuery.data().count;
+    const { pixelCount, countedAt } = await getPixelCount();
 
     return {
       success: true,
-      message: `**Canvas Status**\nStatus: ${status}\nStarted: ${startedAt}\nSize: ${canvasWidth} x ${canvasHeight}\nTotal Pixels: ${pixelCount}`
+      message: `**Canvas Status**\nStatus: ${status}\nStarted: ${startedAt}\nSize: ${canvasWidth} x ${canvasHeight}\nTotal Pixels: ${pixelCount}\n-# Pixel count as of <t:${Math.floor(countedAt.getTime() / 1000)}:R>`
     };
   } catch (error) {
     return { success: false, message: `❌ Failed to get canvas status: ${error.message}` };
   }
 }
 
+/**
+ * Describe a session's grid for /canvas view:grid. Every placement snaps to
+ * the corner of its cell, so each pixel fills one cell.
+ */
+function formatGridInfo(session, filledCells) {
+  const snap = session.gridSnap || 0;
+  if (snap <= 1) {
+    return 'This session has no grid: pixels are placed where they are typed. Start a session with `grid_snap` to add one.';
+  }
+  const columns = session.canvasWidth ? Math.ceil(session.canvasWidth / snap) : 0;
+  const rows = session.canvasHeight ? Math.ceil(session.canvasHeight / snap) : 0;
+  const total = columns && rows ? `${columns} x ${rows} = ${columns * rows}` : 'unbounded';
+  return `**Canvas Grid**\nCell size: ${snap} x ${snap} pixels\nGrid cells: ${total}\nFilled cells: ${filledCells}\n` +
+    '-# Placements snap to the top-left corner of their cell';
+}
+
+/**
+ * Get the session's grid configuration
+ */
+async function getGridInfo() {
+  try {
+    const sessionDoc = await firestore.collection('sessions').doc('current').get();
+    if (!sessionDoc.exists) {
+      return { success: true, message: 'No active session found.' };
+    }
+    const session = sessionDoc.data();
+    const { pixelCount } = session.gridSnap > 1 ? await getPixelCount() : { pixelCount: 0 };
+    return { success: true, message: formatGridInfo(session, pixelCount) };
+  } catch (error) {
+    return { success: false, message: `❌ Failed to get the canvas grid: ${error.message}` };
+  }
+}
+
 /**
  * CloudEvent function handler (Pub/Sub)
  */
@@ -255,7 +1315,7 @@ functions.cloudEvent('handler', async (cloudEvent) => {
     const data = cloudEvent.data.message.data;
     const messageData = JSON.parse(Buffer.from(data, 'base64').toString());
 
-    const { action, userId, username, interactionToken, applicationId, canvasWidth, canvasHeight } = messageData;
+    const { action, userId, username, channelId, interactionToken, applicationId, canvasWidth, canvasHeight, snapshotsBucket, origin, gridSnap } = messageData;
 
     // Add span attributes
     span.setAttributes({
@@ -273,7 +1333,7 @@ functions.cloudEvent('handler', async (cloudEvent) => {
         span.updateName('session.start');
         if (canvasWidth) span.setAttribute('session.canvas_width', canvasWidth);
         if (canvasHeight) span.setAttribute('session.canvas_height', canvasHeight);
-        result = await startSession({ userId, username, canvasWidth, canvasHeight });
+        result = await startSession({ userId, username, canvasWidth, canvasHeight, snapshotsBucket, origin, gridSnap });
         break;
 
       case 'pause':
@@ -286,6 +1346,47 @@ functions.cloudEvent('handler', async (cloudEvent) => {
         result = await resumeSession();
         break;
 
+      case 'schedule': {
+        span.updateName('session.schedule');
+        const { opensAt, closesAt, closedMessage } = messageData;
+        result = await scheduleSession({ opensAt, closesAt, closedMessage });
+        break;
+      }
+
+      case 'stop': {
+        span.updateName('session.stop');
+        const spanContext = span.spanContext();
+        const traceAttributes = { traceId: spanContext.traceId, spanId: spanContext.spanId };
+        result = await stopSession({
+          userId, username, channelId, traceAttributes,
+          purge: messageData.purge,
+          continuation: messageData.continuation,
+          message: messageData
+        });
+        break;
+      }
+
+      case 'backfill':
+        span.updateName('session.backfill');
+        result = await backfillPixelCounts({ userId, continuation: messageData.continuation, message: messageData });
+        break;
+
+      case 'zone':
+        span.updateName(`zone.${messageData.zoneAction}`);
+        result = await manageZone({ ...messageData });
+        break;
+
+      case 'verify':
+        span.updateName('canvas.verify');
+        span.setAttribute('verify.repair', Boolean(messageData.repair));
+        result = await verifyCanvas({ userId, repair: messageData.repair, continuation: messageData.continuation, message: messageData });
+        break;
+
+      case 'import':
+        span.updateName('session.import');
+        result = await importSession({ userId, importId: messageData.importId });
+        break;
+
       case 'reset':
         span.updateName('session.reset');
         result = await resetCanvas();
@@ -301,19 +1402,61 @@ functions.cloudEvent('handler', async (cloudEvent) => {
         result = await getCanvasStatus();
         break;
 
+      case 'grid':
+        span.updateName('session.grid');
+        result = await getGridInfo();
+        break;
+
       default:
         result = { success: false, message: `❌ Unknown action: ${action}` };
         span.setStatus({ code: SpanStatusCode.ERROR, message: `Unknown action: ${action}` });
     }
 
-    // Send Discord follow-up
-    if (interactionToken && applicationId) {
+    // Continuations of a running backfill were audited when it started
+    const audited = auditTargetFor(messageData);
+    if (audited && !messageData.continuation) {
+      const params = { success: result.success };
+      if (action === 'start') {
+        if (canvasWidth) params.canvasWidth = canvasWidth;
+        if (canvasHeight) params.canvasHeight = canvasHeight;
+        if (snapshotsBucket) params.snapshotsBucket = snapshotsBucket;
+        if (origin) params.origin = origin;
+        if (gridSnap) params.gridSnap = gridSnap;
+      }
+      if (action === 'verify') params.repair = Boolean(messageData.repair);
+      if (action === 'stop') params.purge = Boolean(messageData.purge);
+      if (action === 'import') params.importId = messageData.importId;
+      if (action === 'zone') {
+        for (const field of ['minX', 'minY', 'maxX', 'maxY', 'allowedUsers']) {
+          if (messageData[field] !== undefined) params[field] = messageData[field];
+        }
+      }
+      if (action === 'schedule') {
+        for (const field of ['opensAt', 'closesAt', 'closedMessage']) {
+          if (messageData[field] !== undefined) params[field] = messageData[field];
+        }
+      }
+      await writeAuditEntry({
+        actorId: userId,
+        actorName: username,
+        action: audited.action,
+        target: audited.target,
+        params,
+        traceId: span.spanContext().traceId,
+      });
+    }
+
+    // Send Discord follow-up (long-running jobs may have nothing to report yet)
+    if (interactionToken && applicationId && result.message) {
       await sendDiscordFollowUp(applicationId, interactionToken, result.message);
     }
 
     if (result.success) {
       logJson('INFO', 'session_command_success', { action, user_id: userId });
       span.setStatus({ code: SpanStatusCode.OK });
+    } else if (result.rejected) {
+      logJson('WARNING', 'session_transition_rejected', { action, user_id: userId, error: result.message });
+      span.setAttribute('session.rejected', true);
     } else {
       logJson('ERROR', 'session_command_failed', { action, user_id: userId, error: result.message });
       span.setStatus({ code: SpanStatusCode.ERROR, message: result.message });
@@ -332,3 +1475,16 @@ functions.cloudEvent('handler', async (cloudEvent) => {
     }
   }
 });
+
+// Exported for index.test.js
+module.exports = {
+  backfillPixelCounts,
+  formatGridInfo,
+  pauseSession,
+  repairPixels,
+  resumeSession,
+  startSession,
+  stopSession,
+  validateTransition,
+  verifyCanvas,
+};
@@ -0,0 +1,269 @@
+/**
+ * Session worker tests, mostly against the Firestore emulator. Start it with
+ * `docker compose up firestore`, then run `npm test` with
+ * FIRESTORE_EMULATOR_HOST=localhost:8080; without it those are skipped.
+ */
+const { describe, it, before, beforeEach } = require('node:test');
+const assert = require('node:assert/strict');
+
+const EMULATOR_HOST = process.env.FIRESTORE_EMULATOR_HOST;
+process.env.PROJECT_ID = process.env.PROJECT_ID || 'team11-local';
+const DATABASE_ID = 'team11-database';
+
+const skip = !EMULATOR_HOST && 'FIRESTORE_EMULATOR_HOST is not set';
+
+let worker;
+let firestore;
+
+// The emulator tests load the worker and the Firestore client here; without
+// the emulator `npm test` runs only the tests that need no Firestore
+function load() {
+  if (!worker) {
+    const { Firestore } = require('@google-cloud/firestore');
+    worker = require('./index');
+    firestore = new Firestore({ projectId: process.env.PROJECT_ID, databaseId: DATABASE_ID });
+  }
+}
+
+async function clearEmulator() {
+  const url = `http://${EMULATOR_HOST}/emulator/v1/projects/${process.env.PROJECT_ID}/databases/${DATABASE_ID}/documents`;
+  const response = await fetch(url, { method: 'DELETE' });
+  assert.ok(response.ok, `clearing the emulator failed: ${response.status}`);
+}
+
+async function seed(collection, docs) {
+  const batch = firestore.batch();
+  Object.entries(docs).forEach(([id, data]) => batch.set(firestore.collection(collection).doc(id), data));
+  await batch.commit();
+}
+
+async function pixelCounts() {
+  const snapshot = await firestore.collection('users').get();
+  return Object.fromEntries(snapshot.docs.map(doc => [doc.id, doc.get('pixelCount')]));
+}
+
+describe('backfillPixelCounts', { skip }, () => {
+  before(load);
+  beforeEach(async () => {
+    await clearEmulator();
+    await seed('users', {
+      u1: { id: 'u1', pixelCount: 99 },
+      u2: { id: 'u2', pixelCount: 0 },
+      u3: { id: 'u3', pixelCount: 5 },
+    });
+    await seed('pixels', {
+      '0_0': { x: 0, y: 0, color: '#FF0000', userId: 'u1' },
+      '1_0': { x: 1, y: 0, color: '#FF0000', userId: 'u1' },
+      '2_0': { x: 2, y: 0, color: '#00FF00', userId: 'u1' },
+      '0_1': { x: 0, y: 1, color: '#0000FF', userId: 'u2' },
+      '1_1': { x: 1, y: 1, color: '#0000FF', userId: 'u2' },
+      '2_1': { x: 2, y: 1, color: '#FFFFFF', userId: 'u4' },
+      '0_2': { x: 0, y: 2, color: '#000000' },
+    });
+  });
+
+  const backfill = () => worker.backfillPixelCounts({ userId: 'admin', message: { action: 'backfill' } });
+
+  it('recomputes pixelCount from the pixels of a fixture canvas', async () => {
+    const result = await backfill();
+
+    assert.equal(result.success, true, result.message);
+    assert.match(result.message, /Scanned 7 pixels from 3 users; updated 3 user docs, created 1/);
+    // u3 has no pixel left and u4 placed one without a user document
+    assert.deepEqual(await pixelCounts(), { u1: 3, u2: 2, u3: 0, u4: 1 });
+    const migration = await firestore.collection('migrations').doc('backfill_pixel_counts').get();
+    assert.equal(migration.get('status'), 'completed');
+    assert.equal(migration.get('scanned'), 7);
+  });
+
+  it('does not count the tallies of an earlier run again', async () => {
+    await backfill();
+    const result = await backfill();
+
+    assert.equal(result.success, true, result.message);
+    assert.deepEqual(await pixelCounts(), { u1: 3, u2: 2, u3: 0, u4: 1 });
+  });
+
+  it('answers that a run making progress is still running', async () => {
+    const now = new Date().toISOString();
+    await seed('migrations', {
+      backfill_pixel_counts: { status: 'running', cursor: null, scanned: 400, startedAt: now, updatedAt: now },
+    });
+
+    const result = await backfill();
+
+    assert.match(result.message, /already running \(400 pixels scanned\)/);
+    assert.equal((await pixelCounts()).u1, 99);
+  });
+
+  it('restarts a run that stopped making progress', async () => {
+    const longAgo = new Date(Date.now() - 60 * 60 * 1000).toISOString();
+    await seed('migrations', {
+      backfill_pixel_counts: { status: 'running', cursor: '1_1', scanned: 4, startedAt: longAgo, updatedAt: longAgo },
+    });
+    await seed('migrations/backfill_pixel_counts/counts', { u1: { pixelCount: 50 } });
+
+    const result = await backfill();
+
+    assert.equal(result.success, true, result.message);
+    assert.deepEqual(await pixelCounts(), { u1: 3, u2: 2, u3: 0, u4: 1 });
+  });
+});
+
+describe('verifyCanvas', { skip }, () => {
+  before(load);
+  beforeEach(async () => {
+    await clearEmulator();
+    await seed('pixels', {
+      '0_0': { x: 0, y: 0, color: '#FF0000', userId: 'u1', username: 'one', source: 'discord' },
+      '1_0': { x: 1, y: 0, color: '#00FF00', userId: 'u1', username: 'one', source: 'discord' },
+      '2_0': { x: 2, y: 0, color: '#0000FF', userId: 'u2', username: 'two', source: 'web' },
+    });
+    // 1_0 was overwritten by u2 after u1, but the pixel still shows u1's
+    // color; 2_0 was placed before history was enabled
+    await seed('pixel_history', {
+      h1: { x: 0, y: 0, color: '#FF0000', userId: 'u1', username: 'one', source: 'discord', timestamp: new Date('2026-01-01T00:00:00Z') },
+      h2: { x: 1, y: 0, color: '#00FF00', userId: 'u1', username: 'one', source: 'discord', timestamp: new Date('2026-01-01T00:00:00Z') },
+      h3: { x: 1, y: 0, color: '#FFFF00', userId: 'u2', username: 'two', source: 'web', timestamp: new Date('2026-01-01T00:01:00Z') },
+    });
+  });
+
+  const verify = (repair) => worker.verifyCanvas({ userId: 'admin', repair, message: { action: 'verify', repair } });
+  const pixel = async (id) => (await firestore.collection('pixels').doc(id).get()).data();
+
+  it('reports a seeded mismatch without repairing it', async () => {
+    const result = await verify(false);
+
+    assert.equal(result.success, true, result.message);
+    assert.match(result.message, /Checked 3 pixels: 1 mismatched \(run with repair:true to fix them\), 1 without history/);
+    assert.equal((await pixel('1_0')).color, '#00FF00');
+  });
+
+  it('repairs a seeded mismatch from the latest history entry', async () => {
+    const result = await verify(true);
+
+    assert.equal(result.success, true, result.message);
+    assert.match(result.message, /1 mismatched \(1 repaired\)/);
+    assert.deepEqual(await pixel('1_0'), { x: 1, y: 0, color: '#FFFF00', userId: 'u2', username: 'two', source: 'web' });
+    assert.equal((await pixel('0_0')).color, '#FF0000');
+    assert.equal((await pixel('2_0')).color, '#0000FF');
+  });
+
+  it('leaves a pixel placed since it was read alone', async () => {
+    const ref = firestore.collection('pixels').doc('1_0');
+    const read = await ref.get();
+    // A placement lands between the read and the repair
+    await ref.update({ color: '#000000', userId: 'u3' });
+
+    const entry = { color: '#FFFF00', userId: 'u2', username: 'two', source: 'web' };
+    const result = await worker.repairPixels([{ ref, updateTime: read.updateTime, entry }]);
+
+    assert.deepEqual(result, { repaired: 0, skipped: 1 });
+    assert.equal((await pixel('1_0')).color, '#000000');
+  });
+
+  it('answers that a run making progress is still running', async () => {
+    const now = new Date().toISOString();
+    await seed('migrations', { verify_canvas: { status: 'running', cursor: null, scanned: 200, startedAt: now, updatedAt: now } });
+
+    const result = await verify(true);
+
+    assert.match(result.message, /already running \(200 pixels checked\)/);
+    assert.equal((await pixel('1_0')).color, '#00FF00');
+  });
+
+  for (const [name, state] of [
+    ['stopped making progress', { status: 'running', updatedAt: new Date(Date.now() - 60 * 60 * 1000).toISOString() }],
+    ['failed', { status: 'failed', error: 'boom', failedAt: new Date().toISOString() }],
+  ]) {
+    it(`restarts a run that ${name}`, async () => {
+      await seed('migrations', { verify_canvas: { cursor: '1_0', scanned: 2, mismatched: 0, repaired: 0, unverified: 0, ...state } });
+
+      const result = await verify(true);
+
+      assert.equal(result.success, true, result.message);
+      assert.match(result.message, /Checked 3 pixels: 1 mismatched \(1 repaired\)/);
+      const migration = await firestore.collection('migrations').doc('verify_canvas').get();
+      assert.equal(migration.get('status'), 'completed');
+    });
+  }
+});
+
+describe('validateTransition', () => {
+  // The worker connects to nothing until a command runs
+  const { validateTransition } = require('./index');
+  const statuses = ['inactive', 'active', 'paused', 'stopped'];
+  const allowed = new Set(['inactive>active', 'active>paused', 'paused>active', 'active>stopped', 'paused>stopped']);
+
+  for (const from of statuses) {
+    for (const to of statuses) {
+      const legal = allowed.has(`${from}>${to}`);
+      it(`${legal ? 'allows' : 'refuses'} ${from} → ${to}`, () => {
+        const error = validateTransition(from, to);
+        if (legal) {
+          assert.equal(error, null);
+        } else if (from === to) {
+          assert.equal(error.message, `the session is already ${from}`);
+        } else {
+          assert.equal(error.message, `the session is ${from} and cannot become ${to}`);
+        }
+      });
+    }
+  }
+});
+
+describe('formatGridInfo', () => {
+  const { formatGridInfo } = require('./index');
+
+  it('explains a session without a grid', () => {
+    assert.match(formatGridInfo({ canvasWidth: 100, canvasHeight: 100 }, 0), /^This session has no grid/);
+    assert.match(formatGridInfo({ canvasWidth: 100, canvasHeight: 100, gridSnap: 1 }, 0), /^This session has no grid/);
+  });
+
+  it('counts the cells of a bounded canvas, rounding partial cells up', () => {
+    assert.equal(formatGridInfo({ canvasWidth: 100, canvasHeight: 30, gridSnap: 8 }, 12),
+      '**Canvas Grid**\nCell size: 8 x 8 pixels\nGrid cells: 13 x 4 = 52\nFilled cells: 12\n' +
+      '-# Placements snap to the top-left corner of their cell');
+  });
+
+  it('leaves the total open on an unbounded canvas', () => {
+    assert.match(formatGridInfo({ gridSnap: 4 }, 3), /Grid cells: unbounded\nFilled cells: 3/);
+  });
+});
+
+describe('session commands', { skip }, () => {
+  before(load);
+  beforeEach(clearEmulator);
+
+  const session = async () => (await firestore.collection('sessions').doc('current').get()).data();
+
+  it('starts an inactive canvas', async () => {
+    const result = await worker.startSession({ userId: 'admin', username: 'admin', canvasWidth: 50, canvasHeight: 40 });
+
+    assert.equal(result.success, true, result.message);
+    assert.equal((await session()).status, 'active');
+  });
+
+  it('stores the grid a session starts with', async () => {
+    const result = await worker.startSession({ userId: 'admin', username: 'admin', canvasWidth: 64, canvasHeight: 64, gridSnap: 8 });
+
+    assert.equal(result.message, '✅ Session started successfully (64x64, 8x8 grid)');
+    assert.equal((await session()).gridSnap, 8);
+  });
+
+  for (const [name, status, command, message] of [
+    ['start an active session', 'active', () => worker.startSession({ userId: 'admin' }), '⚠️ Cannot start the session: the session is already active.'],
+    ['pause a paused session', 'paused', () => worker.pauseSession(), '⚠️ Cannot pause the session: the session is already paused.'],
+    ['resume an active session', 'active', () => worker.resumeSession(), '⚠️ Cannot resume the session: the session is already active.'],
+    ['stop a stopped session', 'stopped', () => worker.stopSession({ userId: 'admin' }), '⚠️ Cannot stop the session: the session is already stopped.'],
+  ]) {
+    it(`refuses to ${name} and leaves it alone`, async () => {
+      await seed('sessions', { current: { status, canvasWidth: 100, canvasHeight: 100 } });
+
+      const result = await command();
+
+      assert.deepEqual(result, { success: false, rejected: true, message });
+      assert.deepEqual(await session(), { status, canvasWidth: 100, canvasHeight: 100 });
+    });
+  }
+});
@@ -0,0 +1,106 @@
+package snapshotworker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/team11/snapshot-worker/internal/coords"
+)
+
+const (
+	defaultCanvasWidth  = 1000
+	defaultCanvasHeight = 1000
+
+	// Dimensions only change when a new session starts, so a short-lived
+	// per-instance copy saves a read on back-to-back snapshots and tiles.
+	canvasSizeCacheTTL = 30 * time.Second
+)
+
+// Where resolveCanvasSize found the dimensions
+const (
+	canvasSizeFromRequest = "request"
+	canvasSizeFromCache   = "cache"
+	canvasSizeFromSession = "session"
+	canvasSizeFromDefault = "default"
+)
+
+var (
+	canvasSizeMu     sync.Mutex
+	canvasSizeCached struct {
+		width, height int
+		origin        coords.Origin
+		at            time.Time
+	}
+)
+
+// resolveCanvasSize picks the canvas dimensions for a render: dimensions
+// given by the request win, then a recent cached session read, then
+// sessions/current. When none is available it falls back to 1000x1000 and
+// says so in the logs and the trace, since the render may be cropped.
+func resolveCanvasSize(ctx context.Context, reqW, reqH int) (int, int, string) {
+	span := trace.SpanFromContext(ctx)
+
+	if reqW > 0 && reqH > 0 {
+		return reqW, reqH, canvasSizeFromRequest
+	}
+
+	canvasSizeMu.Lock()
+	cached := canvasSizeCached
+	canvasSizeMu.Unlock()
+	if cached.width > 0 && time.Since(cached.at) < canvasSizeCacheTTL {
+		return cached.width, cached.height, canvasSizeFromCache
+	}
+
+	doc, err := getFirestore().Collection("sessions").Doc("current").Get(ctx)
+	if err == nil {
+		data := doc.Data()
+		w, h := toIntVal(data["canvasWidth"]), toIntVal(data["canvasHeight"])
+		if w > 0 && h > 0 {
+			canvasSizeMu.Lock()
+			canvasSizeCached.width, canvasSizeCached.height, canvasSizeCached.at = w, h, time.Now()
+			canvasSizeCached.origin = coords.FromSession(data)
+			canvasSizeMu.Unlock()
+			return w, h, canvasSizeFromSession
+		}
+	}
+
+	reason := "session has no dimensions"
+	if err != nil {
+		reason = err.Error()
+	}
+	slog.Warn("canvas_size_fallback",
+		"width", defaultCanvasWidth,
+		"height", defaultCanvasHeight,
+		"reason", reason,
+	)
+	span.AddEvent("canvas_size_fallback", trace.WithAttributes(
+		attribute.Int("canvas.width", defaultCanvasWidth),
+		attribute.Int("canvas.height", defaultCanvasHeight),
+		attribute.String("reason", reason),
+	))
+	return defaultCanvasWidth, defaultCanvasHeight, canvasSizeFromDefault
+}
+
+// getCanvasSize resolves the current session's canvas dimensions
+func getCanvasSize(ctx context.Context) (int, int) {
+	w, h, _ := resolveCanvasSize(ctx, 0, 0)
+	return w, h
+}
+
+// getCanvasView is getCanvasSize plus the session's coordinate origin, for
+// requests typed in user coordinates. It is cached with the dimensions; a
+// canvas of the default size has no session and is TopLeft.
+func getCanvasView(ctx context.Context) (int, int, coords.Origin) {
+	w, h, source := resolveCanvasSize(ctx, 0, 0)
+	if source == canvasSizeFromDefault {
+		return w, h, coords.TopLeft
+	}
+	canvasSizeMu.Lock()
+	defer canvasSizeMu.Unlock()
+	return w, h, canvasSizeCached.origin
+}
@@ -0,0 +1,173 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/team11/snapshot-worker/internal/discord"
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+// summaryTopContributors is how many users a canvas summary names
+const summaryTopContributors = 5
+
+// summaryBaseline is stats/canvas_summary, what the previous summary saw
+type summaryBaseline struct {
+	LastRunAt  time.Time `firestore:"lastRunAt"`
+	PixelCount int       `firestore:"pixelCount"`
+}
+
+// canvasSummary is what one summary reports
+type canvasSummary struct {
+	PixelCount   int
+	CanvasWidth  int
+	CanvasHeight int
+	// Set from the second summary on
+	Previous *summaryBaseline
+	// Pixels whose latest placement is after Previous.LastRunAt, by owner
+	Painted      int
+	Contributors []ownerCount
+	Names        map[string]string
+	ThumbnailURL string
+}
+
+// fillPercent is the share of the canvas holding a pixel
+func (s canvasSummary) fillPercent() float64 {
+	cells := s.CanvasWidth * s.CanvasHeight
+	if cells <= 0 {
+		return 0
+	}
+	return float64(s.PixelCount) * 100 / float64(cells)
+}
+
+// filledDelta is the change in filled pixels since the previous summary.
+// It is negative after a clear.
+func (s canvasSummary) filledDelta() int {
+	if s.Previous == nil {
+		return 0
+	}
+	return s.PixelCount - s.Previous.PixelCount
+}
+
+// handleCanvasSummary posts the scheduled summary of the canvas to the
+// channel of the request and records the new baseline. The first run has no
+// baseline, so it reports totals only.
+func handleCanvasSummary(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "handleCanvasSummary")
+	defer span.End()
+
+	var req messages.CanvasSummaryRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse canvas summary request: %w", err)
+	}
+	if req.ChannelID == "" || discordBotToken == "" {
+		slog.Warn("canvas_summary_skipped", "reason", "no channel or bot token")
+		return nil
+	}
+
+	now := time.Now().UTC()
+	baselineRef := getFirestore().Collection("stats").Doc("canvas_summary")
+	summary := canvasSummary{}
+	summary.CanvasWidth, summary.CanvasHeight = getCanvasSize(ctx)
+
+	if doc, err := baselineRef.Get(ctx); err == nil {
+		var prev summaryBaseline
+		if err := doc.DataTo(&prev); err == nil && !prev.LastRunAt.IsZero() {
+			summary.Previous = &prev
+		}
+	}
+
+	count, err := countPixels(ctx)
+	if err != nil {
+		slog.Error("canvas_summary_failed", "error", err.Error())
+		return err
+	}
+	summary.PixelCount = count
+
+	if summary.Previous != nil {
+		// Only Timestamp updatedAt values compare with a Timestamp
+		pixels, err := queryPixels(ctx, getFirestore().Collection("pixels").Where("updatedAt", ">", summary.Previous.LastRunAt), pixelLoad{Owner: true})
+		if err != nil {
+			slog.Error("canvas_summary_failed", "error", err.Error())
+			return err
+		}
+		summary.Painted = len(pixels)
+		summary.Contributors = topOwners(pixels, summaryTopContributors)
+		summary.Names = ownerNames(ctx, summary.Contributors)
+	}
+
+	if last, err := getLastSnapshot(ctx); err == nil && snapshotsBucket != "" {
+		// Thumbnail links in snapshots/latest expire; sign a fresh one
+		summary.ThumbnailURL = objectURL(bucketOrDefault(last.Bucket), fmt.Sprintf("snapshots/%d/thumbnail.png", last.Timestamp))
+	}
+
+	span.SetAttributes(
+		attribute.Int("summary.pixel_count", summary.PixelCount),
+		attribute.Int("summary.painted", summary.Painted),
+		attribute.Bool("summary.first", summary.Previous == nil),
+	)
+
+	if err := discordClient.ChannelMessage(ctx, req.ChannelID, discord.Message{
+		Embeds: []map[string]interface{}{buildSummaryEmbed(summary)},
+	}); err != nil {
+		// Not retried: a redelivery could post the summary twice
+		slog.Error("canvas_summary_post_failed", "channel_id", req.ChannelID, "error", err.Error())
+		return nil
+	}
+
+	if _, err := baselineRef.Set(ctx, summaryBaseline{LastRunAt: now, PixelCount: count}); err != nil {
+		slog.Warn("canvas_summary_baseline_not_saved", "error", err.Error())
+	}
+	slog.Info("canvas_summary_posted",
+		"channel_id", req.ChannelID,
+		"pixel_count", summary.PixelCount,
+		"painted", summary.Painted,
+		"first", summary.Previous == nil,
+	)
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
+
+// buildSummaryEmbed lays out a canvas summary
+func buildSummaryEmbed(s canvasSummary) map[string]interface{} {
+	fields := []map[string]interface{}{
+		{"name": "Pixels on the canvas", "value": fmt.Sprintf("%d", s.PixelCount), "inline": true},
+		{"name": "Filled", "value": fmt.Sprintf("%.1f%% of %dx%d", s.fillPercent(), s.CanvasWidth, s.CanvasHeight), "inline": true},
+	}
+
+	description := "First canvas summary. The next one will show what changed since."
+	if s.Previous != nil {
+		description = fmt.Sprintf("Since <t:%d:f>: %d pixels painted, %+d filled.",
+			s.Previous.LastRunAt.Unix(), s.Painted, s.filledDelta())
+
+		var top strings.Builder
+		for i, c := range s.Contributors {
+			fmt.Fprintf(&top, "%d. %s: %d\n", i+1, s.Names[c.UserID], c.Count)
+		}
+		if top.Len() == 0 {
+			top.WriteString("Nobody drew since the last summary.")
+		}
+		fields = append(fields, map[string]interface{}{"name": "Top contributors", "value": top.String()})
+	}
+
+	embed := map[string]interface{}{
+		"title":       "Canvas summary",
+		"description": description,
+		"fields":      fields,
+		"color":       0x5865F2,
+	}
+	if s.ThumbnailURL != "" {
+		embed["image"] = map[string]string{"url": s.ThumbnailURL}
+		embed["footer"] = map[string]string{"text": "Image: latest snapshot"}
+	}
+	return embed
+}
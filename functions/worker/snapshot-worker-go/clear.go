@@ -0,0 +1,300 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/team11/snapshot-worker/internal/audit"
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+const (
+	clearSnapshotTag = "pre_clear"
+	// Session status while a clear runs; the pixel worker rejects placements
+	// for any status other than "active"
+	sessionClearing = "clearing"
+)
+
+var errClearIncomplete = errors.New("canvas clear incomplete, will resume on redelivery")
+
+// ClearJob tracks progress in clear_jobs/{jobId}. Phases run in order:
+// backup (pre_clear snapshot) -> pixels (delete) -> stats (reset) -> done.
+type ClearJob struct {
+	JobID       string `firestore:"jobId"`
+	RequestedBy string `firestore:"requestedBy"`
+	Phase       string `firestore:"phase"`
+	// Session status before the clear, restored once it is done
+	PreviousStatus     string `firestore:"previousStatus,omitempty"`
+	BackupTimestamp    int64  `firestore:"backupTimestamp,omitempty"`
+	BackupManifestURL  string `firestore:"backupManifestUrl,omitempty"`
+	BackupThumbnailURL string `firestore:"backupThumbnailUrl,omitempty"`
+	PixelsDeleted      int    `firestore:"pixelsDeleted"`
+	UsersReset         int    `firestore:"usersReset"`
+	StartedAt          string `firestore:"startedAt"`
+	UpdatedAt          string `firestore:"updatedAt"`
+	CompletedAt        string `firestore:"completedAt,omitempty"`
+}
+
+// loadClearJob returns the job for req, creating it on first delivery. The
+// job is created in the same transaction that puts the session into
+// "clearing", so placements stop before the backup is taken.
+func loadClearJob(ctx context.Context, req messages.CanvasClearRequest) (*ClearJob, error) {
+	jobRef := getFirestore().Collection("clear_jobs").Doc(req.JobID)
+	sessionRef := getFirestore().Collection("sessions").Doc("current")
+
+	var job ClearJob
+	err := getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(jobRef)
+		if err == nil {
+			return doc.DataTo(&job)
+		}
+		if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		session, err := tx.Get(sessionRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		job = ClearJob{
+			JobID:       req.JobID,
+			RequestedBy: req.RequestedBy,
+			Phase:       "backup",
+			StartedAt:   now,
+			UpdatedAt:   now,
+		}
+		if session != nil && session.Exists() {
+			current, _ := session.Data()["status"].(string)
+			if current != sessionClearing {
+				job.PreviousStatus = current
+			}
+			if err := tx.Update(sessionRef, []firestore.Update{{Path: "status", Value: sessionClearing}}); err != nil {
+				return err
+			}
+		}
+		return tx.Set(jobRef, job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func saveClearJob(ctx context.Context, job *ClearJob) error {
+	job.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	_, err := getFirestore().Collection("clear_jobs").Doc(job.JobID).Set(ctx, job)
+	return err
+}
+
+// takeClearBackup renders the pre_clear snapshot and checks snapshots/latest
+// points at it before anything is deleted.
+func takeClearBackup(ctx context.Context, req messages.CanvasClearRequest) (*LastSnapshot, error) {
+	backup, err := generateSnapshot(ctx, messages.SnapshotRequest{
+		UserID:   req.RequestedBy,
+		Username: req.RequestedByName,
+		Tag:      clearSnapshotTag,
+	}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if backup == nil {
+		return nil, errors.New("backup snapshot incomplete")
+	}
+
+	stored, err := getLastSnapshot(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshots/latest: %w", err)
+	}
+	if stored.Timestamp != backup.Timestamp || stored.Tag != clearSnapshotTag {
+		return nil, fmt.Errorf("backup snapshot %d is not the latest", backup.Timestamp)
+	}
+	return stored, nil
+}
+
+// resetLeaderboard empties the public leaderboard; it fills up again from
+// users.pixelCount as pixels are placed. Moving refreshedAt keeps a rebuild
+// that read the old counts from writing them back.
+func resetLeaderboard(ctx context.Context) error {
+	_, err := leaderboardRef().Set(ctx, map[string]interface{}{
+		"entries":     []interface{}{},
+		"version":     firestore.Increment(1),
+		"updatedAt":   time.Now().UTC().Format(time.RFC3339),
+		"refreshedAt": time.Now().UTC(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// endClearing restores the session status saved when the clear started. An
+// admin who changed the status in the meantime wins.
+func endClearing(ctx context.Context, job *ClearJob) error {
+	ref := getFirestore().Collection("sessions").Doc("current")
+	return getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		restored := job.PreviousStatus
+		if current, _ := doc.Data()["status"].(string); current != sessionClearing {
+			restored = current
+		} else if restored == "" {
+			restored = "active"
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: restored},
+			{Path: "resetAt", Value: time.Now().UTC().Format(time.RFC3339)},
+			{Path: "pixelsCleared", Value: job.PixelsDeleted},
+		})
+	})
+}
+
+// runClearJob advances the job until it is done or the time budget runs out.
+func runClearJob(ctx context.Context, job *ClearJob, req messages.CanvasClearRequest, deadline time.Time) (bool, error) {
+	if job.Phase == "backup" {
+		backup, err := takeClearBackup(ctx, req)
+		if err != nil {
+			return false, err
+		}
+		job.BackupTimestamp = backup.Timestamp
+		job.BackupManifestURL = backup.ManifestURL
+		job.BackupThumbnailURL = backup.ThumbnailURL
+		job.Phase = "pixels"
+		if err := saveClearJob(ctx, job); err != nil {
+			return false, err
+		}
+	}
+
+	if job.Phase == "pixels" {
+		done, err := processDocs(ctx, deadline, "pixels",
+			getFirestore().Collection("pixels").Limit(deletionPageSize),
+			func(bw *firestore.BulkWriter, ref *firestore.DocumentRef) (*firestore.BulkWriterJob, error) {
+				return bw.Delete(ref)
+			},
+			func(n int) error {
+				job.PixelsDeleted += n
+				return saveClearJob(ctx, job)
+			})
+		if err != nil || !done {
+			return false, err
+		}
+		job.Phase = "stats"
+		if err := saveClearJob(ctx, job); err != nil {
+			return false, err
+		}
+	}
+
+	if job.Phase == "stats" {
+		done, err := processDocs(ctx, deadline, "users",
+			getFirestore().Collection("users").Where("pixelCount", ">", 0).Limit(deletionPageSize),
+			func(bw *firestore.BulkWriter, ref *firestore.DocumentRef) (*firestore.BulkWriterJob, error) {
+				return bw.Update(ref, []firestore.Update{{Path: "pixelCount", Value: 0}})
+			},
+			func(n int) error {
+				job.UsersReset += n
+				return saveClearJob(ctx, job)
+			})
+		if err != nil || !done {
+			return false, err
+		}
+		if err := resetLeaderboard(ctx); err != nil {
+			return false, err
+		}
+		if err := endClearing(ctx, job); err != nil {
+			return false, err
+		}
+		job.Phase = "done"
+		job.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := saveClearJob(ctx, job); err != nil {
+			return false, err
+		}
+	}
+
+	return job.Phase == "done", nil
+}
+
+func handleCanvasClear(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "clearCanvas")
+	defer span.End()
+
+	var req messages.CanvasClearRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse clear request: %w", err)
+	}
+	if req.JobID == "" {
+		return nil
+	}
+	span.SetAttributes(
+		attribute.String("clear.job_id", req.JobID),
+		attribute.String("clear.requested_by", req.RequestedBy),
+	)
+
+	job, err := loadClearJob(ctx, req)
+	if err != nil {
+		slog.Error("canvas_clear_failed", "job_id", req.JobID, "error", err.Error())
+		return err
+	}
+	// A redelivery after the job finished has nothing left to do
+	if job.Phase == "done" {
+		return nil
+	}
+
+	done, err := runClearJob(ctx, job, req, time.Now().Add(deletionTimeBudget))
+	span.SetAttributes(
+		attribute.String("clear.phase", job.Phase),
+		attribute.Int("clear.pixels_deleted", job.PixelsDeleted),
+	)
+	if err != nil {
+		slog.Error("canvas_clear_failed", "job_id", req.JobID, "phase", job.Phase, "error", err.Error())
+		return err
+	}
+	if !done {
+		slog.Info("canvas_clear_progress", "job_id", req.JobID, "phase", job.Phase, "pixels_deleted", job.PixelsDeleted)
+		return errClearIncomplete
+	}
+
+	slog.Info("canvas_cleared",
+		"job_id", req.JobID,
+		"requested_by", req.RequestedBy,
+		"pixels_deleted", job.PixelsDeleted,
+		"users_reset", job.UsersReset,
+		"backup_manifest_url", job.BackupManifestURL,
+	)
+	audit.Record(ctx, getFirestore(), audit.Entry{
+		ActorID:   req.RequestedBy,
+		ActorName: req.RequestedByName,
+		Action:    "canvas.clear",
+		Target:    "pixels",
+		Params: map[string]interface{}{
+			"success":           true,
+			"jobId":             req.JobID,
+			"pixelsDeleted":     job.PixelsDeleted,
+			"usersReset":        job.UsersReset,
+			"backupManifestUrl": job.BackupManifestURL,
+		},
+	})
+
+	if req.ChannelID != "" {
+		sendChannelMessage(req.ChannelID, fmt.Sprintf("🧹 Canvas cleared by **%s**: %d pixels removed.\nBackup snapshot: %s\nManifest: %s",
+			req.RequestedByName, job.PixelsDeleted, job.BackupThumbnailURL, job.BackupManifestURL))
+	}
+	sendEphemeralFollowUp(req.ApplicationID, req.InteractionToken,
+		fmt.Sprintf("Canvas cleared. %d pixels removed; the backup snapshot is at %s", job.PixelsDeleted, job.BackupManifestURL))
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
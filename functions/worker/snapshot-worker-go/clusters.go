@@ -0,0 +1,96 @@
+package snapshotworker
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Cluster is a group of nearby pixels as cached in pixel_clusters by the
+// cluster analysis run. Bounds are canvas coordinates, inclusive.
+type Cluster struct {
+	ID         int `firestore:"id"`
+	MinX       int `firestore:"minX"`
+	MinY       int `firestore:"minY"`
+	MaxX       int `firestore:"maxX"`
+	MaxY       int `firestore:"maxY"`
+	PixelCount int `firestore:"pixelCount"`
+}
+
+func getClusters(ctx context.Context) ([]Cluster, error) {
+	docs, err := getFirestore().Collection("pixel_clusters").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	clusters := make([]Cluster, 0, len(docs))
+	for _, doc := range docs {
+		var c Cluster
+		if err := doc.DataTo(&c); err != nil {
+			continue
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters, nil
+}
+
+// clusterColor picks a distinct, saturated color per cluster by rotating the
+// hue with the golden angle.
+func clusterColor(id int) color.RGBA {
+	h := math.Mod(float64(id)*137.508, 360)
+	return hsvToRGBA(h, 0.9, 0.9)
+}
+
+func hsvToRGBA(h, s, v float64) color.RGBA {
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 255}
+}
+
+// drawClusterOverlays outlines each cluster's bounding box with a 1px
+// rectangle. scale maps canvas coordinates to image coordinates.
+func drawClusterOverlays(img *image.RGBA, clusters []Cluster, scale float64) {
+	for _, c := range clusters {
+		outlineRect(img, c.MinX, c.MinY, c.MaxX, c.MaxY, scale, clusterColor(c.ID))
+	}
+}
+
+// outlineRect draws a 1px rectangle around the inclusive canvas bounds and
+// returns the clipped image rectangle, empty when it lies off the image.
+func outlineRect(img *image.RGBA, minX, minY, maxX, maxY int, scale float64, col color.RGBA) image.Rectangle {
+	x0 := int(float64(minX) * scale)
+	y0 := int(float64(minY) * scale)
+	x1 := int(float64(maxX) * scale)
+	y1 := int(float64(maxY) * scale)
+
+	r := image.Rect(x0, y0, x1+1, y1+1).Intersect(img.Bounds())
+	if r.Empty() {
+		return r
+	}
+	for x := r.Min.X; x < r.Max.X; x++ {
+		img.SetRGBA(x, r.Min.Y, col)
+		img.SetRGBA(x, r.Max.Y-1, col)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		img.SetRGBA(r.Min.X, y, col)
+		img.SetRGBA(r.Max.X-1, y, col)
+	}
+	return r
+}
@@ -0,0 +1,190 @@
+package snapshotworker
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+const (
+	colorChartTop       = 10
+	colorChartWidth     = 800
+	colorChartPadding   = 16
+	colorChartBarHeight = 28
+	colorChartBarGap    = 8
+	// Labels use the 5x7 font drawn at 2x, 6px per glyph including spacing
+	colorChartFontScale = 2
+	colorChartLabelW    = 8 * 6 * colorChartFontScale
+	colorChartCountW    = 8 * 6 * colorChartFontScale
+)
+
+//go:embed fonts/font5x7.txt
+var font5x7Source string
+
+// font5x7 maps each glyph to its 7 rows; bit 4 is the leftmost column
+var font5x7 = parseFont5x7(font5x7Source)
+
+// parseFont5x7 reads blocks of a glyph line followed by 7 rows of '#'/'.'
+func parseFont5x7(src string) map[byte][7]uint8 {
+	glyphs := make(map[byte][7]uint8)
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for i := 0; i+7 < len(lines); i += 8 {
+		if len(lines[i]) != 1 {
+			break
+		}
+		var rows [7]uint8
+		for r := 0; r < 7; r++ {
+			for c, ch := range lines[i+1+r] {
+				if ch == '#' && c < 5 {
+					rows[r] |= 1 << (4 - c)
+				}
+			}
+		}
+		glyphs[lines[i][0]] = rows
+	}
+	return glyphs
+}
+
+// drawText renders s with the 5x7 font; characters without a glyph are
+// left blank but still advance.
+func drawText(img *image.RGBA, x, y int, s string, scale int, c color.Color) {
+	src := &image.Uniform{c}
+	for i := 0; i < len(s); i++ {
+		rows, ok := font5x7[s[i]]
+		if ok {
+			for r := 0; r < 7; r++ {
+				for col := 0; col < 5; col++ {
+					if rows[r]&(1<<(4-col)) == 0 {
+						continue
+					}
+					px, py := x+col*scale, y+r*scale
+					draw.Draw(img, image.Rect(px, py, px+scale, py+scale), src, image.Point{}, draw.Src)
+				}
+			}
+		}
+		x += 6 * scale
+	}
+}
+
+type colorCount struct {
+	Color string
+	Count int
+}
+
+// topColors ranks colors by count, highest first; ties sort by hex code
+func topColors(colorCounts map[string]int, n int) []colorCount {
+	ranked := make([]colorCount, 0, len(colorCounts))
+	for c, k := range colorCounts {
+		ranked = append(ranked, colorCount{Color: c, Count: k})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Color < ranked[j].Color
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// generateColorChart draws a horizontal bar chart of the top colors. Each bar
+// is filled with its own color and its width is proportional to its count;
+// the hex code is on the left and the count on the right.
+func generateColorChart(colorCounts map[string]int) []byte {
+	ranked := topColors(colorCounts, colorChartTop)
+	rows := max(1, len(ranked))
+	height := 2*colorChartPadding + rows*colorChartBarHeight + (rows-1)*colorChartBarGap
+
+	img := image.NewRGBA(image.Rect(0, 0, colorChartWidth, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	if len(ranked) == 0 {
+		return encodePNG(img)
+	}
+
+	text := color.RGBA{0x33, 0x33, 0x33, 0xFF}
+	outline := color.RGBA{0xCC, 0xCC, 0xCC, 0xFF}
+	barX := colorChartPadding + colorChartLabelW
+	maxBarW := colorChartWidth - barX - colorChartCountW - colorChartPadding
+	textOffset := (colorChartBarHeight - 7*colorChartFontScale) / 2
+
+	for i, cc := range ranked {
+		y := colorChartPadding + i*(colorChartBarHeight+colorChartBarGap)
+		w := max(1, cc.Count*maxBarW/ranked[0].Count)
+		bar := image.Rect(barX, y, barX+w, y+colorChartBarHeight)
+
+		// A one-pixel frame keeps white and near-white bars visible
+		draw.Draw(img, bar, &image.Uniform{outline}, image.Point{}, draw.Src)
+		draw.Draw(img, bar.Inset(1), &image.Uniform{parseColor(cc.Color)}, image.Point{}, draw.Src)
+
+		drawText(img, colorChartPadding, y+textOffset, "#"+strings.ToUpper(cc.Color), colorChartFontScale, text)
+		drawText(img, barX+w+colorChartPadding/2, y+textOffset, fmt.Sprintf("%d", cc.Count), colorChartFontScale, text)
+	}
+	return encodePNG(img)
+}
+
+func handleColorChart(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "generateColorChart")
+	defer span.End()
+
+	var req messages.ColorChartRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse color chart request: %w", err)
+	}
+
+	canvasW, _ := getCanvasSize(ctx)
+	pixels, err := getPixelsPartitioned(ctx, canvasW, pixelLoad{})
+	if err != nil {
+		slog.Error("color_chart_pixels_fetch_failed", "error", err.Error())
+		sendFollowUp(req.ApplicationID, req.InteractionToken, fmt.Sprintf("Failed to get pixels: %v", err))
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, p := range pixels {
+		counts[strings.ToUpper(strings.TrimPrefix(p.Color, "#"))]++
+	}
+	span.SetAttributes(
+		attribute.Int("color_chart.pixel_count", len(pixels)),
+		attribute.Int("color_chart.distinct_colors", len(counts)),
+	)
+	if len(counts) == 0 {
+		sendFollowUp(req.ApplicationID, req.InteractionToken, "No pixels placed yet.")
+		return nil
+	}
+
+	// Charts are throwaway; the bucket lifecycle removes color-charts/ after a day
+	path := fmt.Sprintf("color-charts/%d.png", time.Now().UnixMilli())
+	url, err := uploadWithRetry(ctx, generateColorChart(counts), path, "image/png")
+	if err != nil {
+		slog.Error("color_chart_upload_failed", "error", err.Error())
+		sendFollowUp(req.ApplicationID, req.InteractionToken, "Failed to upload the color chart.")
+		return nil
+	}
+
+	slog.Info("color_chart_generated", "pixel_count", len(pixels), "distinct_colors", len(counts), "user_id", req.UserID)
+	sendFollowUpEmbed(req.ApplicationID, req.InteractionToken, map[string]interface{}{
+		"title":       "Canvas colors",
+		"description": fmt.Sprintf("Top %d of %d colors across %d pixels", min(colorChartTop, len(counts)), len(counts), len(pixels)),
+		"image":       map[string]string{"url": url},
+		"color":       0x5865F2,
+	})
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
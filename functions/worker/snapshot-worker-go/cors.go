@@ -0,0 +1,76 @@
+package snapshotworker
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+var (
+	// corsOrigins may fetch snapshot objects from a browser (SNAPSHOT_CORS_ORIGINS,
+	// comma-separated). Empty leaves the bucket's CORS configuration alone.
+	corsOrigins []string
+	// corsChecked holds the buckets already checked by this instance
+	corsChecked sync.Map
+)
+
+func init() {
+	for _, o := range strings.Split(os.Getenv("SNAPSHOT_CORS_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			corsOrigins = append(corsOrigins, o)
+		}
+	}
+}
+
+// snapshotsCORS is the policy the web viewer needs: read-only access to
+// manifests and tiles from the configured origins
+func snapshotsCORS(origins []string) []storage.CORS {
+	return []storage.CORS{{
+		Origins:         origins,
+		Methods:         []string{"GET", "HEAD"},
+		ResponseHeaders: []string{"Content-Type", "Cache-Control", "x-goog-hash"},
+		MaxAge:          time.Hour,
+	}}
+}
+
+// ensureBucketCORS applies snapshotsCORS to a snapshots bucket once per
+// instance, on the first snapshot stored in it. The bucket is only updated
+// when its current policy differs. Failures are logged and never fail the
+// snapshot.
+func ensureBucketCORS(ctx context.Context, name string) {
+	if len(corsOrigins) == 0 {
+		return
+	}
+	if _, checked := corsChecked.LoadOrStore(name, true); checked {
+		return
+	}
+	bucket := getStorage().Bucket(name)
+	want := snapshotsCORS(corsOrigins)
+
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		slog.Warn("bucket_cors_check_failed", "bucket", name, "error", err.Error())
+		return
+	}
+	if reflect.DeepEqual(attrs.CORS, want) {
+		return
+	}
+
+	if _, err := bucket.Update(ctx, storage.BucketAttrsToUpdate{CORS: want}); err != nil {
+		slog.Warn("bucket_cors_update_failed", "bucket", name, "error", err.Error())
+		return
+	}
+	slog.Info("bucket_cors_applied",
+		"bucket", name,
+		"origins", want[0].Origins,
+		"methods", want[0].Methods,
+		"response_headers", want[0].ResponseHeaders,
+		"max_age_seconds", int(want[0].MaxAge.Seconds()),
+	)
+}
@@ -0,0 +1,315 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/team11/snapshot-worker/internal/audit"
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+const (
+	deletionPageSize = 500
+	// Leave headroom before the 300s function timeout; an unfinished job
+	// returns an error so Pub/Sub redelivers it and it resumes.
+	deletionTimeBudget = 240 * time.Second
+	anonymizedUser     = "deleted"
+)
+
+var errDeletionIncomplete = errors.New("user data deletion incomplete, will resume on redelivery")
+
+// DeletionJob tracks progress in deletion_jobs/{userId}. Phases run in order:
+// pixels (anonymize) -> pixel_history (anonymize) -> rate_limits (delete) ->
+// user (delete) -> done.
+type DeletionJob struct {
+	UserID            string `firestore:"userId"`
+	RequestedBy       string `firestore:"requestedBy"`
+	Phase             string `firestore:"phase"`
+	PixelsAnonymized  int    `firestore:"pixelsAnonymized"`
+	HistoryAnonymized int    `firestore:"historyAnonymized"`
+	RateLimitsDeleted int    `firestore:"rateLimitsDeleted"`
+	StartedAt         string `firestore:"startedAt"`
+	UpdatedAt         string `firestore:"updatedAt"`
+	CompletedAt       string `firestore:"completedAt,omitempty"`
+}
+
+func loadDeletionJob(ctx context.Context, req messages.DataDeletionRequest) (*DeletionJob, error) {
+	ref := getFirestore().Collection("deletion_jobs").Doc(req.UserID)
+	doc, err := ref.Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+
+	var job DeletionJob
+	if err == nil {
+		if err := doc.DataTo(&job); err != nil {
+			return nil, err
+		}
+		if job.Phase != "done" {
+			return &job, nil
+		}
+	}
+
+	// New job, or a fresh request after an earlier deletion completed
+	now := time.Now().UTC().Format(time.RFC3339)
+	job = DeletionJob{
+		UserID:      req.UserID,
+		RequestedBy: req.RequestedBy,
+		Phase:       "pixels",
+		StartedAt:   now,
+		UpdatedAt:   now,
+	}
+	if _, err := ref.Set(ctx, job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func saveDeletionJob(ctx context.Context, job *DeletionJob) error {
+	job.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	_, err := getFirestore().Collection("deletion_jobs").Doc(job.UserID).Set(ctx, job)
+	return err
+}
+
+// processUserDocs applies write to every document of collection owned by
+// userID, one page at a time.
+func processUserDocs(ctx context.Context, deadline time.Time, collection, userID string,
+	write func(*firestore.BulkWriter, *firestore.DocumentRef) (*firestore.BulkWriterJob, error),
+	progress func(n int) error) (bool, error) {
+
+	q := getFirestore().Collection(collection).Where("userId", "==", userID).Limit(deletionPageSize)
+	return processDocs(ctx, deadline, collection, q, write, progress)
+}
+
+// processDocs applies write to every document q matches, one page at a time.
+// write must make the document stop matching q (anonymize or delete), so
+// each page picks up where the last left off.
+func processDocs(ctx context.Context, deadline time.Time, collection string, q firestore.Query,
+	write func(*firestore.BulkWriter, *firestore.DocumentRef) (*firestore.BulkWriterJob, error),
+	progress func(n int) error) (bool, error) {
+
+	for time.Now().Before(deadline) {
+		docs, err := q.Documents(ctx).GetAll()
+		if err != nil {
+			return false, err
+		}
+		if len(docs) == 0 {
+			return true, nil
+		}
+
+		bw := getFirestore().BulkWriter(ctx)
+		jobs := make([]*firestore.BulkWriterJob, 0, len(docs))
+		for _, doc := range docs {
+			job, err := write(bw, doc.Ref)
+			if err != nil {
+				bw.End()
+				return false, err
+			}
+			jobs = append(jobs, job)
+		}
+		bw.End()
+
+		written := 0
+		for _, job := range jobs {
+			if _, err := job.Results(); err == nil {
+				written++
+			}
+		}
+		if err := progress(written); err != nil {
+			return false, err
+		}
+		if written == 0 {
+			return false, fmt.Errorf("%s: no writes succeeded", collection)
+		}
+	}
+	return false, nil
+}
+
+// runDeletionJob advances the job until it is done or the time budget runs out.
+func runDeletionJob(ctx context.Context, job *DeletionJob, deadline time.Time) (bool, error) {
+	if job.Phase == "pixels" {
+		done, err := processUserDocs(ctx, deadline, "pixels", job.UserID,
+			func(bw *firestore.BulkWriter, ref *firestore.DocumentRef) (*firestore.BulkWriterJob, error) {
+				return bw.Update(ref, []firestore.Update{
+					{Path: "userId", Value: anonymizedUser},
+					{Path: "username", Value: anonymizedUser},
+				})
+			},
+			func(n int) error {
+				job.PixelsAnonymized += n
+				return saveDeletionJob(ctx, job)
+			})
+		if err != nil || !done {
+			return false, err
+		}
+		job.Phase = "pixel_history"
+		if err := saveDeletionJob(ctx, job); err != nil {
+			return false, err
+		}
+	}
+
+	if job.Phase == "pixel_history" {
+		done, err := processUserDocs(ctx, deadline, "pixel_history", job.UserID,
+			func(bw *firestore.BulkWriter, ref *firestore.DocumentRef) (*firestore.BulkWriterJob, error) {
+				return bw.Update(ref, []firestore.Update{
+					{Path: "userId", Value: anonymizedUser},
+					{Path: "username", Value: anonymizedUser},
+				})
+			},
+			func(n int) error {
+				job.HistoryAnonymized += n
+				return saveDeletionJob(ctx, job)
+			})
+		if err != nil || !done {
+			return false, err
+		}
+		job.Phase = "rate_limits"
+		if err := saveDeletionJob(ctx, job); err != nil {
+			return false, err
+		}
+	}
+
+	if job.Phase == "rate_limits" {
+		done, err := processUserDocs(ctx, deadline, "rate_limits", job.UserID,
+			func(bw *firestore.BulkWriter, ref *firestore.DocumentRef) (*firestore.BulkWriterJob, error) {
+				return bw.Delete(ref)
+			},
+			func(n int) error {
+				job.RateLimitsDeleted += n
+				return saveDeletionJob(ctx, job)
+			})
+		if err != nil || !done {
+			return false, err
+		}
+		job.Phase = "user"
+		if err := saveDeletionJob(ctx, job); err != nil {
+			return false, err
+		}
+	}
+
+	if job.Phase == "user" {
+		if err := removeFromLeaderboard(ctx, job.UserID); err != nil {
+			return false, err
+		}
+		if _, err := getFirestore().Collection("overwrite_notices").Doc(job.UserID).Delete(ctx); err != nil {
+			return false, err
+		}
+		// A later pixel placement simply recreates a fresh user document
+		if _, err := getFirestore().Collection("users").Doc(job.UserID).Delete(ctx); err != nil {
+			return false, err
+		}
+		job.Phase = "done"
+		job.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := saveDeletionJob(ctx, job); err != nil {
+			return false, err
+		}
+	}
+
+	return job.Phase == "done", nil
+}
+
+// removeFromLeaderboard drops the user from the public leaderboard document,
+// and like resetLeaderboard keeps an earlier rebuild from adding them back.
+func removeFromLeaderboard(ctx context.Context, userID string) error {
+	ref := leaderboardRef()
+	return getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		entries, _ := doc.Data()["entries"].([]interface{})
+		kept := make([]interface{}, 0, len(entries))
+		for _, e := range entries {
+			if m, ok := e.(map[string]interface{}); ok && m["userId"] == userID {
+				continue
+			}
+			kept = append(kept, e)
+		}
+		if len(kept) == len(entries) {
+			return nil
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "entries", Value: kept},
+			{Path: "version", Value: firestore.Increment(1)},
+			{Path: "updatedAt", Value: time.Now().UTC().Format(time.RFC3339)},
+			{Path: "refreshedAt", Value: time.Now().UTC()},
+		})
+	})
+}
+
+func handleDataDeletion(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "deleteUserData")
+	defer span.End()
+
+	var req messages.DataDeletionRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse deletion request: %w", err)
+	}
+	if req.UserID == "" || req.UserID == anonymizedUser {
+		return nil
+	}
+	span.SetAttributes(
+		attribute.String("deletion.user_id", req.UserID),
+		attribute.String("deletion.requested_by", req.RequestedBy),
+	)
+
+	job, err := loadDeletionJob(ctx, req)
+	if err != nil {
+		slog.Error("user_data_deletion_failed", "user_id", req.UserID, "error", err.Error())
+		return err
+	}
+
+	done, err := runDeletionJob(ctx, job, time.Now().Add(deletionTimeBudget))
+	span.SetAttributes(
+		attribute.String("deletion.phase", job.Phase),
+		attribute.Int("deletion.pixels_anonymized", job.PixelsAnonymized),
+	)
+	if err != nil {
+		slog.Error("user_data_deletion_failed", "user_id", req.UserID, "phase", job.Phase, "error", err.Error())
+		return err
+	}
+	if !done {
+		slog.Info("user_data_deletion_progress", "user_id", req.UserID, "phase", job.Phase, "pixels_anonymized", job.PixelsAnonymized)
+		return errDeletionIncomplete
+	}
+
+	slog.Info("user_data_deleted",
+		"user_id", req.UserID,
+		"requested_by", req.RequestedBy,
+		"pixels_anonymized", job.PixelsAnonymized,
+		"history_anonymized", job.HistoryAnonymized,
+		"rate_limits_deleted", job.RateLimitsDeleted,
+	)
+	audit.Record(ctx, getFirestore(), audit.Entry{
+		ActorID:   req.RequestedBy,
+		ActorName: req.RequestedByName,
+		Action:    "user_data.delete",
+		Target:    req.UserID,
+		Params: map[string]interface{}{
+			"success":           true,
+			"pixelsAnonymized":  job.PixelsAnonymized,
+			"historyAnonymized": job.HistoryAnonymized,
+			"rateLimitsDeleted": job.RateLimitsDeleted,
+		},
+	})
+
+	sendEphemeralFollowUp(req.ApplicationID, req.InteractionToken,
+		fmt.Sprintf("Data deleted. %d pixels were anonymized and %d rate-limit records removed.", job.PixelsAnonymized, job.RateLimitsDeleted))
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package snapshotworker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// Emulator tests read and write the Firestore emulator like the pixel
+// worker's: `docker compose up firestore`, then go test with
+// FIRESTORE_EMULATOR_HOST=localhost:8080. They are skipped without it and
+// with -short.
+
+// requireEmulator skips the test unless the emulator is configured, and
+// otherwise empties it and the caches filled from it.
+func requireEmulator(t testing.TB) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("emulator test skipped with -short")
+	}
+	host := os.Getenv("FIRESTORE_EMULATOR_HOST")
+	if host == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	if projectID == "" {
+		projectID = "team11-local"
+	}
+
+	url := fmt.Sprintf("http://%s/emulator/v1/projects/%s/databases/team11-database/documents", host, projectID)
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("clear emulator: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("clear emulator: %s", resp.Status)
+	}
+
+	canvasSizeMu.Lock()
+	canvasSizeCached.at = time.Time{}
+	canvasSizeMu.Unlock()
+}
+
+// seedDoc writes a document at path, e.g. "pixels/4_4"
+func seedDoc(t testing.TB, path string, data map[string]interface{}) {
+	t.Helper()
+	if _, err := getFirestore().Doc(path).Set(context.Background(), data); err != nil {
+		t.Fatalf("seed %s: %v", path, err)
+	}
+}
+
+// seedPixels writes pixels and an active session of the given size
+func seedPixels(t testing.TB, width, height int, pixels []Pixel) {
+	t.Helper()
+	seedDoc(t, "sessions/current", map[string]interface{}{"status": "active", "canvasWidth": width, "canvasHeight": height})
+	bw := getFirestore().BulkWriter(context.Background())
+	for _, p := range pixels {
+		ref := getFirestore().Collection("pixels").Doc(fmt.Sprintf("%d_%d", p.X, p.Y))
+		if _, err := bw.Set(ref, map[string]interface{}{"x": p.X, "y": p.Y, "color": p.Color, "userId": "u1"}); err != nil {
+			t.Fatalf("seed pixel %d,%d: %v", p.X, p.Y, err)
+		}
+	}
+	bw.End()
+}
+
+// readDoc returns the document at path, or nil when it does not exist
+func readDoc(t *testing.T, path string) map[string]interface{} {
+	t.Helper()
+	doc, err := getFirestore().Doc(path).Get(context.Background())
+	if err != nil {
+		if !doc.Exists() {
+			return nil
+		}
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return doc.Data()
+}
@@ -0,0 +1,191 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/api/iterator"
+
+	"github.com/team11/snapshot-worker/internal/audit"
+	"github.com/team11/snapshot-worker/internal/discord"
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+const (
+	exportPageSize  = 500
+	exportURLExpiry = 24 * time.Hour
+)
+
+// UserDataExport is the JSON document handed to the user
+type UserDataExport struct {
+	ExportedAt string                   `json:"exportedAt"`
+	UserID     string                   `json:"userId"`
+	User       map[string]interface{}   `json:"user"`
+	Pixels     []map[string]interface{} `json:"pixels"`
+	RateLimits []map[string]interface{} `json:"rateLimits"`
+}
+
+// collectUserDocs pages through every document matching userId == id.
+func collectUserDocs(ctx context.Context, collection, userID string) ([]map[string]interface{}, error) {
+	var out []map[string]interface{}
+	q := getFirestore().Collection(collection).
+		Where("userId", "==", userID).
+		OrderBy(firestore.DocumentID, firestore.Asc).
+		Limit(exportPageSize)
+
+	var last *firestore.DocumentSnapshot
+	for {
+		page := q
+		if last != nil {
+			page = q.StartAfter(last)
+		}
+		iter := page.Documents(ctx)
+		n := 0
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return nil, err
+			}
+			data := doc.Data()
+			data["id"] = doc.Ref.ID
+			out = append(out, data)
+			last = doc
+			n++
+		}
+		iter.Stop()
+		if n < exportPageSize {
+			return out, nil
+		}
+	}
+}
+
+func buildUserDataExport(ctx context.Context, userID string) (*UserDataExport, error) {
+	export := &UserDataExport{
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		UserID:     userID,
+	}
+
+	if doc, err := getFirestore().Collection("users").Doc(userID).Get(ctx); err == nil {
+		export.User = doc.Data()
+	}
+
+	pixels, err := collectUserDocs(ctx, "pixels", userID)
+	if err != nil {
+		return nil, fmt.Errorf("pixels: %w", err)
+	}
+	export.Pixels = pixels
+
+	rateLimits, err := collectUserDocs(ctx, "rate_limits", userID)
+	if err != nil {
+		return nil, fmt.Errorf("rate limits: %w", err)
+	}
+	export.RateLimits = rateLimits
+
+	return export, nil
+}
+
+// uploadPrivate stores an object and returns a signed URL valid for expiry.
+// Unlike upload, it never falls back to a public URL.
+func uploadPrivate(ctx context.Context, bucket string, data []byte, path, contentType string, expiry time.Duration) (string, error) {
+	w := getStorage().Bucket(bucket).Object(path).NewWriter(ctx)
+	w.ContentType = contentType
+	w.CacheControl = "private, no-store"
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return getStorage().Bucket(bucket).SignedURL(path, &storage.SignedURLOptions{
+		Method:  "GET",
+		Expires: time.Now().Add(expiry),
+	})
+}
+
+func sendEphemeralFollowUp(appID, token, content string) {
+	if discordBotToken == "" {
+		return
+	}
+	discordClient.FollowUp(context.Background(), appID, token, discord.Message{
+		Content: content,
+		Flags:   discord.FlagEphemeral,
+	})
+}
+
+func handleDataExport(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "exportUserData")
+	defer span.End()
+
+	var req messages.DataExportRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse export request: %w", err)
+	}
+	span.SetAttributes(attribute.String("export.user_id", req.UserID))
+
+	fail := func(err error) error {
+		slog.Error("user_data_export_failed", "user_id", req.UserID, "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		audit.Record(ctx, getFirestore(), audit.Entry{
+			ActorID:   req.UserID,
+			ActorName: req.Username,
+			Action:    "user_data.export",
+			Target:    req.UserID,
+			Params:    map[string]interface{}{"success": false, "error": err.Error()},
+		})
+		sendEphemeralFollowUp(req.ApplicationID, req.InteractionToken, "Failed to export your data. Please try again later.")
+		return nil
+	}
+
+	export, err := buildUserDataExport(ctx, req.UserID)
+	if err != nil {
+		return fail(err)
+	}
+
+	payload, _ := json.MarshalIndent(export, "", "  ")
+	path := fmt.Sprintf("exports/%s/%d.json", req.UserID, time.Now().UnixMilli())
+	url, err := uploadPrivate(ctx, exportsBucket, payload, path, "application/json", exportURLExpiry)
+	if err != nil {
+		return fail(err)
+	}
+
+	slog.Info("user_data_exported",
+		"user_id", req.UserID,
+		"pixel_count", len(export.Pixels),
+		"rate_limit_count", len(export.RateLimits),
+		"object", path,
+	)
+	span.SetAttributes(attribute.Int("export.pixel_count", len(export.Pixels)))
+
+	audit.Record(ctx, getFirestore(), audit.Entry{
+		ActorID:   req.UserID,
+		ActorName: req.Username,
+		Action:    "user_data.export",
+		Target:    req.UserID,
+		Params: map[string]interface{}{
+			"success":    true,
+			"object":     path,
+			"pixelCount": len(export.Pixels),
+		},
+	})
+
+	sendEphemeralFollowUp(req.ApplicationID, req.InteractionToken,
+		fmt.Sprintf("Your data export is ready (%d pixels). This link expires in 24 hours:\n%s", len(export.Pixels), url))
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package snapshotworker
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// fakeGCS is an in-memory stand-in for the GCS JSON upload API. It checks
+// the CRC32C and MD5 a writer sends against the bytes it received, as GCS
+// does, and fail can refuse chosen attempts with a status and message.
+type fakeGCS struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	attempts map[string]int
+	fail     func(bucket, name string, attempt int) (int, string)
+}
+
+// useFakeGCS points getStorage at a fresh fakeGCS and shortens the upload
+// backoff for the rest of the test.
+func useFakeGCS(t *testing.T) *fakeGCS {
+	t.Helper()
+	f := &fakeGCS{objects: map[string][]byte{}, attempts: map[string]int{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	t.Setenv("STORAGE_EMULATOR_HOST", srv.URL)
+
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		t.Fatalf("storage client: %v", err)
+	}
+	stOnce.Do(func() {})
+	prevClient, prevDelay, prevJitter := stClient, uploadBaseDelay, uploadJitter
+	stClient, uploadBaseDelay, uploadJitter = client, time.Millisecond, time.Millisecond
+	t.Cleanup(func() {
+		stClient, uploadBaseDelay, uploadJitter = prevClient, prevDelay, prevJitter
+		client.Close()
+	})
+	return f
+}
+
+// object returns a stored object and whether it exists
+func (f *fakeGCS) object(bucket, name string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[bucket+"/"+name]
+	return data, ok
+}
+
+// attemptsFor returns how many uploads of an object were received
+func (f *fakeGCS) attemptsFor(bucket, name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.attempts[bucket+"/"+name]
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := strings.CutPrefix(r.URL.Path, "/upload/storage/v1/b/")
+	bucket, ok2 := strings.CutSuffix(bucket, "/o")
+	if r.Method != http.MethodPost || !ok || !ok2 {
+		writeGCSError(w, http.StatusNotImplemented, "fakeGCS only handles uploads")
+		return
+	}
+
+	meta, data, err := readMultipartUpload(r)
+	if err != nil {
+		writeGCSError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name, _ := meta["name"].(string)
+	key := bucket + "/" + name
+
+	f.mu.Lock()
+	f.attempts[key]++
+	attempt := f.attempts[key]
+	f.mu.Unlock()
+
+	if f.fail != nil {
+		if code, msg := f.fail(bucket, name, attempt); code != 0 {
+			writeGCSError(w, code, msg)
+			return
+		}
+	}
+	if crc, ok := meta["crc32c"].(string); ok && crc != objectCRC32C(data) {
+		writeGCSError(w, http.StatusBadRequest, fmt.Sprintf("Provided CRC32C %q doesn't match calculated CRC32C %q.", crc, objectCRC32C(data)))
+		return
+	}
+	sum := md5.Sum(data)
+	if md5Hash, ok := meta["md5Hash"].(string); ok && md5Hash != base64.StdEncoding.EncodeToString(sum[:]) {
+		writeGCSError(w, http.StatusBadRequest, "Provided MD5 hash doesn't match calculated MD5 hash.")
+		return
+	}
+
+	f.mu.Lock()
+	f.objects[key] = data
+	f.mu.Unlock()
+
+	meta["bucket"] = bucket
+	meta["size"] = fmt.Sprint(len(data))
+	meta["generation"] = "1"
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
+
+// readMultipartUpload splits a multipart upload into its JSON metadata and
+// object bytes
+func readMultipartUpload(r *http.Request) (map[string]any, []byte, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, err
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+
+	part, err := mr.NextPart()
+	if err != nil {
+		return nil, nil, err
+	}
+	var meta map[string]any
+	if err := json.NewDecoder(part).Decode(&meta); err != nil {
+		return nil, nil, err
+	}
+	part, err = mr.NextPart()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(part)
+	return meta, data, err
+}
+
+func writeGCSError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"code": code, "message": msg},
+	})
+}
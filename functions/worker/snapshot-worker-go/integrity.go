@@ -0,0 +1,41 @@
+package snapshotworker
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+
+	"cloud.google.com/go/storage"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// objectCRC32C returns the CRC32C of data in the encoding GCS uses for
+// object metadata (base64 of the big-endian value), so clients can compare
+// it with the object's x-goog-hash header.
+func objectCRC32C(data []byte) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], crc32.Checksum(data, crc32cTable))
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// writeVerified writes data to obj with its CRC32C and MD5 attached, so GCS
+// refuses to finalize an object whose bytes were corrupted in transit.
+// uploadWithRetry retries refused writes with a fresh writer.
+func writeVerified(ctx context.Context, obj *storage.ObjectHandle, data []byte, configure func(*storage.Writer)) error {
+	sum := md5.Sum(data)
+
+	w := obj.NewWriter(ctx)
+	configure(w)
+	w.CRC32C = crc32.Checksum(data, crc32cTable)
+	w.SendCRC32C = true
+	w.MD5 = sum[:]
+
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,350 @@
+// Package discord sends messages through the Discord REST API: follow-ups
+// and edits to deferred interaction responses, channel messages and DMs.
+//
+// Every request has a timeout and is retried on rate limits (429) and server
+// errors, so callers only decide whether a failure is worth logging.
+//
+// The same package lives in each Go function module; keep the copies in sync.
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the Discord REST API version used by all functions
+const DefaultBaseURL = "https://discord.com/api/v10"
+
+// FlagEphemeral makes an interaction follow-up visible to the invoking user only
+const FlagEphemeral = 64
+
+const (
+	requestTimeout = 10 * time.Second
+	maxAttempts    = 3
+	// Longest Retry-After honored before giving up on a rate-limited request
+	maxRetryAfter = 5 * time.Second
+)
+
+// ErrMissingInteraction is returned when a follow-up has no application ID or
+// interaction token to reply to.
+var ErrMissingInteraction = errors.New("discord: missing application ID or interaction token")
+
+// APIError is a response Discord refused. Status 403 on a DM means the user
+// does not accept messages from the bot.
+type APIError struct {
+	Status int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("discord API error: %d", e.Status)
+}
+
+// Message is the body of a follow-up, edit or channel message.
+type Message struct {
+	Content    string                   `json:"content,omitempty"`
+	Embeds     []map[string]interface{} `json:"embeds,omitempty"`
+	Components []map[string]interface{} `json:"components,omitempty"`
+	Flags      int                      `json:"flags,omitempty"`
+}
+
+// Client calls the Discord API as a bot.
+type Client struct {
+	BotToken string
+	BaseURL  string
+	HTTP     *http.Client
+}
+
+// New returns a client for the public Discord API.
+func New(botToken string) *Client {
+	return &Client{
+		BotToken: botToken,
+		BaseURL:  DefaultBaseURL,
+		HTTP:     &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// FollowUp posts a new message to a deferred interaction.
+func (c *Client) FollowUp(ctx context.Context, appID, token string, msg Message) error {
+	if appID == "" || token == "" {
+		return ErrMissingInteraction
+	}
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/webhooks/%s/%s", appID, token), msg)
+}
+
+// EditOriginal replaces the deferred "thinking..." response.
+func (c *Client) EditOriginal(ctx context.Context, appID, token string, msg Message) error {
+	if appID == "" || token == "" {
+		return ErrMissingInteraction
+	}
+	return c.do(ctx, http.MethodPatch, fmt.Sprintf("/webhooks/%s/%s/messages/@original", appID, token), msg)
+}
+
+// DeleteOriginal removes the deferred "thinking..." response.
+func (c *Client) DeleteOriginal(ctx context.Context, appID, token string) error {
+	if appID == "" || token == "" {
+		return ErrMissingInteraction
+	}
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/webhooks/%s/%s/messages/@original", appID, token), nil)
+}
+
+// ChannelMessage posts msg to a channel.
+func (c *Client) ChannelMessage(ctx context.Context, channelID string, msg Message) error {
+	if channelID == "" {
+		return errors.New("discord: missing channel ID")
+	}
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/channels/%s/messages", channelID), msg)
+}
+
+// CreateDM opens (or returns the existing) DM channel with a user. Send to it
+// with ChannelMessage.
+func (c *Client) CreateDM(ctx context.Context, userID string) (string, error) {
+	if userID == "" {
+		return "", errors.New("discord: missing user ID")
+	}
+	var channel struct {
+		ID string `json:"id"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/users/@me/channels", map[string]string{"recipient_id": userID}, &channel); err != nil {
+		return "", err
+	}
+	return channel.ID, nil
+}
+
+// AddMemberRole gives a guild member a role. Adding a role the member already
+// has succeeds.
+func (c *Client) AddMemberRole(ctx context.Context, guildID, userID, roleID string) error {
+	if guildID == "" || userID == "" || roleID == "" {
+		return errors.New("discord: missing guild, user or role ID")
+	}
+	return c.do(ctx, http.MethodPut, fmt.Sprintf("/guilds/%s/members/%s/roles/%s", guildID, userID, roleID), nil)
+}
+
+// User is the part of a Discord user the functions read.
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Bot      bool   `json:"bot"`
+}
+
+// Application is the part of a Discord application the functions read. Bot
+// is the application's bot user.
+type Application struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Bot   *User  `json:"bot"`
+	Flags int    `json:"flags"`
+}
+
+// Privileged gateway intents, as application flags. The developer portal
+// sets either the flag or, for bots in fewer than 100 servers, the flag
+// shifted left by one (its "limited" variant); both count as enabled.
+const (
+	IntentPresence       = 1 << 12
+	IntentGuildMembers   = 1 << 14
+	IntentMessageContent = 1 << 18
+)
+
+// intentNames names the privileged intents in configuration and problems
+var intentNames = []struct {
+	name   string
+	intent int
+}{
+	{"presence", IntentPresence},
+	{"guild_members", IntentGuildMembers},
+	{"message_content", IntentMessageContent},
+}
+
+// ParseIntents reads a comma-separated list of privileged intents, e.g.
+// "guild_members,message_content", into intent flags.
+func ParseIntents(s string) (int, error) {
+	intents := 0
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, n := range intentNames {
+			if n.name == name {
+				intents |= n.intent
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("discord: unknown intent %q", name)
+		}
+	}
+	return intents, nil
+}
+
+// MissingIntents returns the names of the intents the application has not
+// enabled
+func (a Application) MissingIntents(intents int) []string {
+	var missing []string
+	for _, n := range intentNames {
+		if intents&n.intent != 0 && a.Flags&(n.intent|n.intent<<1) == 0 {
+			missing = append(missing, n.name)
+		}
+	}
+	return missing
+}
+
+// CurrentUser returns the user the bot token belongs to.
+func (c *Client) CurrentUser(ctx context.Context) (User, error) {
+	var user User
+	err := c.doJSON(ctx, http.MethodGet, "/users/@me", nil, &user)
+	return user, err
+}
+
+// CurrentApplication returns the application the bot token belongs to.
+func (c *Client) CurrentApplication(ctx context.Context) (Application, error) {
+	var app Application
+	err := c.doJSON(ctx, http.MethodGet, "/applications/@me", nil, &app)
+	return app, err
+}
+
+// What SelfCheck found wrong, as a SelfCheckError's Problem
+const (
+	ProblemTokenMissing        = "token_missing"
+	ProblemTokenInvalid        = "token_invalid"
+	ProblemApplicationMismatch = "application_mismatch"
+	ProblemMissingIntents      = "missing_intents"
+	ProblemUnreachable         = "unreachable"
+)
+
+// SelfCheckError names what is wrong with a client's bot token. Status is
+// Discord's answer when it refused the token.
+type SelfCheckError struct {
+	Problem string
+	Detail  string
+	Status  int
+}
+
+func (e *SelfCheckError) Error() string {
+	return fmt.Sprintf("discord self-check: %s: %s", e.Problem, e.Detail)
+}
+
+// SelfCheck confirms the bot token is accepted and, when appID is set,
+// belongs to that application, so a revoked or swapped token shows up before
+// a follow-up fails. It also checks that the application has the privileged
+// intents given, see ParseIntents. It makes two requests; callers cache the
+// result.
+func (c *Client) SelfCheck(ctx context.Context, appID string, intents int) error {
+	if c.BotToken == "" {
+		return &SelfCheckError{Problem: ProblemTokenMissing, Detail: "no bot token configured"}
+	}
+	user, err := c.CurrentUser(ctx)
+	if err != nil {
+		return selfCheckFailed("GET /users/@me", err)
+	}
+	if !user.Bot {
+		return &SelfCheckError{Problem: ProblemTokenInvalid, Detail: fmt.Sprintf("token belongs to user %s, not a bot", user.ID)}
+	}
+	app, err := c.CurrentApplication(ctx)
+	if err != nil {
+		return selfCheckFailed("GET /applications/@me", err)
+	}
+	if appID != "" && app.ID != appID {
+		return &SelfCheckError{Problem: ProblemApplicationMismatch, Detail: fmt.Sprintf("token belongs to application %s (%s), expected %s", app.ID, app.Name, appID)}
+	}
+	if app.Bot != nil && app.Bot.ID != user.ID {
+		return &SelfCheckError{Problem: ProblemApplicationMismatch, Detail: fmt.Sprintf("bot user %s is not the bot of application %s", user.ID, app.ID)}
+	}
+	if missing := app.MissingIntents(intents); len(missing) > 0 {
+		return &SelfCheckError{Problem: ProblemMissingIntents, Detail: fmt.Sprintf("application %s lacks the %s intents", app.ID, strings.Join(missing, ", "))}
+	}
+	return nil
+}
+
+// selfCheckFailed classifies a failed self-check request: Discord refusing
+// the token, or Discord not answering properly.
+func selfCheckFailed(request string, err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && (apiErr.Status == http.StatusUnauthorized || apiErr.Status == http.StatusForbidden) {
+		return &SelfCheckError{Problem: ProblemTokenInvalid, Detail: request + " was refused", Status: apiErr.Status}
+	}
+	return &SelfCheckError{Problem: ProblemUnreachable, Detail: fmt.Sprintf("%s: %v", request, err)}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, msg interface{}) error {
+	return c.doJSON(ctx, method, path, msg, nil)
+}
+
+// doJSON is do that decodes a successful response body into out, if not nil.
+func (c *Client) doJSON(ctx context.Context, method, path string, msg, out interface{}) error {
+	var payload []byte
+	if msg != nil {
+		payload, _ = json.Marshal(msg)
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = c.send(ctx, method, path, payload, out)
+		if err == nil || retryAfter < 0 || attempt == maxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryAfter):
+		}
+	}
+	return err
+}
+
+// send makes one request. A negative retryAfter means the error is final.
+func (c *Client) send(ctx context.Context, method, path string, payload []byte, out interface{}) (time.Duration, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return -1, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bot "+c.BotToken)
+
+	// Transport errors are not retried: the message may already be posted
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return -1, fmt.Errorf("discord API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+
+	apiErr := &APIError{Status: resp.StatusCode}
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		if out != nil {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return -1, fmt.Errorf("discord API response: %w", err)
+			}
+		}
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		wait := time.Second
+		if s, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil {
+			wait = time.Duration(s * float64(time.Second))
+		}
+		if wait > maxRetryAfter {
+			return -1, apiErr
+		}
+		return wait, apiErr
+	case resp.StatusCode >= 500:
+		return time.Second, apiErr
+	default:
+		return -1, apiErr
+	}
+}
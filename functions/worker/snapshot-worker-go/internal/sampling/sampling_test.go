@@ -0,0 +1,92 @@
+package sampling
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRatio(t *testing.T) {
+	tests := []struct {
+		env  string
+		want float64
+	}{
+		{"", 1},
+		{"0", 0},
+		{"0.25", 0.25},
+		{"1", 1},
+		{"-0.1", 1},
+		{"1.5", 1},
+		{"half", 1},
+	}
+	for _, tt := range tests {
+		t.Setenv("TRACE_SAMPLE_RATIO", tt.env)
+		if got := Ratio(); got != tt.want {
+			t.Errorf("Ratio() with %q = %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestForce(t *testing.T) {
+	ctx := context.Background()
+	if Forced(ctx) {
+		t.Error("a plain context is forced")
+	}
+	if !Forced(Force(ctx)) {
+		t.Error("Force did not mark the context")
+	}
+}
+
+// recorded returns whether each span was sampled, and whether it was
+// tagged as forced
+func recorded(sr *tracetest.SpanRecorder) map[string]bool {
+	forced := make(map[string]bool)
+	for _, s := range sr.Ended() {
+		tagged := false
+		for _, a := range s.Attributes() {
+			if a == attribute.Bool(SpanAttribute, true) {
+				tagged = true
+			}
+		}
+		forced[s.Name()] = tagged
+	}
+	return forced
+}
+
+func TestSampler(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(New(0)), sdktrace.WithSpanProcessor(sr))
+	tracer := tp.Tracer("test")
+
+	_, plain := tracer.Start(context.Background(), "plain")
+	plain.End()
+
+	ctx, root := tracer.Start(Force(context.Background()), "forced")
+	_, child := tracer.Start(ctx, "forced-child")
+	child.End()
+	root.End()
+
+	got := recorded(sr)
+	if _, ok := got["plain"]; ok {
+		t.Error("a span was sampled at ratio 0 without being forced")
+	}
+	for _, name := range []string{"forced", "forced-child"} {
+		if tagged, ok := got[name]; !ok || !tagged {
+			t.Errorf("span %s: sampled %v, tagged %v; want both", name, ok, tagged)
+		}
+	}
+}
+
+func TestSamplerFollowsRatio(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(New(1)), sdktrace.WithSpanProcessor(sr))
+	_, span := tp.Tracer("test").Start(context.Background(), "plain")
+	span.End()
+
+	if tagged, ok := recorded(sr)["plain"]; !ok || tagged {
+		t.Errorf("span sampled %v, tagged %v; want sampled and not tagged", ok, tagged)
+	}
+}
@@ -0,0 +1,63 @@
+package snapshotworker
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"strconv"
+)
+
+// layerOpacity is how strongly the previous thumbnail shows under a layered
+// snapshot (SNAPSHOT_LAYER_OPACITY, 0 to 1, default 0.5)
+var layerOpacity = 0.5
+
+func init() {
+	if v, err := strconv.ParseFloat(os.Getenv("SNAPSHOT_LAYER_OPACITY"), 64); err == nil && v >= 0 && v <= 1 {
+		layerOpacity = v
+	}
+}
+
+// loadPreviousThumbnail reads the thumbnail of snapshots/latest to render a
+// layered snapshot on. It returns nil and the reason when there is no
+// usable one: a layer from a canvas of another size would not line up.
+func loadPreviousThumbnail(ctx context.Context, canvasW, canvasH int) (image.Image, string) {
+	last, err := getLastSnapshot(ctx)
+	if err != nil {
+		return nil, "there is no previous snapshot"
+	}
+	if last.CanvasWidth != canvasW || last.CanvasHeight != canvasH {
+		return nil, fmt.Sprintf("the previous snapshot is %dx%d, not %dx%d", last.CanvasWidth, last.CanvasHeight, canvasW, canvasH)
+	}
+	path := fmt.Sprintf("snapshots/%d/thumbnail.png", last.Timestamp)
+	r, err := getStorage().Bucket(bucketOrDefault(last.Bucket)).Object(path).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Sprintf("its thumbnail could not be read (%v)", err)
+	}
+	defer r.Close()
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, fmt.Sprintf("its thumbnail could not be decoded (%v)", err)
+	}
+	return img, ""
+}
+
+// drawLayer scales prev to the size of dst (nearest neighbour, so pixel
+// edges stay sharp) and draws it over dst at layerOpacity.
+func drawLayer(dst *image.RGBA, prev image.Image) {
+	db, sb := dst.Bounds(), prev.Bounds()
+	scaled := image.NewRGBA(db)
+	for y := db.Min.Y; y < db.Max.Y; y++ {
+		sy := sb.Min.Y + (y-db.Min.Y)*sb.Dy()/db.Dy()
+		for x := db.Min.X; x < db.Max.X; x++ {
+			sx := sb.Min.X + (x-db.Min.X)*sb.Dx()/db.Dx()
+			scaled.Set(x, y, prev.At(sx, sy))
+		}
+	}
+	mask := image.NewUniform(color.Alpha{A: uint8(math.Round(layerOpacity * 255))})
+	draw.DrawMask(dst, db, scaled, db.Min, mask, image.Point{}, draw.Over)
+}
@@ -0,0 +1,119 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+// leaderboardSize is how many users the watched leaderboard document keeps
+const leaderboardSize = 20
+
+// leaderboardEntry is one row of leaderboards/session_leaderboard
+type leaderboardEntry struct {
+	UserID     string `firestore:"userId"`
+	Username   string `firestore:"username"`
+	PixelCount int    `firestore:"pixelCount"`
+}
+
+func leaderboardRef() *firestore.DocumentRef {
+	return getFirestore().Collection("leaderboards").Doc("session_leaderboard")
+}
+
+// leaderboardEntryFrom credits a user document the way placements are
+// credited: the /nickname display name, else the Discord username
+func leaderboardEntryFrom(userID string, data map[string]interface{}) leaderboardEntry {
+	name, _ := data["displayName"].(string)
+	if name == "" {
+		name, _ = data["username"].(string)
+	}
+	return leaderboardEntry{UserID: userID, Username: name, PixelCount: toIntVal(data["pixelCount"])}
+}
+
+// leaderboardRefreshedAt is when the rebuild last written to the board
+// started reading users; zero when it was never rebuilt
+func leaderboardRefreshedAt(data map[string]interface{}) time.Time {
+	t, _ := data["refreshedAt"].(time.Time)
+	return t
+}
+
+// handleLeaderboardRefresh rebuilds leaderboards/session_leaderboard from
+// the leaderboardSize users with the highest pixelCount, ties ordered by
+// user ID like /leaderboard. Placements commit before they request a
+// refresh, so a rebuild that started reading after RequestedAt already
+// counts them and the request is skipped; under load most are. A rebuild
+// is only written when no later one was, so the board never goes back.
+func handleLeaderboardRefresh(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "handleLeaderboardRefresh")
+	defer span.End()
+
+	var req messages.LeaderboardRefresh
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse leaderboard refresh: %w", err)
+	}
+	// An unreadable time is rebuilt for rather than dropped
+	requestedAt, err := time.Parse(time.RFC3339Nano, req.RequestedAt)
+	if err != nil {
+		requestedAt = time.Now()
+	}
+
+	ref := leaderboardRef()
+	if doc, err := ref.Get(ctx); err == nil && !leaderboardRefreshedAt(doc.Data()).Before(requestedAt) {
+		span.SetAttributes(attribute.Bool("leaderboard.skipped", true))
+		return nil
+	}
+
+	startedAt := time.Now().UTC()
+	docs, err := getFirestore().Collection("users").
+		Where("pixelCount", ">", 0).
+		OrderBy("pixelCount", firestore.Desc).
+		OrderBy(firestore.DocumentID, firestore.Desc).
+		Limit(leaderboardSize).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("read top users: %w", err)
+	}
+	entries := make([]leaderboardEntry, len(docs))
+	for i, doc := range docs {
+		entries[i] = leaderboardEntryFrom(doc.Ref.ID, doc.Data())
+	}
+
+	written := false
+	err = getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		written = false
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil && !leaderboardRefreshedAt(doc.Data()).Before(startedAt) {
+			return nil
+		}
+		written = true
+		return tx.Set(ref, map[string]interface{}{
+			"entries":     entries,
+			"version":     firestore.Increment(1),
+			"updatedAt":   time.Now().UTC().Format(time.RFC3339),
+			"refreshedAt": startedAt,
+		}, firestore.MergeAll)
+	})
+	if err != nil {
+		return fmt.Errorf("write leaderboard: %w", err)
+	}
+	span.SetAttributes(
+		attribute.Int("leaderboard.entries", len(entries)),
+		attribute.Bool("leaderboard.written", written),
+	)
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package snapshotworker
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+func TestLeaderboardEntryFrom(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want leaderboardEntry
+	}{
+		{"username", map[string]interface{}{"username": "alice", "pixelCount": int64(7)}, leaderboardEntry{"u1", "alice", 7}},
+		{"display name", map[string]interface{}{"username": "alice", "displayName": "Al", "pixelCount": int64(7)}, leaderboardEntry{"u1", "Al", 7}},
+		{"no count", map[string]interface{}{"username": "alice"}, leaderboardEntry{"u1", "alice", 0}},
+	}
+	for _, tt := range tests {
+		if got := leaderboardEntryFrom("u1", tt.data); got != tt.want {
+			t.Errorf("%s: leaderboardEntryFrom() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// refreshLeaderboard runs handleLeaderboardRefresh for a request made at
+func refreshLeaderboard(t *testing.T, at time.Time) {
+	t.Helper()
+	data, _ := json.Marshal(messages.LeaderboardRefresh{RequestedAt: at.UTC().Format(time.RFC3339Nano)})
+	if err := handleLeaderboardRefresh(t.Context(), data); err != nil {
+		t.Fatalf("handleLeaderboardRefresh: %v", err)
+	}
+}
+
+// boardUsers returns the user IDs on the leaderboard and its version
+func boardUsers(t *testing.T) ([]string, int) {
+	t.Helper()
+	board := readDoc(t, "leaderboards/session_leaderboard")
+	entries, _ := board["entries"].([]interface{})
+	users := make([]string, len(entries))
+	for i, e := range entries {
+		users[i], _ = e.(map[string]interface{})["userId"].(string)
+	}
+	return users, toIntVal(board["version"])
+}
+
+func TestLeaderboardRefresh(t *testing.T) {
+	requireEmulator(t)
+	for i := 0; i < leaderboardSize+5; i++ {
+		seedDoc(t, fmt.Sprintf("users/u%02d", i), map[string]interface{}{"username": fmt.Sprintf("user %d", i), "pixelCount": i / 2})
+	}
+
+	refreshLeaderboard(t, time.Now())
+	users, version := boardUsers(t)
+	// Highest count first, ties by descending user ID; u00 and u01 have none
+	var want []string
+	for i := leaderboardSize + 4; i > 4; i-- {
+		want = append(want, fmt.Sprintf("u%02d", i))
+	}
+	if !slices.Equal(users, want) || version != 1 {
+		t.Fatalf("board = %v at version %d, want %v at version 1", users, version, want)
+	}
+
+	// A request the last rebuild already covered is skipped
+	seedDoc(t, "users/u00", map[string]interface{}{"username": "user 0", "pixelCount": 100})
+	refreshLeaderboard(t, time.Now().Add(-time.Minute))
+	if users, version := boardUsers(t); version != 1 || users[0] == "u00" {
+		t.Fatalf("covered request rebuilt the board: %v at version %d", users, version)
+	}
+
+	refreshLeaderboard(t, time.Now())
+	if users, version := boardUsers(t); version != 2 || users[0] != "u00" {
+		t.Fatalf("board = %v at version %d, want u00 first at version 2", users, version)
+	}
+}
+
+func TestLeaderboardRefreshAfterReset(t *testing.T) {
+	requireEmulator(t)
+	seedDoc(t, "users/u1", map[string]interface{}{"username": "alice", "pixelCount": 3})
+	requested := time.Now()
+
+	if err := resetLeaderboard(t.Context()); err != nil {
+		t.Fatalf("resetLeaderboard: %v", err)
+	}
+	// Requested before the reset, so the reset stands
+	refreshLeaderboard(t, requested)
+	if users, _ := boardUsers(t); len(users) != 0 {
+		t.Fatalf("board = %v after a reset, want it empty", users)
+	}
+}
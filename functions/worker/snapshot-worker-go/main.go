@@ -2,8 +2,12 @@ package snapshotworker
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
@@ -12,9 +16,11 @@ import (
 	"log"
 	"log/slog"
 	"math"
-	"net/http"
 	"os"
 	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,18 +35,43 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
+
+	"github.com/team11/snapshot-worker/internal/audit"
+	"github.com/team11/snapshot-worker/internal/discord"
+	"github.com/team11/snapshot-worker/internal/flowcontrol"
+	"github.com/team11/snapshot-worker/internal/messages"
+	"github.com/team11/snapshot-worker/internal/sampling"
 )
 
 const (
-	tileSize         = 2048
-	thumbnailMaxSize = 800
-	discordAPI       = "https://discord.com/api/v10"
+	tileSize = 2048
+	// Longest side of a thumbnail, before SNAPSHOT_PIXEL_SCALE: the default,
+	// and the range THUMBNAIL_MAX_SIZE and requests may choose from
+	defaultThumbnailSize = 800
+	minThumbnailSize     = 100
+	maxThumbnailSize     = 4096
+
+	// Largest SNAPSHOT_PIXEL_SCALE accepted. Zoomed thumbnails are also kept
+	// within maxThumbnailSize, so a large scale only helps small canvases.
+	maxPixelScale = 16
+
+	// Pixel reads are split into x-ranges queried concurrently. Canvases
+	// narrower than partitionMinWidth use a single query.
+	pixelReadPartitions = 16
+	partitionMinWidth   = 1024
 )
 
 var (
 	projectID       string
 	snapshotsBucket string
+	mirrorBuckets   []string
+	exportsBucket   string
+	includeClusters bool
+	pixelScale      int
+	thumbnailSize   int
+	intake          *flowcontrol.Limiter
 	discordBotToken string
+	discordClient   *discord.Client
 	fsClient        *firestore.Client
 	stClient        *storage.Client
 	fsOnce          sync.Once
@@ -52,7 +83,33 @@ var (
 func init() {
 	projectID = os.Getenv("PROJECT_ID")
 	snapshotsBucket = os.Getenv("SNAPSHOTS_BUCKET")
+	// SNAPSHOTS_BUCKETS adds mirrors; its first entry is the primary when
+	// SNAPSHOTS_BUCKET is unset
+	for _, b := range strings.Split(os.Getenv("SNAPSHOTS_BUCKETS"), ",") {
+		if b = strings.TrimSpace(b); b == "" {
+			continue
+		}
+		if snapshotsBucket == "" {
+			snapshotsBucket = b
+		} else if b != snapshotsBucket && !slices.Contains(mirrorBuckets, b) {
+			mirrorBuckets = append(mirrorBuckets, b)
+		}
+	}
+	// Exports hold personal data, so they never fall back to the public
+	// snapshots bucket
+	exportsBucket = os.Getenv("USER_EXPORTS_BUCKET")
+	includeClusters = os.Getenv("SNAPSHOT_INCLUDE_CLUSTERS") == "true"
+	pixelScale = 1
+	if v, err := strconv.Atoi(os.Getenv("SNAPSHOT_PIXEL_SCALE")); err == nil && v > 1 {
+		pixelScale = min(v, maxPixelScale)
+	}
+	thumbnailSize = defaultThumbnailSize
+	if v, err := strconv.Atoi(os.Getenv("THUMBNAIL_MAX_SIZE")); err == nil && v >= minThumbnailSize && v <= maxThumbnailSize {
+		thumbnailSize = v
+	}
+	intake = flowcontrol.NewLimiter(flowcontrol.SettingsFromEnv())
 	discordBotToken = strings.TrimSpace(os.Getenv("DISCORD_BOT_TOKEN"))
+	discordClient = discord.New(discordBotToken)
 
 	// Initialize OpenTelemetry with GCP Cloud Trace exporter
 	ctx := context.Background()
@@ -65,6 +122,7 @@ func init() {
 		tracerProvider = sdktrace.NewTracerProvider(
 			sdktrace.WithBatcher(exporter),
 			sdktrace.WithResource(res),
+			sdktrace.WithSampler(sampling.New(sampling.Ratio())),
 		)
 		otel.SetTracerProvider(tracerProvider)
 	}
@@ -81,6 +139,16 @@ func init() {
 		},
 	})))
 
+	if snapshotsBucket == "" {
+		slog.Error("config_invalid", "variable", "SNAPSHOTS_BUCKET", "error", errSnapshotsNotConfigured.Error())
+	}
+	if exportsBucket == "" {
+		slog.Warn("config_invalid", "variable", "USER_EXPORTS_BUCKET", "error", errExportsNotConfigured.Error())
+	}
+	if v, _ := strconv.Atoi(os.Getenv("SNAPSHOT_PIXEL_SCALE")); v > maxPixelScale {
+		slog.Warn("config_invalid", "variable", "SNAPSHOT_PIXEL_SCALE", "error", fmt.Sprintf("%d is above the maximum, using %d", v, maxPixelScale))
+	}
+
 	functions.CloudEvent("handler", handleCloudEvent)
 }
 
@@ -106,11 +174,30 @@ func getStorage() *storage.Client {
 	return stClient
 }
 
-// Pixel from Firestore
+// Pixel from Firestore. Only the fields a pixelLoad asks for are read;
+// the others are left empty.
 type Pixel struct {
-	X     int    `firestore:"x"`
-	Y     int    `firestore:"y"`
-	Color string `firestore:"color"`
+	X      int    `firestore:"x"`
+	Y      int    `firestore:"y"`
+	Color  string `firestore:"color"`
+	UserID string `firestore:"userId"`
+}
+
+// pixelLoad says which optional Pixel fields a reader needs. x, y and color
+// are always read; pixel documents carry much more (username, timestamps,
+// admin flag), so renders that only need colors select just those.
+type pixelLoad struct {
+	// Owner reads userId, for ownership maps and contributor counts
+	Owner bool
+}
+
+// fieldPaths is the projection for a query
+func (o pixelLoad) fieldPaths() []string {
+	paths := []string{"x", "y", "color"}
+	if o.Owner {
+		paths = append(paths, "userId")
+	}
+	return paths
 }
 
 type tileKey struct{ x, y int }
@@ -119,6 +206,8 @@ type TileResult struct {
 	X   int    `json:"x"`
 	Y   int    `json:"y"`
 	URL string `json:"url"`
+	// Base64 CRC32C of the PNG, comparable with the object's x-goog-hash
+	CRC32C string `json:"crc32c,omitempty"`
 }
 
 type Manifest struct {
@@ -131,26 +220,63 @@ type Manifest struct {
 	Tiles        []TileResult `json:"tiles"`
 	ThumbnailURL string       `json:"thumbnailUrl"`
 	PixelCount   int          `json:"pixelCount"`
+	PixelHash    string       `json:"pixelHash"`
+
+	ThumbnailCRC32C string `json:"thumbnailCrc32c,omitempty"`
+	// CPU profile of tile generation, when ENABLE_PROFILING is set
+	ProfileURL string `json:"profileUrl,omitempty"`
+	// Thumbnail with protected zones outlined, when requested
+	ZonesURL string `json:"zonesUrl,omitempty"`
+	// Why the snapshot was taken, e.g. "pre_clear"
+	Tag string `json:"tag,omitempty"`
+	// Thumbnail drawn over the previous snapshot's, see drawLayer
+	Layered bool `json:"layered,omitempty"`
+	// Longest side the thumbnail was fitted to, before SNAPSHOT_PIXEL_SCALE
+	ThumbnailSize int `json:"thumbnailSize,omitempty"`
+	// Bucket the snapshot is stored in; empty for SNAPSHOTS_BUCKET before
+	// buckets were recorded
+	Bucket string `json:"bucket,omitempty"`
+	// Part of the canvas a region snapshot covers; its tiles and thumbnail
+	// start at this offset instead of the canvas origin
+	Region *ManifestRegion `json:"region,omitempty"`
+}
+
+// ManifestRegion places a region snapshot on the canvas: pixel (0, 0) of
+// the snapshot is canvas pixel (X, Y)
+type ManifestRegion struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
 }
 
-// CloudEvent Pub/Sub data
-type MessagePublishedData struct {
-	Message struct {
-		Data       []byte            `json:"data"`
-		Attributes map[string]string `json:"attributes"`
-	} `json:"message"`
+// LastSnapshot is the pointer to the most recent snapshot, stored in snapshots/latest
+type LastSnapshot struct {
+	Timestamp    int64  `firestore:"timestamp"`
+	ManifestURL  string `firestore:"manifestUrl"`
+	ThumbnailURL string `firestore:"thumbnailUrl"`
+	PixelHash    string `firestore:"pixelHash"`
+	PixelCount   int    `firestore:"pixelCount"`
+	TileCount    int    `firestore:"tileCount"`
+	CanvasWidth  int    `firestore:"canvasWidth"`
+	CanvasHeight int    `firestore:"canvasHeight"`
+	Tag          string `firestore:"tag,omitempty"`
+	Bucket       string `firestore:"bucket,omitempty"`
+	// Zero for snapshots taken before the size was configurable, which
+	// used defaultThumbnailSize
+	ThumbnailSize int `firestore:"thumbnailSize,omitempty"`
+
+	ManifestCRC32C string `firestore:"manifestCrc32c"`
 }
 
-type SnapshotRequest struct {
-	ChannelID        string `json:"channelId"`
-	UserID           string `json:"userId"`
-	Username         string `json:"username"`
-	InteractionToken string `json:"interactionToken"`
-	ApplicationID    string `json:"applicationId"`
+// loadPixels reads every pixel with a single query
+func loadPixels(ctx context.Context, opts pixelLoad) ([]Pixel, error) {
+	return queryPixels(ctx, getFirestore().Collection("pixels").Query, opts)
 }
 
-func getAllPixels(ctx context.Context) ([]Pixel, error) {
-	docs, err := getFirestore().Collection("pixels").Documents(ctx).GetAll()
+// queryPixels runs q with the projection opts asks for
+func queryPixels(ctx context.Context, q firestore.Query, opts pixelLoad) ([]Pixel, error) {
+	docs, err := q.Select(opts.fieldPaths()...).Documents(ctx).GetAll()
 	if err != nil {
 		return nil, err
 	}
@@ -165,6 +291,176 @@ func getAllPixels(ctx context.Context) ([]Pixel, error) {
 	return pixels, nil
 }
 
+// completePendingStop finishes a /session stop: once the session worker has
+// archived the pixels and the final snapshot is stored, a session marked
+// pendingStop becomes "stopped". A stop with purge then deletes the live
+// pixels.
+func completePendingStop(ctx context.Context) (bool, error) {
+	ref := getFirestore().Collection("sessions").Doc("current")
+	stopped := false
+	purgeArchive := ""
+	err := getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if pending, _ := doc.Data()["pendingStop"].(bool); !pending {
+			return nil
+		}
+		stopped = true
+		purgeArchive, _ = doc.Data()["purgeArchive"].(string)
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: "stopped"},
+			{Path: "stoppedAt", Value: time.Now().UTC().Format(time.RFC3339)},
+			{Path: "pendingStop", Value: firestore.Delete},
+		})
+	})
+	if err == nil && purgeArchive != "" {
+		purgeArchivedPixels(ctx, ref, purgeArchive)
+	}
+	return stopped, err
+}
+
+// purgeArchivedPixels deletes the live pixels of a session stopped with
+// purge. The session worker only sets purgeArchive after the copy in
+// sessions/history_{id}/pixels matched the canvas, and nobody can draw on a
+// stopped session. The field is cleared once the pixels are gone; a purge
+// that runs out of time is logged and leaves it set.
+func purgeArchivedPixels(ctx context.Context, sessionRef *firestore.DocumentRef, archiveID string) {
+	deleted := 0
+	done, err := processDocs(ctx, time.Now().Add(deletionTimeBudget), "pixels",
+		getFirestore().Collection("pixels").Limit(deletionPageSize),
+		func(bw *firestore.BulkWriter, ref *firestore.DocumentRef) (*firestore.BulkWriterJob, error) {
+			return bw.Delete(ref)
+		},
+		func(n int) error {
+			deleted += n
+			return nil
+		})
+	if err != nil || !done {
+		errMsg := "time budget exhausted"
+		if err != nil {
+			errMsg = err.Error()
+		}
+		slog.Warn("session_purge_incomplete", "archive_id", archiveID, "deleted", deleted, "error", errMsg)
+		return
+	}
+	if _, err := sessionRef.Update(ctx, []firestore.Update{{Path: "purgeArchive", Value: firestore.Delete}}); err != nil {
+		slog.Warn("session_purge_unrecorded", "archive_id", archiveID, "error", err.Error())
+	}
+	slog.Info("session_pixels_purged", "archive_id", archiveID, "deleted", deleted)
+}
+
+// partitionBounds splits [0, canvasW) into at most n x-ranges. When the canvas
+// is wide enough, range edges fall on tile boundaries so each partition owns
+// whole tile columns.
+func partitionBounds(canvasW, n int) []int {
+	step := int(math.Ceil(float64(canvasW) / float64(n)))
+	if canvasW >= n*tileSize {
+		step = int(math.Ceil(float64(step)/float64(tileSize))) * tileSize
+	}
+	bounds := []int{0}
+	for x := step; x < canvasW; x += step {
+		bounds = append(bounds, x)
+	}
+	return append(bounds, canvasW)
+}
+
+// getPixelsPartitioned reads all pixels using concurrent x-range queries.
+// The first and last ranges are open-ended so out-of-bounds pixels are
+// returned exactly as the single query would.
+func getPixelsPartitioned(ctx context.Context, canvasW int, opts pixelLoad) ([]Pixel, error) {
+	if canvasW < partitionMinWidth {
+		return loadPixels(ctx, opts)
+	}
+
+	ctx, span := tracer.Start(ctx, "getPixelsPartitioned")
+	defer span.End()
+
+	bounds := partitionBounds(canvasW, pixelReadPartitions)
+	parts := len(bounds) - 1
+	span.SetAttributes(attribute.Int("snapshot.read_partitions", parts))
+
+	results := make([][]Pixel, parts)
+	errs := make([]error, parts)
+	var wg sync.WaitGroup
+	for i := 0; i < parts; i++ {
+		q := getFirestore().Collection("pixels").Query
+		if i > 0 {
+			q = q.Where("x", ">=", bounds[i])
+		}
+		if i < parts-1 {
+			q = q.Where("x", "<", bounds[i+1])
+		}
+
+		wg.Add(1)
+		go func(i int, q firestore.Query) {
+			defer wg.Done()
+			results[i], errs[i] = queryPixels(ctx, q, opts)
+		}(i, q)
+	}
+	wg.Wait()
+
+	total := 0
+	for i := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		total += len(results[i])
+	}
+	pixels := make([]Pixel, 0, total)
+	for _, part := range results {
+		pixels = append(pixels, part...)
+	}
+	return pixels, nil
+}
+
+// hashPixels returns a stable hash of the canvas content, independent of the
+// order in which Firestore returned the pixels.
+func hashPixels(pixels []Pixel, canvasW, canvasH int) string {
+	sorted := make([]Pixel, len(pixels))
+	copy(sorted, pixels)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Y != sorted[j].Y {
+			return sorted[i].Y < sorted[j].Y
+		}
+		return sorted[i].X < sorted[j].X
+	})
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%dx%d;", canvasW, canvasH)
+	for _, p := range sorted {
+		fmt.Fprintf(h, "%d,%d,%s;", p.X, p.Y, strings.ToUpper(p.Color))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// reusesSnapshot reports whether last already shows the canvas req asks
+// for. A zones overlay is never cached and a tagged snapshot must be its
+// own, so both always need a fresh render; a snapshot in another bucket or
+// at another thumbnail size does not count either.
+func reusesSnapshot(last *LastSnapshot, req messages.SnapshotRequest, pixelHash, bucket string, thumbSize int) bool {
+	return last.PixelHash == pixelHash && !req.Zones && req.Tag == "" &&
+		bucketOrDefault(last.Bucket) == bucket && cmp.Or(last.ThumbnailSize, defaultThumbnailSize) == thumbSize
+}
+
+func getLastSnapshot(ctx context.Context) (*LastSnapshot, error) {
+	doc, err := getFirestore().Collection("snapshots").Doc("latest").Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var last LastSnapshot
+	if err := doc.DataTo(&last); err != nil {
+		return nil, err
+	}
+	return &last, nil
+}
+
+func saveLastSnapshot(ctx context.Context, last LastSnapshot) error {
+	_, err := getFirestore().Collection("snapshots").Doc("latest").Set(ctx, last)
+	return err
+}
+
 func parseColor(c string) color.RGBA {
 	c = strings.TrimPrefix(c, "#")
 	if len(c) != 6 {
@@ -175,73 +471,178 @@ func parseColor(c string) color.RGBA {
 	return color.RGBA{r, g, b, 255}
 }
 
+// maxPaletteSize is the most colors a paletted PNG can hold
+const maxPaletteSize = 256
+
+// buildTilePalette returns the colors of a tile, white background first, or
+// nil when there are more than maxPaletteSize of them.
+func buildTilePalette(pixels []Pixel) color.Palette {
+	palette := color.Palette{color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}}
+	seen := map[color.RGBA]bool{color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}: true}
+	for _, p := range pixels {
+		c := parseColor(p.Color)
+		if seen[c] {
+			continue
+		}
+		if len(palette) == maxPaletteSize {
+			return nil
+		}
+		seen[c] = true
+		palette = append(palette, c)
+	}
+	return palette
+}
+
+// generateTile renders one tile as a PNG. Tiles with at most
+// maxPaletteSize colors, the usual case, are drawn as a paletted image at
+// one byte per pixel instead of four, which also encodes smaller.
 func generateTile(pixels []Pixel, tx, ty, canvasW, canvasH int) []byte {
+	palette := buildTilePalette(pixels)
+	if palette == nil {
+		return generateRGBATile(pixels, tx, ty, canvasW, canvasH)
+	}
+
+	bounds, startX, startY := tileBounds(tx, ty, canvasW, canvasH)
+	img := image.NewPaletted(bounds, palette)
+	index := make(map[color.RGBA]uint8, len(palette))
+	for i, c := range palette {
+		index[c.(color.RGBA)] = uint8(i)
+	}
+	// Index 0 is the white background, so only pixels need setting
+	for _, p := range pixels {
+		img.SetColorIndex(p.X-startX, p.Y-startY, index[parseColor(p.Color)])
+	}
+	return encodePNG(img)
+}
+
+// generateRGBATile renders a tile at four bytes per pixel. It is also how
+// every tile was encoded before paletted tiles, which repairs of older
+// snapshots need to reproduce their checksums.
+func generateRGBATile(pixels []Pixel, tx, ty, canvasW, canvasH int) []byte {
+	bounds, startX, startY := tileBounds(tx, ty, canvasW, canvasH)
+	img := image.NewRGBA(bounds)
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	for _, p := range pixels {
+		img.Set(p.X-startX, p.Y-startY, parseColor(p.Color))
+	}
+	return encodePNG(img)
+}
+
+// tileWorkers bounds how many tiles are generated and uploaded at once
+func tileWorkers() int {
+	return min(max(runtime.NumCPU()*2, 4), 32)
+}
+
+// groupByTile buckets the pixels inside the canvas by the tile they fall in
+func groupByTile(pixels []Pixel, canvasW, canvasH int) map[tileKey][]Pixel {
+	tiles := make(map[tileKey][]Pixel)
+	for _, p := range pixels {
+		if p.X >= 0 && p.X < canvasW && p.Y >= 0 && p.Y < canvasH {
+			tk := tileKey{p.X / tileSize, p.Y / tileSize}
+			tiles[tk] = append(tiles[tk], p)
+		}
+	}
+	return tiles
+}
+
+// tileBounds returns the size of a tile, clipped to the canvas, and the
+// canvas coordinate of its top-left pixel
+func tileBounds(tx, ty, canvasW, canvasH int) (image.Rectangle, int, int) {
 	startX := tx * tileSize
 	startY := ty * tileSize
 	endX := min(startX+tileSize, canvasW)
 	endY := min(startY+tileSize, canvasH)
-	w := endX - startX
-	h := endY - startY
+	return image.Rect(0, 0, endX-startX, endY-startY), startX, startY
+}
 
-	img := image.NewRGBA(image.Rect(0, 0, w, h))
-	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+// errThumbnailSize refuses a requested thumbnail size outside the range
+var errThumbnailSize = fmt.Errorf("thumbnail size must be between %d and %d", minThumbnailSize, maxThumbnailSize)
 
-	for _, p := range pixels {
-		img.Set(p.X-startX, p.Y-startY, parseColor(p.Color))
+// thumbnailSizeFor is the size a request asked for, or THUMBNAIL_MAX_SIZE
+// when it did not
+func thumbnailSizeFor(requested int) (int, error) {
+	if requested == 0 {
+		return thumbnailSize, nil
+	}
+	if requested < minThumbnailSize || requested > maxThumbnailSize {
+		return 0, errThumbnailSize
 	}
+	return requested, nil
+}
 
-	var buf bytes.Buffer
-	enc := &png.Encoder{CompressionLevel: png.BestSpeed}
-	enc.Encode(&buf, img)
-	return buf.Bytes()
+func generateThumbnail(pixels []Pixel, canvasW, canvasH, maxSize int) []byte {
+	img, _ := renderThumbnail(pixels, canvasW, canvasH, maxSize)
+	return encodePNG(img)
+}
+
+// generateLayeredThumbnail is generateThumbnail drawn over a faded copy of
+// the previous thumbnail, for a layered snapshot
+func generateLayeredThumbnail(prev image.Image, pixels []Pixel, canvasW, canvasH, maxSize int) []byte {
+	img, _ := renderThumbnailOver(prev, pixels, canvasW, canvasH, maxSize)
+	return encodePNG(img)
+}
+
+// renderThumbnail draws the canvas scaled down so its longest side fits
+// maxSize and returns the image along with the scale that was applied. With
+// SNAPSHOT_PIXEL_SCALE above 1 every pixel then becomes an N×N block, so the
+// output is at most maxSize*N on a side, and N is lowered when needed to keep
+// it within maxThumbnailSize.
+func renderThumbnail(pixels []Pixel, canvasW, canvasH, maxSize int) (*image.RGBA, float64) {
+	return renderThumbnailOver(nil, pixels, canvasW, canvasH, maxSize)
 }
 
-func generateThumbnail(pixels []Pixel, canvasW, canvasH int) []byte {
-	scale := math.Min(float64(thumbnailMaxSize)/float64(canvasW), float64(thumbnailMaxSize)/float64(canvasH))
+// renderThumbnailOver is renderThumbnail with prev, when not nil, drawn
+// between the white background and the pixels (see drawLayer).
+func renderThumbnailOver(prev image.Image, pixels []Pixel, canvasW, canvasH, maxSize int) (*image.RGBA, float64) {
+	scale := math.Min(float64(maxSize)/float64(canvasW), float64(maxSize)/float64(canvasH))
 	scale = math.Min(scale, 1.0)
+	zoom := min(pixelScale, max(1, int(maxThumbnailSize/(scale*float64(max(canvasW, canvasH))))))
+	scale *= float64(zoom)
 
+	// A very wide or tall canvas scales its short side below one pixel;
+	// it still gets a one-pixel strip rather than an empty image
 	tw := max(1, int(float64(canvasW)*scale))
 	th := max(1, int(float64(canvasH)*scale))
 
 	img := image.NewRGBA(image.Rect(0, 0, tw, th))
 	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	if prev != nil {
+		drawLayer(img, prev)
+	}
 
 	for _, p := range pixels {
 		if p.X >= 0 && p.X < canvasW && p.Y >= 0 && p.Y < canvasH {
 			px := int(float64(p.X) * scale)
 			py := int(float64(p.Y) * scale)
 			if px < tw && py < th {
-				img.Set(px, py, parseColor(p.Color))
+				// Cover the pixel's whole footprint, at least one output pixel
+				block := image.Rect(px, py,
+					max(px+1, int(float64(p.X+1)*scale)),
+					max(py+1, int(float64(p.Y+1)*scale)),
+				).Intersect(img.Bounds())
+				draw.Draw(img, block, &image.Uniform{parseColor(p.Color)}, image.Point{}, draw.Src)
 			}
 		}
 	}
 
+	return img, scale
+}
+
+func encodePNG(img image.Image) []byte {
 	var buf bytes.Buffer
 	enc := &png.Encoder{CompressionLevel: png.BestSpeed}
 	enc.Encode(&buf, img)
 	return buf.Bytes()
 }
 
-func upload(ctx context.Context, data []byte, path, contentType string) (string, error) {
-	obj := getStorage().Bucket(snapshotsBucket).Object(path)
-	w := obj.NewWriter(ctx)
-	w.ContentType = contentType
-	w.CacheControl = "public, max-age=3600"
-	if _, err := w.Write(data); err != nil {
-		w.Close()
-		return "", err
-	}
-	if err := w.Close(); err != nil {
-		return "", err
-	}
-	signedURL, err := getStorage().Bucket(snapshotsBucket).SignedURL(path, &storage.SignedURLOptions{
-		Method:  "GET",
-		Expires: time.Now().Add(7 * 24 * time.Hour),
-	})
-	if err != nil {
-		return fmt.Sprintf("https://storage.googleapis.com/%s/%s", snapshotsBucket, path), nil
+// cacheControlFor lets browsers keep images for an hour but revalidate JSON
+// (manifests) quickly, so the web viewer notices new snapshots.
+func cacheControlFor(contentType string) string {
+	if contentType == "application/json" {
+		return "public, max-age=60"
 	}
-	return signedURL, nil
+	return "public, max-age=3600"
 }
 
 func toIntVal(v interface{}) int {
@@ -256,8 +657,8 @@ func toIntVal(v interface{}) int {
 }
 
 func postToDiscord(channelID, thumbnailURL string, m Manifest) {
-	body, _ := json.Marshal(map[string]interface{}{
-		"embeds": []map[string]interface{}{{
+	discordClient.ChannelMessage(context.Background(), channelID, discord.Message{
+		Embeds: []map[string]interface{}{{
 			"title": "Canvas Snapshot",
 			"description": fmt.Sprintf("**Canvas:** %dx%d pixels\n**Pixels drawn:** %d\n**Tiles:** %d (sparse)\n\n[View Thumbnail](%s)",
 				m.CanvasWidth, m.CanvasHeight, m.PixelCount, len(m.Tiles), thumbnailURL),
@@ -267,40 +668,114 @@ func postToDiscord(channelID, thumbnailURL string, m Manifest) {
 			"footer":    map[string]string{"text": fmt.Sprintf("Tile size: %dpx | Sparse chunking", tileSize)},
 		}},
 	})
+}
 
-	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/channels/%s/messages", discordAPI, channelID), bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bot "+discordBotToken)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
+func sendChannelMessage(channelID, content string) {
+	if discordBotToken == "" {
 		return
 	}
-	resp.Body.Close()
+	discordClient.ChannelMessage(context.Background(), channelID, discord.Message{Content: content})
 }
 
 func sendFollowUp(appID, token, content string) {
-	if appID == "" || token == "" || discordBotToken == "" {
+	if discordBotToken == "" {
 		return
 	}
-	body, _ := json.Marshal(map[string]string{"content": content})
-	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/webhooks/%s/%s", discordAPI, appID, token), bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bot "+discordBotToken)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
+	discordClient.FollowUp(context.Background(), appID, token, discord.Message{Content: content})
+}
+
+func sendFollowUpEmbed(appID, token string, embed map[string]interface{}) {
+	if discordBotToken == "" {
+		return
+	}
+	discordClient.FollowUp(context.Background(), appID, token, discord.Message{
+		Embeds: []map[string]interface{}{embed},
+	})
+}
+
+// auditSnapshot records snapshots requested by an admin. Scheduled snapshots
+// carry no user and are not audited.
+func auditSnapshot(ctx context.Context, req messages.SnapshotRequest, params map[string]interface{}) {
+	if req.UserID == "" {
+		return
+	}
+	if req.Tag != "" {
+		params["tag"] = req.Tag
+	}
+	audit.Record(ctx, getFirestore(), audit.Entry{
+		ActorID:   req.UserID,
+		ActorName: req.Username,
+		Action:    "snapshot.create",
+		Target:    "canvas",
+		Params:    params,
+	})
+}
+
+var errFlowControlRejected = errors.New("instance at flow-control limit, message will be redelivered")
+
+var errSnapshotInProgress = errors.New("another snapshot is rendering for this channel, message will be redelivered")
+
+var errSnapshotsNotConfigured = errors.New("snapshots are not configured: SNAPSHOTS_BUCKET is not set")
+
+var errExportsNotConfigured = errors.New("exports are not configured: USER_EXPORTS_BUCKET is not set")
+
+// missingBucket returns the configuration error of a message type that
+// cannot be handled without a bucket to upload to, or nil. Deletions never
+// upload, /history still answers without its chart, exports need the
+// private USER_EXPORTS_BUCKET, overwrite notices, canvas summaries and
+// role rewards only call Discord and pixel count reconciliations and
+// leaderboard refreshes only Firestore.
+func missingBucket(msgType string) error {
+	switch msgType {
+	case messages.TypeUserDataDelete, messages.TypePixelHistory, messages.TypeOverwriteNotice, messages.TypeCanvasSummary, messages.TypeRoleReward,
+		messages.TypePixelCountReconcile, messages.TypeLeaderboardRefresh:
+		return nil
+	case messages.TypeUserDataExport, messages.TypeSessionExport:
+		if exportsBucket == "" {
+			return errExportsNotConfigured
+		}
+		return nil
+	}
+	if snapshotsBucket == "" {
+		return errSnapshotsNotConfigured
+	}
+	return nil
+}
+
+// replyNotConfigured answers a request that needs the missing bucket. Every
+// request published by the discord-proxy carries its interaction; scheduled
+// snapshots have none and are only logged.
+func replyNotConfigured(data []byte, err error) {
+	var req struct {
+		InteractionToken string `json:"interactionToken"`
+		ApplicationID    string `json:"applicationId"`
+	}
+	if json.Unmarshal(data, &req) != nil || req.InteractionToken == "" {
+		return
+	}
+	if errors.Is(err, errExportsNotConfigured) {
+		sendEphemeralFollowUp(req.ApplicationID, req.InteractionToken, "Data exports are not configured on this deployment. Ask an admin to set USER_EXPORTS_BUCKET.")
 		return
 	}
-	resp.Body.Close()
+	sendFollowUp(req.ApplicationID, req.InteractionToken, "Snapshots are not configured on this deployment. Ask an admin to set SNAPSHOTS_BUCKET.")
 }
 
 func handleCloudEvent(ctx context.Context, e event.Event) error {
 	start := time.Now()
 
-	var msg MessagePublishedData
+	var msg messages.MessagePublishedData
 	if err := e.DataAs(&msg); err != nil {
 		return fmt.Errorf("parse event: %w", err)
 	}
 
+	// Refused deliveries are retried by Pub/Sub with backoff
+	size := len(msg.Message.Data)
+	if !intake.TryAcquire(size) {
+		slog.Warn("flow_control_rejected", "type", msg.Message.Attributes["type"], "bytes", size)
+		return errFlowControlRejected
+	}
+	defer intake.Release(size)
+
 	// Extract trace context from Pub/Sub attributes
 	if traceID := msg.Message.Attributes["traceId"]; traceID != "" {
 		if spanID := msg.Message.Attributes["spanId"]; spanID != "" {
@@ -315,25 +790,121 @@ func handleCloudEvent(ctx context.Context, e event.Event) error {
 			ctx = trace.ContextWithRemoteSpanContext(ctx, parentCtx)
 		}
 	}
+	if msg.Message.Attributes[sampling.Attribute] == "true" {
+		ctx = sampling.Force(ctx)
+	}
 
-	ctx, span := tracer.Start(ctx, "generateSnapshot")
-	defer span.End()
+	// Redelivery cannot fix configuration, so these are acked
+	if err := missingBucket(msg.Message.Attributes["type"]); err != nil {
+		slog.Error("snapshot_request_failed", "type", msg.Message.Attributes["type"], "error", err.Error())
+		replyNotConfigured(msg.Message.Data, err)
+		return nil
+	}
+
+	switch msg.Message.Attributes["type"] {
+	case messages.TypeUserDataExport:
+		return handleDataExport(ctx, msg.Message.Data)
+	case messages.TypeSessionExport:
+		return handleSessionExport(ctx, msg.Message.Data)
+	case messages.TypeRoleReward:
+		return handleRoleReward(ctx, msg.Message.Data)
+	case messages.TypeLeaderboardRefresh:
+		return handleLeaderboardRefresh(ctx, msg.Message.Data)
+	case messages.TypeUserDataDelete:
+		return handleDataDeletion(ctx, msg.Message.Data)
+	case messages.TypeTileRequest:
+		return handleTileRequest(ctx, msg.Message.Data)
+	case messages.TypeColorChart:
+		return handleColorChart(ctx, msg.Message.Data)
+	case messages.TypeOwnershipMap:
+		return handleOwnershipMap(ctx, msg.Message.Data)
+	case messages.TypeRegionStats:
+		return handleRegionStats(ctx, msg.Message.Data)
+	case messages.TypePixelHistory:
+		return handlePixelHistory(ctx, msg.Message.Data)
+	case messages.TypeCanvasClear:
+		return handleCanvasClear(ctx, msg.Message.Data)
+	case messages.TypeSnapshotVerify:
+		return handleSnapshotVerify(ctx, msg.Message.Data)
+	case messages.TypeSnapshotRegion:
+		return handleSnapshotRegion(ctx, msg.Message.Data)
+	case messages.TypeOverwriteNotice:
+		return handleOverwriteNotice(ctx, msg.Message.Data)
+	case messages.TypeCanvasSummary:
+		return handleCanvasSummary(ctx, msg.Message.Data)
+	case messages.TypePixelCountReconcile:
+		return handlePixelCountReconcile(ctx)
+	}
 
-	var req SnapshotRequest
+	var req messages.SnapshotRequest
 	if err := json.Unmarshal(msg.Message.Data, &req); err != nil {
 		return fmt.Errorf("parse request: %w", err)
 	}
 
-	// Get canvas dimensions from session
-	canvasW, canvasH := 1000, 1000
-	if doc, err := getFirestore().Collection("sessions").Doc("current").Get(ctx); err == nil {
-		data := doc.Data()
-		if w := toIntVal(data["canvasWidth"]); w > 0 {
-			canvasW = w
+	// One render per channel at a time. Extras from a command are refused;
+	// the final snapshot of /session stop has no one to tell and must still
+	// run, so it is redelivered until the lock is free.
+	if req.ChannelID != "" {
+		acquired, err := acquireSnapshotLock(ctx, req.ChannelID, e.ID(), req.UserID)
+		if err != nil {
+			slog.Error("snapshot_lock_failed", "channel_id", req.ChannelID, "error", err.Error())
+			return err
 		}
-		if h := toIntVal(data["canvasHeight"]); h > 0 {
-			canvasH = h
+		if !acquired {
+			slog.Warn("snapshot_in_progress", "channel_id", req.ChannelID, "user_id", req.UserID)
+			if req.InteractionToken == "" {
+				return errSnapshotInProgress
+			}
+			sendFollowUp(req.ApplicationID, req.InteractionToken, "A snapshot is already rendering for this channel. Try again when it has finished.")
+			return nil
 		}
+		defer func() {
+			if err := releaseSnapshotLock(context.WithoutCancel(ctx), req.ChannelID, e.ID()); err != nil {
+				slog.Warn("snapshot_lock_release_failed", "channel_id", req.ChannelID, "error", err.Error())
+			}
+		}()
+	}
+
+	_, err := generateSnapshot(ctx, req, start)
+
+	// Flush traces before function exits (required for serverless)
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	if errors.Is(err, errBucketNotAllowed) || errors.Is(err, errThumbnailSize) || errors.Is(err, errSnapshotGuardrail) {
+		return nil // redelivery would be refused the same way
+	}
+	return err
+}
+
+// generateSnapshot renders the canvas, uploads it and answers req. It returns
+// the snapshots/latest pointer covering the current canvas: the previous one
+// when nothing changed, the new one when it was stored completely, or nil.
+func generateSnapshot(ctx context.Context, req messages.SnapshotRequest, start time.Time) (*LastSnapshot, error) {
+	ctx, span := tracer.Start(ctx, "generateSnapshot")
+	defer span.End()
+
+	bucket, err := selectSnapshotsBucket(ctx, req.SnapshotsBucket)
+	if err != nil {
+		slog.Error("snapshot_bucket_rejected", "error", err.Error(), "user_id", req.UserID)
+		sendFollowUp(req.ApplicationID, req.InteractionToken, fmt.Sprintf("Snapshot refused: %v", err))
+		return nil, err
+	}
+	thumbSize, err := thumbnailSizeFor(req.ThumbnailSize)
+	if err != nil {
+		sendFollowUp(req.ApplicationID, req.InteractionToken, fmt.Sprintf("Snapshot refused: %v", err))
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.String("snapshot.bucket", bucket),
+		attribute.Int("snapshot.thumbnail_size", thumbSize),
+	)
+	ensureBucketCORS(ctx, bucket)
+
+	canvasW, canvasH, sizeSource := resolveCanvasSize(ctx, req.CanvasWidth, req.CanvasHeight)
+	sizeNote := ""
+	if sizeSource == canvasSizeFromDefault {
+		sizeNote = fmt.Sprintf("\nNote: the session's canvas size was unavailable, so this was rendered at the default %dx%d and may be cropped.", canvasW, canvasH)
 	}
 
 	// Add span attributes
@@ -341,16 +912,63 @@ func handleCloudEvent(ctx context.Context, e event.Event) error {
 		span.SetAttributes(
 			attribute.Int("canvas.width", canvasW),
 			attribute.Int("canvas.height", canvasH),
+			attribute.String("canvas.size_source", sizeSource),
 			attribute.String("snapshot.user_id", req.UserID),
+			attribute.String("snapshot.tag", req.Tag),
 		)
 	}
 
+	if msg := checkSnapshotGuardrail(ctx, req); msg != "" {
+		slog.Warn("snapshot_refused", "reason", msg, "user_id", req.UserID)
+		sendFollowUp(req.ApplicationID, req.InteractionToken, msg)
+		return nil, errSnapshotGuardrail
+	}
+
 	// Get all pixels
-	pixels, err := getAllPixels(ctx)
+	pixels, err := getPixelsPartitioned(ctx, canvasW, pixelLoad{})
 	if err != nil {
 		slog.Error("snapshot_pixels_fetch_failed", "error", err.Error(), "user_id", req.UserID)
 		sendFollowUp(req.ApplicationID, req.InteractionToken, fmt.Sprintf("Failed to get pixels: %v", err))
-		return err
+		return nil, err
+	}
+
+	// Skip rendering when nothing changed since the last snapshot
+	pixelHash := hashPixels(pixels, canvasW, canvasH)
+	if last, err := getLastSnapshot(ctx); err == nil && reusesSnapshot(last, req, pixelHash, bucket, thumbSize) {
+		slog.Info("snapshot_unchanged",
+			"pixel_count", len(pixels),
+			"last_timestamp", last.Timestamp,
+			"user_id", req.UserID,
+		)
+		if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+			span.SetAttributes(attribute.Bool("snapshot.unchanged", true))
+		}
+
+		// The previous snapshot already is the final one
+		if stopped, err := completePendingStop(ctx); err != nil {
+			slog.Error("session_stop_failed", "error", err.Error())
+		} else if stopped && req.ChannelID != "" {
+			sendChannelMessage(req.ChannelID, "⏹️ Session stopped. Final snapshot below.")
+		}
+
+		if req.ChannelID != "" && last.ThumbnailURL != "" {
+			postSnapshotToChannel(req.ChannelID, last.ThumbnailURL, Manifest{
+				CanvasWidth:  last.CanvasWidth,
+				CanvasHeight: last.CanvasHeight,
+				PixelCount:   last.PixelCount,
+				Tiles:        make([]TileResult, last.TileCount),
+			})
+		}
+		auditSnapshot(ctx, req, map[string]interface{}{
+			"unchanged":   true,
+			"pixelCount":  last.PixelCount,
+			"manifestUrl": last.ManifestURL,
+		})
+		if req.InteractionToken != "" && req.ApplicationID != "" {
+			sendFollowUp(req.ApplicationID, req.InteractionToken,
+				fmt.Sprintf("No changes since last snapshot (%d pixels)\nManifest: %s", last.PixelCount, last.ManifestURL)+sizeNote)
+		}
+		return last, nil
 	}
 
 	timestamp := time.Now().UnixMilli()
@@ -359,24 +977,24 @@ func handleCloudEvent(ctx context.Context, e event.Event) error {
 	tilesY := int(math.Ceil(float64(canvasH) / float64(tileSize)))
 
 	// Group pixels by tile — only tiles with pixels will be generated
-	tilePixelMap := make(map[tileKey][]Pixel)
-	for _, p := range pixels {
-		if p.X >= 0 && p.X < canvasW && p.Y >= 0 && p.Y < canvasH {
-			tk := tileKey{p.X / tileSize, p.Y / tileSize}
-			tilePixelMap[tk] = append(tilePixelMap[tk], p)
+	tilePixelMap := groupByTile(pixels, canvasW, canvasH)
+
+	// Read before the new snapshot can replace it
+	var prevThumb image.Image
+	var layerNote string
+	if req.Layered {
+		var reason string
+		if prevThumb, reason = loadPreviousThumbnail(ctx, canvasW, canvasH); prevThumb == nil {
+			slog.Warn("snapshot_layer_unavailable", "reason", reason, "user_id", req.UserID)
+			layerNote = fmt.Sprintf("\nNote: rendered without layering, because %s.", reason)
 		}
 	}
 
-	// Generate + upload tiles in parallel using goroutine pool
-	maxWorkers := runtime.NumCPU() * 2
-	if maxWorkers > 32 {
-		maxWorkers = 32
-	}
-	if maxWorkers < 4 {
-		maxWorkers = 4
-	}
+	// Profile tile generation when ENABLE_PROFILING is set
+	profile := startCPUProfile()
 
-	sem := make(chan struct{}, maxWorkers)
+	// Generate + upload tiles in parallel using goroutine pool
+	sem := make(chan struct{}, tileWorkers())
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	var results []TileResult
@@ -389,29 +1007,76 @@ func handleCloudEvent(ctx context.Context, e event.Event) error {
 			defer func() { <-sem }()
 
 			data := generateTile(px, tk.x, tk.y, canvasW, canvasH)
-			path := fmt.Sprintf("%s/tile-%d-%d.png", snapshotDir, tk.x, tk.y)
-			url, err := upload(ctx, data, path, "image/png")
+			path := tileObjectPath(timestamp, tk.x, tk.y)
+			url, err := uploadToBucket(ctx, bucket, data, path, "image/png")
 			if err != nil {
 				return
 			}
 
 			mu.Lock()
-			results = append(results, TileResult{X: tk.x, Y: tk.y, URL: url})
+			results = append(results, TileResult{X: tk.x, Y: tk.y, URL: url, CRC32C: objectCRC32C(data)})
 			mu.Unlock()
 		}(tk, px)
 	}
 
-	var thumbURL string
+	var thumbURL, thumbCRC32C string
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		sem <- struct{}{}
 		defer func() { <-sem }()
 
-		thumbData := generateThumbnail(pixels, canvasW, canvasH)
-		thumbURL, _ = upload(ctx, thumbData, snapshotDir+"/thumbnail.png", "image/png")
+		var thumbData []byte
+		if prevThumb != nil {
+			thumbData = generateLayeredThumbnail(prevThumb, pixels, canvasW, canvasH, thumbSize)
+		} else {
+			thumbData = generateThumbnail(pixels, canvasW, canvasH, thumbSize)
+		}
+		if url, err := uploadToBucket(ctx, bucket, thumbData, snapshotDir+"/thumbnail.png", "image/png"); err == nil {
+			thumbURL, thumbCRC32C = url, objectCRC32C(thumbData)
+		}
 	}()
 
+	if includeClusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			clusters, err := getClusters(ctx)
+			if err != nil {
+				slog.Warn("snapshot_clusters_fetch_failed", "error", err.Error())
+				return
+			}
+			img, scale := renderThumbnail(pixels, canvasW, canvasH, thumbSize)
+			drawClusterOverlays(img, clusters, scale)
+			uploadToBucket(ctx, bucket, encodePNG(img), snapshotDir+"/clusters.png", "image/png")
+		}()
+	}
+
+	var zonesURL, zonesNote string
+	if req.Zones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			zones, err := getZones(ctx)
+			if err != nil {
+				slog.Warn("snapshot_zones_fetch_failed", "error", err.Error())
+				return
+			}
+			img, scale := renderThumbnail(pixels, canvasW, canvasH, thumbSize)
+			drawZoneOverlays(img, zones, scale)
+			if url, err := uploadToBucket(ctx, bucket, encodePNG(img), snapshotDir+"/zones.png", "image/png"); err == nil {
+				zonesURL = url
+				zonesNote = fmt.Sprintf("\nZones: %s", url) + zoneLegend(zones)
+			}
+		}()
+	}
+
 	wg.Wait()
 
 	// Create manifest
@@ -425,10 +1090,44 @@ func handleCloudEvent(ctx context.Context, e event.Event) error {
 		Tiles:        results,
 		ThumbnailURL: thumbURL,
 		PixelCount:   len(pixels),
+		PixelHash:    pixelHash,
+
+		ThumbnailCRC32C: thumbCRC32C,
+		ProfileURL:      profile.finish(ctx, timestamp),
+		ZonesURL:        zonesURL,
+		Tag:             req.Tag,
+		Layered:         prevThumb != nil,
+		ThumbnailSize:   thumbSize,
+		Bucket:          bucket,
 	}
 
 	manifestJSON, _ := json.MarshalIndent(manifest, "", "  ")
-	manifestURL, err := upload(ctx, manifestJSON, snapshotDir+"/manifest.json", "application/json")
+	manifestURL, err := uploadToBucket(ctx, bucket, manifestJSON, snapshotDir+"/manifest.json", "application/json")
+	// Only record a complete snapshot, so a partial one is regenerated next time
+	var saved *LastSnapshot
+	if err == nil && thumbURL != "" && len(results) == len(tilePixelMap) {
+		last := LastSnapshot{
+			Timestamp:    timestamp,
+			ManifestURL:  manifestURL,
+			ThumbnailURL: thumbURL,
+			PixelHash:    pixelHash,
+			PixelCount:   len(pixels),
+			TileCount:    len(results),
+			CanvasWidth:  canvasW,
+			CanvasHeight: canvasH,
+			Tag:          req.Tag,
+			Bucket:       bucket,
+
+			ThumbnailSize: thumbSize,
+
+			ManifestCRC32C: objectCRC32C(manifestJSON),
+		}
+		if err := saveLastSnapshot(ctx, last); err != nil {
+			slog.Warn("snapshot_pointer_save_failed", "error", err.Error())
+		} else {
+			saved = &last
+		}
+	}
 
 	elapsed := time.Since(start)
 
@@ -450,22 +1149,37 @@ func handleCloudEvent(ctx context.Context, e event.Event) error {
 		)
 	}
 
+	auditSnapshot(ctx, req, map[string]interface{}{
+		"pixelCount":  len(pixels),
+		"tileCount":   len(results),
+		"manifestUrl": manifestURL,
+		"bucket":      bucket,
+		"complete":    err == nil && thumbURL != "" && len(results) == len(tilePixelMap),
+	})
+
+	// Stop the session if this was its final snapshot
+	if err == nil {
+		if stopped, err := completePendingStop(ctx); err != nil {
+			slog.Error("session_stop_failed", "error", err.Error())
+		} else if stopped {
+			slog.Info("session_stopped", "user_id", req.UserID)
+			if req.ChannelID != "" {
+				sendChannelMessage(req.ChannelID, "⏹️ Session stopped. Final snapshot below.")
+			}
+		}
+	}
+
 	// Post to Discord
 	if req.ChannelID != "" {
-		postToDiscord(req.ChannelID, thumbURL, manifest)
+		postSnapshotToChannel(req.ChannelID, thumbURL, manifest)
 	}
 
 	// Send follow-up
 	if req.InteractionToken != "" && req.ApplicationID != "" {
 		msg := fmt.Sprintf("Snapshot generated in %.1fs: %d tiles (%d pixels)\nManifest: %s",
-			elapsed.Seconds(), len(results), len(pixels), manifestURL)
+			elapsed.Seconds(), len(results), len(pixels), manifestURL) + zonesNote + layerNote + sizeNote
 		sendFollowUp(req.ApplicationID, req.InteractionToken, msg)
 	}
 
-	// Flush traces before function exits (required for serverless)
-	if tracerProvider != nil {
-		tracerProvider.ForceFlush(ctx)
-	}
-
-	return nil
+	return saved, nil
 }
@@ -0,0 +1,212 @@
+package snapshotworker
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/team11/snapshot-worker/internal/discord"
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+const (
+	// overwriteNoticeWindow is the least time between two DMs to one user.
+	// Overwrites in between are kept in overwrite_notices/{userId} and
+	// summarized in the next DM.
+	overwriteNoticeWindow = 10 * time.Minute
+	// maxNotifyFailures DMs refused in a row turn /notify off for the user
+	maxNotifyFailures = 3
+	// overwritersListed is how many overwriters a DM names
+	overwritersListed = 5
+)
+
+// handleOverwriteNotice DMs a user whose pixels were painted over, at most
+// once per overwriteNoticeWindow. The preference is checked again here, so
+// a notice published just before /notify off is dropped.
+func handleOverwriteNotice(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "handleOverwriteNotice")
+	defer span.End()
+
+	var notice messages.OverwriteNotice
+	if err := json.Unmarshal(data, &notice); err != nil {
+		return fmt.Errorf("parse overwrite notice: %w", err)
+	}
+	span.SetAttributes(attribute.String("notice.owner_id", notice.OwnerID))
+	if notice.OwnerID == "" || discordBotToken == "" {
+		return nil
+	}
+
+	userRef := getFirestore().Collection("users").Doc(notice.OwnerID)
+	noticeRef := getFirestore().Collection("overwrite_notices").Doc(notice.OwnerID)
+	now := time.Now().UTC()
+	var due []messages.Overwriter
+	var failures int64
+	err := getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		due = nil
+		userDoc, err := tx.Get(userRef)
+		if err != nil || !userDoc.Exists() {
+			return nil
+		}
+		if on, _ := userDoc.Data()["notifyOverwrites"].(bool); !on {
+			return nil
+		}
+		failures, _ = userDoc.Data()["notifyFailures"].(int64)
+
+		var pending map[string]interface{}
+		var lastSentAt time.Time
+		if doc, err := tx.Get(noticeRef); err == nil && doc.Exists() {
+			pending, _ = doc.Data()["pending"].(map[string]interface{})
+			lastSentAt, _ = doc.Data()["lastSentAt"].(time.Time)
+		}
+		merged := mergeOverwriters(pending, notice.Overwriters)
+		if now.Sub(lastSentAt) < overwriteNoticeWindow {
+			return tx.Set(noticeRef, map[string]interface{}{
+				"pending":    overwritersToMap(merged),
+				"lastSentAt": lastSentAt,
+			})
+		}
+		due = merged
+		return tx.Set(noticeRef, map[string]interface{}{
+			"pending":    map[string]interface{}{},
+			"lastSentAt": now,
+		})
+	})
+	if err != nil {
+		slog.Error("overwrite_notice_failed", "owner_id", notice.OwnerID, "error", err.Error())
+		return err
+	}
+	if len(due) == 0 {
+		span.SetAttributes(attribute.Bool("notice.sent", false))
+		return nil
+	}
+
+	// The counts are already taken off pending; a failed DM loses them
+	// rather than repeating them after a redelivery
+	sendErr := sendOverwriteDM(ctx, notice.OwnerID, due)
+	span.SetAttributes(attribute.Bool("notice.sent", sendErr == nil))
+	var apiErr *discord.APIError
+	switch {
+	case sendErr == nil:
+		slog.Info("overwrite_notice_sent", "owner_id", notice.OwnerID, "overwriters", len(due))
+		if failures > 0 {
+			userRef.Update(ctx, []firestore.Update{{Path: "notifyFailures", Value: 0}})
+		}
+	case errors.As(sendErr, &apiErr) && apiErr.Status == http.StatusForbidden:
+		recordNotifyFailure(ctx, userRef)
+	default:
+		slog.Warn("overwrite_notice_send_failed", "owner_id", notice.OwnerID, "error", sendErr.Error())
+	}
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
+
+func sendOverwriteDM(ctx context.Context, userID string, overwriters []messages.Overwriter) error {
+	channelID, err := discordClient.CreateDM(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return discordClient.ChannelMessage(ctx, channelID, discord.Message{Content: formatOverwriteDM(overwriters)})
+}
+
+// recordNotifyFailure counts a DM the user's privacy settings refused and
+// turns /notify off once maxNotifyFailures are refused in a row.
+func recordNotifyFailure(ctx context.Context, userRef *firestore.DocumentRef) {
+	err := getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(userRef)
+		if err != nil {
+			return err
+		}
+		failures, _ := doc.Data()["notifyFailures"].(int64)
+		failures++
+		updates := []firestore.Update{{Path: "notifyFailures", Value: failures}}
+		if failures >= maxNotifyFailures {
+			updates = append(updates, firestore.Update{Path: "notifyOverwrites", Value: false})
+			slog.Warn("overwrite_notify_disabled", "user_id", userRef.ID, "failures", failures)
+		}
+		return tx.Update(userRef, updates)
+	})
+	if err != nil {
+		slog.Warn("overwrite_notify_failure_not_recorded", "user_id", userRef.ID, "error", err.Error())
+	}
+}
+
+// mergeOverwriters adds a notice's counts to those pending on
+// overwrite_notices, largest counts first
+func mergeOverwriters(pending map[string]interface{}, add []messages.Overwriter) []messages.Overwriter {
+	byUser := make(map[string]messages.Overwriter)
+	for userID, v := range pending {
+		entry, _ := v.(map[string]interface{})
+		name, _ := entry["username"].(string)
+		count, _ := entry["count"].(int64)
+		byUser[userID] = messages.Overwriter{UserID: userID, Username: name, Count: int(count)}
+	}
+	for _, o := range add {
+		cur := byUser[o.UserID]
+		byUser[o.UserID] = messages.Overwriter{UserID: o.UserID, Username: cmp.Or(o.Username, cur.Username), Count: cur.Count + o.Count}
+	}
+	merged := make([]messages.Overwriter, 0, len(byUser))
+	for _, o := range byUser {
+		merged = append(merged, o)
+	}
+	slices.SortFunc(merged, func(a, b messages.Overwriter) int {
+		return cmp.Or(b.Count-a.Count, cmp.Compare(a.UserID, b.UserID))
+	})
+	return merged
+}
+
+func overwritersToMap(overwriters []messages.Overwriter) map[string]interface{} {
+	m := make(map[string]interface{}, len(overwriters))
+	for _, o := range overwriters {
+		m[o.UserID] = map[string]interface{}{"username": o.Username, "count": o.Count}
+	}
+	return m
+}
+
+// formatOverwriteDM summarizes who painted over the user's pixels, naming
+// the overwritersListed users with the largest counts.
+func formatOverwriteDM(overwriters []messages.Overwriter) string {
+	total := 0
+	for _, o := range overwriters {
+		total += o.Count
+	}
+	pixels := func(n int) string {
+		if n == 1 {
+			return "1 pixel"
+		}
+		return fmt.Sprintf("%d pixels", n)
+	}
+
+	verb := "were"
+	if total == 1 {
+		verb = "was"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s of yours %s painted over on the canvas:\n", pixels(total), verb)
+	for i, o := range overwriters {
+		if i == overwritersListed && len(overwriters) > overwritersListed+1 {
+			rest := 0
+			for _, o := range overwriters[i:] {
+				rest += o.Count
+			}
+			fmt.Fprintf(&b, "- %s by %d other users\n", pixels(rest), len(overwriters)-i)
+			break
+		}
+		fmt.Fprintf(&b, "- %s by %s\n", pixels(o.Count), cmp.Or(o.Username, o.UserID))
+	}
+	b.WriteString("Use /notify off to stop these messages.")
+	return b.String()
+}
@@ -0,0 +1,193 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+const (
+	// ownershipLegendTop is how many owners the /canvas view:owners legend lists
+	ownershipLegendTop = 10
+	// unownedColor marks pixels of deleted users and pixels without an owner
+	unownedColor = "9E9E9E"
+)
+
+// ownerColor maps a user ID to a stable hex color: the FNV-1a hash picks the
+// hue and one of three lightness steps, at a fixed saturation, so the same
+// user always has the same color across maps.
+func ownerColor(userID string) string {
+	if userID == "" || userID == anonymizedUser {
+		return unownedColor
+	}
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	sum := h.Sum32()
+	hue := float64(sum % 360)
+	lightness := []float64{0.40, 0.55, 0.70}[(sum/360)%3]
+	r, g, b := hslToRGB(hue, 0.70, lightness)
+	return fmt.Sprintf("%02X%02X%02X", r, g, b)
+}
+
+// hslToRGB converts hue (degrees), saturation and lightness (0 to 1)
+func hslToRGB(h, s, l float64) (uint8, uint8, uint8) {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	to8 := func(v float64) uint8 { return uint8(math.Round((v + m) * 255)) }
+	return to8(r), to8(g), to8(b)
+}
+
+// tintByOwner returns the pixels recolored with ownerColor, ready for the
+// thumbnail pipeline
+func tintByOwner(pixels []Pixel) []Pixel {
+	tinted := make([]Pixel, len(pixels))
+	for i, p := range pixels {
+		tinted[i] = Pixel{X: p.X, Y: p.Y, Color: ownerColor(p.UserID), UserID: p.UserID}
+	}
+	return tinted
+}
+
+type ownerCount struct {
+	UserID string
+	Count  int
+}
+
+// topOwners ranks owners by pixels held, highest first; ties sort by ID.
+// Unowned pixels are left out.
+func topOwners(pixels []Pixel, n int) []ownerCount {
+	counts := make(map[string]int)
+	for _, p := range pixels {
+		if p.UserID != "" && p.UserID != anonymizedUser {
+			counts[p.UserID]++
+		}
+	}
+	ranked := make([]ownerCount, 0, len(counts))
+	for id, k := range counts {
+		ranked = append(ranked, ownerCount{UserID: id, Count: k})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].UserID < ranked[j].UserID
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// ownerNames reads the display names (set with /nickname) or else usernames
+// of the given users; missing ones fall back to a mention
+func ownerNames(ctx context.Context, owners []ownerCount) map[string]string {
+	names := make(map[string]string, len(owners))
+	refs := make([]*firestore.DocumentRef, len(owners))
+	for i, o := range owners {
+		refs[i] = getFirestore().Collection("users").Doc(o.UserID)
+		names[o.UserID] = fmt.Sprintf("<@%s>", o.UserID)
+	}
+	docs, err := getFirestore().GetAll(ctx, refs)
+	if err != nil {
+		return names
+	}
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		name, _ := doc.Data()["displayName"].(string)
+		if name == "" {
+			name, _ = doc.Data()["username"].(string)
+		}
+		if name != "" {
+			names[doc.Ref.ID] = name
+		}
+	}
+	return names
+}
+
+// handleOwnershipMap answers /canvas view:owners with the thumbnail drawn in
+// owner colors instead of pixel colors, and a legend of the top owners.
+func handleOwnershipMap(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "generateOwnershipMap")
+	defer span.End()
+
+	var req messages.OwnershipMapRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse ownership map request: %w", err)
+	}
+
+	canvasW, canvasH := getCanvasSize(ctx)
+	pixels, err := getPixelsPartitioned(ctx, canvasW, pixelLoad{Owner: true})
+	if err != nil {
+		slog.Error("ownership_map_pixels_fetch_failed", "error", err.Error())
+		sendFollowUp(req.ApplicationID, req.InteractionToken, fmt.Sprintf("Failed to get pixels: %v", err))
+		return err
+	}
+	if len(pixels) == 0 {
+		sendFollowUp(req.ApplicationID, req.InteractionToken, "No pixels placed yet.")
+		return nil
+	}
+
+	owners := topOwners(pixels, ownershipLegendTop)
+	span.SetAttributes(
+		attribute.Int("ownership_map.pixel_count", len(pixels)),
+		attribute.Int("ownership_map.legend_size", len(owners)),
+	)
+
+	// Like color charts, maps are throwaway; the bucket lifecycle removes
+	// ownership-maps/ after a day
+	path := fmt.Sprintf("ownership-maps/%d.png", time.Now().UnixMilli())
+	url, err := uploadWithRetry(ctx, generateThumbnail(tintByOwner(pixels), canvasW, canvasH, thumbnailSize), path, "image/png")
+	if err != nil {
+		slog.Error("ownership_map_upload_failed", "error", err.Error())
+		sendFollowUp(req.ApplicationID, req.InteractionToken, "Failed to upload the ownership map.")
+		return nil
+	}
+
+	names := ownerNames(ctx, owners)
+	var legend strings.Builder
+	for _, o := range owners {
+		fmt.Fprintf(&legend, "`#%s` %s: %d\n", ownerColor(o.UserID), names[o.UserID], o.Count)
+	}
+	fmt.Fprintf(&legend, "`#%s` deleted or unknown users", unownedColor)
+
+	slog.Info("ownership_map_generated", "pixel_count", len(pixels), "legend_size", len(owners), "user_id", req.UserID)
+	sendFollowUpEmbed(req.ApplicationID, req.InteractionToken, map[string]interface{}{
+		"title":       "Who owns what",
+		"description": fmt.Sprintf("Each pixel is drawn in its owner's color. Top %d owners by pixels held:\n%s", len(owners), legend.String()),
+		"image":       map[string]string{"url": url},
+		"color":       0x5865F2,
+	})
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
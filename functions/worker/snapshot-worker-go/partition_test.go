@@ -0,0 +1,106 @@
+package snapshotworker
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestPartitionBounds(t *testing.T) {
+	tests := []struct {
+		canvasW, n int
+		want       []int
+	}{
+		{1000, 4, []int{0, 250, 500, 750, 1000}},
+		{1001, 4, []int{0, 251, 502, 753, 1001}},
+		// Wide enough for every range to own whole tile columns
+		{8 * tileSize, 4, []int{0, 2 * tileSize, 4 * tileSize, 6 * tileSize, 8 * tileSize}},
+		{9 * tileSize, 4, []int{0, 3 * tileSize, 6 * tileSize, 9 * tileSize}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.canvasW, tt.n), func(t *testing.T) {
+			got := partitionBounds(tt.canvasW, tt.n)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("partitionBounds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// spreadPixels returns n pixels scattered over a w x h canvas, plus two
+// outside it that both reads must return alike
+func spreadPixels(n, w, h int) []Pixel {
+	pixels := make([]Pixel, 0, n+2)
+	for i := 0; i < n; i++ {
+		pixels = append(pixels, Pixel{X: (i * 7919) % w, Y: (i * 104729) % h, Color: fmt.Sprintf("%06X", i*2654435%0xFFFFFF)})
+	}
+	return append(pixels, Pixel{X: -1, Y: 0, Color: "000000"}, Pixel{X: w, Y: 0, Color: "000000"})
+}
+
+// sortedPixels orders pixels by position, so reads compare regardless of
+// the order Firestore returned them in
+func sortedPixels(pixels []Pixel) []Pixel {
+	sort.Slice(pixels, func(i, j int) bool {
+		if pixels[i].Y != pixels[j].Y {
+			return pixels[i].Y < pixels[j].Y
+		}
+		return pixels[i].X < pixels[j].X
+	})
+	return pixels
+}
+
+func TestPartitionedReadMatchesSerial(t *testing.T) {
+	requireEmulator(t)
+	const w, h = 3 * tileSize, 1024
+	seedPixels(t, w, h, spreadPixels(2000, w, h))
+	ctx := t.Context()
+
+	serial, err := loadPixels(ctx, pixelLoad{})
+	if err != nil {
+		t.Fatalf("serial read: %v", err)
+	}
+	partitioned, err := getPixelsPartitioned(ctx, w, pixelLoad{})
+	if err != nil {
+		t.Fatalf("partitioned read: %v", err)
+	}
+	if fmt.Sprint(sortedPixels(partitioned)) != fmt.Sprint(sortedPixels(serial)) {
+		t.Fatalf("partitioned read returned %d pixels, serial %d, or they differ", len(partitioned), len(serial))
+	}
+
+	want, got := groupByTile(serial, w, h), groupByTile(partitioned, w, h)
+	if len(got) != len(want) {
+		t.Fatalf("%d tiles, want %d", len(got), len(want))
+	}
+	for k, pixels := range want {
+		a := generateTile(pixels, k.x, k.y, w, h)
+		b := generateTile(got[k], k.x, k.y, w, h)
+		if !bytes.Equal(a, b) {
+			t.Errorf("tile %v differs from the serial read's", k)
+		}
+	}
+}
+
+// BenchmarkPixelReads compares the single query with the partitioned read
+// on a canvas wide enough to be split
+func BenchmarkPixelReads(b *testing.B) {
+	requireEmulator(b)
+	const w, h = 8 * tileSize, 2048
+	seedPixels(b, w, h, spreadPixels(20000, w, h))
+	ctx := b.Context()
+
+	b.Run("serial", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := loadPixels(ctx, pixelLoad{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("partitioned", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := getPixelsPartitioned(ctx, w, pixelLoad{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
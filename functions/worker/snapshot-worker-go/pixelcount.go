@@ -0,0 +1,171 @@
+package snapshotworker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+// Pixel counts come from Firestore count aggregations, which read one index
+// entry per 1000 documents instead of every pixel. stats/overview keeps the
+// last total: the session worker answers /canvas from it, counts fall back
+// on it where the aggregation API is unavailable (some emulator versions),
+// and pixel_count_reconcile corrects it.
+
+// snapshotMaxPixels (SNAPSHOT_MAX_PIXELS) refuses /snapshot of a canvas
+// with more pixels, before they are read; 0 is no limit
+var snapshotMaxPixels, _ = strconv.Atoi(os.Getenv("SNAPSHOT_MAX_PIXELS"))
+
+// errSnapshotGuardrail refuses a /snapshot that checkSnapshotGuardrail
+// stopped; redelivery would be refused the same way
+var errSnapshotGuardrail = fmt.Errorf("snapshot refused by its pixel count")
+
+func overviewRef() *firestore.DocumentRef {
+	return getFirestore().Collection("stats").Doc("overview")
+}
+
+// aggregateCount counts the documents matching q
+func aggregateCount(ctx context.Context, q firestore.Query) (int, error) {
+	res, err := q.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := res["count"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("count aggregation returned %T", res["count"])
+	}
+	return int(v.GetIntegerValue()), nil
+}
+
+// aggregationUnavailable reports whether err means the backend does not
+// serve aggregation queries, rather than that the count failed
+func aggregationUnavailable(err error) bool {
+	return status.Code(err) == codes.Unimplemented
+}
+
+// countPixels counts the pixels on the canvas. Without aggregations it
+// returns the count last stored in stats/overview, or the aggregation's
+// error when there is none.
+func countPixels(ctx context.Context) (int, error) {
+	n, err := aggregateCount(ctx, getFirestore().Collection("pixels").Query)
+	if err == nil || !aggregationUnavailable(err) {
+		return n, err
+	}
+	doc, derr := overviewRef().Get(ctx)
+	if derr != nil || doc.Data()["pixelCount"] == nil {
+		return 0, err
+	}
+	slog.Warn("pixel_count_fallback", "source", "stats/overview", "error", err.Error())
+	return toIntVal(doc.Data()["pixelCount"]), nil
+}
+
+// countPixelsIn counts the pixels inside r, with the same composite index
+// as queryRegionPixels. Without aggregations it reads the region's
+// document names instead; no stored count covers a region.
+func countPixelsIn(ctx context.Context, r ManifestRegion) (int, error) {
+	q := getFirestore().Collection("pixels").
+		Where("x", ">=", r.X).
+		Where("x", "<", r.X+r.Width).
+		Where("y", ">=", r.Y).
+		Where("y", "<", r.Y+r.Height).
+		OrderBy("x", firestore.Asc).
+		OrderBy("y", firestore.Asc)
+	n, err := aggregateCount(ctx, q)
+	if err == nil || !aggregationUnavailable(err) {
+		return n, err
+	}
+	docs, err := q.Select().Documents(ctx).GetAll()
+	if err != nil {
+		return 0, err
+	}
+	return len(docs), nil
+}
+
+// snapshotGuardrailMessage is why a /snapshot of a canvas holding count
+// pixels is refused, or "" when it may render
+func snapshotGuardrailMessage(count, maxPixels int) string {
+	switch {
+	case count == 0:
+		return "The canvas is empty: there is nothing to snapshot yet."
+	case maxPixels > 0 && count > maxPixels:
+		return fmt.Sprintf("The canvas has %d pixels, more than the %d a snapshot can render (SNAPSHOT_MAX_PIXELS).", count, maxPixels)
+	}
+	return ""
+}
+
+// checkSnapshotGuardrail counts the canvas before a /snapshot reads all of
+// it, and returns why it is refused or "". Snapshots without an interaction
+// (scheduled ones, the final one of /session stop) always render, so a stop
+// can complete; so does any snapshot the count fails for.
+func checkSnapshotGuardrail(ctx context.Context, req messages.SnapshotRequest) string {
+	if req.InteractionToken == "" {
+		return ""
+	}
+	count, err := countPixels(ctx)
+	if err != nil {
+		slog.Warn("snapshot_guardrail_skipped", "error", err.Error())
+		return ""
+	}
+	return snapshotGuardrailMessage(count, snapshotMaxPixels)
+}
+
+// handlePixelCountReconcile corrects stats/overview from an aggregation
+// count, on the schedule of pixel_count_reconcile_schedule, and logs how
+// far the stored count had drifted. Nothing is written when aggregations
+// are unavailable: the stored count is then the only one.
+func handlePixelCountReconcile(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "handlePixelCountReconcile")
+	defer span.End()
+
+	actual, err := aggregateCount(ctx, getFirestore().Collection("pixels").Query)
+	if aggregationUnavailable(err) {
+		slog.Warn("pixel_count_reconcile_skipped", "reason", "aggregation unavailable")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Without a stored count there is nothing to drift from
+	stored, drift := actual, 0
+	if doc, err := overviewRef().Get(ctx); err == nil && doc.Data()["pixelCount"] != nil {
+		stored = toIntVal(doc.Data()["pixelCount"])
+		drift = actual - stored
+	}
+	span.SetAttributes(
+		attribute.Int("pixel_count.actual", actual),
+		attribute.Int("pixel_count.drift", drift),
+	)
+
+	now := time.Now().UTC()
+	if _, err := overviewRef().Set(ctx, map[string]interface{}{
+		"pixelCount":   actual,
+		"countedAt":    now,
+		"reconciledAt": now,
+		"lastDrift":    drift,
+	}); err != nil {
+		return err
+	}
+
+	level := slog.LevelInfo
+	if drift != 0 {
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "pixel_count_reconciled", "stored", stored, "actual", actual, "drift", drift)
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package snapshotworker
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+func TestSnapshotGuardrailMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		count     int
+		maxPixels int
+		want      string
+	}{
+		{"empty canvas", 0, 0, "The canvas is empty: there is nothing to snapshot yet."},
+		{"no limit", 5_000_000, 0, ""},
+		{"under the limit", 999, 1000, ""},
+		{"at the limit", 1000, 1000, ""},
+		{"over the limit", 1001, 1000, "The canvas has 1001 pixels, more than the 1000 a snapshot can render (SNAPSHOT_MAX_PIXELS)."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snapshotGuardrailMessage(tt.count, tt.maxPixels); got != tt.want {
+				t.Errorf("snapshotGuardrailMessage(%d, %d) = %q, want %q", tt.count, tt.maxPixels, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotGuardrailSkipsUninteractiveSnapshots(t *testing.T) {
+	// Counting would need Firestore; the final snapshot of a stop never counts
+	if msg := checkSnapshotGuardrail(t.Context(), messages.SnapshotRequest{UserID: "u1"}); msg != "" {
+		t.Errorf("checkSnapshotGuardrail() = %q for a snapshot without an interaction", msg)
+	}
+}
+
+func TestAggregationUnavailable(t *testing.T) {
+	if !aggregationUnavailable(status.Error(codes.Unimplemented, "aggregation queries are not supported")) {
+		t.Error("Unimplemented was not taken for missing aggregations")
+	}
+	for _, err := range []error{nil, errors.New("boom"), status.Error(codes.Unavailable, "down"), status.Error(codes.FailedPrecondition, "needs an index")} {
+		if aggregationUnavailable(err) {
+			t.Errorf("aggregationUnavailable(%v) = true", err)
+		}
+	}
+}
+
+func TestCountPixels(t *testing.T) {
+	requireEmulator(t)
+	seedPixels(t, 16, 16, []Pixel{
+		{X: 1, Y: 1, Color: "FF0000"},
+		{X: 2, Y: 3, Color: "00FF00"},
+		{X: 10, Y: 12, Color: "0000FF"},
+	})
+
+	if n, err := countPixels(t.Context()); err != nil || n != 3 {
+		t.Errorf("countPixels() = %d, %v; want 3", n, err)
+	}
+	tests := []struct {
+		region ManifestRegion
+		want   int
+	}{
+		{ManifestRegion{X: 0, Y: 0, Width: 16, Height: 16}, 3},
+		{ManifestRegion{X: 0, Y: 0, Width: 4, Height: 4}, 2},
+		{ManifestRegion{X: 2, Y: 3, Width: 1, Height: 1}, 1},
+		{ManifestRegion{X: 4, Y: 0, Width: 4, Height: 16}, 0},
+	}
+	for _, tt := range tests {
+		if n, err := countPixelsIn(t.Context(), tt.region); err != nil || n != tt.want {
+			t.Errorf("countPixelsIn(%+v) = %d, %v; want %d", tt.region, n, err, tt.want)
+		}
+	}
+}
+
+func TestPixelCountReconcile(t *testing.T) {
+	requireEmulator(t)
+	seedPixels(t, 16, 16, []Pixel{{X: 1, Y: 1, Color: "FF0000"}, {X: 2, Y: 3, Color: "00FF00"}})
+	seedDoc(t, "stats/overview", map[string]interface{}{"pixelCount": 7})
+
+	if err := handlePixelCountReconcile(t.Context()); err != nil {
+		t.Fatalf("handlePixelCountReconcile: %v", err)
+	}
+	overview := readDoc(t, "stats/overview")
+	if toIntVal(overview["pixelCount"]) != 2 || toIntVal(overview["lastDrift"]) != -5 || overview["reconciledAt"] == nil {
+		t.Errorf("stats/overview = %v, want pixelCount 2 after a drift of -5", overview)
+	}
+
+	// A second run finds nothing to correct
+	if err := handlePixelCountReconcile(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if overview := readDoc(t, "stats/overview"); toIntVal(overview["lastDrift"]) != 0 {
+		t.Errorf("lastDrift = %v after reconciling twice, want 0", overview["lastDrift"])
+	}
+}
@@ -0,0 +1,186 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+const (
+	pixelHistoryLimit = 20
+	// The chart has one bar per placement, oldest on the left; a bar's height
+	// is how long its color stayed on the canvas
+	historyChartWidth   = 800
+	historyChartHeight  = 160
+	historyChartPadding = 16
+	historyChartBarGap  = 4
+)
+
+// historyEntry is one document of pixel_history, as written by the pixel
+// worker
+type historyEntry struct {
+	Color         string    `firestore:"color"`
+	PreviousColor string    `firestore:"previousColor"`
+	UserID        string    `firestore:"userId"`
+	Username      string    `firestore:"username"`
+	Source        string    `firestore:"source"`
+	Timestamp     time.Time `firestore:"timestamp"`
+}
+
+// getPixelHistory returns the latest placements at (x, y), newest first
+func getPixelHistory(ctx context.Context, x, y int) ([]historyEntry, error) {
+	docs, err := getFirestore().Collection("pixel_history").
+		Where("x", "==", x).
+		Where("y", "==", y).
+		OrderBy("timestamp", firestore.Desc).
+		Limit(pixelHistoryLimit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]historyEntry, 0, len(docs))
+	for _, doc := range docs {
+		var e historyEntry
+		if err := doc.DataTo(&e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// generateHistoryChart draws one bar per entry in chronological order, filled
+// with the placed color. Each bar's height is proportional to how long the
+// color lasted: until the next placement, or until now for the newest.
+func generateHistoryChart(entries []historyEntry, now time.Time) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, historyChartWidth, historyChartHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	n := len(entries)
+	if n == 0 {
+		return encodePNG(img)
+	}
+
+	durations := make([]time.Duration, n)
+	var longest time.Duration
+	for i := range entries {
+		// entries are newest first; bar i is entry n-1-i
+		e := entries[n-1-i]
+		end := now
+		if i < n-1 {
+			end = entries[n-2-i].Timestamp
+		}
+		durations[i] = max(end.Sub(e.Timestamp), 0)
+		longest = max(longest, durations[i])
+	}
+
+	outline := color.RGBA{0xCC, 0xCC, 0xCC, 0xFF}
+	maxBarH := historyChartHeight - 2*historyChartPadding
+	barW := (historyChartWidth - 2*historyChartPadding - (n-1)*historyChartBarGap) / n
+	baseline := historyChartHeight - historyChartPadding
+
+	for i, d := range durations {
+		h := maxBarH
+		if longest > 0 {
+			h = max(4, int(int64(d)*int64(maxBarH)/int64(longest)))
+		}
+		x := historyChartPadding + i*(barW+historyChartBarGap)
+		bar := image.Rect(x, baseline-h, x+barW, baseline)
+
+		// A one-pixel frame keeps white and near-white bars visible
+		draw.Draw(img, bar, &image.Uniform{outline}, image.Point{}, draw.Src)
+		draw.Draw(img, bar.Inset(1), &image.Uniform{parseColor(entries[n-1-i].Color)}, image.Point{}, draw.Src)
+	}
+	return encodePNG(img)
+}
+
+// historyEmbed lists the entries newest first, one line each, and takes its
+// accent color from the current color of the pixel.
+func historyEmbed(x, y int, entries []historyEntry, chartURL string) map[string]interface{} {
+	var lines strings.Builder
+	for _, e := range entries {
+		name := e.Username
+		if name == "" {
+			name = e.UserID
+		}
+		fmt.Fprintf(&lines, "<t:%d:f> `#%s` %s (%s)\n",
+			e.Timestamp.Unix(), strings.ToUpper(strings.TrimPrefix(e.Color, "#")), name, e.Source)
+	}
+
+	accent, _ := strconv.ParseInt(strings.TrimPrefix(entries[0].Color, "#"), 16, 32)
+	embed := map[string]interface{}{
+		"title":       fmt.Sprintf("History of (%d, %d)", x, y),
+		"description": lines.String(),
+		"color":       accent,
+		"footer":      map[string]string{"text": fmt.Sprintf("Last %d placements, newest first. Bars show how long each color lasted.", len(entries))},
+	}
+	if chartURL != "" {
+		embed["image"] = map[string]string{"url": chartURL}
+	}
+	return embed
+}
+
+func handlePixelHistory(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "pixelHistory")
+	defer span.End()
+
+	var req messages.PixelHistoryRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse history request: %w", err)
+	}
+	reply := func(content string) {
+		sendFollowUp(req.ApplicationID, req.InteractionToken, content)
+	}
+	span.SetAttributes(
+		attribute.Int("history.x", req.X),
+		attribute.Int("history.y", req.Y),
+	)
+
+	// req has user coordinates; history is kept in storage coordinates
+	canvasW, canvasH, origin := getCanvasView(ctx)
+	if req.X < 0 || req.X >= canvasW || req.Y < 0 || req.Y >= canvasH {
+		reply(fmt.Sprintf("(%d, %d) is out of bounds (0-%d, 0-%d)", req.X, req.Y, canvasW-1, canvasH-1))
+		return nil
+	}
+	x, y := origin.ToCanvas(req.X, req.Y, canvasH)
+
+	entries, err := getPixelHistory(ctx, x, y)
+	if err != nil {
+		slog.Error("pixel_history_fetch_failed", "x", req.X, "y", req.Y, "error", err.Error())
+		reply(fmt.Sprintf("Failed to get the history: %v", err))
+		return err
+	}
+	span.SetAttributes(attribute.Int("history.entries", len(entries)))
+	if len(entries) == 0 {
+		reply("No history found.")
+		return nil
+	}
+
+	// The chart is optional; the embed is still useful without it.
+	// history-charts/ is removed by the bucket lifecycle after a day.
+	path := fmt.Sprintf("history-charts/%d-%d-%d.png", time.Now().UnixMilli(), req.X, req.Y)
+	url, err := uploadWithRetry(ctx, generateHistoryChart(entries, time.Now()), path, "image/png")
+	if err != nil {
+		slog.Warn("history_chart_upload_failed", "x", req.X, "y", req.Y, "error", err.Error())
+		url = ""
+	}
+
+	slog.Info("pixel_history_sent", "x", req.X, "y", req.Y, "entries", len(entries), "user_id", req.UserID)
+	sendFollowUpEmbed(req.ApplicationID, req.InteractionToken, historyEmbed(req.X, req.Y, entries, url))
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
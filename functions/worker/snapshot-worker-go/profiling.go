@@ -0,0 +1,98 @@
+package snapshotworker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	httppprof "net/http/pprof"
+	"os"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// maxProfileDuration caps a CPU profile even when tile generation runs longer
+const maxProfileDuration = 30 * time.Second
+
+var (
+	// ENABLE_PROFILING uploads a CPU profile of each snapshot's tile
+	// generation. ENABLE_PPROF serves net/http/pprof on PPROF_PORT, which is
+	// only reachable in local emulation or on Cloud Run.
+	profilingEnabled bool
+	pprofEnabled     bool
+	pprofPort        string
+)
+
+func init() {
+	profilingEnabled = os.Getenv("ENABLE_PROFILING") == "true"
+	pprofEnabled = os.Getenv("ENABLE_PPROF") == "true"
+	pprofPort = os.Getenv("PPROF_PORT")
+	if pprofPort == "" {
+		pprofPort = "6060"
+	}
+
+	if pprofEnabled {
+		go servePprof()
+	}
+}
+
+func servePprof() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+
+	slog.Info("pprof_listening", "port", pprofPort)
+	if err := http.ListenAndServe(":"+pprofPort, mux); err != nil {
+		slog.Warn("pprof_server_failed", "port", pprofPort, "error", err.Error())
+	}
+}
+
+// cpuProfile is a CPU profile in progress
+type cpuProfile struct {
+	buf     bytes.Buffer
+	timer   *time.Timer
+	stopped sync.Once
+}
+
+// startCPUProfile begins profiling when ENABLE_PROFILING is set. It returns
+// nil when profiling is off or another invocation on this instance already
+// holds the process-wide CPU profiler.
+func startCPUProfile() *cpuProfile {
+	if !profilingEnabled {
+		return nil
+	}
+	p := &cpuProfile{}
+	if err := pprof.StartCPUProfile(&p.buf); err != nil {
+		slog.Warn("cpu_profile_start_failed", "error", err.Error())
+		return nil
+	}
+	p.timer = time.AfterFunc(maxProfileDuration, p.stop)
+	return p
+}
+
+func (p *cpuProfile) stop() {
+	p.stopped.Do(pprof.StopCPUProfile)
+}
+
+// finish stops the profile and uploads it to profiles/{timestamp}.pprof,
+// returning its URL or "" when nothing was uploaded.
+func (p *cpuProfile) finish(ctx context.Context, timestamp int64) string {
+	if p == nil {
+		return ""
+	}
+	p.timer.Stop()
+	p.stop()
+
+	url, err := uploadWithRetry(ctx, p.buf.Bytes(), fmt.Sprintf("profiles/%d.pprof", timestamp), "application/octet-stream")
+	if err != nil {
+		slog.Warn("cpu_profile_upload_failed", "error", err.Error())
+		return ""
+	}
+	slog.Info("cpu_profile_uploaded", "bytes", p.buf.Len(), "timestamp", timestamp)
+	return url
+}
@@ -0,0 +1,154 @@
+package snapshotworker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// parsedProfile holds the parts of a pprof profile.proto the tests check
+type parsedProfile struct {
+	sampleTypes [][2]int64 // (type, unit) string table indexes
+	samples     int
+	strings     []string
+	duration    int64
+}
+
+// parseProfile decodes a gzipped profile.proto. github.com/google/pprof is
+// not a dependency, so this walks the wire format for the fields it needs
+// and fails on anything malformed.
+func parseProfile(t *testing.T, data []byte) parsedProfile {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("profile is not gzipped: %v", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompress profile: %v", err)
+	}
+
+	var p parsedProfile
+	for len(raw) > 0 {
+		num, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			t.Fatalf("bad tag: %v", protowire.ParseError(n))
+		}
+		raw = raw[n:]
+		var field []byte
+		var value uint64
+		switch typ {
+		case protowire.BytesType:
+			field, n = protowire.ConsumeBytes(raw)
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(raw)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, raw)
+		}
+		if n < 0 {
+			t.Fatalf("bad field %d: %v", num, protowire.ParseError(n))
+		}
+		raw = raw[n:]
+
+		switch num {
+		case 1: // sample_type
+			p.sampleTypes = append(p.sampleTypes, parseValueType(t, field))
+		case 2: // sample
+			p.samples++
+		case 6: // string_table
+			p.strings = append(p.strings, string(field))
+		case 10: // duration_nanos
+			p.duration = int64(value)
+		}
+	}
+	return p
+}
+
+func parseValueType(t *testing.T, b []byte) [2]int64 {
+	t.Helper()
+	var vt [2]int64
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || typ != protowire.VarintType {
+			t.Fatalf("bad ValueType")
+		}
+		b = b[n:]
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			t.Fatalf("bad ValueType: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if num == 1 || num == 2 {
+			vt[num-1] = int64(v)
+		}
+	}
+	return vt
+}
+
+func TestCPUProfileUpload(t *testing.T) {
+	f := useFakeGCS(t)
+	prevEnabled, prevBucket, prevMirrors := profilingEnabled, snapshotsBucket, mirrorBuckets
+	profilingEnabled, snapshotsBucket, mirrorBuckets = true, "snapshots", nil
+	t.Cleanup(func() { profilingEnabled, snapshotsBucket, mirrorBuckets = prevEnabled, prevBucket, prevMirrors })
+
+	profile := startCPUProfile()
+	if profile == nil {
+		t.Fatal("startCPUProfile() = nil with ENABLE_PROFILING set")
+	}
+	if second := startCPUProfile(); second != nil {
+		second.stop()
+		t.Fatal("a second profile started while the first was running")
+	}
+
+	// Something for the profiler to sample
+	sink := 0
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); {
+		for i := range 10000 {
+			sink += i * i
+		}
+	}
+	_ = sink
+
+	if url := profile.finish(t.Context(), 1700000000); url == "" {
+		t.Fatal("finish() returned no URL")
+	}
+	data, ok := f.object("snapshots", "profiles/1700000000.pprof")
+	if !ok {
+		t.Fatal("profile was not uploaded to profiles/1700000000.pprof")
+	}
+
+	p := parseProfile(t, data)
+	var types []string
+	for _, vt := range p.sampleTypes {
+		if vt[0] >= int64(len(p.strings)) || vt[1] >= int64(len(p.strings)) {
+			t.Fatalf("sample type %v points outside the string table", vt)
+		}
+		types = append(types, p.strings[vt[0]]+"/"+p.strings[vt[1]])
+	}
+	if !slices.Contains(types, "cpu/nanoseconds") {
+		t.Errorf("sample types = %v, want cpu/nanoseconds", types)
+	}
+	if p.duration <= 0 {
+		t.Errorf("duration = %d, want > 0", p.duration)
+	}
+	t.Logf("profile: %d bytes, %d samples", len(data), p.samples)
+}
+
+func TestCPUProfileDisabled(t *testing.T) {
+	prev := profilingEnabled
+	profilingEnabled = false
+	t.Cleanup(func() { profilingEnabled = prev })
+
+	profile := startCPUProfile()
+	if profile != nil {
+		t.Fatal("startCPUProfile() started a profile without ENABLE_PROFILING")
+	}
+	if url := profile.finish(t.Context(), 1700000000); url != "" {
+		t.Errorf("finish() on a nil profile = %q, want empty", url)
+	}
+}
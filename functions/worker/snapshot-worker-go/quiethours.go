@@ -0,0 +1,89 @@
+package snapshotworker
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// quietHours is a daily window, in minutes after midnight, during which
+// snapshots are still generated but not posted to the Discord channel. A
+// window whose end is before its start wraps past midnight.
+type quietHours struct {
+	start, end int
+	loc        *time.Location
+	raw        string
+}
+
+// quiet is nil unless QUIET_HOURS is set, e.g. "22:00-07:00 UTC"
+var quiet *quietHours
+
+func init() {
+	if v := strings.TrimSpace(os.Getenv("QUIET_HOURS")); v != "" {
+		q, err := parseQuietHours(v)
+		if err != nil {
+			slog.Warn("quiet_hours_invalid", "value", v, "error", err.Error())
+			return
+		}
+		quiet = q
+	}
+}
+
+// parseQuietHours reads "HH:MM-HH:MM" with an optional IANA zone name
+// after it; without one the times are UTC.
+func parseQuietHours(s string) (*quietHours, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("expected \"HH:MM-HH:MM [zone]\"")
+	}
+	loc := time.UTC
+	if len(fields) == 2 {
+		l, err := time.LoadLocation(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		loc = l
+	}
+
+	bounds := strings.Split(fields[0], "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("expected \"HH:MM-HH:MM [zone]\"")
+	}
+	var minutes [2]int
+	for i, b := range bounds {
+		t, err := time.Parse("15:04", b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q", b)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	if minutes[0] == minutes[1] {
+		return nil, fmt.Errorf("start and end are equal")
+	}
+	return &quietHours{start: minutes[0], end: minutes[1], loc: loc, raw: s}, nil
+}
+
+// contains reports whether now falls inside the window
+func (q *quietHours) contains(now time.Time) bool {
+	if q == nil {
+		return false
+	}
+	local := now.In(q.loc)
+	m := local.Hour()*60 + local.Minute()
+	if q.start < q.end {
+		return m >= q.start && m < q.end
+	}
+	return m >= q.start || m < q.end
+}
+
+// postSnapshotToChannel posts the snapshot embed unless quiet hours are on.
+// Follow-ups to the command's author are not affected.
+func postSnapshotToChannel(channelID, thumbnailURL string, m Manifest) {
+	if quiet.contains(time.Now()) {
+		slog.Info("snapshot_post_suppressed", "channel_id", channelID, "quiet_hours", quiet.raw)
+		return
+	}
+	postToDiscord(channelID, thumbnailURL, m)
+}
@@ -0,0 +1,124 @@
+package snapshotworker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		in      string
+		start   int
+		end     int
+		zone    string
+		wantErr bool
+	}{
+		{in: "22:00-07:00", start: 22 * 60, end: 7 * 60, zone: "UTC"},
+		{in: "22:00-07:00 UTC", start: 22 * 60, end: 7 * 60, zone: "UTC"},
+		{in: "01:30-05:45 Europe/Paris", start: 90, end: 345, zone: "Europe/Paris"},
+		{in: "22:00", wantErr: true},
+		{in: "22:00-07:00 UTC extra", wantErr: true},
+		{in: "25:00-07:00", wantErr: true},
+		{in: "22:00-07:00 Nowhere/Special", wantErr: true},
+		{in: "08:00-08:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			q, err := parseQuietHours(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseQuietHours(%q) = %+v, want an error", tt.in, q)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseQuietHours(%q): %v", tt.in, err)
+			}
+			if q.start != tt.start || q.end != tt.end || q.loc.String() != tt.zone {
+				t.Errorf("got %d-%d %s, want %d-%d %s", q.start, q.end, q.loc, tt.start, tt.end, tt.zone)
+			}
+		})
+	}
+}
+
+func TestQuietHoursContains(t *testing.T) {
+	overnight, _ := parseQuietHours("22:00-07:00 UTC")
+	daytime, _ := parseQuietHours("09:00-17:00 UTC")
+	paris, _ := parseQuietHours("22:00-07:00 Europe/Paris")
+	at := func(hh, mm int) time.Time { return time.Date(2026, 1, 15, hh, mm, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name string
+		q    *quietHours
+		now  time.Time
+		want bool
+	}{
+		{"overnight late evening", overnight, at(23, 30), true},
+		{"overnight at start", overnight, at(22, 0), true},
+		{"overnight after midnight", overnight, at(3, 0), true},
+		{"overnight at end", overnight, at(7, 0), false},
+		{"overnight midday", overnight, at(12, 0), false},
+		{"daytime inside", daytime, at(12, 0), true},
+		{"daytime before", daytime, at(8, 59), false},
+		{"daytime at end", daytime, at(17, 0), false},
+		// 21:30 UTC is 22:30 in Paris in January
+		{"zone inside", paris, at(21, 30), true},
+		{"zone outside", paris, at(6, 30), false},
+		{"not configured", nil, at(3, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.contains(tt.now); got != tt.want {
+				t.Errorf("contains(%s) = %v, want %v", tt.now.Format("15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostSnapshotToChannel(t *testing.T) {
+	// Windows around the current time, so postSnapshotToChannel's own clock
+	// is inside or outside them
+	now := time.Now().UTC()
+	window := func(from, to time.Duration) *quietHours {
+		q, err := parseQuietHours(fmt.Sprintf("%s-%s UTC", now.Add(from).Format("15:04"), now.Add(to).Format("15:04")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+
+	tests := []struct {
+		name     string
+		quiet    *quietHours
+		wantPost bool
+	}{
+		{"in window suppressed", window(-time.Hour, time.Hour), false},
+		{"out of window posted", window(time.Hour, 2*time.Hour), true},
+		{"not configured posted", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := useFakeDiscord(t)
+			prev := quiet
+			quiet = tt.quiet
+			t.Cleanup(func() { quiet = prev })
+
+			postSnapshotToChannel("chan-1", "https://example.test/thumb.png", Manifest{CanvasWidth: 100, CanvasHeight: 100})
+
+			calls := d.received()
+			if !tt.wantPost {
+				if len(calls) != 0 {
+					t.Errorf("posted %d messages during quiet hours", len(calls))
+				}
+				return
+			}
+			if len(calls) != 1 || calls[0].Path != "/channels/chan-1/messages" {
+				t.Fatalf("calls = %+v, want one post to chan-1", calls)
+			}
+			if len(calls[0].Body.Embeds) != 1 || calls[0].Body.Embeds[0]["title"] != "Canvas Snapshot" {
+				t.Errorf("body = %+v, want the snapshot embed", calls[0].Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/team11/snapshot-worker/internal/audit"
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+// getRegionPixels reads only the pixels inside r, like getTilePixels: a
+// range query on x, then y filtered in memory.
+func getRegionPixels(ctx context.Context, r ManifestRegion) ([]Pixel, error) {
+	q := getFirestore().Collection("pixels").
+		Where("x", ">=", r.X).
+		Where("x", "<", r.X+r.Width)
+	pixels, err := queryPixels(ctx, q, pixelLoad{})
+	if err != nil {
+		return nil, err
+	}
+	return clipToRegion(pixels, r), nil
+}
+
+// clipToRegion keeps the pixels inside r and moves them so the region
+// starts at (0, 0). The result can then go through the tile and thumbnail
+// pipeline as a canvas of r.Width by r.Height.
+func clipToRegion(pixels []Pixel, r ManifestRegion) []Pixel {
+	clipped := make([]Pixel, 0, len(pixels))
+	for _, p := range pixels {
+		if p.X >= r.X && p.X < r.X+r.Width && p.Y >= r.Y && p.Y < r.Y+r.Height {
+			clipped = append(clipped, Pixel{X: p.X - r.X, Y: p.Y - r.Y, Color: p.Color})
+		}
+	}
+	return clipped
+}
+
+// handleSnapshotRegion renders part of the canvas into regions/{timestamp}/,
+// laid out like a full snapshot. The manifest's canvasWidth and canvasHeight
+// are the region's size and its region field gives the offset. Region
+// snapshots never replace snapshots/latest.
+func handleSnapshotRegion(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "generateRegionSnapshot")
+	defer span.End()
+
+	var req messages.SnapshotRegionRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse region snapshot request: %w", err)
+	}
+	reply := func(content string) {
+		sendFollowUp(req.ApplicationID, req.InteractionToken, content)
+	}
+
+	// The corners are typed in the session's origin; the bounds are the same
+	// either way, the region is then taken in storage coordinates
+	canvasW, canvasH, origin := getCanvasView(ctx)
+	if req.MinX < 0 || req.MinY < 0 || req.MaxX >= canvasW || req.MaxY >= canvasH || req.MinX > req.MaxX || req.MinY > req.MaxY {
+		reply(fmt.Sprintf("Region x %d-%d, y %d-%d is out of bounds (0-%d, 0-%d)",
+			req.MinX, req.MaxX, req.MinY, req.MaxY, canvasW-1, canvasH-1))
+		return nil
+	}
+	minX, minY, maxX, maxY := origin.ToCanvasRect(req.MinX, req.MinY, req.MaxX, req.MaxY, canvasH)
+	region := ManifestRegion{X: minX, Y: minY, Width: maxX - minX + 1, Height: maxY - minY + 1}
+	span.SetAttributes(
+		attribute.Int("region.x", region.X),
+		attribute.Int("region.y", region.Y),
+		attribute.Int("region.width", region.Width),
+		attribute.Int("region.height", region.Height),
+	)
+
+	bucket, err := selectSnapshotsBucket(ctx, "")
+	if err != nil {
+		slog.Error("snapshot_bucket_rejected", "error", err.Error(), "user_id", req.UserID)
+		reply(fmt.Sprintf("Snapshot refused: %v", err))
+		return nil
+	}
+
+	// The region's own count, so a small region of a large canvas renders
+	if snapshotMaxPixels > 0 {
+		if n, err := countPixelsIn(ctx, region); err != nil {
+			slog.Warn("snapshot_guardrail_skipped", "error", err.Error())
+		} else if n > snapshotMaxPixels {
+			reply(snapshotGuardrailMessage(n, snapshotMaxPixels))
+			return nil
+		}
+	}
+
+	pixels, err := getRegionPixels(ctx, region)
+	if err != nil {
+		slog.Error("region_pixels_fetch_failed", "error", err.Error(), "user_id", req.UserID)
+		reply(fmt.Sprintf("Failed to get pixels: %v", err))
+		return err
+	}
+
+	timestamp := time.Now().UnixMilli()
+	dir := fmt.Sprintf("regions/%d", timestamp)
+	tilePixelMap := groupByTile(pixels, region.Width, region.Height)
+
+	sem := make(chan struct{}, tileWorkers())
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var results []TileResult
+	for tk, px := range tilePixelMap {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			data := generateTile(px, tk.x, tk.y, region.Width, region.Height)
+			url, err := uploadToBucket(ctx, bucket, data, fmt.Sprintf("%s/tile-%d-%d.png", dir, tk.x, tk.y), "image/png")
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			results = append(results, TileResult{X: tk.x, Y: tk.y, URL: url, CRC32C: objectCRC32C(data)})
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	thumbData := generateThumbnail(pixels, region.Width, region.Height, thumbnailSize)
+	thumbURL, err := uploadToBucket(ctx, bucket, thumbData, dir+"/thumbnail.png", "image/png")
+	if err != nil {
+		thumbURL = ""
+	}
+
+	manifest := Manifest{
+		Timestamp:    timestamp,
+		CanvasWidth:  region.Width,
+		CanvasHeight: region.Height,
+		TileSize:     tileSize,
+		TilesX:       int(math.Ceil(float64(region.Width) / float64(tileSize))),
+		TilesY:       int(math.Ceil(float64(region.Height) / float64(tileSize))),
+		Tiles:        results,
+		ThumbnailURL: thumbURL,
+		PixelCount:   len(pixels),
+		PixelHash:    hashPixels(pixels, region.Width, region.Height),
+
+		ThumbnailCRC32C: objectCRC32C(thumbData),
+		ThumbnailSize:   thumbnailSize,
+		Bucket:          bucket,
+		Region:          &region,
+	}
+	manifestJSON, _ := json.MarshalIndent(manifest, "", "  ")
+	manifestURL, err := uploadToBucket(ctx, bucket, manifestJSON, dir+"/manifest.json", "application/json")
+	complete := err == nil && thumbURL != "" && len(results) == len(tilePixelMap)
+
+	slog.Info("region_snapshot_generated",
+		"region_x", region.X,
+		"region_y", region.Y,
+		"region_width", region.Width,
+		"region_height", region.Height,
+		"pixel_count", len(pixels),
+		"tile_count", len(results),
+		"complete", complete,
+		"user_id", req.UserID,
+	)
+	span.SetAttributes(
+		attribute.Int("snapshot.pixel_count", len(pixels)),
+		attribute.Int("snapshot.tile_count", len(results)),
+	)
+	audit.Record(ctx, getFirestore(), audit.Entry{
+		ActorID:   req.UserID,
+		ActorName: req.Username,
+		Action:    "snapshot.region",
+		Target:    dir,
+		Params: map[string]interface{}{
+			"minX":        req.MinX,
+			"minY":        req.MinY,
+			"maxX":        req.MaxX,
+			"maxY":        req.MaxY,
+			"pixelCount":  len(pixels),
+			"tileCount":   len(results),
+			"manifestUrl": manifestURL,
+			"complete":    complete,
+		},
+	})
+
+	if !complete {
+		reply(fmt.Sprintf("Region snapshot incomplete: %d of %d tiles uploaded. Try again.", len(results), len(tilePixelMap)))
+	} else {
+		reply(fmt.Sprintf("Region snapshot of x %d-%d, y %d-%d: %d tiles (%d pixels)\nThumbnail: %s\nManifest: %s",
+			req.MinX, req.MaxX, req.MinY, req.MaxY, len(results), len(pixels), thumbURL, manifestURL))
+	}
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+const (
+	regionStatsTopColors = 5
+	// regionStatsMaxPixels caps the pixels read for one /canvas view:region;
+	// regions with more cells than this are reported as possibly incomplete
+	regionStatsMaxPixels = 10000
+)
+
+// regionStats sums up the pixels of a region
+type regionStats struct {
+	Pixels    int
+	Users     int
+	Colors    []colorCount
+	Occupancy float64
+}
+
+// computeRegionStats counts pixels, distinct placers (anonymized pixels
+// excluded) and top colors over an area of the given size
+func computeRegionStats(pixels []Pixel, area int) regionStats {
+	users := make(map[string]bool)
+	counts := make(map[string]int)
+	for _, p := range pixels {
+		if p.UserID != "" && p.UserID != anonymizedUser {
+			users[p.UserID] = true
+		}
+		counts[strings.ToUpper(strings.TrimPrefix(p.Color, "#"))]++
+	}
+	stats := regionStats{
+		Pixels: len(pixels),
+		Users:  len(users),
+		Colors: topColors(counts, regionStatsTopColors),
+	}
+	if area > 0 {
+		stats.Occupancy = 100 * float64(len(pixels)) / float64(area)
+	}
+	return stats
+}
+
+// queryRegionPixels reads the pixels inside r, at most regionStatsMaxPixels.
+// Unlike getRegionPixels it filters y in the query too, so a narrow region
+// of a tall canvas does not read whole columns; that needs the composite
+// index pixels (x ASC, y ASC), listed in firestore.indexes.json.
+func queryRegionPixels(ctx context.Context, r ManifestRegion) ([]Pixel, error) {
+	q := getFirestore().Collection("pixels").
+		Where("x", ">=", r.X).
+		Where("x", "<", r.X+r.Width).
+		Where("y", ">=", r.Y).
+		Where("y", "<", r.Y+r.Height).
+		OrderBy("x", firestore.Asc).
+		OrderBy("y", firestore.Asc).
+		Limit(regionStatsMaxPixels)
+	return queryPixels(ctx, q, pixelLoad{Owner: true})
+}
+
+// buildRegionStatsEmbed lays out the stats of the region typed as
+// (minX, minY) to (maxX, maxY)
+func buildRegionStatsEmbed(req messages.RegionStatsRequest, stats regionStats, area int, thumbURL string) map[string]interface{} {
+	var colors strings.Builder
+	for i, c := range stats.Colors {
+		fmt.Fprintf(&colors, "%d. `#%s`: %d\n", i+1, c.Color, c.Count)
+	}
+	if colors.Len() == 0 {
+		colors.WriteString("No pixels placed yet.")
+	}
+
+	description := fmt.Sprintf("(%d, %d) to (%d, %d), %d cells", req.MinX, req.MinY, req.MaxX, req.MaxY, area)
+	if area > regionStatsMaxPixels {
+		description += fmt.Sprintf("\n⚠️ Regions over %d cells only read the first %d pixels, so artists and top colors may be incomplete.", regionStatsMaxPixels, regionStatsMaxPixels)
+	}
+	embed := map[string]interface{}{
+		"title":       "Region statistics",
+		"description": description,
+		"color":       0x5865F2,
+		"fields": []map[string]interface{}{
+			{"name": "Pixels", "value": fmt.Sprintf("%d", stats.Pixels), "inline": true},
+			{"name": "Artists", "value": fmt.Sprintf("%d", stats.Users), "inline": true},
+			{"name": "Occupancy", "value": fmt.Sprintf("%.1f%%", stats.Occupancy), "inline": true},
+			{"name": "Top colors", "value": colors.String()},
+		},
+	}
+	if thumbURL != "" {
+		embed["image"] = map[string]string{"url": thumbURL}
+	}
+	return embed
+}
+
+// handleRegionStats answers /canvas view:region with the statistics of a
+// region and a thumbnail of it
+func handleRegionStats(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "generateRegionStats")
+	defer span.End()
+
+	var req messages.RegionStatsRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse region stats request: %w", err)
+	}
+
+	// Corners are typed in the session's origin, like /snapshot-region
+	canvasW, canvasH, origin := getCanvasView(ctx)
+	if req.MinX < 0 || req.MinY < 0 || req.MaxX >= canvasW || req.MaxY >= canvasH || req.MinX > req.MaxX || req.MinY > req.MaxY {
+		sendFollowUp(req.ApplicationID, req.InteractionToken, fmt.Sprintf("Region x %d-%d, y %d-%d is out of bounds (0-%d, 0-%d)",
+			req.MinX, req.MaxX, req.MinY, req.MaxY, canvasW-1, canvasH-1))
+		return nil
+	}
+	minX, minY, maxX, maxY := origin.ToCanvasRect(req.MinX, req.MinY, req.MaxX, req.MaxY, canvasH)
+	region := ManifestRegion{X: minX, Y: minY, Width: maxX - minX + 1, Height: maxY - minY + 1}
+	area := region.Width * region.Height
+
+	pixels, err := queryRegionPixels(ctx, region)
+	if err != nil {
+		slog.Error("region_stats_pixels_fetch_failed", "error", err.Error(), "user_id", req.UserID)
+		sendFollowUp(req.ApplicationID, req.InteractionToken, fmt.Sprintf("Failed to get pixels: %v", err))
+		return err
+	}
+	stats := computeRegionStats(pixels, area)
+	// A truncated read still gets the region's exact pixel count
+	if len(pixels) == regionStatsMaxPixels {
+		if n, err := countPixelsIn(ctx, region); err == nil {
+			stats.Pixels = n
+			stats.Occupancy = 100 * float64(n) / float64(area)
+		} else {
+			slog.Warn("region_stats_count_failed", "error", err.Error())
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("region.width", region.Width),
+		attribute.Int("region.height", region.Height),
+		attribute.Int("region_stats.pixel_count", stats.Pixels),
+		attribute.Bool("region_stats.truncated", len(pixels) == regionStatsMaxPixels),
+	)
+
+	// Like color charts, thumbnails are throwaway; the bucket lifecycle
+	// removes region-stats/ after a day. Stats are still sent without one.
+	var thumbURL string
+	if len(pixels) > 0 {
+		path := fmt.Sprintf("region-stats/%d.png", time.Now().UnixMilli())
+		url, err := uploadWithRetry(ctx, generateThumbnail(clipToRegion(pixels, region), region.Width, region.Height, thumbnailSize), path, "image/png")
+		if err != nil {
+			slog.Warn("region_stats_thumbnail_failed", "error", err.Error())
+		}
+		thumbURL = url
+	}
+
+	slog.Info("region_stats_generated",
+		"width", region.Width,
+		"height", region.Height,
+		"pixel_count", stats.Pixels,
+		"user_id", req.UserID,
+	)
+	sendFollowUpEmbed(req.ApplicationID, req.InteractionToken, buildRegionStatsEmbed(req, stats, area, thumbURL))
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
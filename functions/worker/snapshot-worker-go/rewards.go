@@ -0,0 +1,162 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/team11/snapshot-worker/internal/discord"
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+// rewardsConfig is config/rewards: the Discord role earned at each pixel
+// count, and where to tell admins the bot cannot grant them
+type rewardsConfig struct {
+	Roles          map[string]string `firestore:"roles"`
+	AlertChannelID string            `firestore:"alertChannelId"`
+}
+
+// earnedRoles lists the roles of every threshold up to count that are not in
+// granted, lowest threshold first
+func (c rewardsConfig) earnedRoles(count int, granted []interface{}) []string {
+	thresholds := make([]int, 0, len(c.Roles))
+	for key := range c.Roles {
+		if n, err := strconv.Atoi(key); err == nil && n > 0 && n <= count {
+			thresholds = append(thresholds, n)
+		}
+	}
+	slices.Sort(thresholds)
+	var roles []string
+	for _, n := range thresholds {
+		roleID := c.Roles[strconv.Itoa(n)]
+		if roleID != "" && !slices.Contains(granted, interface{}(roleID)) && !slices.Contains(roles, roleID) {
+			roles = append(roles, roleID)
+		}
+	}
+	return roles
+}
+
+// handleRoleReward grants a user the roles of config/rewards they have
+// earned, in the guild recorded on their user document by their last Discord
+// placement. Granted roles are kept in users.rewardRoles so each is added
+// once; roles are never taken away. A 403 means the bot lacks Manage Roles
+// or sits below the role, which no retry fixes: admins are told once and the
+// message is acked.
+func handleRoleReward(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "handleRoleReward")
+	defer span.End()
+
+	var req messages.RoleRewardCheck
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse role reward check: %w", err)
+	}
+	span.SetAttributes(attribute.String("reward.user_id", req.UserID))
+	if discordBotToken == "" {
+		slog.Warn("role_reward_skipped", "user_id", req.UserID, "reason", "no bot token")
+		return nil
+	}
+
+	configRef := getFirestore().Collection("config").Doc("rewards")
+	var cfg rewardsConfig
+	configDoc, err := configRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read rewards config: %w", err)
+	}
+	if err := configDoc.DataTo(&cfg); err != nil {
+		slog.Error("role_reward_config_invalid", "error", err.Error())
+		return nil
+	}
+
+	userRef := getFirestore().Collection("users").Doc(req.UserID)
+	userDoc, err := userRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read user: %w", err)
+	}
+	guildID, _ := userDoc.Data()["guildId"].(string)
+	if guildID == "" {
+		// Users who only drew on the web have never been seen in a guild
+		slog.Info("role_reward_skipped", "user_id", req.UserID, "reason", "no guild")
+		return nil
+	}
+	granted, _ := userDoc.Data()["rewardRoles"].([]interface{})
+	count := max(req.PixelCount, toIntVal(userDoc.Data()["pixelCount"]))
+
+	for _, roleID := range cfg.earnedRoles(count, granted) {
+		err := discordClient.AddMemberRole(ctx, guildID, req.UserID, roleID)
+		var apiErr *discord.APIError
+		switch {
+		case err == nil:
+			if _, err := userRef.Update(ctx, []firestore.Update{
+				{Path: "rewardRoles", Value: firestore.ArrayUnion(roleID)},
+			}); err != nil {
+				slog.Warn("role_reward_not_recorded", "user_id", req.UserID, "role_id", roleID, "error", err.Error())
+			}
+			slog.Info("role_reward_granted", "user_id", req.UserID, "role_id", roleID, "pixel_count", count)
+		case errors.As(err, &apiErr) && apiErr.Status == http.StatusForbidden:
+			slog.Error("role_reward_forbidden", "user_id", req.UserID, "role_id", roleID, "guild_id", guildID)
+			alertRewardPermission(ctx, configRef, cfg.AlertChannelID, roleID)
+			return nil
+		case errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound:
+			// The user left the guild or the role was deleted
+			slog.Warn("role_reward_not_found", "user_id", req.UserID, "role_id", roleID, "guild_id", guildID)
+		default:
+			// Adding a role twice is harmless, so the redelivery retries all
+			slog.Error("role_reward_failed", "user_id", req.UserID, "role_id", roleID, "error", err.Error())
+			return err
+		}
+	}
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
+
+// alertRewardPermission tells admins, once, that the bot may not grant a
+// reward role. The time of the alert is kept in
+// config/rewards.permissionAlertSentAt; deleting it re-arms the alert.
+func alertRewardPermission(ctx context.Context, configRef *firestore.DocumentRef, channelID, roleID string) {
+	var first bool
+	err := getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		first = false
+		doc, err := tx.Get(configRef)
+		if err != nil {
+			return err
+		}
+		if _, sent := doc.Data()["permissionAlertSentAt"]; sent {
+			return nil
+		}
+		first = true
+		return tx.Update(configRef, []firestore.Update{{Path: "permissionAlertSentAt", Value: time.Now().UTC()}})
+	})
+	if err != nil {
+		slog.Warn("role_reward_alert_not_recorded", "error", err.Error())
+		return
+	}
+	if !first || channelID == "" {
+		return
+	}
+	content := fmt.Sprintf("Pixel milestone roles cannot be granted: Discord refused to add role `%s`. "+
+		"Give the bot the Manage Roles permission and move its role above the reward roles, "+
+		"then delete `permissionAlertSentAt` from `config/rewards` to re-enable this alert.", roleID)
+	if err := discordClient.ChannelMessage(ctx, channelID, discord.Message{Content: content}); err != nil {
+		slog.Warn("role_reward_alert_failed", "channel_id", channelID, "error", err.Error())
+	}
+}
@@ -0,0 +1,250 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/api/iterator"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/team11/snapshot-worker/internal/audit"
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+const (
+	// sessionExportFormat and sessionExportVersion identify the export file
+	// to whatever imports it
+	sessionExportFormat  = "team11-session-export"
+	sessionExportVersion = 1
+)
+
+// sessionExportStats is what an export wrote
+type sessionExportStats struct {
+	Pixels int
+	Users  int
+	Colors int
+}
+
+// exportSession writes the session as one JSON object:
+//
+//	{"format", "version", "exportedAt",
+//	 "session": sessions/current or null,
+//	 "pixels": [every pixels document, with its "id"],
+//	 "colors": {hex: pixels of that color},
+//	 "users": [the users document of every pixel owner, with its "id"]}
+//
+// Pixels are read a page at a time and encoded as they arrive, so memory
+// holds one page plus the color counts and owner IDs. Owners without a users
+// document (deleted users) are left out of "users".
+func exportSession(ctx context.Context, w io.Writer) (sessionExportStats, error) {
+	var stats sessionExportStats
+	enc := json.NewEncoder(w)
+	write := func(s string) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+
+	var session map[string]interface{}
+	doc, err := getFirestore().Collection("sessions").Doc("current").Get(ctx)
+	if err == nil {
+		session = doc.Data()
+	} else if status.Code(err) != grpccodes.NotFound {
+		return stats, fmt.Errorf("session: %w", err)
+	}
+
+	header := fmt.Sprintf(`{"format":%q,"version":%d,"exportedAt":%q,"session":`,
+		sessionExportFormat, sessionExportVersion, time.Now().UTC().Format(time.RFC3339))
+	if err := write(header); err != nil {
+		return stats, err
+	}
+	if err := enc.Encode(session); err != nil {
+		return stats, err
+	}
+
+	if err := write(`,"pixels":[`); err != nil {
+		return stats, err
+	}
+	colors := make(map[string]int)
+	var owners []string
+	seen := make(map[string]bool)
+	q := getFirestore().Collection("pixels").OrderBy(firestore.DocumentID, firestore.Asc).Limit(exportPageSize)
+	var last *firestore.DocumentSnapshot
+	for {
+		page := q
+		if last != nil {
+			page = q.StartAfter(last)
+		}
+		iter := page.Documents(ctx)
+		n := 0
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return stats, fmt.Errorf("pixels: %w", err)
+			}
+			data := doc.Data()
+			data["id"] = doc.Ref.ID
+			if stats.Pixels > 0 {
+				if err := write(","); err != nil {
+					iter.Stop()
+					return stats, err
+				}
+			}
+			if err := enc.Encode(data); err != nil {
+				iter.Stop()
+				return stats, err
+			}
+			stats.Pixels++
+			if color, _ := data["color"].(string); color != "" {
+				colors[color]++
+			}
+			if userID, _ := data["userId"].(string); userID != "" && userID != anonymizedUser && !seen[userID] {
+				seen[userID] = true
+				owners = append(owners, userID)
+			}
+			last = doc
+			n++
+		}
+		iter.Stop()
+		if n < exportPageSize {
+			break
+		}
+	}
+	stats.Colors = len(colors)
+
+	if err := write(`],"colors":`); err != nil {
+		return stats, err
+	}
+	if err := enc.Encode(colors); err != nil {
+		return stats, err
+	}
+
+	if err := write(`,"users":[`); err != nil {
+		return stats, err
+	}
+	for start := 0; start < len(owners); start += exportPageSize {
+		end := min(start+exportPageSize, len(owners))
+		refs := make([]*firestore.DocumentRef, 0, end-start)
+		for _, userID := range owners[start:end] {
+			refs = append(refs, getFirestore().Collection("users").Doc(userID))
+		}
+		docs, err := getFirestore().GetAll(ctx, refs)
+		if err != nil {
+			return stats, fmt.Errorf("users: %w", err)
+		}
+		for _, doc := range docs {
+			if !doc.Exists() {
+				continue
+			}
+			data := doc.Data()
+			data["id"] = doc.Ref.ID
+			if stats.Users > 0 {
+				if err := write(","); err != nil {
+					return stats, err
+				}
+			}
+			if err := enc.Encode(data); err != nil {
+				return stats, err
+			}
+			stats.Users++
+		}
+	}
+	return stats, write("]}\n")
+}
+
+// handleSessionExport answers /session action:export: the export is
+// streamed into the exports bucket and the admin gets a signed link, since
+// a whole canvas is beyond Discord's attachment limit.
+func handleSessionExport(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "exportSession")
+	defer span.End()
+
+	var req messages.SessionExportRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse session export request: %w", err)
+	}
+
+	path := fmt.Sprintf("exports/sessions/%d.json", time.Now().UnixMilli())
+	fail := func(err error) error {
+		slog.Error("session_export_failed", "user_id", req.UserID, "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		audit.Record(ctx, getFirestore(), audit.Entry{
+			ActorID:   req.UserID,
+			ActorName: req.Username,
+			Action:    "session.export",
+			Target:    "current",
+			Params:    map[string]interface{}{"success": false, "error": err.Error()},
+		})
+		sendEphemeralFollowUp(req.ApplicationID, req.InteractionToken, "Failed to export the session. Please try again later.")
+		return nil
+	}
+
+	// Closing the writer commits the object; cancelling ctx abandons it
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	obj := getStorage().Bucket(exportsBucket).Object(path)
+	w := obj.NewWriter(writeCtx)
+	w.ContentType = "application/json"
+	w.CacheControl = "private, no-store"
+	stats, err := exportSession(ctx, w)
+	if err != nil {
+		cancel()
+		w.Close()
+		return fail(err)
+	}
+	if err := w.Close(); err != nil {
+		return fail(err)
+	}
+	url, err := getStorage().Bucket(exportsBucket).SignedURL(path, &storage.SignedURLOptions{
+		Method:  "GET",
+		Expires: time.Now().Add(exportURLExpiry),
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	slog.Info("session_exported",
+		"user_id", req.UserID,
+		"pixel_count", stats.Pixels,
+		"user_count", stats.Users,
+		"object", path,
+	)
+	span.SetAttributes(
+		attribute.Int("export.pixel_count", stats.Pixels),
+		attribute.Int("export.user_count", stats.Users),
+	)
+
+	audit.Record(ctx, getFirestore(), audit.Entry{
+		ActorID:   req.UserID,
+		ActorName: req.Username,
+		Action:    "session.export",
+		Target:    "current",
+		Params: map[string]interface{}{
+			"success":    true,
+			"object":     path,
+			"pixelCount": stats.Pixels,
+		},
+	})
+
+	sendEphemeralFollowUp(req.ApplicationID, req.InteractionToken,
+		fmt.Sprintf("Session export is ready (%d pixels, %d colors, %d users). This link expires in 24 hours:\n%s",
+			stats.Pixels, stats.Colors, stats.Users, url))
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package snapshotworker
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/png"
+	"testing"
+)
+
+// These tests render real PNGs end to end without GCS or Firestore: pixels
+// in, encoded image out, decoded again with image.Decode.
+
+// decodeImage decodes encoded image bytes through the registered formats
+func decodeImage(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode image: %v", err)
+	}
+	if format != "png" {
+		t.Fatalf("format = %s, want png", format)
+	}
+	return img
+}
+
+func TestGenerateTileIntegration(t *testing.T) {
+	// 100 pixels spread over the tile, each with its own color
+	pixels := make([]Pixel, 0, 100)
+	want := make(map[image.Point]color.RGBA, 100)
+	for i := 0; i < 100; i++ {
+		x, y := i*20+3, (i*37)%2048
+		c := color.RGBA{uint8(i), uint8(255 - i), uint8(i * 2), 0xFF}
+		pixels = append(pixels, Pixel{X: x, Y: y, Color: fmt.Sprintf("%02X%02X%02X", c.R, c.G, c.B)})
+		want[image.Point{X: x, Y: y}] = c
+	}
+
+	img := decodeImage(t, generateTile(pixels, 0, 0, 2048, 2048))
+	if got := img.Bounds().Size(); got != (image.Point{X: 2048, Y: 2048}) {
+		t.Fatalf("size = %v, want 2048x2048", got)
+	}
+	for p, c := range want {
+		if got := rgbaAt(img, p.X, p.Y); got != c {
+			t.Errorf("pixel %v = %v, want %v", p, got, c)
+		}
+	}
+
+	// Every cell next to a placed pixel, and the corners, are background
+	for p := range want {
+		for _, q := range []image.Point{{X: p.X + 1, Y: p.Y}, {X: p.X - 1, Y: p.Y}} {
+			if _, placed := want[q]; placed || !q.In(img.Bounds()) {
+				continue
+			}
+			if got := rgbaAt(img, q.X, q.Y); got != white {
+				t.Errorf("unplaced pixel %v = %v, want white", q, got)
+			}
+		}
+	}
+	for _, q := range []image.Point{{X: 0, Y: 2047}, {X: 2047, Y: 0}, {X: 2047, Y: 2047}} {
+		if got := rgbaAt(img, q.X, q.Y); got != white {
+			t.Errorf("corner %v = %v, want white", q, got)
+		}
+	}
+}
+
+func TestGenerateThumbnailIntegration(t *testing.T) {
+	withPixelScale(t, 1)
+
+	img := decodeImage(t, generateThumbnail([]Pixel{{X: 0, Y: 0, Color: "FF0000"}}, 4000, 3000, 800))
+	if got := img.Bounds().Size(); got != (image.Point{X: 800, Y: 600}) {
+		t.Fatalf("size = %v, want 800x600", got)
+	}
+}
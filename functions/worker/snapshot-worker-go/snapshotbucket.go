@@ -0,0 +1,67 @@
+package snapshotworker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// allowedBuckets may be chosen instead of SNAPSHOTS_BUCKET by a session
+// (sessions/current.snapshotsBucket) or a snapshot request, e.g. for an
+// event with its own retention (SNAPSHOTS_BUCKET_ALLOWLIST, comma-separated)
+var allowedBuckets []string
+
+func init() {
+	for _, b := range strings.Split(os.Getenv("SNAPSHOTS_BUCKET_ALLOWLIST"), ",") {
+		if b = strings.TrimSpace(b); b != "" && !slices.Contains(allowedBuckets, b) {
+			allowedBuckets = append(allowedBuckets, b)
+		}
+	}
+}
+
+var errBucketNotAllowed = errors.New("bucket is not in SNAPSHOTS_BUCKET_ALLOWLIST")
+
+// selectSnapshotsBucket picks the bucket for one snapshot: the request's
+// override, else the session's, else SNAPSHOTS_BUCKET. An override that is
+// not allowlisted is refused rather than replaced by the default, so an
+// event's snapshots never silently land in the shared bucket.
+func selectSnapshotsBucket(ctx context.Context, override string) (string, error) {
+	bucket := override
+	if bucket == "" {
+		if doc, err := getFirestore().Collection("sessions").Doc("current").Get(ctx); err == nil {
+			bucket, _ = doc.Data()["snapshotsBucket"].(string)
+		}
+	}
+	if bucket == "" || bucket == snapshotsBucket {
+		return snapshotsBucket, nil
+	}
+	if !slices.Contains(allowedBuckets, bucket) {
+		return "", fmt.Errorf("%w: %s", errBucketNotAllowed, bucket)
+	}
+	return bucket, nil
+}
+
+// knownBuckets are all buckets snapshots may be stored in, SNAPSHOTS_BUCKET
+// first
+func knownBuckets() []string {
+	buckets := []string{snapshotsBucket}
+	for _, b := range allowedBuckets {
+		if b != snapshotsBucket {
+			buckets = append(buckets, b)
+		}
+	}
+	return buckets
+}
+
+// bucketOrDefault resolves the bucket recorded on a manifest or on
+// snapshots/latest; snapshots taken before buckets were recorded are in
+// SNAPSHOTS_BUCKET.
+func bucketOrDefault(bucket string) string {
+	if bucket == "" {
+		return snapshotsBucket
+	}
+	return bucket
+}
@@ -0,0 +1,56 @@
+package snapshotworker
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// snapshotLockTTL bounds how long a channel's lock can outlive a render that
+// never released it, e.g. an instance killed at the 300 s function timeout
+const snapshotLockTTL = 6 * time.Minute
+
+// acquireSnapshotLock claims snapshot_locks/{channelID} for the message
+// holder, so a channel renders one /snapshot at a time. It reports false
+// while another message holds an unexpired lock. A redelivery of the holding
+// message gets its lock back.
+func acquireSnapshotLock(ctx context.Context, channelID, holder, userID string) (bool, error) {
+	ref := getFirestore().Collection("snapshot_locks").Doc(channelID)
+	acquired := false
+	err := getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		acquired = false
+		now := time.Now().UTC()
+		if doc, err := tx.Get(ref); err == nil && doc.Exists() {
+			current, _ := doc.Data()["holder"].(string)
+			expiresAt, _ := doc.Data()["expiresAt"].(time.Time)
+			if current != holder && expiresAt.After(now) {
+				return nil
+			}
+		}
+		acquired = true
+		return tx.Set(ref, map[string]interface{}{
+			"holder":    holder,
+			"userId":    userID,
+			"startedAt": now,
+			"expiresAt": now.Add(snapshotLockTTL),
+		})
+	})
+	return acquired, err
+}
+
+// releaseSnapshotLock deletes the channel's lock if holder still owns it;
+// an expired lock taken over by another message is left alone.
+func releaseSnapshotLock(ctx context.Context, channelID, holder string) error {
+	ref := getFirestore().Collection("snapshot_locks").Doc(channelID)
+	return getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil || !doc.Exists() {
+			return nil
+		}
+		if current, _ := doc.Data()["holder"].(string); current != holder {
+			return nil
+		}
+		return tx.Delete(ref)
+	})
+}
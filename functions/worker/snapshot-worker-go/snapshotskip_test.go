@@ -0,0 +1,98 @@
+package snapshotworker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+func TestHashPixels(t *testing.T) {
+	pixels := []Pixel{{X: 1, Y: 2, Color: "FF0000"}, {X: 3, Y: 0, Color: "00FF00"}, {X: 0, Y: 2, Color: "0000FF"}}
+	reordered := []Pixel{pixels[2], pixels[0], pixels[1]}
+	lower := []Pixel{{X: 1, Y: 2, Color: "ff0000"}, pixels[1], pixels[2]}
+	recolored := []Pixel{{X: 1, Y: 2, Color: "FF0001"}, pixels[1], pixels[2]}
+
+	want := hashPixels(pixels, 10, 10)
+	if got := hashPixels(reordered, 10, 10); got != want {
+		t.Error("hash depends on the order pixels were read in")
+	}
+	if got := hashPixels(lower, 10, 10); got != want {
+		t.Error("hash depends on the case of colors")
+	}
+	if got := hashPixels(recolored, 10, 10); got == want {
+		t.Error("hash ignores a changed color")
+	}
+	if got := hashPixels(pixels[:2], 10, 10); got == want {
+		t.Error("hash ignores a removed pixel")
+	}
+	if got := hashPixels(pixels, 10, 11); got == want {
+		t.Error("hash ignores the canvas size")
+	}
+}
+
+func TestReusesSnapshot(t *testing.T) {
+	defer func(b string) { snapshotsBucket = b }(snapshotsBucket)
+	snapshotsBucket = "snapshots"
+	last := &LastSnapshot{PixelHash: "h1", Bucket: "snapshots", ThumbnailSize: 800}
+
+	tests := []struct {
+		name   string
+		last   *LastSnapshot
+		req    messages.SnapshotRequest
+		hash   string
+		bucket string
+		thumb  int
+		want   bool
+	}{
+		{"unchanged canvas", last, messages.SnapshotRequest{}, "h1", "snapshots", 800, true},
+		{"canvas changed", last, messages.SnapshotRequest{}, "h2", "snapshots", 800, false},
+		{"zones overlay", last, messages.SnapshotRequest{Zones: true}, "h1", "snapshots", 800, false},
+		{"tagged", last, messages.SnapshotRequest{Tag: "pre_clear"}, "h1", "snapshots", 800, false},
+		{"another bucket", last, messages.SnapshotRequest{}, "h1", "event", 800, false},
+		{"another thumbnail size", last, messages.SnapshotRequest{}, "h1", "snapshots", 400, false},
+		{"from before buckets and sizes were recorded", &LastSnapshot{PixelHash: "h1"}, messages.SnapshotRequest{}, "h1", "snapshots", defaultThumbnailSize, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reusesSnapshot(tt.last, tt.req, tt.hash, tt.bucket, tt.thumb); got != tt.want {
+				t.Errorf("reusesSnapshot() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnchangedCanvasSkipsRendering(t *testing.T) {
+	requireEmulator(t)
+	gcs := useFakeGCS(t)
+	dc := useFakeDiscord(t)
+	defer func(b string, size int) { snapshotsBucket, thumbnailSize = b, size }(snapshotsBucket, thumbnailSize)
+	snapshotsBucket, thumbnailSize = "snapshots", defaultThumbnailSize
+
+	pixels := []Pixel{{X: 1, Y: 1, Color: "FF0000"}, {X: 2, Y: 3, Color: "00FF00"}}
+	seedPixels(t, 16, 16, pixels)
+	seedDoc(t, "snapshots/latest", map[string]interface{}{
+		"timestamp": int64(1700000000000), "manifestUrl": "https://example.test/manifest.json", "thumbnailUrl": "https://example.test/thumb.png",
+		"pixelHash": hashPixels(pixels, 16, 16), "pixelCount": 2, "tileCount": 1, "canvasWidth": 16, "canvasHeight": 16,
+		"bucket": "snapshots", "thumbnailSize": defaultThumbnailSize,
+	})
+
+	last, err := generateSnapshot(t.Context(), messages.SnapshotRequest{UserID: "u1", ApplicationID: "app", InteractionToken: "tok"}, time.Now())
+	if err != nil {
+		t.Fatalf("generateSnapshot: %v", err)
+	}
+	if last == nil || last.Timestamp != 1700000000000 {
+		t.Errorf("latest = %+v, want the previous snapshot", last)
+	}
+	gcs.mu.Lock()
+	uploaded := len(gcs.objects)
+	gcs.mu.Unlock()
+	if uploaded != 0 {
+		t.Errorf("uploaded %d objects for an unchanged canvas", uploaded)
+	}
+	calls := dc.received()
+	if len(calls) != 1 || !strings.Contains(calls[0].Body.Content, "No changes since last snapshot") {
+		t.Errorf("Discord calls = %+v, want one no-changes follow-up", calls)
+	}
+}
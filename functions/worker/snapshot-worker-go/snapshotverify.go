@@ -0,0 +1,283 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/team11/snapshot-worker/internal/audit"
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+// verifyParallelism bounds concurrent GCS metadata requests per manifest
+const verifyParallelism = 20
+
+// tileObjectPath is where generateSnapshot stores a tile of the snapshot
+// taken at timestamp
+func tileObjectPath(timestamp int64, x, y int) string {
+	return fmt.Sprintf("snapshots/%d/tile-%d-%d.png", timestamp, x, y)
+}
+
+// readManifest loads snapshots/{timestamp}/manifest.json from bucket. The
+// returned manifest always names the bucket it was read from.
+func readManifest(ctx context.Context, bucket string, timestamp int64) (*Manifest, error) {
+	r, err := getStorage().Bucket(bucket).Object(fmt.Sprintf("snapshots/%d/manifest.json", timestamp)).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	m.Bucket = bucket
+	return &m, nil
+}
+
+// findManifest reads the manifest of a snapshot whose bucket is unknown,
+// trying every known bucket in turn.
+func findManifest(ctx context.Context, timestamp int64) (*Manifest, error) {
+	for _, bucket := range knownBuckets() {
+		m, err := readManifest(ctx, bucket, timestamp)
+		if !errors.Is(err, storage.ErrObjectNotExist) {
+			return m, err
+		}
+	}
+	return nil, storage.ErrObjectNotExist
+}
+
+// verifyManifest checks that every tile listed in m still exists in the
+// manifest's bucket and returns the object paths of the missing ones, sorted.
+// Any error other than a missing object aborts the check.
+func verifyManifest(ctx context.Context, m Manifest) (missing []string, err error) {
+	ctx, span := tracer.Start(ctx, "verifyManifest")
+	defer span.End()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	bucket := getStorage().Bucket(bucketOrDefault(m.Bucket))
+	sem := make(chan struct{}, verifyParallelism)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for _, tile := range m.Tiles {
+		path := tileObjectPath(m.Timestamp, tile.X, tile.Y)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			_, attrErr := bucket.Object(path).Attrs(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case attrErr == nil:
+			case errors.Is(attrErr, storage.ErrObjectNotExist):
+				missing = append(missing, path)
+			case err == nil:
+				err = fmt.Errorf("attrs %s: %w", path, attrErr)
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	span.SetAttributes(
+		attribute.Int("verify.tiles", len(m.Tiles)),
+		attribute.Int("verify.missing", len(missing)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// repairTiles re-renders the missing tiles of m from the current canvas. A
+// re-render only reproduces the snapshot while the canvas is unchanged, so
+// it refuses when the pixel hash no longer matches the manifest.
+func repairTiles(ctx context.Context, m Manifest, missing []string) (int, error) {
+	pixels, err := getPixelsPartitioned(ctx, m.CanvasWidth, pixelLoad{})
+	if err != nil {
+		return 0, err
+	}
+	if hashPixels(pixels, m.CanvasWidth, m.CanvasHeight) != m.PixelHash {
+		return 0, errors.New("the canvas changed since this snapshot, so its tiles can no longer be rendered")
+	}
+
+	tiles := make(map[string]TileResult, len(m.Tiles))
+	for _, t := range m.Tiles {
+		tiles[tileObjectPath(m.Timestamp, t.X, t.Y)] = t
+	}
+
+	repaired := 0
+	for _, path := range missing {
+		t := tiles[path]
+		var inTile []Pixel
+		for _, p := range pixels {
+			if p.X/tileSize == t.X && p.Y/tileSize == t.Y && p.X < m.CanvasWidth && p.Y < m.CanvasHeight {
+				inTile = append(inTile, p)
+			}
+		}
+		data := generateTile(inTile, t.X, t.Y, m.CanvasWidth, m.CanvasHeight)
+		if t.CRC32C != "" && objectCRC32C(data) != t.CRC32C {
+			// Snapshots taken before paletted tiles stored RGBA tiles
+			data = generateRGBATile(inTile, t.X, t.Y, m.CanvasWidth, m.CanvasHeight)
+		}
+		if t.CRC32C != "" && objectCRC32C(data) != t.CRC32C {
+			return repaired, fmt.Errorf("re-rendered %s does not match the manifest checksum", path)
+		}
+		if _, err := uploadToBucket(ctx, bucketOrDefault(m.Bucket), data, path, "image/png"); err != nil {
+			return repaired, err
+		}
+		repaired++
+	}
+	return repaired, nil
+}
+
+// verifyEmbed reports a verification; missing tiles are listed up to the
+// embed field limit.
+func verifyEmbed(m Manifest, missing []string, repaired int, repairErr error) map[string]interface{} {
+	color := 0x57F287
+	description := fmt.Sprintf("All %d tiles are present.", len(m.Tiles))
+	if len(missing) > 0 {
+		color = 0xED4245
+		description = fmt.Sprintf("%d of %d tiles are missing.", len(missing), len(m.Tiles))
+	}
+	fields := []map[string]interface{}{}
+
+	if len(missing) > 0 {
+		var list strings.Builder
+		for i, path := range missing {
+			line := "`" + path[strings.LastIndex(path, "/")+1:] + "`\n"
+			if list.Len()+len(line) > 1000 {
+				fmt.Fprintf(&list, "...and %d more", len(missing)-i)
+				break
+			}
+			list.WriteString(line)
+		}
+		fields = append(fields, map[string]interface{}{"name": "Missing tiles", "value": list.String()})
+	}
+	if repairErr != nil {
+		fields = append(fields, map[string]interface{}{"name": "Repair failed", "value": fmt.Sprintf("%d repaired before: %v", repaired, repairErr)})
+	} else if repaired > 0 {
+		color = 0x57F287
+		fields = append(fields, map[string]interface{}{"name": "Repaired", "value": fmt.Sprintf("%d tiles re-rendered", repaired)})
+	} else if len(missing) > 0 {
+		fields = append(fields, map[string]interface{}{"name": "Repair", "value": "Run with repair:true to re-render them"})
+	}
+
+	return map[string]interface{}{
+		"title":       fmt.Sprintf("Snapshot %d integrity", m.Timestamp),
+		"description": description,
+		"color":       color,
+		"fields":      fields,
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+func handleSnapshotVerify(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "verifySnapshot")
+	defer span.End()
+
+	var req messages.SnapshotVerifyRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse verify request: %w", err)
+	}
+	reply := func(content string) {
+		sendFollowUp(req.ApplicationID, req.InteractionToken, content)
+	}
+
+	timestamp := req.SnapshotTimestamp
+	var bucket string
+	if timestamp == 0 {
+		last, err := getLastSnapshot(ctx)
+		if err != nil {
+			reply("No snapshot to verify yet.")
+			return nil
+		}
+		timestamp, bucket = last.Timestamp, bucketOrDefault(last.Bucket)
+	}
+	span.SetAttributes(
+		attribute.Int64("verify.snapshot", timestamp),
+		attribute.Bool("verify.repair", req.Repair),
+	)
+
+	var m *Manifest
+	var err error
+	if bucket != "" {
+		m, err = readManifest(ctx, bucket, timestamp)
+	} else {
+		m, err = findManifest(ctx, timestamp)
+	}
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		reply(fmt.Sprintf("Snapshot %d has no manifest.", timestamp))
+		return nil
+	}
+	if err != nil {
+		slog.Error("snapshot_verify_failed", "snapshot", timestamp, "error", err.Error())
+		reply(fmt.Sprintf("Failed to read the manifest: %v", err))
+		return err
+	}
+
+	missing, err := verifyManifest(ctx, *m)
+	if err != nil {
+		slog.Error("snapshot_verify_failed", "snapshot", timestamp, "error", err.Error())
+		reply(fmt.Sprintf("Failed to check the tiles: %v", err))
+		return err
+	}
+
+	repaired := 0
+	var repairErr error
+	if req.Repair && len(missing) > 0 {
+		repaired, repairErr = repairTiles(ctx, *m, missing)
+	}
+
+	slog.Info("snapshot_verified",
+		"snapshot", timestamp,
+		"tiles", len(m.Tiles),
+		"missing", len(missing),
+		"repaired", repaired,
+		"user_id", req.UserID,
+	)
+	params := map[string]interface{}{
+		"snapshot": timestamp,
+		"bucket":   m.Bucket,
+		"repair":   req.Repair,
+		"missing":  len(missing),
+		"repaired": repaired,
+	}
+	if repairErr != nil {
+		params["repairError"] = repairErr.Error()
+	}
+	audit.Record(ctx, getFirestore(), audit.Entry{
+		ActorID:   req.UserID,
+		ActorName: req.Username,
+		Action:    "snapshot.verify",
+		Target:    fmt.Sprintf("snapshots/%d", timestamp),
+		Params:    params,
+	})
+
+	sendFollowUpEmbed(req.ApplicationID, req.InteractionToken, verifyEmbed(*m, missing, repaired, repairErr))
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package snapshotworker
+
+import (
+	"image"
+	"testing"
+)
+
+// withPixelScale sets SNAPSHOT_PIXEL_SCALE's value for the rest of the test
+func withPixelScale(t *testing.T, scale int) {
+	t.Helper()
+	prev := pixelScale
+	pixelScale = scale
+	t.Cleanup(func() { pixelScale = prev })
+}
+
+func TestThumbnailPixelScale(t *testing.T) {
+	withPixelScale(t, 3)
+
+	img := decodePNG(t, generateThumbnail([]Pixel{{X: 5, Y: 10, Color: "#FF0000"}}, 100, 100, 800))
+	if got := img.Bounds().Size(); got != (image.Point{X: 300, Y: 300}) {
+		t.Fatalf("size = %v, want 300x300", got)
+	}
+
+	block := image.Rect(15, 30, 18, 33)
+	for y := 28; y < 35; y++ {
+		for x := 13; x < 20; x++ {
+			want := white
+			if (image.Point{X: x, Y: y}).In(block) {
+				want = red
+			}
+			if got := rgbaAt(img, x, y); got != want {
+				t.Errorf("(%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestThumbnailPixelScaleBounded(t *testing.T) {
+	tests := []struct {
+		name         string
+		scale        int
+		canvasW      int
+		canvasH      int
+		maxSize      int
+		wantW, wantH int
+	}{
+		{"small canvas zooms fully", 16, 100, 50, 800, 1600, 800},
+		{"fitted canvas zooms until the cap", 16, 2000, 1000, 800, 4000, 2000},
+		{"largest thumbnail does not zoom", 16, 8000, 8000, maxThumbnailSize, maxThumbnailSize, maxThumbnailSize},
+		{"no scale", 1, 2000, 1000, 800, 800, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withPixelScale(t, tt.scale)
+			img, _ := renderThumbnail(nil, tt.canvasW, tt.canvasH, tt.maxSize)
+			if got := img.Bounds().Size(); got != (image.Point{X: tt.wantW, Y: tt.wantH}) {
+				t.Errorf("size = %v, want %dx%d", got, tt.wantW, tt.wantH)
+			}
+			if w, h := img.Bounds().Dx(), img.Bounds().Dy(); w > maxThumbnailSize || h > maxThumbnailSize {
+				t.Errorf("size %dx%d is above maxThumbnailSize", w, h)
+			}
+		})
+	}
+}
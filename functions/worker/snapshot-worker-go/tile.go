@@ -0,0 +1,119 @@
+package snapshotworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/team11/snapshot-worker/internal/coords"
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+// resolveTile returns the tile a request targets; a pixel coordinate, typed
+// in the session's origin, maps to the tile containing it. Tile coordinates
+// always count rows from the top, like the rendered images.
+func resolveTile(req messages.TileRequest, origin coords.Origin, canvasH int) (int, int, bool) {
+	if req.X != nil && req.Y != nil {
+		if *req.X < 0 || *req.Y < 0 {
+			return 0, 0, false
+		}
+		x, y := origin.ToCanvas(*req.X, *req.Y, canvasH)
+		if y < 0 {
+			// Above a flipped canvas; -1 / tileSize would round to row 0
+			return x / tileSize, -1, true
+		}
+		return x / tileSize, y / tileSize, true
+	}
+	if req.TileX != nil && req.TileY != nil {
+		return *req.TileX, *req.TileY, true
+	}
+	return 0, 0, false
+}
+
+// getTilePixels reads only the pixels of one tile: a range query on x, then
+// y filtered in memory to avoid needing a composite index.
+func getTilePixels(ctx context.Context, tx, ty, canvasW, canvasH int) ([]Pixel, error) {
+	startX, startY := tx*tileSize, ty*tileSize
+	endX, endY := min(startX+tileSize, canvasW), min(startY+tileSize, canvasH)
+
+	q := getFirestore().Collection("pixels").
+		Where("x", ">=", startX).
+		Where("x", "<", endX)
+	pixels, err := queryPixels(ctx, q, pixelLoad{})
+	if err != nil {
+		return nil, err
+	}
+
+	inTile := pixels[:0]
+	for _, p := range pixels {
+		if p.Y >= startY && p.Y < endY {
+			inTile = append(inTile, p)
+		}
+	}
+	return inTile, nil
+}
+
+func handleTileRequest(ctx context.Context, data []byte) error {
+	ctx, span := tracer.Start(ctx, "generateSingleTile")
+	defer span.End()
+
+	var req messages.TileRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("parse tile request: %w", err)
+	}
+	reply := func(content string) {
+		sendFollowUp(req.ApplicationID, req.InteractionToken, content)
+	}
+
+	canvasW, canvasH, origin := getCanvasView(ctx)
+	tilesX := int(math.Ceil(float64(canvasW) / float64(tileSize)))
+	tilesY := int(math.Ceil(float64(canvasH) / float64(tileSize)))
+
+	tx, ty, ok := resolveTile(req, origin, canvasH)
+	if !ok {
+		reply("Give either tile_x and tile_y, or x and y of a pixel inside the tile.")
+		return nil
+	}
+	if tx < 0 || tx >= tilesX || ty < 0 || ty >= tilesY {
+		reply(fmt.Sprintf("Tile (%d, %d) is out of bounds (0-%d, 0-%d)", tx, ty, tilesX-1, tilesY-1))
+		return nil
+	}
+	span.SetAttributes(
+		attribute.Int("tile.x", tx),
+		attribute.Int("tile.y", ty),
+	)
+
+	pixels, err := getTilePixels(ctx, tx, ty, canvasW, canvasH)
+	if err != nil {
+		slog.Error("tile_pixels_fetch_failed", "tile_x", tx, "tile_y", ty, "error", err.Error())
+		reply(fmt.Sprintf("Failed to get pixels: %v", err))
+		return err
+	}
+
+	path := fmt.Sprintf("tiles/%d/tile-%d-%d.png", time.Now().UnixMilli(), tx, ty)
+	url, err := uploadWithRetry(ctx, generateTile(pixels, tx, ty, canvasW, canvasH), path, "image/png")
+	if err != nil {
+		slog.Error("tile_upload_failed", "tile_x", tx, "tile_y", ty, "error", err.Error())
+		reply("Failed to upload the tile.")
+		return nil
+	}
+
+	slog.Info("tile_generated", "tile_x", tx, "tile_y", ty, "pixel_count", len(pixels), "user_id", req.UserID)
+	span.SetAttributes(attribute.Int("tile.pixel_count", len(pixels)))
+
+	// The covered pixels are shown the way the user types them
+	minX, minY, maxX, maxY := origin.ToUserRect(tx*tileSize, ty*tileSize,
+		min((tx+1)*tileSize, canvasW)-1, min((ty+1)*tileSize, canvasH)-1, canvasH)
+	reply(fmt.Sprintf("Tile (%d, %d) covering x %d-%d, y %d-%d (%d pixels)\n%s",
+		tx, ty, minX, maxX, minY, maxY, len(pixels), url))
+
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package snapshotworker
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/team11/snapshot-worker/internal/coords"
+	"github.com/team11/snapshot-worker/internal/messages"
+)
+
+var (
+	white = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	red   = color.RGBA{0xFF, 0x00, 0x00, 0xFF}
+	blue  = color.RGBA{0x00, 0x00, 0xFF, 0xFF}
+)
+
+// decodePNG decodes an encoded image or fails the test
+func decodePNG(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode PNG: %v", err)
+	}
+	return img
+}
+
+// rgbaAt returns the color of one pixel of img as RGBA
+func rgbaAt(img image.Image, x, y int) color.RGBA {
+	return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+}
+
+func TestResolveTile(t *testing.T) {
+	ptr := func(v int) *int { return &v }
+	tests := []struct {
+		name   string
+		req    messages.TileRequest
+		origin coords.Origin
+		wantX  int
+		wantY  int
+		wantOK bool
+	}{
+		{"pixel in the first tile", messages.TileRequest{X: ptr(0), Y: ptr(0)}, coords.TopLeft, 0, 0, true},
+		{"last pixel of the first tile", messages.TileRequest{X: ptr(2047), Y: ptr(2047)}, coords.TopLeft, 0, 0, true},
+		{"first pixel of the next tile", messages.TileRequest{X: ptr(2048), Y: ptr(10)}, coords.TopLeft, 1, 0, true},
+		{"bottom-left origin flips the row", messages.TileRequest{X: ptr(5), Y: ptr(0)}, coords.BottomLeft, 0, 1, true},
+		{"above a flipped canvas", messages.TileRequest{X: ptr(5), Y: ptr(3000)}, coords.BottomLeft, 0, -1, true},
+		{"tile coordinates", messages.TileRequest{TileX: ptr(1), TileY: ptr(1)}, coords.BottomLeft, 1, 1, true},
+		{"negative pixel", messages.TileRequest{X: ptr(-1), Y: ptr(0)}, coords.TopLeft, 0, 0, false},
+		{"nothing given", messages.TileRequest{}, coords.TopLeft, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, y, ok := resolveTile(tt.req, tt.origin, 3000)
+			if x != tt.wantX || y != tt.wantY || ok != tt.wantOK {
+				t.Fatalf("resolveTile() = (%d, %d, %v), want (%d, %d, %v)", x, y, ok, tt.wantX, tt.wantY, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSingleTile(t *testing.T) {
+	// The second tile of a 3000×1000 canvas is clipped to 952×1000
+	pixels := []Pixel{
+		{X: 2048, Y: 0, Color: "FF0000"},
+		{X: 2999, Y: 999, Color: "#0000FF"},
+		{X: 10, Y: 10, Color: "00FF00"},
+	}
+	tiles := groupByTile(pixels, 3000, 1000)
+	tile := tiles[tileKey{1, 0}]
+	if len(tile) != 2 {
+		t.Fatalf("tile (1, 0) holds %d pixels, want 2", len(tile))
+	}
+
+	img := decodePNG(t, generateTile(tile, 1, 0, 3000, 1000))
+	if b := img.Bounds(); b.Dx() != 952 || b.Dy() != 1000 {
+		t.Fatalf("tile is %dx%d, want 952x1000", b.Dx(), b.Dy())
+	}
+	for _, tc := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, red},
+		{951, 999, blue},
+		{1, 1, white},
+	} {
+		if got := rgbaAt(img, tc.x, tc.y); got != tc.want {
+			t.Errorf("tile pixel (%d, %d) = %v, want %v", tc.x, tc.y, got, tc.want)
+		}
+	}
+}
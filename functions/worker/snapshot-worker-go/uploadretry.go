@@ -0,0 +1,168 @@
+package snapshotworker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/api/googleapi"
+)
+
+// uploadAttempts bounds how often one object is written
+const uploadAttempts = 3
+
+// Delay before the n-th retry is uploadBaseDelay << (n-1), ± uploadJitter.
+// Variables so tests can shorten them.
+var (
+	uploadBaseDelay = time.Second
+	uploadJitter    = 500 * time.Millisecond
+)
+
+// uploadWithRetry writes data to path in the snapshots bucket and returns a
+// URL for it, signed for a week when possible. Transient GCS errors (network
+// errors, 408, 429, 5xx, and writes refused for a checksum mismatch) are
+// retried with exponential backoff; anything else, such as a missing bucket
+// or a permission error, fails at once.
+//
+// The object is then copied to every mirror bucket (SNAPSHOTS_BUCKETS). Only
+// the primary write can fail the upload; mirror failures are logged.
+func uploadWithRetry(ctx context.Context, data []byte, path, contentType string) (string, error) {
+	return uploadToBucket(ctx, snapshotsBucket, data, path, contentType)
+}
+
+// uploadToBucket is uploadWithRetry for a given snapshots bucket, such as a
+// session's own. Mirrors only copy SNAPSHOTS_BUCKET, so other buckets are
+// written alone.
+func uploadToBucket(ctx context.Context, bucket string, data []byte, path, contentType string) (string, error) {
+	if bucket == "" {
+		return "", errSnapshotsNotConfigured
+	}
+	ctx, span := tracer.Start(ctx, "uploadWithRetry")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("upload.bucket", bucket),
+		attribute.String("upload.object", path),
+		attribute.Int("upload.bytes", len(data)),
+	)
+
+	retries, err := writeWithRetry(ctx, bucket, path, data, contentType)
+	span.SetAttributes(attribute.Int("upload.retries", retries))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", fmt.Errorf("upload %s: %w", path, err)
+	}
+
+	if bucket == snapshotsBucket && len(mirrorBuckets) > 0 {
+		failed := writeMirrors(ctx, path, data, contentType)
+		span.SetAttributes(
+			attribute.Int("upload.mirrors", len(mirrorBuckets)),
+			attribute.Int("upload.mirror_failures", failed),
+		)
+	}
+
+	return objectURL(bucket, path), nil
+}
+
+// objectURL signs a GET URL for an object, valid for 7 days, or falls back
+// to its public URL when signing fails
+func objectURL(bucket, path string) string {
+	signedURL, err := getStorage().Bucket(bucket).SignedURL(path, &storage.SignedURLOptions{
+		Method:  "GET",
+		Expires: time.Now().Add(7 * 24 * time.Hour),
+	})
+	if err != nil {
+		return fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucket, path)
+	}
+	return signedURL
+}
+
+// writeMirrors copies an uploaded object to every mirror bucket concurrently
+// and returns how many copies failed.
+func writeMirrors(ctx context.Context, path string, data []byte, contentType string) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	for _, bucket := range mirrorBuckets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := writeWithRetry(ctx, bucket, path, data, contentType); err != nil {
+				slog.Warn("snapshot_mirror_upload_failed", "bucket", bucket, "object", path, "error", err.Error())
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return failed
+}
+
+// writeWithRetry writes data to path in bucket, retrying transient errors as
+// described on uploadWithRetry, and returns how many retries it took.
+func writeWithRetry(ctx context.Context, bucket, path string, data []byte, contentType string) (int, error) {
+	obj := getStorage().Bucket(bucket).Object(path)
+	configure := func(w *storage.Writer) {
+		w.ContentType = contentType
+		w.CacheControl = cacheControlFor(contentType)
+	}
+
+	var err error
+	retries := 0
+	for attempt := 1; ; attempt++ {
+		if err = writeVerified(ctx, obj, data, configure); err == nil {
+			break
+		}
+		if attempt == uploadAttempts || !isRetryableUploadError(err) {
+			break
+		}
+		delay := uploadBackoff(attempt)
+		slog.Warn("snapshot_upload_retry",
+			"bucket", bucket,
+			"object", path,
+			"attempt", attempt,
+			"delay_ms", delay.Milliseconds(),
+			"error", err.Error(),
+		)
+		select {
+		case <-ctx.Done():
+			err = errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+			retries++
+			continue
+		}
+		break
+	}
+	return retries, err
+}
+
+// isRetryableUploadError follows GCS's retry guidance, and also retries the
+// 400 GCS returns when the bytes it received do not match the checksums
+// writeVerified sent, since a fresh write usually succeeds.
+func isRetryableUploadError(err error) bool {
+	if storage.ShouldRetry(err) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == 400 {
+		msg := strings.ToLower(apiErr.Message)
+		return strings.Contains(msg, "crc32c") || strings.Contains(msg, "md5")
+	}
+	return false
+}
+
+// uploadBackoff is the delay after the given failed attempt: 1s, 2s, 4s, ...
+// with up to half a second of jitter either way.
+func uploadBackoff(attempt int) time.Duration {
+	jitter := time.Duration(rand.Int64N(int64(2*uploadJitter))) - uploadJitter
+	return uploadBaseDelay<<(attempt-1) + jitter
+}
@@ -0,0 +1,131 @@
+package snapshotworker
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsRetryableUploadError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"crc32c mismatch", &googleapi.Error{Code: 400, Message: `Provided CRC32C "AAAAAA==" doesn't match calculated CRC32C "BBBBBB==".`}, true},
+		{"md5 mismatch", &googleapi.Error{Code: 400, Message: "Provided MD5 hash doesn't match calculated MD5 hash."}, true},
+		{"other bad request", &googleapi.Error{Code: 400, Message: "Invalid object name"}, false},
+		{"service unavailable", &googleapi.Error{Code: 503}, true},
+		{"rate limited", &googleapi.Error{Code: 429}, true},
+		{"missing bucket", &googleapi.Error{Code: 404}, false},
+		{"permission denied", &googleapi.Error{Code: 403}, false},
+		{"plain error", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableUploadError(tt.err); got != tt.want {
+				t.Errorf("isRetryableUploadError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteWithRetryChecksumMismatch(t *testing.T) {
+	f := useFakeGCS(t)
+	// The first attempt arrives corrupted: GCS refuses it for its checksum
+	f.fail = func(bucket, name string, attempt int) (int, string) {
+		if attempt == 1 {
+			return http.StatusBadRequest, `Provided CRC32C "AAAAAA==" doesn't match calculated CRC32C "BBBBBB==".`
+		}
+		return 0, ""
+	}
+
+	data := []byte("png bytes")
+	retries, err := writeWithRetry(t.Context(), "snapshots", "s1/latest.png", data, "image/png")
+	if err != nil {
+		t.Fatalf("writeWithRetry: %v", err)
+	}
+	if retries != 1 {
+		t.Errorf("retries = %d, want 1", retries)
+	}
+	if n := f.attemptsFor("snapshots", "s1/latest.png"); n != 2 {
+		t.Errorf("attempts = %d, want 2", n)
+	}
+	if got, ok := f.object("snapshots", "s1/latest.png"); !ok || !bytes.Equal(got, data) {
+		t.Errorf("stored object = %q, %v; want %q", got, ok, data)
+	}
+}
+
+func TestWriteWithRetryGivesUpOnOtherBadRequests(t *testing.T) {
+	f := useFakeGCS(t)
+	f.fail = func(bucket, name string, attempt int) (int, string) {
+		return http.StatusBadRequest, "Invalid object name"
+	}
+
+	retries, err := writeWithRetry(t.Context(), "snapshots", "s1/latest.png", []byte("png"), "image/png")
+	if err == nil {
+		t.Fatal("writeWithRetry succeeded, want an error")
+	}
+	if retries != 0 || f.attemptsFor("snapshots", "s1/latest.png") != 1 {
+		t.Errorf("retries = %d, attempts = %d; want a single attempt", retries, f.attemptsFor("snapshots", "s1/latest.png"))
+	}
+}
+
+func TestUploadWithRetryTransientErrors(t *testing.T) {
+	f := useFakeGCS(t)
+	prevBucket, prevMirrors := snapshotsBucket, mirrorBuckets
+	snapshotsBucket, mirrorBuckets = "snapshots", nil
+	t.Cleanup(func() { snapshotsBucket, mirrorBuckets = prevBucket, prevMirrors })
+	f.fail = func(bucket, name string, attempt int) (int, string) {
+		if attempt <= 2 {
+			return http.StatusServiceUnavailable, "Backend Error"
+		}
+		return 0, ""
+	}
+
+	url, err := uploadWithRetry(t.Context(), []byte("tile"), "s1/tile_0_0.png", "image/png")
+	if err != nil {
+		t.Fatalf("uploadWithRetry: %v", err)
+	}
+	if url == "" {
+		t.Error("uploadWithRetry returned no URL")
+	}
+	if n := f.attemptsFor("snapshots", "s1/tile_0_0.png"); n != 3 {
+		t.Errorf("attempts = %d, want 3", n)
+	}
+	if _, ok := f.object("snapshots", "s1/tile_0_0.png"); !ok {
+		t.Error("object was not stored")
+	}
+}
+
+func TestWriteWithRetryFinalErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantAttempts int
+	}{
+		{"missing bucket", http.StatusNotFound, 1},
+		{"permission denied", http.StatusForbidden, 1},
+		{"unavailable every time", http.StatusServiceUnavailable, uploadAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeGCS(t)
+			f.fail = func(bucket, name string, attempt int) (int, string) {
+				return tt.status, http.StatusText(tt.status)
+			}
+
+			retries, err := writeWithRetry(t.Context(), "snapshots", "s1/latest.png", []byte("png"), "image/png")
+			var apiErr *googleapi.Error
+			if !errors.As(err, &apiErr) || apiErr.Code != tt.status {
+				t.Fatalf("err = %v, want googleapi error %d", err, tt.status)
+			}
+			if n := f.attemptsFor("snapshots", "s1/latest.png"); n != tt.wantAttempts || retries != tt.wantAttempts-1 {
+				t.Errorf("attempts = %d, retries = %d; want %d attempts", n, retries, tt.wantAttempts)
+			}
+		})
+	}
+}
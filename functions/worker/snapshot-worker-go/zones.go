@@ -0,0 +1,73 @@
+package snapshotworker
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Zone is an admin-protected area as written by /zone lock. Bounds are
+// canvas coordinates, inclusive.
+type Zone struct {
+	Label  string `firestore:"label"`
+	MinX   int    `firestore:"minX"`
+	MinY   int    `firestore:"minY"`
+	MaxX   int    `firestore:"maxX"`
+	MaxY   int    `firestore:"maxY"`
+	Locked bool   `firestore:"locked"`
+}
+
+var (
+	zoneLockedColor   = color.RGBA{220, 38, 38, 255}
+	zoneUnlockedColor = color.RGBA{156, 163, 175, 255}
+)
+
+func getZones(ctx context.Context) ([]Zone, error) {
+	docs, err := getFirestore().Collection("zones").OrderBy("label", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	zones := make([]Zone, 0, len(docs))
+	for _, doc := range docs {
+		var z Zone
+		if err := doc.DataTo(&z); err != nil {
+			continue
+		}
+		zones = append(zones, z)
+	}
+	return zones, nil
+}
+
+// drawZoneOverlays outlines each zone, red when locked and grey otherwise,
+// and numbers it in the top-left corner. The font has no letters, so labels
+// go in the legend returned by zoneLegend instead.
+func drawZoneOverlays(img *image.RGBA, zones []Zone, scale float64) {
+	for i, z := range zones {
+		col := zoneUnlockedColor
+		if z.Locked {
+			col = zoneLockedColor
+		}
+		r := outlineRect(img, z.MinX, z.MinY, z.MaxX, z.MaxY, scale, col)
+		if r.Empty() {
+			continue
+		}
+		drawText(img, r.Min.X+2, r.Min.Y+2, fmt.Sprint(i+1), 1, col)
+	}
+}
+
+// zoneLegend maps the numbers drawn by drawZoneOverlays to zone labels.
+func zoneLegend(zones []Zone) string {
+	var b strings.Builder
+	for i, z := range zones {
+		state := "unlocked"
+		if z.Locked {
+			state = "locked"
+		}
+		fmt.Fprintf(&b, "\n%d. %s (%s)", i+1, z.Label, state)
+	}
+	return b.String()
+}
@@ -0,0 +1,353 @@
+// Package walrecovery settles pixel writes the pixel worker announced in
+// wal_entries but never marked committed or aborted, because its instance
+// died in between. Cloud Scheduler triggers it every 5 minutes through the
+// wal-recovery topic.
+package walrecovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/team11/wal-recovery/internal/pixelrules"
+)
+
+const (
+	// walStaleAfter is how old a pending entry must be to count as crashed
+	// rather than in flight
+	walStaleAfter = 2 * time.Minute
+	// walRecoveryPageSize bounds one run; older entries come first and the
+	// rest wait for the next run
+	walRecoveryPageSize = 500
+
+	walPending   = "pending"
+	walCommitted = "committed"
+	walAborted   = "aborted"
+)
+
+// How recoverEntry settled an entry, in its recovery field. Only replayed
+// and landed entries are committed; the others are aborted because the
+// write's checks no longer pass.
+const (
+	recoveryLanded    = "landed"
+	recoveryReplayed  = "replayed"
+	recoveryClosed    = "session_closed"
+	recoveryConflict  = "conflict"
+	recoveryProtected = "pixel_protected"
+	recoveryZone      = "zone_protected"
+	recoveryColor     = "color_restricted"
+	recoveryCooldown  = "color_cooldown"
+)
+
+var (
+	projectID string
+	fsClient  *firestore.Client
+	fsOnce    sync.Once
+
+	// protectAdminPixels and sameColorCooldown mirror the pixel worker's
+	// PROTECT_ADMIN_PIXELS and SAME_COLOR_COOLDOWN; set both the same way
+	protectAdminPixels bool
+	sameColorCooldown  time.Duration
+)
+
+// walEntry is the part of a wal_entries document recovery reads; the pixel
+// worker's wal.go writes it.
+type walEntry struct {
+	X                 int       `firestore:"x"`
+	Y                 int       `firestore:"y"`
+	Color             string    `firestore:"color"`
+	BlendMode         string    `firestore:"blendMode"`
+	ExpectedUpdatedAt string    `firestore:"expectedUpdatedAt"`
+	UserID            string    `firestore:"userId"`
+	Username          string    `firestore:"username"`
+	Source            string    `firestore:"source"`
+	AdminPlaced       bool      `firestore:"adminPlaced"`
+	Imported          bool      `firestore:"imported"`
+	Status            string    `firestore:"status"`
+	CreatedAt         time.Time `firestore:"createdAt"`
+}
+
+// zone is the part of a zones document a replay checks
+type zone struct {
+	MinX         int      `firestore:"minX"`
+	MinY         int      `firestore:"minY"`
+	MaxX         int      `firestore:"maxX"`
+	MaxY         int      `firestore:"maxY"`
+	Locked       bool     `firestore:"locked"`
+	AllowedUsers []string `firestore:"allowedUsers"`
+}
+
+// replayState is what recoverEntry's transaction read for an entry
+type replayState struct {
+	pixel       map[string]interface{} // nil when the cell is empty
+	session     map[string]interface{} // nil when there is none
+	zones       []zone
+	constraints []pixelrules.TimeConstraint
+	// colorPlacedAt is when the entry's user last placed its color, zero
+	// when the cooldowns have no record of it
+	colorPlacedAt time.Time
+	now           time.Time
+}
+
+func init() {
+	projectID = os.Getenv("PROJECT_ID")
+	protectAdminPixels = os.Getenv("PROTECT_ADMIN_PIXELS") == "true"
+	if v, err := strconv.Atoi(os.Getenv("SAME_COLOR_COOLDOWN")); err == nil && v > 0 {
+		sameColorCooldown = time.Duration(v) * time.Second
+	}
+	functions.CloudEvent("handler", handleRecovery)
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.MessageKey {
+				a.Key = "message"
+			} else if a.Key == slog.LevelKey {
+				a.Key = "severity"
+			}
+			return a
+		},
+	})))
+}
+
+func getFirestore() *firestore.Client {
+	fsOnce.Do(func() {
+		var err error
+		fsClient, err = firestore.NewClientWithDatabase(context.Background(), projectID, "team11-database")
+		if err != nil {
+			log.Fatalf("Firestore client: %v", err)
+		}
+	})
+	return fsClient
+}
+
+// handleRecovery settles the oldest pending entries created more than
+// walStaleAfter ago. An entry that fails is logged and left pending for the
+// next run; only a failed query fails the run.
+func handleRecovery(ctx context.Context, e event.Event) error {
+	docs, err := getFirestore().Collection("wal_entries").
+		Where("status", "==", walPending).
+		Where("createdAt", "<", time.Now().UTC().Add(-walStaleAfter)).
+		OrderBy("createdAt", firestore.Asc).
+		Limit(walRecoveryPageSize).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("query wal_entries: %w", err)
+	}
+
+	counts := make(map[string]int)
+	for _, doc := range docs {
+		recovery, err := recoverEntry(ctx, doc.Ref)
+		if err != nil {
+			slog.Warn("wal_entry_recovery_failed", "wal_id", doc.Ref.ID, "error", err.Error())
+			counts["failed"]++
+			continue
+		}
+		if recovery == recoveryReplayed {
+			slog.Info("wal_entry_replayed", "wal_id", doc.Ref.ID)
+		}
+		counts[recovery]++
+	}
+
+	slog.Info("wal_recovery_completed",
+		"pending", len(docs),
+		"landed", counts[recoveryLanded],
+		"replayed", counts[recoveryReplayed],
+		"session_closed", counts[recoveryClosed],
+		"conflict", counts[recoveryConflict],
+		"pixel_protected", counts[recoveryProtected],
+		"zone_protected", counts[recoveryZone],
+		"color_restricted", counts[recoveryColor],
+		"color_cooldown", counts[recoveryCooldown],
+		"failed", counts["failed"],
+	)
+	return nil
+}
+
+// recoverEntry settles one pending entry in a transaction and says how. When
+// the pixel holds this write or a later one, the write landed and the entry
+// is only marked committed. Otherwise the pixel document is written as
+// replayDecision allows; user stats, history and the leaderboard are not,
+// since the placement's follow-ups never ran either.
+func recoverEntry(ctx context.Context, ref *firestore.DocumentRef) (string, error) {
+	var recovery string
+	err := getFirestore().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		recovery = ""
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var e walEntry
+		if err := doc.DataTo(&e); err != nil {
+			return err
+		}
+		// Settled by the pixel worker after the query
+		if e.Status != walPending {
+			return nil
+		}
+
+		pixelRef := getFirestore().Collection("pixels").Doc(fmt.Sprintf("%d_%d", e.X, e.Y))
+		pixelDoc, err := tx.Get(pixelRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		sessionDoc, err := tx.Get(getFirestore().Collection("sessions").Doc("current"))
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		zoneDocs, err := tx.Documents(getFirestore().Collection("zones")).GetAll()
+		if err != nil {
+			return err
+		}
+		constraintDocs, err := tx.Documents(getFirestore().Collection("time_constraints")).GetAll()
+		if err != nil {
+			return err
+		}
+		cooldownRef := getFirestore().Collection("color_cooldowns").Doc(pixelrules.CooldownID(e.UserID, e.Color))
+		// Admin imports are held to no cooldown
+		cooling := sameColorCooldown > 0 && !e.Imported
+		var cooldownDoc *firestore.DocumentSnapshot
+		if cooling {
+			if cooldownDoc, err = tx.Get(cooldownRef); err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+		}
+
+		s := replayState{now: time.Now()}
+		if pixelDoc.Exists() {
+			s.pixel = pixelDoc.Data()
+		}
+		if sessionDoc.Exists() {
+			s.session = sessionDoc.Data()
+		}
+		for _, d := range zoneDocs {
+			var z zone
+			if err := d.DataTo(&z); err == nil {
+				s.zones = append(s.zones, z)
+			}
+		}
+		// Like the pixel worker, a constraint that cannot be read is skipped
+		for _, d := range constraintDocs {
+			var c pixelrules.TimeConstraint
+			if err := d.DataTo(&c); err == nil && c.Compile() == nil {
+				s.constraints = append(s.constraints, c)
+			}
+		}
+		if cooling && cooldownDoc.Exists() {
+			s.colorPlacedAt, _ = pixelrules.StoredTime(cooldownDoc.Data()["lastPlacedAt"])
+		}
+		var color string
+		recovery, color = replayDecision(e, s)
+
+		settled := walAborted
+		switch recovery {
+		case recoveryLanded:
+			settled = walCommitted
+		case recoveryReplayed:
+			settled = walCommitted
+			tx.Set(pixelRef, map[string]interface{}{
+				"x":           e.X,
+				"y":           e.Y,
+				"color":       color,
+				"userId":      e.UserID,
+				"username":    e.Username,
+				"source":      e.Source,
+				"updatedAt":   time.Now().UTC(),
+				"adminPlaced": e.AdminPlaced,
+			})
+			// The replayed placement starts its cooldown, unless a later
+			// one already did
+			if cooling && e.CreatedAt.After(s.colorPlacedAt) {
+				tx.Set(cooldownRef, pixelrules.CooldownDoc(e.UserID, e.Color, e.CreatedAt, sameColorCooldown))
+			}
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: settled},
+			{Path: "settledAt", Value: time.Now().UTC()},
+			{Path: "recovery", Value: recovery},
+		})
+	})
+	return recovery, err
+}
+
+// replayDecision runs again the checks the pixel worker made before and
+// inside the write, against what s read now, and returns how the entry is
+// settled and, for a replay, the color to store. Nothing is replayed while
+// the session is not active, is being stopped or is outside its schedule: a
+// cleared, stopped or closed canvas must stay that way. Zones and time
+// constraints are checked as they are now too, so a replay never paints
+// what a placement made now could not. Cooldowns are checked around when
+// the placement was made, since its own claim never committed: a placement
+// of the same color within the cooldown of it, before or after, refuses it.
+//
+// The other placement checks are not run again. Source, user ID and color
+// were validated before the entry was written, and entries are only
+// written by the pixel worker. Rate-limit quota was charged then and is
+// only refunded for writes that are reported failed, which a pending entry
+// never was, so a replay is not charged again.
+func replayDecision(e walEntry, s replayState) (string, string) {
+	if s.pixel != nil && writtenSince(s.pixel["updatedAt"], e.CreatedAt) {
+		return recoveryLanded, ""
+	}
+	if s.session == nil || s.session["status"] != "active" {
+		return recoveryClosed, ""
+	}
+	if pending, _ := s.session["pendingStop"].(bool); pending {
+		return recoveryClosed, ""
+	}
+	if pixelrules.NotOpenYet(pixelrules.ParseScheduleTime(s.session["opensAt"]), s.now) ||
+		pixelrules.Closed(pixelrules.ParseScheduleTime(s.session["closesAt"]), s.now) {
+		return recoveryClosed, ""
+	}
+	for _, z := range s.zones {
+		if z.Locked && e.X >= z.MinX && e.X <= z.MaxX && e.Y >= z.MinY && e.Y <= z.MaxY && !slices.Contains(z.AllowedUsers, e.UserID) {
+			return recoveryZone, ""
+		}
+	}
+	for _, c := range s.constraints {
+		if c.Restricts(e.Color, s.now) {
+			return recoveryColor, ""
+		}
+	}
+	if sameColorCooldown > 0 && !e.Imported && !s.colorPlacedAt.IsZero() &&
+		e.CreatedAt.Sub(s.colorPlacedAt).Abs() < sameColorCooldown {
+		return recoveryCooldown, ""
+	}
+	if s.pixel == nil {
+		return recoveryReplayed, e.Color
+	}
+	if pixelrules.IsStale(s.pixel["updatedAt"], e.ExpectedUpdatedAt) {
+		return recoveryConflict, ""
+	}
+	if adminPlaced, _ := s.pixel["adminPlaced"].(bool); protectAdminPixels && adminPlaced && !e.AdminPlaced {
+		return recoveryProtected, ""
+	}
+	previous, _ := s.pixel["color"].(string)
+	return recoveryReplayed, pixelrules.BlendHex(previous, e.Color, e.BlendMode)
+}
+
+// writtenSince reports whether a pixel's updatedAt is at or after t. The
+// pixel worker writes the pixel with its entry's createdAt, so equal means
+// this very write landed. Pixels last written before updatedAt became a
+// Timestamp hold RFC 3339 strings.
+func writtenSince(updatedAt interface{}, t time.Time) bool {
+	switch v := updatedAt.(type) {
+	case time.Time:
+		return !v.Before(t)
+	case string:
+		parsed, err := time.Parse(time.RFC3339, v)
+		return err == nil && !parsed.Before(t.Truncate(time.Second))
+	}
+	return false
+}
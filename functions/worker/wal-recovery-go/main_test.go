@@ -0,0 +1,248 @@
+package walrecovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/team11/wal-recovery/internal/pixelrules"
+)
+
+func TestReplayDecision(t *testing.T) {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := created.Add(5 * time.Minute)
+	active := map[string]interface{}{"status": "active"}
+	entry := walEntry{X: 4, Y: 4, Color: "0000FF", BlendMode: pixelrules.BlendReplace, UserID: "u2", CreatedAt: created}
+	older := map[string]interface{}{"color": "FF0000", "userId": "u1", "updatedAt": created.Add(-time.Minute)}
+	adminPixel := map[string]interface{}{"color": "FF0000", "userId": "u1", "updatedAt": created.Add(-time.Minute), "adminPlaced": true}
+	locked := []zone{{MinX: 0, MinY: 0, MaxX: 9, MaxY: 9, Locked: true, AllowedUsers: []string{"u1"}}}
+	blueAtNight := pixelrules.TimeConstraint{ColorPattern: "^0000FF$", AllowedHours: []int{22, 23}}
+	if err := blueAtNight.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	with := func(f func(e *walEntry)) walEntry {
+		e := entry
+		f(&e)
+		return e
+	}
+	state := func(pixel, session map[string]interface{}, f func(s *replayState)) replayState {
+		s := replayState{pixel: pixel, session: session, now: now}
+		if f != nil {
+			f(&s)
+		}
+		return s
+	}
+	placedAt := func(t time.Time) func(s *replayState) {
+		return func(s *replayState) { s.colorPlacedAt = t }
+	}
+
+	tests := []struct {
+		name         string
+		entry        walEntry
+		state        replayState
+		protect      bool
+		wantRecovery string
+		wantColor    string
+	}{
+		{"empty pixel is replayed", entry, state(nil, active, nil), false, recoveryReplayed, "0000FF"},
+		{"older pixel is replayed over", entry, state(older, active, nil), false, recoveryReplayed, "0000FF"},
+		{"this write landed", entry, state(map[string]interface{}{"updatedAt": created}, active, nil), false, recoveryLanded, ""},
+		{"a later write landed", entry, state(map[string]interface{}{"updatedAt": created.Add(time.Second)}, active, nil), false, recoveryLanded, ""},
+		{"no session", entry, state(nil, nil, nil), false, recoveryClosed, ""},
+		{"stopped session", entry, state(nil, map[string]interface{}{"status": "stopped"}, nil), false, recoveryClosed, ""},
+		{"session being stopped", entry, state(nil, map[string]interface{}{"status": "active", "pendingStop": true}, nil), false, recoveryClosed, ""},
+		{"closed since", entry, state(nil, map[string]interface{}{"status": "active", "closesAt": now.Add(-time.Minute).Format(time.RFC3339)}, nil), false, recoveryClosed, ""},
+		{"not open yet", entry, state(nil, map[string]interface{}{"status": "active", "opensAt": now.Add(time.Hour)}, nil), false, recoveryClosed, ""},
+		{"open", entry, state(nil, map[string]interface{}{"status": "active", "opensAt": created.Add(-time.Hour), "closesAt": now.Add(time.Hour)}, nil), false, recoveryReplayed, "0000FF"},
+		{"locked zone", entry, state(nil, active, func(s *replayState) { s.zones = locked }), false, recoveryZone, ""},
+		{"allowlisted in the zone", with(func(e *walEntry) { e.UserID = "u1" }), state(nil, active, func(s *replayState) { s.zones = locked }), false, recoveryReplayed, "0000FF"},
+		{"color restricted now", entry, state(nil, active, func(s *replayState) { s.constraints = []pixelrules.TimeConstraint{blueAtNight} }), false, recoveryColor, ""},
+		{"other color unrestricted", with(func(e *walEntry) { e.Color = "00FF00" }), state(nil, active, func(s *replayState) { s.constraints = []pixelrules.TimeConstraint{blueAtNight} }), false, recoveryReplayed, "00FF00"},
+		{"color placed just before", entry, state(nil, active, placedAt(created.Add(-30*time.Second))), false, recoveryCooldown, ""},
+		{"color placed just after", entry, state(nil, active, placedAt(created.Add(30*time.Second))), false, recoveryCooldown, ""},
+		{"color placed a cooldown before", entry, state(nil, active, placedAt(created.Add(-time.Minute))), false, recoveryReplayed, "0000FF"},
+		{"imports have no cooldown", with(func(e *walEntry) { e.Imported = true }), state(nil, active, placedAt(created.Add(-30*time.Second))), false, recoveryReplayed, "0000FF"},
+		{"client saw an older pixel", with(func(e *walEntry) { e.ExpectedUpdatedAt = created.Add(-time.Hour).Format(time.RFC3339) }), state(older, active, nil), false, recoveryConflict, ""},
+		{"client saw this pixel", with(func(e *walEntry) { e.ExpectedUpdatedAt = created.Add(-time.Minute).Format(time.RFC3339) }), state(older, active, nil), false, recoveryReplayed, "0000FF"},
+		{"admin pixel with protection", entry, state(adminPixel, active, nil), true, recoveryProtected, ""},
+		{"admin pixel without protection", entry, state(adminPixel, active, nil), false, recoveryReplayed, "0000FF"},
+		{"admin over admin pixel", with(func(e *walEntry) { e.AdminPlaced = true }), state(adminPixel, active, nil), true, recoveryReplayed, "0000FF"},
+		{"blended onto the pixel", with(func(e *walEntry) { e.BlendMode = pixelrules.BlendAverage }), state(older, active, nil), false, recoveryReplayed, "7F007F"},
+		{"nothing to blend onto", with(func(e *walEntry) { e.BlendMode = pixelrules.BlendAverage }), state(nil, active, nil), false, recoveryReplayed, "0000FF"},
+	}
+	defer func(v bool, d time.Duration) { protectAdminPixels, sameColorCooldown = v, d }(protectAdminPixels, sameColorCooldown)
+	sameColorCooldown = time.Minute
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protectAdminPixels = tt.protect
+			recovery, color := replayDecision(tt.entry, tt.state)
+			if recovery != tt.wantRecovery || color != tt.wantColor {
+				t.Errorf("replayDecision() = %q, %q, want %q, %q", recovery, color, tt.wantRecovery, tt.wantColor)
+			}
+		})
+	}
+}
+
+// Emulator tests run against the Firestore emulator like the pixel
+// worker's: `docker compose up firestore`, then go test with
+// FIRESTORE_EMULATOR_HOST=localhost:8080. They are skipped without it and
+// with -short.
+
+// requireEmulator skips the test unless the emulator is configured, and
+// otherwise empties it.
+func requireEmulator(t *testing.T) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("emulator test skipped with -short")
+	}
+	host := os.Getenv("FIRESTORE_EMULATOR_HOST")
+	if host == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	if projectID == "" {
+		projectID = "team11-local"
+	}
+
+	url := fmt.Sprintf("http://%s/emulator/v1/projects/%s/databases/team11-database/documents", host, projectID)
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("clear emulator: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("clear emulator: %s", resp.Status)
+	}
+}
+
+// seedDoc writes a document at path, e.g. "pixels/4_4"
+func seedDoc(t *testing.T, path string, data map[string]interface{}) {
+	t.Helper()
+	if _, err := getFirestore().Doc(path).Set(context.Background(), data); err != nil {
+		t.Fatalf("seed %s: %v", path, err)
+	}
+}
+
+// readDoc returns the document at path, or nil when it does not exist
+func readDoc(t *testing.T, path string) map[string]interface{} {
+	t.Helper()
+	doc, err := getFirestore().Doc(path).Get(context.Background())
+	if err != nil {
+		if !doc.Exists() {
+			return nil
+		}
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return doc.Data()
+}
+
+func TestRecoverEntryReplays(t *testing.T) {
+	requireEmulator(t)
+	created := time.Now().UTC().Add(-5 * time.Minute)
+	seedDoc(t, "sessions/current", map[string]interface{}{"status": "active", "canvasWidth": 10, "canvasHeight": 10})
+	seedDoc(t, "pixels/4_4", map[string]interface{}{"x": 4, "y": 4, "color": "FF0000", "userId": "u1", "updatedAt": created.Add(-time.Minute)})
+	seedDoc(t, "wal_entries/w1", map[string]interface{}{
+		"x": 4, "y": 4, "color": "0000FF", "blendMode": pixelrules.BlendReplace, "userId": "u2", "username": "two",
+		"source": "web", "adminPlaced": false, "status": walPending, "createdAt": created,
+	})
+
+	recovery, err := recoverEntry(t.Context(), getFirestore().Doc("wal_entries/w1"))
+	if err != nil {
+		t.Fatalf("recoverEntry: %v", err)
+	}
+	if recovery != recoveryReplayed {
+		t.Errorf("recovery = %q, want %q", recovery, recoveryReplayed)
+	}
+	if got := readDoc(t, "pixels/4_4"); got["color"] != "0000FF" || got["userId"] != "u2" || got["username"] != "two" {
+		t.Errorf("pixel after replay = %v, want u2's 0000FF", got)
+	}
+	if got := readDoc(t, "wal_entries/w1"); got["status"] != walCommitted || got["recovery"] != recoveryReplayed {
+		t.Errorf("entry after replay = %v, want committed and replayed", got)
+	}
+
+	// A second run finds the entry settled and leaves it alone
+	recovery, err = recoverEntry(t.Context(), getFirestore().Doc("wal_entries/w1"))
+	if err != nil || recovery != "" {
+		t.Errorf("second recoverEntry = %q, %v, want nothing done", recovery, err)
+	}
+}
+
+func TestRecoverEntryLanded(t *testing.T) {
+	requireEmulator(t)
+	created := time.Now().UTC().Add(-5 * time.Minute)
+	seedDoc(t, "sessions/current", map[string]interface{}{"status": "active"})
+	seedDoc(t, "pixels/4_4", map[string]interface{}{"x": 4, "y": 4, "color": "00FF00", "userId": "u3", "updatedAt": created.Add(time.Second)})
+	seedDoc(t, "wal_entries/w1", map[string]interface{}{
+		"x": 4, "y": 4, "color": "0000FF", "blendMode": pixelrules.BlendReplace, "userId": "u2",
+		"status": walPending, "createdAt": created,
+	})
+
+	if recovery, err := recoverEntry(t.Context(), getFirestore().Doc("wal_entries/w1")); err != nil || recovery != recoveryLanded {
+		t.Fatalf("recoverEntry = %q, %v, want %q", recovery, err, recoveryLanded)
+	}
+	if got := readDoc(t, "pixels/4_4")["color"]; got != "00FF00" {
+		t.Errorf("color = %v, want the later write's 00FF00 untouched", got)
+	}
+	if got := readDoc(t, "wal_entries/w1")["status"]; got != walCommitted {
+		t.Errorf("status = %v, want %s", got, walCommitted)
+	}
+}
+
+func TestRecoverEntryPendingStop(t *testing.T) {
+	requireEmulator(t)
+	created := time.Now().UTC().Add(-5 * time.Minute)
+	seedDoc(t, "sessions/current", map[string]interface{}{"status": "active", "pendingStop": true})
+	seedDoc(t, "wal_entries/w1", map[string]interface{}{
+		"x": 4, "y": 4, "color": "0000FF", "blendMode": pixelrules.BlendReplace, "userId": "u2",
+		"status": walPending, "createdAt": created,
+	})
+
+	if recovery, err := recoverEntry(t.Context(), getFirestore().Doc("wal_entries/w1")); err != nil || recovery != recoveryClosed {
+		t.Fatalf("recoverEntry = %q, %v, want %q", recovery, err, recoveryClosed)
+	}
+	if got := readDoc(t, "pixels/4_4"); got != nil {
+		t.Errorf("pixel replayed onto a stopping session: %v", got)
+	}
+	if got := readDoc(t, "wal_entries/w1")["status"]; got != walAborted {
+		t.Errorf("status = %v, want %s", got, walAborted)
+	}
+}
+
+func TestRecoverEntryColorCooldown(t *testing.T) {
+	requireEmulator(t)
+	defer func(d time.Duration) { sameColorCooldown = d }(sameColorCooldown)
+	sameColorCooldown = time.Minute
+	// Firestore keeps microseconds
+	created := time.Now().UTC().Add(-5 * time.Minute).Truncate(time.Microsecond)
+	seedDoc(t, "sessions/current", map[string]interface{}{"status": "active"})
+	entry := func(x int) map[string]interface{} {
+		return map[string]interface{}{
+			"x": x, "y": 4, "color": "0000ff", "blendMode": pixelrules.BlendReplace, "userId": "u2",
+			"status": walPending, "createdAt": created,
+		}
+	}
+
+	// Placed again since, when this placement's claim never committed
+	seedDoc(t, "color_cooldowns/u2_0000FF", map[string]interface{}{"userId": "u2", "color": "0000FF", "lastPlacedAt": created.Add(20 * time.Second)})
+	seedDoc(t, "wal_entries/w1", entry(4))
+	if recovery, err := recoverEntry(t.Context(), getFirestore().Doc("wal_entries/w1")); err != nil || recovery != recoveryCooldown {
+		t.Fatalf("recoverEntry = %q, %v, want %q", recovery, err, recoveryCooldown)
+	}
+	if got := readDoc(t, "pixels/4_4"); got != nil {
+		t.Errorf("pixel replayed within the cooldown: %v", got)
+	}
+
+	// Replayed, it starts its own cooldown
+	seedDoc(t, "color_cooldowns/u2_0000FF", map[string]interface{}{"userId": "u2", "color": "0000FF", "lastPlacedAt": created.Add(-time.Hour)})
+	seedDoc(t, "wal_entries/w2", entry(5))
+	if recovery, err := recoverEntry(t.Context(), getFirestore().Doc("wal_entries/w2")); err != nil || recovery != recoveryReplayed {
+		t.Fatalf("recoverEntry = %q, %v, want %q", recovery, err, recoveryReplayed)
+	}
+	if got, _ := readDoc(t, "color_cooldowns/u2_0000FF")["lastPlacedAt"].(time.Time); !got.Equal(created) {
+		t.Errorf("cooldown lastPlacedAt = %v, want the replayed placement's %v", got, created)
+	}
+}
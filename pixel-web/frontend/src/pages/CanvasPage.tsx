@@ -1,5 +1,5 @@
 import { useEffect, useState, useRef, useCallback, useLayoutEffect } from "react";
-import { collection, onSnapshot, doc, getDoc } from "firebase/firestore";
+import { collection, onSnapshot, doc, getDoc, Timestamp } from "firebase/firestore";
 import { db } from "../firebase";
 import { apiFetch } from "../api/api";
 import { useAuth } from "../auth/AuthContext";
@@ -43,6 +43,9 @@ export default function CanvasPage() {
   const [canvasWidth, setCanvasWidth] = useState(100);
   const [canvasHeight, setCanvasHeight] = useState(100);
 
+  //Here we store where (0, 0) is for users; pixels stay top-left.
+  const [origin, setOrigin] = useState("top-left");
+
   //Here we store tooltip.
   const [tooltip, setTooltip] = useState<TooltipData | null>(null);
   const [mousePos, setMousePos] = useState<{ x: number; y: number } | null>(null);
@@ -106,11 +109,17 @@ export default function CanvasPage() {
         const s = sessionDoc.data();
         setCanvasWidth(s.canvasWidth || 100);
         setCanvasHeight(s.canvasHeight || 100);
+        setOrigin(s.origin || "top-left");
       }
     };
     loadSession();
   }, []);
 
+  //Here we convert canvas coordinates to the session's origin for display,
+  //like the Discord replies (internal/coords in the Go functions).
+  const toUserCoords = (x: number, y: number) =>
+    origin === "bottom-left" ? { x, y: canvasHeight - 1 - y } : { x, y };
+
   //Here we stream pixels.
   useEffect(() => {
     const unsub = onSnapshot(collection(db, "pixels"), (snapshot) => {
@@ -125,7 +134,11 @@ export default function CanvasPage() {
             updated[id] = {
               color: data.color,
               username: data.username || "Unknown",
-              updatedAt: data.updatedAt || new Date().toISOString(),
+              // Timestamp since the worker migration; older pixels hold strings
+              updatedAt:
+                data.updatedAt instanceof Timestamp
+                  ? data.updatedAt.toDate().toISOString()
+                  : data.updatedAt || new Date().toISOString(),
               userId: data.userId || "",
             };
           }
@@ -428,7 +441,7 @@ export default function CanvasPage() {
             fontSize: 14,
           }}
         >
-          Cursor: ({cursorCoords.x}, {cursorCoords.y})
+          Cursor: ({toUserCoords(cursorCoords.x, cursorCoords.y).x}, {toUserCoords(cursorCoords.x, cursorCoords.y).y})
         </div>
       )}
 
@@ -480,7 +493,7 @@ export default function CanvasPage() {
             pointerEvents: "none",
           }}
         >
-          <div><strong>({tooltip.x}, {tooltip.y})</strong></div>
+          <div><strong>({toUserCoords(tooltip.x, tooltip.y).x}, {toUserCoords(tooltip.x, tooltip.y).y})</strong></div>
           <div>#{tooltip.pixel.color}</div>
           <div>{tooltip.pixel.username}</div>
         </div>
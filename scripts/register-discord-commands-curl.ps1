@@ -36,16 +36,46 @@ if (!(Test-Path $tempDir)) {
 # Write JSON files without BOM using .NET
 $utf8NoBom = New-Object System.Text.UTF8Encoding $false
 
-$drawJson = '{"name":"draw","description":"Draw a pixel on the canvas","options":[{"name":"x","description":"X coordinate","type":4,"required":true},{"name":"y","description":"Y coordinate","type":4,"required":true},{"name":"color","description":"Hex color e.g. FF0000","type":3,"required":true}]}'
-$canvasJson = '{"name":"canvas","description":"Get current canvas state and info"}'
-$sessionJson = '{"name":"session","description":"Manage canvas session (Admin only)","options":[{"name":"action","description":"Session action","type":3,"required":true,"choices":[{"name":"start","value":"start"},{"name":"pause","value":"pause"},{"name":"reset","value":"reset"}]},{"name":"width","description":"Canvas width in pixels (default: 100)","type":4,"required":false,"min_value":10,"max_value":100000},{"name":"height","description":"Canvas height in pixels (default: 100)","type":4,"required":false,"min_value":10,"max_value":100000}]}'
-$snapshotJson = '{"name":"snapshot","description":"Generate canvas snapshot image (Admin only)"}'
+$drawJson = '{"name":"draw","dm_permission":false,"description":"Draw a pixel on the canvas","options":[{"name":"x","description":"X coordinate","type":4,"required":true},{"name":"y","description":"Y coordinate","type":4,"required":true},{"name":"color","description":"Hex color e.g. FF0000","type":3,"required":true},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$canvasJson = '{"name":"canvas","dm_permission":false,"description":"Get current canvas state and info","options":[{"name":"view","description":"What to show (default: status); region needs x1 y1 x2 y2; clear is Admin only","type":3,"required":false,"choices":[{"name":"status","value":"status"},{"name":"colors","value":"colors"},{"name":"owners","value":"owners"},{"name":"region","value":"region"},{"name":"grid","value":"grid"},{"name":"clear","value":"clear"}]},{"name":"x1","description":"Region: X of one corner","type":4,"required":false,"min_value":0},{"name":"y1","description":"Region: Y of one corner","type":4,"required":false,"min_value":0},{"name":"x2","description":"Region: X of the opposite corner","type":4,"required":false,"min_value":0},{"name":"y2","description":"Region: Y of the opposite corner","type":4,"required":false,"min_value":0},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$sessionJson = '{"name":"session","dm_permission":false,"description":"Manage canvas session (Admin only)","options":[{"name":"action","description":"Session action","type":3,"required":true,"choices":[{"name":"start","value":"start"},{"name":"pause","value":"pause"},{"name":"resume","value":"resume"},{"name":"reset","value":"reset"},{"name":"stop","value":"stop"},{"name":"end","value":"end"},{"name":"backfill","value":"backfill"},{"name":"schedule","value":"schedule"},{"name":"export","value":"export"},{"name":"import","value":"import"}]},{"name":"width","description":"Canvas width in pixels (default: 100)","type":4,"required":false,"min_value":10,"max_value":100000},{"name":"height","description":"Canvas height in pixels (default: 100)","type":4,"required":false,"min_value":10,"max_value":100000},{"name":"snapshots_bucket","description":"Start: store this session's snapshots in another allowlisted bucket","type":3,"required":false},{"name":"grid_snap","description":"Start: snap pixels to the corner of square cells this wide (default: 1, no grid)","type":4,"required":false,"min_value":1,"max_value":100},{"name":"origin","description":"Start: where (0, 0) is (default: top-left)","type":3,"required":false,"choices":[{"name":"top-left","value":"top-left"},{"name":"bottom-left","value":"bottom-left"}]},{"name":"opens_at","description":"Schedule: opening time, RFC 3339 (e.g. 2026-06-01T18:00:00Z) or clear","type":3,"required":false},{"name":"closes_at","description":"Schedule: closing time, RFC 3339 or clear","type":3,"required":false},{"name":"closed_message","description":"Schedule: message shown after closing","type":3,"required":false,"max_length":200},{"name":"file","description":"Import: JSON file of a /session export","type":11,"required":false},{"name":"purge","description":"Stop: delete the live pixels once they are archived","type":5,"required":false},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$snapshotJson = '{"name":"snapshot","dm_permission":false,"description":"Generate canvas snapshot image (Admin only)","options":[{"name":"zones","description":"Also render the protected zones","type":5,"required":false},{"name":"layered","description":"Draw the thumbnail over a faded copy of the previous one","type":5,"required":false},{"name":"thumbnail_size","description":"Longest side of the thumbnail in pixels","type":4,"required":false,"min_value":100,"max_value":4096},{"name":"verify","description":"Check the tiles of a snapshot exist instead of taking one","type":5,"required":false},{"name":"snapshot","description":"Verify: snapshot timestamp (default: latest)","type":4,"required":false,"min_value":1},{"name":"repair","description":"Verify: re-render missing tiles","type":5,"required":false},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$tileJson = '{"name":"tile","dm_permission":false,"description":"Render one 2048x2048 canvas tile at full resolution","options":[{"name":"tile_x","description":"Tile column","type":4,"required":false,"min_value":0},{"name":"tile_y","description":"Tile row","type":4,"required":false,"min_value":0},{"name":"x","description":"X of a pixel inside the tile (instead of tile_x)","type":4,"required":false,"min_value":0},{"name":"y","description":"Y of a pixel inside the tile (instead of tile_y)","type":4,"required":false,"min_value":0},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$snapshotRegionJson = '{"name":"snapshot-region","dm_permission":false,"description":"Snapshot part of the canvas (Admin only)","options":[{"name":"x1","description":"X of one corner","type":4,"required":true,"min_value":0},{"name":"y1","description":"Y of one corner","type":4,"required":true,"min_value":0},{"name":"x2","description":"X of the opposite corner","type":4,"required":true,"min_value":0},{"name":"y2","description":"Y of the opposite corner","type":4,"required":true,"min_value":0},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$protectedJson = '{"name":"protected","dm_permission":false,"description":"List the protected areas where you cannot draw","options":[{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$colorJson = '{"name":"color","dm_permission":false,"description":"Show the color of a pixel","options":[{"name":"x","description":"X coordinate","type":4,"required":true,"min_value":0},{"name":"y","description":"Y coordinate","type":4,"required":true,"min_value":0},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$historyJson = '{"name":"history","dm_permission":false,"description":"Show the latest placements at a pixel","options":[{"name":"x","description":"X coordinate","type":4,"required":true,"min_value":0},{"name":"y","description":"Y coordinate","type":4,"required":true,"min_value":0},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$mydataJson = '{"name":"mydata","dm_permission":false,"description":"Manage your personal data","options":[{"name":"export","description":"Export all data stored about you","type":1},{"name":"delete","description":"Delete your data and anonymize your pixels","type":1,"options":[{"name":"user","description":"User whose data to delete (Admin only)","type":6,"required":false}]}]}'
+$leaderboardJson = '{"name":"leaderboard","dm_permission":false,"description":"Show the top pixel placers","options":[{"name":"window","description":"Time window (default: all time)","type":3,"required":false,"choices":[{"name":"all time","value":"all"},{"name":"last 24 hours","value":"24h"}]},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$userstatsJson = '{"name":"userstats","dm_permission":false,"description":"Show pixel stats for a user","options":[{"name":"user","description":"User to show (default: you)","type":6,"required":false},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$streakJson = '{"name":"streak","dm_permission":false,"description":"Show how many days in a row a user has drawn","options":[{"name":"user","description":"User to show (default: you)","type":6,"required":false},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$notifyJson = '{"name":"notify","dm_permission":false,"description":"DM me when others paint over my pixels","options":[{"name":"setting","description":"Turn notifications on or off","type":3,"required":true,"choices":[{"name":"on","value":"on"},{"name":"off","value":"off"}]},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$nicknameJson = '{"name":"nickname","dm_permission":false,"description":"Set the name your pixels are credited to","options":[{"name":"name","description":"Display name, 2 to 32 characters; leave out to use your Discord username","type":3,"required":false,"min_length":2,"max_length":32},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$verifyJson = '{"name":"verify","dm_permission":false,"description":"Check the canvas against pixel history (Admin only)","options":[{"name":"repair","description":"Rewrite mismatched pixels from history","type":5,"required":false},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
+$zoneJson = '{"name":"zone","dm_permission":false,"description":"Manage protected canvas zones (Admin only)","options":[{"name":"lock","description":"Lock a zone so only allowed users can draw in it","type":1,"options":[{"name":"label","description":"Zone name","type":3,"required":true},{"name":"x1","description":"First corner X (required for a new zone)","type":4,"required":false,"min_value":0},{"name":"y1","description":"First corner Y","type":4,"required":false,"min_value":0},{"name":"x2","description":"Opposite corner X","type":4,"required":false,"min_value":0},{"name":"y2","description":"Opposite corner Y","type":4,"required":false,"min_value":0},{"name":"allow","description":"Users who may still draw here (mentions)","type":3,"required":false}]},{"name":"unlock","description":"Unlock a zone","type":1,"options":[{"name":"label","description":"Zone name","type":3,"required":true}]},{"name":"list","description":"List zones","type":1}]}'
+$auditJson = '{"name":"audit","dm_permission":false,"description":"View the admin audit log (Admin only)","options":[{"name":"recent","description":"Show the last 10 audit entries","type":1}]}'
+$importPixelsJson = '{"name":"import-pixels","dm_permission":false,"description":"Place the pixels of a JSON file (Admin only)","options":[{"name":"file","description":"JSON file with a pixels list of x, y and color, 500 at most","type":11,"required":true},{"name":"debug","description":"Admin only: trace this command in full","type":5,"required":false}]}'
 
 $commands = @(
     @{ name = "draw"; json = $drawJson },
     @{ name = "canvas"; json = $canvasJson },
     @{ name = "session"; json = $sessionJson },
-    @{ name = "snapshot"; json = $snapshotJson }
+    @{ name = "snapshot"; json = $snapshotJson },
+    @{ name = "snapshot-region"; json = $snapshotRegionJson },
+    @{ name = "verify"; json = $verifyJson },
+    @{ name = "zone"; json = $zoneJson },
+    @{ name = "tile"; json = $tileJson },
+    @{ name = "history"; json = $historyJson },
+    @{ name = "color"; json = $colorJson },
+    @{ name = "protected"; json = $protectedJson },
+    @{ name = "mydata"; json = $mydataJson },
+    @{ name = "leaderboard"; json = $leaderboardJson },
+    @{ name = "userstats"; json = $userstatsJson },
+    @{ name = "streak"; json = $streakJson },
+    @{ name = "notify"; json = $notifyJson },
+    @{ name = "nickname"; json = $nicknameJson },
+    @{ name = "audit"; json = $auditJson },
+    @{ name = "import-pixels"; json = $importPixelsJson }
 )
 
 foreach ($cmd in $commands) {
@@ -43,6 +43,7 @@ resource "google_project_service" "required_apis" {
     "monitoring.googleapis.com",
     "cloudtrace.googleapis.com",
     "telemetry.googleapis.com",
+    "cloudscheduler.googleapis.com",
   ])
 
   service            = each.value
@@ -100,6 +101,7 @@ locals {
     "pixel-worker"    = "../../../functions/worker/pixel-worker-go"
     "snapshot-worker" = "../../../functions/worker/snapshot-worker-go"
     "session-worker"  = "../../../functions/worker/session-worker"
+    "wal-recovery"    = "../../../functions/worker/wal-recovery-go"
   }
 }
 
@@ -140,11 +142,13 @@ module "discord_proxy" {
   timeout                 = 60
 
   environment_variables = {
-    PROJECT_ID            = var.project_id
-    PIXEL_EVENTS_TOPIC    = module.pubsub.pixel_events_topic
-    SNAPSHOT_EVENTS_TOPIC = module.pubsub.snapshot_events_topic
-    SESSION_EVENTS_TOPIC  = module.pubsub.session_events_topic
-    OTEL_SERVICE_NAME     = "discord-proxy"
+    PROJECT_ID             = var.project_id
+    PIXEL_EVENTS_TOPIC     = module.pubsub.pixel_events_topic
+    SNAPSHOT_EVENTS_TOPIC  = module.pubsub.snapshot_events_topic
+    SESSION_EVENTS_TOPIC   = module.pubsub.session_events_topic
+    OTEL_SERVICE_NAME      = "discord-proxy"
+    TRACE_SAMPLE_RATIO     = tostring(var.trace_sample_ratio)
+    DISCORD_APPLICATION_ID = var.discord_application_id
   }
 
   secret_environment_variables = [
@@ -239,10 +243,20 @@ module "pixel_worker" {
   timeout               = 120
 
   environment_variables = {
-    PROJECT_ID         = var.project_id
-    PUBLIC_PIXEL_TOPIC = module.pubsub.public_pixel_topic
-    OTEL_SERVICE_NAME  = "pixel-worker"
-    DISCORD_CHANNEL_ID = "1464188353040617577"
+    PROJECT_ID                  = var.project_id
+    PUBLIC_PIXEL_TOPIC          = module.pubsub.public_pixel_topic
+    PRESENCE_TOPIC              = module.pubsub.presence_topic
+    SNAPSHOT_EVENTS_TOPIC       = module.pubsub.snapshot_events_topic
+    OTEL_SERVICE_NAME           = "pixel-worker"
+    DISCORD_CHANNEL_ID          = "1464188353040617577"
+    PIXEL_HISTORY               = tostring(var.pixel_history_enabled)
+    SAME_COLOR_COOLDOWN         = tostring(var.same_color_cooldown_seconds)
+    RATE_LIMIT_REFUND           = tostring(var.rate_limit_refund_enabled)
+    PROTECT_ADMIN_PIXELS        = tostring(var.protect_admin_pixels)
+    STRICT_ATTRIBUTE_VALIDATION = tostring(var.strict_attribute_validation)
+    DISCORD_APPLICATION_ID      = var.discord_application_id
+    TRACE_SAMPLE_RATIO          = tostring(var.trace_sample_ratio)
+    PIXEL_WAL                   = tostring(var.pixel_wal_enabled)
   }
 
   secret_environment_variables = [
@@ -279,9 +293,17 @@ module "snapshot_worker" {
   timeout               = 300
 
   environment_variables = {
-    PROJECT_ID        = var.project_id
-    SNAPSHOTS_BUCKET  = module.storage.canvas_snapshots_bucket
-    OTEL_SERVICE_NAME = "snapshot-worker"
+    PROJECT_ID                 = var.project_id
+    SNAPSHOTS_BUCKET           = module.storage.canvas_snapshots_bucket
+    SNAPSHOTS_BUCKETS          = join(",", var.snapshot_mirror_buckets)
+    SNAPSHOTS_BUCKET_ALLOWLIST = join(",", var.snapshot_bucket_allowlist)
+    USER_EXPORTS_BUCKET        = module.storage.user_exports_bucket
+    OTEL_SERVICE_NAME          = "snapshot-worker"
+    SNAPSHOT_CORS_ORIGINS      = join(",", var.snapshot_cors_origins)
+    SNAPSHOT_LAYER_OPACITY     = tostring(var.snapshot_layer_opacity)
+    THUMBNAIL_MAX_SIZE         = tostring(var.thumbnail_max_size)
+    SNAPSHOT_MAX_PIXELS        = tostring(var.snapshot_max_pixels)
+    TRACE_SAMPLE_RATIO         = tostring(var.trace_sample_ratio)
   }
 
   secret_environment_variables = [
@@ -300,6 +322,132 @@ module "snapshot_worker" {
   depends_on = [module.iam, module.storage, module.pubsub]
 }
 
+# Let the snapshot worker apply the CORS policy for SNAPSHOT_CORS_ORIGINS
+# (storage.buckets.update is not part of objectAdmin). A custom role, since
+# the predefined ones that carry it also grant bucket IAM and ACL changes.
+resource "google_project_iam_custom_role" "snapshot_bucket_cors" {
+  count = length(var.snapshot_cors_origins) > 0 ? 1 : 0
+
+  project     = var.project_id
+  role_id     = "snapshotBucketCors"
+  title       = "Snapshot bucket CORS"
+  description = "Read and update bucket metadata, for the snapshot worker's CORS policy"
+  permissions = ["storage.buckets.get", "storage.buckets.update"]
+}
+
+resource "google_storage_bucket_iam_member" "snapshot_worker_bucket_cors" {
+  count = length(var.snapshot_cors_origins) > 0 ? 1 : 0
+
+  bucket = module.storage.canvas_snapshots_bucket
+  role   = google_project_iam_custom_role.snapshot_bucket_cors[0].id
+  member = "serviceAccount:${module.iam.worker_functions_sa_email}"
+}
+
+# Same for the buckets sessions may choose instead
+resource "google_storage_bucket_iam_member" "snapshot_worker_allowed_bucket_cors" {
+  for_each = length(var.snapshot_cors_origins) > 0 ? toset(var.snapshot_bucket_allowlist) : toset([])
+
+  bucket = each.value
+  role   = google_project_iam_custom_role.snapshot_bucket_cors[0].id
+  member = "serviceAccount:${module.iam.worker_functions_sa_email}"
+}
+
+# Scheduled canvas summary, posted by the snapshot worker
+resource "google_cloud_scheduler_job" "canvas_summary" {
+  count = var.canvas_summary_schedule == "" ? 0 : 1
+
+  project     = var.project_id
+  region      = var.region
+  name        = "canvas-summary"
+  description = "Posts pixels painted, top contributors and fill to Discord"
+  schedule    = var.canvas_summary_schedule
+  time_zone   = "Etc/UTC"
+
+  pubsub_target {
+    topic_name = "projects/${var.project_id}/topics/${module.pubsub.snapshot_events_topic}"
+    data       = base64encode(jsonencode({ channelId = var.canvas_summary_channel_id }))
+    attributes = {
+      type = "canvas_summary"
+    }
+  }
+
+  depends_on = [google_project_service.required_apis]
+}
+
+# Corrects the pixel count in stats/overview from an aggregation count,
+# in the snapshot worker
+resource "google_cloud_scheduler_job" "pixel_count_reconcile" {
+  count = var.pixel_count_reconcile_schedule == "" ? 0 : 1
+
+  project     = var.project_id
+  region      = var.region
+  name        = "pixel-count-reconcile"
+  description = "Recounts the canvas and logs how far stats/overview had drifted"
+  schedule    = var.pixel_count_reconcile_schedule
+  time_zone   = "Etc/UTC"
+
+  pubsub_target {
+    topic_name = "projects/${var.project_id}/topics/${module.pubsub.snapshot_events_topic}"
+    data       = base64encode("{}")
+    attributes = {
+      type = "pixel_count_reconcile"
+    }
+  }
+
+  depends_on = [google_project_service.required_apis]
+}
+
+# Settles pixel writes the pixel worker announced in wal_entries but did not
+# finish, every 5 minutes
+module "wal_recovery" {
+  source = "../../modules/cloud-function"
+  count  = var.pixel_wal_enabled ? 1 : 0
+
+  project_id            = var.project_id
+  region                = var.region
+  function_name         = "wal-recovery"
+  runtime               = "go122"
+  entry_point           = "handler"
+  source_bucket         = module.storage.functions_source_bucket
+  source_object         = google_storage_bucket_object.function_source_placeholder["wal-recovery"].name
+  service_account_email = module.iam.worker_functions_sa_email
+  trigger_topic         = "projects/${var.project_id}/topics/${module.pubsub.wal_recovery_topic}"
+  retry_on_failure      = false
+  memory                = "256M"
+  timeout               = 120
+
+  environment_variables = {
+    PROJECT_ID           = var.project_id
+    PROTECT_ADMIN_PIXELS = tostring(var.protect_admin_pixels)
+    SAME_COLOR_COOLDOWN  = tostring(var.same_color_cooldown_seconds)
+  }
+
+  labels = {
+    function_type = "worker"
+    service       = "wal-recovery"
+  }
+
+  depends_on = [module.iam, module.storage, module.pubsub]
+}
+
+resource "google_cloud_scheduler_job" "wal_recovery" {
+  count = var.pixel_wal_enabled ? 1 : 0
+
+  project     = var.project_id
+  region      = var.region
+  name        = "wal-recovery"
+  description = "Replays pixel writes left pending in wal_entries"
+  schedule    = "*/5 * * * *"
+  time_zone   = "Etc/UTC"
+
+  pubsub_target {
+    topic_name = "projects/${var.project_id}/topics/${module.pubsub.wal_recovery_topic}"
+    data       = base64encode("{}")
+  }
+
+  depends_on = [google_project_service.required_apis]
+}
+
 # Session worker function
 module "session_worker" {
   source = "../../modules/cloud-function"
@@ -317,8 +465,10 @@ module "session_worker" {
   timeout               = 120
 
   environment_variables = {
-    PROJECT_ID        = var.project_id
-    OTEL_SERVICE_NAME = "session-worker"
+    PROJECT_ID            = var.project_id
+    SNAPSHOT_EVENTS_TOPIC = module.pubsub.snapshot_events_topic
+    SESSION_EVENTS_TOPIC  = module.pubsub.session_events_topic
+    OTEL_SERVICE_NAME     = "session-worker"
   }
 
   secret_environment_variables = [
@@ -373,7 +523,9 @@ module "api_gateway" {
 module "monitoring" {
   source = "../../modules/monitoring"
 
-  project_id = var.project_id
+  project_id                  = var.project_id
+  pixel_lag_alert_seconds     = var.pixel_lag_alert_seconds
+  alert_notification_channels = var.alert_notification_channels
 
   depends_on = [google_project_service.required_apis]
 }
@@ -22,4 +22,116 @@ variable "discord_client_id" {
   default     = "1464237067012931665"
 }
 
+variable "snapshot_mirror_buckets" {
+  description = "Extra buckets every snapshot object is copied to; the worker's project-wide objectAdmin role covers buckets in this project"
+  type        = list(string)
+  default     = []
+}
+
+variable "snapshot_bucket_allowlist" {
+  description = "Buckets a session (/session start snapshots_bucket) may store its snapshots in instead of the snapshots bucket, e.g. for an event with its own retention"
+  type        = list(string)
+  default     = []
+}
+
+variable "snapshot_layer_opacity" {
+  description = "Opacity (0 to 1) of the previous thumbnail under a /snapshot layered:true thumbnail"
+  type        = number
+  default     = 0.5
+}
+
+variable "thumbnail_max_size" {
+  description = "Longest side of snapshot thumbnails in pixels (100-4096), unless /snapshot thumbnail_size asks for another"
+  type        = number
+  default     = 800
+}
+
+variable "snapshot_cors_origins" {
+  description = "Origins allowed to fetch snapshot manifests and tiles from a browser; empty leaves the bucket's CORS policy untouched"
+  type        = list(string)
+  default     = []
+}
+
+variable "pixel_history_enabled" {
+  description = "Record every placement in pixel_history (needed by /verify); adds one write per pixel"
+  type        = bool
+  default     = false
+}
+
+variable "pixel_wal_enabled" {
+  description = "Announce every pixel write in wal_entries and deploy wal-recovery to finish writes a crash interrupted; adds two writes per pixel"
+  type        = bool
+  default     = false
+}
+
+variable "rate_limit_refund_enabled" {
+  description = "Give rate-limit quota back for pixels whose write failed; adds a transaction per failed write"
+  type        = bool
+  default     = false
+}
+
+variable "protect_admin_pixels" {
+  description = "Refuse non-admins any pixel last placed by a Discord admin"
+  type        = bool
+  default     = false
+}
+
+variable "discord_application_id" {
+  description = "Discord application ID; the pixel worker only trusts Discord placements that name it, and the discord-proxy checks the bot token belongs to it. Empty accepts any"
+  type        = string
+  default     = ""
+}
 
+variable "trace_sample_ratio" {
+  description = "Fraction of new traces the Go functions export to Cloud Trace; an admin's debug command option always traces"
+  type        = number
+  default     = 1
+}
+
+variable "strict_attribute_validation" {
+  description = "Drop pixel-events messages with Pub/Sub attributes the pixel worker does not know, instead of only logging them"
+  type        = bool
+  default     = false
+}
+
+variable "same_color_cooldown_seconds" {
+  description = "Seconds a user must wait before placing the same color again; 0 disables the cooldown"
+  type        = number
+  default     = 0
+}
+
+variable "pixel_count_reconcile_schedule" {
+  description = "Cron schedule (UTC) of the pixel count reconciliation, e.g. \"0 * * * *\"; empty disables it"
+  type        = string
+  default     = "0 * * * *"
+}
+
+variable "snapshot_max_pixels" {
+  description = "Refuse /snapshot of a canvas with more pixels than this, before reading them; 0 is no limit"
+  type        = number
+  default     = 0
+}
+
+variable "pixel_lag_alert_seconds" {
+  description = "Alert when pixel messages wait this long before the pixel worker handles them"
+  type        = number
+  default     = 10
+}
+
+variable "alert_notification_channels" {
+  description = "Notification channel IDs (projects/<id>/notificationChannels/<n>) for alerting policies"
+  type        = list(string)
+  default     = []
+}
+
+variable "canvas_summary_schedule" {
+  description = "Cron schedule (UTC) of the canvas summary post, e.g. \"0 */6 * * *\"; empty disables it"
+  type        = string
+  default     = ""
+}
+
+variable "canvas_summary_channel_id" {
+  description = "Discord channel the canvas summary is posted to"
+  type        = string
+  default     = "1464188353040617577"
+}
@@ -19,9 +19,11 @@ resource "google_cloudfunctions2_function" "function" {
   }
 
   service_config {
-    max_instance_count    = var.max_instances
-    min_instance_count    = var.min_instances
-    available_memory      = var.memory
+    max_instance_count               = var.max_instances
+    min_instance_count               = var.min_instances
+    max_instance_request_concurrency = var.max_instance_request_concurrency
+    available_memory                 = var.memory
+    available_cpu                    = var.available_cpu
     timeout_seconds       = var.timeout
     service_account_email = var.service_account_email
 
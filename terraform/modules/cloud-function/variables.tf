@@ -51,6 +51,18 @@ variable "min_instances" {
   default     = 0
 }
 
+variable "max_instance_request_concurrency" {
+  description = "Requests (Pub/Sub deliveries) one instance handles at once; values above 1 need available_cpu >= 1"
+  type        = number
+  default     = 1
+}
+
+variable "available_cpu" {
+  description = "vCPUs per instance (e.g., \"1\"); null keeps the default derived from memory"
+  type        = string
+  default     = null
+}
+
 variable "memory" {
   description = "Memory allocation (e.g., 256M, 512M, 1Gi)"
   type        = string
@@ -31,3 +31,59 @@ resource "google_firestore_index" "pixels_by_user" {
     order      = "DESCENDING"
   }
 }
+
+# Latest history entries of one coordinate (/verify, /history)
+resource "google_firestore_index" "pixel_history_by_coordinate" {
+  project    = var.project_id
+  database   = google_firestore_database.database.name
+  collection = "pixel_history"
+
+  fields {
+    field_path = "x"
+    order      = "ASCENDING"
+  }
+
+  fields {
+    field_path = "y"
+    order      = "ASCENDING"
+  }
+
+  fields {
+    field_path = "timestamp"
+    order      = "DESCENDING"
+  }
+}
+
+# Pixels inside a rectangle (/canvas view:region)
+resource "google_firestore_index" "pixels_by_coordinate" {
+  project    = var.project_id
+  database   = google_firestore_database.database.name
+  collection = "pixels"
+
+  fields {
+    field_path = "x"
+    order      = "ASCENDING"
+  }
+
+  fields {
+    field_path = "y"
+    order      = "ASCENDING"
+  }
+}
+
+# Pending pixel writes older than the cutoff (wal-recovery)
+resource "google_firestore_index" "wal_entries_pending" {
+  project    = var.project_id
+  database   = google_firestore_database.database.name
+  collection = "wal_entries"
+
+  fields {
+    field_path = "status"
+    order      = "ASCENDING"
+  }
+
+  fields {
+    field_path = "createdAt"
+    order      = "ASCENDING"
+  }
+}
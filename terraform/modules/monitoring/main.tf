@@ -28,6 +28,146 @@ resource "google_logging_metric" "discord_commands" {
   }
 }
 
+resource "google_logging_metric" "pixel_rejections" {
+  project = var.project_id
+  name    = "pixel_rejections"
+  filter  = "resource.type=\"cloud_run_revision\" AND jsonPayload.message=\"pixel_rejected\""
+
+  metric_descriptor {
+    metric_kind = "DELTA"
+    value_type  = "INT64"
+    unit        = "1"
+
+    labels {
+      key         = "reason"
+      value_type  = "STRING"
+      description = "Rejection reason, as in pixel_rejected events"
+    }
+  }
+
+  label_extractors = {
+    "reason" = "EXTRACT(jsonPayload.reason)"
+  }
+}
+
+# Discord placements without a valid interaction, handled as "unverified"
+resource "google_logging_metric" "pixel_source_reclassifications" {
+  project = var.project_id
+  name    = "pixel_source_reclassifications"
+  filter  = "resource.type=\"cloud_run_revision\" AND jsonPayload.message=\"pixel_source_reclassified\""
+
+  metric_descriptor {
+    metric_kind = "DELTA"
+    value_type  = "INT64"
+    unit        = "1"
+
+    labels {
+      key         = "reason"
+      value_type  = "STRING"
+      description = "Why the discord source was not trusted"
+    }
+  }
+
+  label_extractors = {
+    "reason" = "EXTRACT(jsonPayload.reason)"
+  }
+}
+
+resource "google_logging_metric" "unknown_message_types" {
+  project = var.project_id
+  name    = "unknown_message_types"
+  filter  = "resource.type=\"cloud_run_revision\" AND jsonPayload.message=\"unknown_message_type\""
+
+  metric_descriptor {
+    metric_kind = "DELTA"
+    value_type  = "INT64"
+    unit        = "1"
+
+    labels {
+      key         = "type"
+      value_type  = "STRING"
+      description = "Pub/Sub type attribute of the acked message"
+    }
+  }
+
+  label_extractors = {
+    "type" = "EXTRACT(jsonPayload.type)"
+  }
+}
+
+# Publish-to-processing delay of pixel messages, from pixel_message_age logs
+resource "google_logging_metric" "pixel_message_age" {
+  project         = var.project_id
+  name            = "pixel_message_age"
+  filter          = "resource.type=\"cloud_run_revision\" AND jsonPayload.message=\"pixel_message_age\""
+  value_extractor = "EXTRACT(jsonPayload.age_ms)"
+
+  metric_descriptor {
+    metric_kind = "DELTA"
+    value_type  = "DISTRIBUTION"
+    unit        = "ms"
+  }
+
+  # 10 ms to about 3 hours
+  bucket_options {
+    exponential_buckets {
+      num_finite_buckets = 20
+      growth_factor      = 2
+      scale              = 10
+    }
+  }
+}
+
+# ------------------------------------------------------------------
+# Alerting
+# ------------------------------------------------------------------
+resource "google_monitoring_alert_policy" "pixel_processing_lag" {
+  project               = var.project_id
+  display_name          = "Pixel worker falling behind"
+  combiner              = "OR"
+  notification_channels = var.alert_notification_channels
+
+  documentation {
+    content   = "Pixel placements wait more than ${var.pixel_lag_alert_seconds}s before the pixel worker handles them. Check the worker's instance count, flow_control_rejected logs and Firestore latency."
+    mime_type = "text/markdown"
+  }
+
+  conditions {
+    display_name = "p95 message age above ${var.pixel_lag_alert_seconds}s"
+    condition_threshold {
+      filter          = "resource.type = \"cloud_run_revision\" AND metric.type = \"logging.googleapis.com/user/pixel_message_age\""
+      comparison      = "COMPARISON_GT"
+      threshold_value = var.pixel_lag_alert_seconds * 1000
+      duration        = "300s"
+
+      aggregations {
+        alignment_period     = "60s"
+        per_series_aligner   = "ALIGN_PERCENTILE_95"
+        cross_series_reducer = "REDUCE_MAX"
+      }
+    }
+  }
+
+  # Catches a worker that stopped processing at all, which logs no ages
+  conditions {
+    display_name = "Oldest unacked pixel message above ${var.pixel_lag_alert_seconds}s"
+    condition_threshold {
+      filter          = "resource.type = \"pubsub_subscription\" AND metric.type = \"pubsub.googleapis.com/subscription/oldest_unacked_message_age\" AND resource.label.subscription_id = monitoring.regex.full_match(\".*pixel-worker.*\")"
+      comparison      = "COMPARISON_GT"
+      threshold_value = var.pixel_lag_alert_seconds
+      duration        = "300s"
+
+      aggregations {
+        alignment_period     = "60s"
+        per_series_aligner   = "ALIGN_MAX"
+        cross_series_reducer = "REDUCE_MAX"
+      }
+    }
+  }
+
+  depends_on = [google_logging_metric.pixel_message_age]
+}
+
 # ------------------------------------------------------------------
 # Dashboard
 # ------------------------------------------------------------------
@@ -3,4 +3,16 @@ variable "project_id" {
   type        = string
 }
 
+variable "pixel_lag_alert_seconds" {
+  description = "Alert when pixel messages wait this long before processing (p95) or in the subscription backlog"
+  type        = number
+  default     = 10
+}
+
+variable "alert_notification_channels" {
+  description = "Notification channel IDs for alerting policies; empty only opens incidents in the console"
+  type        = list(string)
+  default     = []
+}
+
 
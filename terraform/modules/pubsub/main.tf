@@ -56,6 +56,21 @@ resource "google_pubsub_topic" "public_pixel_dead_letter" {
   message_retention_duration = "604800s" # 7 days
 }
 
+# Presence topic (transient cursor positions, not persisted)
+resource "google_pubsub_topic" "presence" {
+  name = "presence"
+
+  message_retention_duration = "600s" # minimum allowed; presence expires after seconds
+}
+
+# WAL recovery trigger, published by Cloud Scheduler; a missed tick is
+# covered by the next one
+resource "google_pubsub_topic" "wal_recovery" {
+  name = "wal-recovery"
+
+  message_retention_duration = "600s"
+}
+
 # Pixel worker subscription
 resource "google_pubsub_subscription" "pixel_worker" {
   name  = "pixel-worker-sub"
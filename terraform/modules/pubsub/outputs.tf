@@ -37,3 +37,13 @@ output "public_pixel_topic" {
   description = "Public pixel topic name (for real-time web client updates)"
   value       = google_pubsub_topic.public_pixel.name
 }
+
+output "presence_topic" {
+  description = "Presence topic name (transient cursor positions)"
+  value       = google_pubsub_topic.presence.name
+}
+
+output "wal_recovery_topic" {
+  description = "WAL recovery trigger topic name"
+  value       = google_pubsub_topic.wal_recovery.name
+}
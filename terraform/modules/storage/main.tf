@@ -38,6 +38,42 @@ resource "google_storage_bucket" "canvas_snapshots" {
       days_since_noncurrent_time = 30
     }
   }
+
+  # /canvas view:colors, view:owners and view:region images and /history
+  # charts are only needed for the reply
+  lifecycle_rule {
+    action {
+      type = "Delete"
+    }
+    condition {
+      age            = 1
+      matches_prefix = ["color-charts/", "ownership-maps/", "region-stats/", "history-charts/"]
+    }
+  }
+
+  # CORS is applied by the snapshot worker from SNAPSHOT_CORS_ORIGINS
+  lifecycle {
+    ignore_changes = [cors]
+  }
+}
+
+# Bucket for GDPR user data exports (private, short-lived)
+resource "google_storage_bucket" "user_exports" {
+  name     = "${var.project_id}-user-exports"
+  location = var.region
+
+  uniform_bucket_level_access = true
+  public_access_prevention    = "enforced"
+  force_destroy               = true
+
+  lifecycle_rule {
+    action {
+      type = "Delete"
+    }
+    condition {
+      age = 2
+    }
+  }
 }
 
 # Bucket for web application hosting
@@ -8,6 +8,11 @@ output "canvas_snapshots_bucket" {
   value       = google_storage_bucket.canvas_snapshots.name
 }
 
+output "user_exports_bucket" {
+  description = "User data exports bucket"
+  value       = google_storage_bucket.user_exports.name
+}
+
 output "web_app_bucket" {
   description = "Web application bucket"
   value       = google_storage_bucket.web_app.name
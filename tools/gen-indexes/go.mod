@@ -0,0 +1,3 @@
+module github.com/team11/gen-indexes
+
+go 1.24.0
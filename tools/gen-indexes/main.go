@@ -0,0 +1,440 @@
+// gen-indexes finds the Firestore queries in Go source and writes the
+// composite indexes they need as firestore.indexes.json.
+//
+//	go run . ../../functions > ../../firestore.indexes.json
+//	go run . -check ../../firestore.indexes.json ../../functions
+//
+// A query is a chain rooted at Collection("name") or CollectionGroup("name"),
+// possibly built up through a local variable (q = q.Where(...)). Equality
+// filters, inequality filters and orderings are merged per chain; a chain
+// over more than one field needs a composite index. With -check the tool
+// exits 1 when the file differs from what the source needs, or when a query
+// needing an index has a collection name that is not a string literal.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IndexFile is the Firebase CLI's firestore.indexes.json format.
+type IndexFile struct {
+	Indexes        []Index       `json:"indexes"`
+	FieldOverrides []interface{} `json:"fieldOverrides"`
+}
+
+type Index struct {
+	CollectionGroup string       `json:"collectionGroup"`
+	QueryScope      string       `json:"queryScope"`
+	Fields          []IndexField `json:"fields"`
+}
+
+type IndexField struct {
+	FieldPath   string `json:"fieldPath"`
+	Order       string `json:"order,omitempty"`
+	ArrayConfig string `json:"arrayConfig,omitempty"`
+}
+
+type filter struct {
+	field, op string
+}
+
+type ordering struct {
+	field, dir string
+}
+
+// query accumulates everything applied to one chain.
+type query struct {
+	pos        token.Position
+	collection string // empty when not a string literal
+	group      bool
+	filters    []filter
+	orders     []ordering
+	// Set once the chain is used as a query rather than for Doc(...)
+	used bool
+}
+
+// equalityOps can be served by merging single-field indexes.
+var equalityOps = map[string]bool{"==": true, "in": true}
+
+// arrayOps filter on array membership and need an arrayConfig field.
+var arrayOps = map[string]bool{"array-contains": true, "array-contains-any": true}
+
+// passThrough methods keep the query shape but add no index requirements.
+var passThrough = map[string]bool{
+	"Limit": true, "LimitToLast": true, "Offset": true, "Select": true,
+	"StartAt": true, "StartAfter": true, "EndAt": true, "EndBefore": true,
+	"Documents": true, "Snapshots": true, "NewAggregationQuery": true,
+}
+
+func main() {
+	check := flag.String("check", "", "compare with this file instead of printing")
+	verbose := flag.Bool("v", false, "list every query found on stderr")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: gen-indexes [-check file] [-v] dir...")
+		os.Exit(2)
+	}
+
+	var queries []*query
+	for _, dir := range flag.Args() {
+		found, err := scanDir(dir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		queries = append(queries, found...)
+	}
+
+	if *verbose {
+		for _, q := range queries {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", q.pos, q.describe())
+		}
+	}
+
+	indexes, problems := buildIndexes(queries)
+	out, _ := json.MarshalIndent(IndexFile{Indexes: indexes, FieldOverrides: []interface{}{}}, "", "  ")
+	out = append(out, '\n')
+
+	if *check == "" {
+		os.Stdout.Write(out)
+		report(problems)
+		return
+	}
+
+	committed, err := os.ReadFile(*check)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	report(problems)
+	if !bytes.Equal(bytes.TrimSpace(committed), bytes.TrimSpace(out)) {
+		fmt.Fprintf(os.Stderr, "%s is out of date; regenerate it with make indexes. Expected:\n%s", *check, out)
+		os.Exit(1)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+func report(problems []string) {
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p)
+	}
+}
+
+// scanDir parses every non-test Go file below dir.
+func scanDir(dir string) ([]*query, error) {
+	var queries []*query
+	fset := token.NewFileSet()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name := d.Name(); name == "vendor" || name == "node_modules" || (strings.HasPrefix(name, ".") && path != dir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		queries = append(queries, scanFile(fset, file)...)
+		return nil
+	})
+	return queries, err
+}
+
+// scanFile tracks query chains per function so that a variable assigned a
+// query and later extended counts as one query.
+func scanFile(fset *token.FileSet, file *ast.File) []*query {
+	var queries []*query
+	ast.Inspect(file, func(n ast.Node) bool {
+		var body *ast.BlockStmt
+		switch fn := n.(type) {
+		case *ast.FuncDecl:
+			body = fn.Body
+		case *ast.FuncLit:
+			body = fn.Body
+		default:
+			return true
+		}
+		if body == nil {
+			return false
+		}
+		s := &scanner{fset: fset, vars: make(map[string]*query)}
+		s.walk(body)
+		for _, q := range s.queries {
+			if q.used {
+				queries = append(queries, q)
+			}
+		}
+		// Nested function literals are scanned on their own
+		return true
+	})
+	return queries
+}
+
+type scanner struct {
+	fset    *token.FileSet
+	vars    map[string]*query
+	queries []*query
+}
+
+// walk visits statements in order, stopping at nested function literals.
+// Each outermost query expression is resolved exactly once.
+func (s *scanner) walk(node ast.Node) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.AssignStmt:
+			for i, rhs := range n.Rhs {
+				q := s.chain(rhs)
+				if q == nil {
+					s.walk(rhs)
+					continue
+				}
+				if i >= len(n.Lhs) {
+					continue
+				}
+				if id, ok := n.Lhs[i].(*ast.Ident); ok && id.Name != "_" {
+					s.vars[id.Name] = q
+				}
+			}
+			return false
+		case *ast.CallExpr, *ast.SelectorExpr:
+			if s.chain(n.(ast.Expr)) != nil {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// chain resolves expr to the query it builds, applying its filters and
+// orderings, or returns nil when expr is not a query.
+func (s *scanner) chain(expr ast.Expr) *query {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return s.chain(e.X)
+	case *ast.Ident:
+		return s.vars[e.Name]
+	case *ast.SelectorExpr:
+		// Collection(...).Query
+		if e.Sel.Name == "Query" {
+			return markUsed(s.chain(e.X))
+		}
+		return nil
+	case *ast.CallExpr:
+		sel, ok := e.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return nil
+		}
+		switch name := sel.Sel.Name; {
+		case name == "Collection" || name == "CollectionGroup":
+			if len(e.Args) != 1 {
+				return nil
+			}
+			q := &query{pos: s.fset.Position(e.Pos()), group: name == "CollectionGroup"}
+			q.collection, _ = stringLit(e.Args[0])
+			s.queries = append(s.queries, q)
+			return q
+		case name == "Where":
+			q := markUsed(s.chain(sel.X))
+			if q != nil && len(e.Args) == 3 {
+				op, _ := stringLit(e.Args[1])
+				q.filters = append(q.filters, filter{fieldName(e.Args[0]), op})
+			}
+			return q
+		case name == "OrderBy":
+			q := markUsed(s.chain(sel.X))
+			if q != nil && len(e.Args) == 2 {
+				q.orders = append(q.orders, ordering{fieldName(e.Args[0]), direction(e.Args[1])})
+			}
+			return q
+		case passThrough[name]:
+			return markUsed(s.chain(sel.X))
+		}
+	}
+	return nil
+}
+
+func markUsed(q *query) *query {
+	if q != nil {
+		q.used = true
+	}
+	return q
+}
+
+func stringLit(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	v, err := strconv.Unquote(lit.Value)
+	return v, err == nil
+}
+
+// fieldName returns a literal field path, __name__ for firestore.DocumentID,
+// or "?" for anything computed at runtime.
+func fieldName(expr ast.Expr) string {
+	if v, ok := stringLit(expr); ok {
+		return v
+	}
+	if sel, ok := expr.(*ast.SelectorExpr); ok && sel.Sel.Name == "DocumentID" {
+		return "__name__"
+	}
+	return "?"
+}
+
+func direction(expr ast.Expr) string {
+	if sel, ok := expr.(*ast.SelectorExpr); ok && sel.Sel.Name == "Desc" {
+		return "DESCENDING"
+	}
+	return "ASCENDING"
+}
+
+func (q *query) describe() string {
+	name := q.collection
+	if name == "" {
+		name = "<dynamic>"
+	}
+	parts := []string{name}
+	for _, f := range q.filters {
+		parts = append(parts, fmt.Sprintf("where %s %s", f.field, f.op))
+	}
+	for _, o := range q.orders {
+		parts = append(parts, fmt.Sprintf("order %s %s", o.field, o.dir))
+	}
+	idx := q.index()
+	if idx == nil {
+		return strings.Join(parts, ", ") + " (single-field)"
+	}
+	return strings.Join(parts, ", ") + " (composite)"
+}
+
+// index returns the composite index fields q needs, or nil when single-field
+// indexes are enough: equality-only filters, or filters and orderings that
+// all touch one field. Equality fields come first, then the orderings, which
+// must start with the inequality field.
+func (q *query) index() []IndexField {
+	orders := q.orders
+	// __name__ last in the direction of the previous ordering is implicit
+	if n := len(orders); n > 0 && orders[n-1].field == "__name__" {
+		if n == 1 && orders[0].dir == "ASCENDING" || n > 1 && orders[n-2].dir == orders[n-1].dir {
+			orders = orders[:n-1]
+		}
+	}
+
+	var equality, arrays []string
+	seen := make(map[string]bool)
+	var ranged []ordering
+	for _, f := range q.filters {
+		switch {
+		case equalityOps[f.op]:
+			equality = append(equality, f.field)
+		case arrayOps[f.op]:
+			arrays = append(arrays, f.field)
+		case !seen[f.field]:
+			seen[f.field] = true
+			ranged = append(ranged, ordering{f.field, "ASCENDING"})
+		}
+	}
+	// An inequality field without an explicit ordering is ordered ascending
+	for _, o := range orders {
+		delete(seen, o.field)
+	}
+	for _, r := range ranged {
+		if seen[r.field] {
+			orders = append([]ordering{r}, orders...)
+		}
+	}
+
+	distinct := make(map[string]bool)
+	for _, f := range equality {
+		distinct[f] = true
+	}
+	for _, f := range arrays {
+		distinct[f] = true
+	}
+	for _, o := range orders {
+		distinct[o.field] = true
+	}
+	if len(distinct) < 2 || len(orders) == 0 && len(arrays) == 0 {
+		return nil
+	}
+
+	var fields []IndexField
+	for _, f := range arrays {
+		fields = append(fields, IndexField{FieldPath: f, ArrayConfig: "CONTAINS"})
+	}
+	for _, f := range equality {
+		fields = append(fields, IndexField{FieldPath: f, Order: "ASCENDING"})
+	}
+	for _, o := range orders {
+		fields = append(fields, IndexField{FieldPath: o.field, Order: o.dir})
+	}
+	return fields
+}
+
+// buildIndexes dedupes and sorts the indexes queries need. Queries that need
+// one but cannot be resolved are reported as problems instead.
+func buildIndexes(queries []*query) ([]Index, []string) {
+	var problems []string
+	seen := make(map[string]bool)
+	indexes := []Index{}
+	for _, q := range queries {
+		fields := q.index()
+		if fields == nil {
+			continue
+		}
+		if q.collection == "" {
+			problems = append(problems, fmt.Sprintf("%s: query needs a composite index but its collection is not a string literal", q.pos))
+			continue
+		}
+		for _, f := range fields {
+			if f.FieldPath == "?" {
+				problems = append(problems, fmt.Sprintf("%s: query needs a composite index but a field path is not a string literal", q.pos))
+				fields = nil
+				break
+			}
+		}
+		if fields == nil {
+			continue
+		}
+		scope := "COLLECTION"
+		if q.group {
+			scope = "COLLECTION_GROUP"
+		}
+		idx := Index{CollectionGroup: q.collection, QueryScope: scope, Fields: fields}
+		key, _ := json.Marshal(idx)
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		indexes = append(indexes, idx)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		a, _ := json.Marshal(indexes[i])
+		b, _ := json.Marshal(indexes[j])
+		return string(a) < string(b)
+	})
+	return indexes, problems
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scanSource scans the body of one Go function
+func scanSource(t *testing.T, body string) []*query {
+	t.Helper()
+	src := "package p\n\nfunc f() {\n" + body + "\n}\n"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "f.go", src, 0)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return scanFile(fset, file)
+}
+
+func TestQueryPatterns(t *testing.T) {
+	asc := func(f string) IndexField { return IndexField{FieldPath: f, Order: "ASCENDING"} }
+	desc := func(f string) IndexField { return IndexField{FieldPath: f, Order: "DESCENDING"} }
+
+	tests := []struct {
+		name       string
+		body       string
+		collection string
+		want       []IndexField // nil: single-field indexes suffice
+	}{
+		{
+			name:       "equality filters",
+			body:       `client.Collection("pixels").Where("x", "==", 1).Where("y", "==", 2).Documents(ctx)`,
+			collection: "pixels",
+		},
+		{
+			name:       "range on one field",
+			body:       `client.Collection("rate_limits").Where("window", "<", w).Limit(500).Documents(ctx)`,
+			collection: "rate_limits",
+		},
+		{
+			name:       "ordered with a limit",
+			body:       `client.Collection("users").OrderBy("pixelCount", firestore.Desc).Limit(10).Documents(ctx)`,
+			collection: "users",
+		},
+		{
+			name:       "equality then ordering",
+			body:       `client.Collection("pixels").Where("userId", "==", id).OrderBy("updatedAt", firestore.Desc).Documents(ctx)`,
+			collection: "pixels",
+			want:       []IndexField{asc("userId"), desc("updatedAt")},
+		},
+		{
+			name:       "equality and an inequality without ordering",
+			body:       `client.Collection("pixels").Where("userId", "==", id).Where("x", ">=", 10).Documents(ctx)`,
+			collection: "pixels",
+			want:       []IndexField{asc("userId"), asc("x")},
+		},
+		{
+			name: "built through a variable",
+			body: `q := client.Collection("pixel_history").Where("x", "==", x)
+q = q.Where("y", "==", y)
+q = q.OrderBy("timestamp", firestore.Desc).Limit(1)
+q.Documents(ctx)`,
+			collection: "pixel_history",
+			want:       []IndexField{asc("x"), asc("y"), desc("timestamp")},
+		},
+		{
+			name:       "array membership",
+			body:       `client.Collection("zones").Where("allowedUsers", "array-contains", id).OrderBy("label", firestore.Asc).Documents(ctx)`,
+			collection: "zones",
+			want:       []IndexField{{FieldPath: "allowedUsers", ArrayConfig: "CONTAINS"}, asc("label")},
+		},
+		{
+			name:       "document ID ordering is implicit",
+			body:       `client.Collection("pixels").OrderBy("x", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc).Documents(ctx)`,
+			collection: "pixels",
+		},
+		{
+			name:       "aggregation over a filtered query",
+			body:       `client.Collection("pixels").Where("userId", "==", id).Where("x", ">=", 0).NewAggregationQuery().WithCount("n")`,
+			collection: "pixels",
+			want:       []IndexField{asc("userId"), asc("x")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := scanSource(t, tt.body)
+			if len(queries) != 1 {
+				t.Fatalf("found %d queries, want 1", len(queries))
+			}
+			q := queries[0]
+			if q.collection != tt.collection {
+				t.Errorf("collection = %q, want %q", q.collection, tt.collection)
+			}
+			if got := q.index(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("index = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocumentReadsAreNotQueries(t *testing.T) {
+	queries := scanSource(t, `client.Collection("users").Doc(id).Get(ctx)
+client.Collection("pixels").Doc("1_1").Set(ctx, data)`)
+	if len(queries) != 0 {
+		t.Errorf("found %d queries in document reads, want 0", len(queries))
+	}
+}
+
+func TestFunctionLiteralsAreSeparateQueries(t *testing.T) {
+	queries := scanSource(t, `q := client.Collection("pixels").Where("userId", "==", id)
+run(func() {
+	client.Collection("users").OrderBy("pixelCount", firestore.Desc).Documents(ctx)
+})
+q.OrderBy("updatedAt", firestore.Asc).Documents(ctx)`)
+
+	byCollection := make(map[string]*query)
+	for _, q := range queries {
+		byCollection[q.collection] = q
+	}
+	if len(queries) != 2 || byCollection["pixels"] == nil || byCollection["users"] == nil {
+		t.Fatalf("queries = %v, want one on pixels and one on users", queries)
+	}
+	if len(byCollection["pixels"].orders) != 1 || len(byCollection["users"].filters) != 0 {
+		t.Errorf("the literal's query leaked into the outer chain")
+	}
+}
+
+func TestBuildIndexes(t *testing.T) {
+	queries := scanSource(t, `client.Collection("pixels").Where("userId", "==", id).OrderBy("updatedAt", firestore.Desc).Documents(ctx)
+client.Collection("pixels").Where("userId", "==", other).OrderBy("updatedAt", firestore.Desc).Limit(5).Documents(ctx)
+client.CollectionGroup("pixels").Where("userId", "==", id).OrderBy("updatedAt", firestore.Desc).Documents(ctx)
+client.Collection(name).Where("a", "==", 1).OrderBy("b", firestore.Asc).Documents(ctx)
+client.Collection("zones").Where(field, "==", 1).OrderBy("b", firestore.Asc).Documents(ctx)
+client.Collection("users").Where("id", "==", id).Documents(ctx)`)
+
+	indexes, problems := buildIndexes(queries)
+	if len(indexes) != 2 {
+		t.Fatalf("indexes = %+v, want the pixels index once per scope", indexes)
+	}
+	if indexes[0].QueryScope != "COLLECTION" || indexes[1].QueryScope != "COLLECTION_GROUP" {
+		t.Errorf("scopes = %s, %s; want COLLECTION then COLLECTION_GROUP", indexes[0].QueryScope, indexes[1].QueryScope)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("problems = %q, want the dynamic collection and the dynamic field", problems)
+	}
+	if !strings.Contains(problems[0], "collection is not a string literal") || !strings.Contains(problems[1], "field path is not a string literal") {
+		t.Errorf("problems = %q", problems)
+	}
+}
+
+// The committed file is what make check-indexes compares against
+func TestCommittedIndexesUpToDate(t *testing.T) {
+	queries, err := scanDir("../../functions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes, problems := buildIndexes(queries)
+	if len(problems) > 0 {
+		t.Errorf("problems: %q", problems)
+	}
+	want, _ := json.MarshalIndent(IndexFile{Indexes: indexes, FieldOverrides: []interface{}{}}, "", "  ")
+
+	committed, err := os.ReadFile("../../firestore.indexes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(committed), bytes.TrimSpace(want)) {
+		t.Errorf("firestore.indexes.json is out of date; run make indexes")
+	}
+}